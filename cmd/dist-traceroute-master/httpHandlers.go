@@ -1,21 +1,31 @@
 package main
 
 import (
-	"database/sql"
+	"context"
 	"encoding/json"
-	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	valid "github.com/asaskevich/govalidator"
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
+	"github.com/urfave/negroni"
 	"github.com/xmirakulix/dist-traceroute/disttrace"
 )
 
+// type for keys of values stored in request context
+type ctxKey int
+
+const (
+	ctxKeyAuthClaims ctxKey = iota
+	ctxKeyConn
+)
+
 // status vars for webinterface
 var lastTransmittedSlaveConfig = "none yet"
 var lastTransmittedSlaveConfigTime time.Time
@@ -34,36 +44,150 @@ func checkSlaveCredentials(slave *disttrace.Slave, writer http.ResponseWriter, r
 	return false, uuid.Nil
 }
 
+// remoteIP returns the IP address of the peer of a request
+func remoteIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// httpHandleAPIAuth logs in a user with the credentials in the request body and returns the tokens of a new session
 func httpHandleAPIAuth() http.HandlerFunc {
 	return func(writer http.ResponseWriter, req *http.Request) {
-		user := req.URL.Query().Get("user")
-		password := req.URL.Query().Get("password")
 
-		log.Debugf("httpHandleAPIAuth: Received API 'auth' request for user<%v>", user)
+		var credentials struct {
+			User     string
+			Password string
+		}
+		if err := json.NewDecoder(req.Body).Decode(&credentials); err != nil {
+			log.Debug("httpHandleAPIAuth: Couldn't decode request body, Error: ", err)
+			http.Error(writer, "Couldn't decode request body", http.StatusBadRequest)
+			return
+		}
+
+		log.Debugf("httpHandleAPIAuth: Received API 'auth' request for user<%v>", credentials.User)
 
-		if !disttrace.AuthUser(user, password, db) {
+		authUser, ok := disttrace.AuthUser(credentials.User, credentials.Password, db)
+		if !ok {
 			time.Sleep(3 * time.Second)
 			http.Error(writer, "User/PW do not match", http.StatusUnauthorized)
-			disttrace.AlertWarnf(req.RemoteAddr, "Unauthorized user login for user '%v'", user)
+			disttrace.AlertWarnf(req.RemoteAddr, "Unauthorized user login for user '%v'", credentials.User)
 			return
 		}
 
-		claims := disttrace.AuthClaims{
-			Username: user,
-		}
-		token, err := disttrace.GetToken(claims)
+		tokens, err := disttrace.NewSession(db, authUser)
 		if err != nil {
-			log.Warn("httpHandleAPIAuth: Can't generate auth token")
-			http.Error(writer, "Can't generate token", http.StatusBadRequest)
+			log.Warn("httpHandleAPIAuth: Can't start session")
+			http.Error(writer, "Can't generate token", http.StatusInternalServerError)
 			return
 		}
 
-		if _, err := writer.Write(token); err != nil {
-			log.Warn("httpHandleAPIAuth: Couldn't write response: ", err)
+		log.Debug("httpHandleAPIAuth: Replying with success.")
+		generateJSONResponse(writer, req, tokens)
+	}
+}
+
+// httpHandleAPIAuthRefresh returns new tokens for the refresh token in the request body
+func httpHandleAPIAuthRefresh() http.HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		log.Debug("httpHandleAPIAuthRefresh: Received API 'refresh' request")
+
+		var refresh struct {
+			RefreshToken string
+		}
+		if err := json.NewDecoder(req.Body).Decode(&refresh); err != nil {
+			log.Debug("httpHandleAPIAuthRefresh: Couldn't decode request body, Error: ", err)
+			http.Error(writer, "Couldn't decode request body", http.StatusBadRequest)
+			return
 		}
 
-		log.Debug("httpHandleAPIAuth: Replying with success.")
-		return
+		tokens, err := disttrace.RefreshSession(db, refresh.RefreshToken)
+		switch err {
+		case nil:
+		case disttrace.ErrInvalidRefreshToken:
+			http.Error(writer, err.Error(), http.StatusUnauthorized)
+			return
+		default:
+			http.Error(writer, "Couldn't refresh session", http.StatusInternalServerError)
+			return
+		}
+
+		generateJSONResponse(writer, req, tokens)
+	}
+}
+
+// httpHandleAPIAuthLogout revokes the token of the request and ends its session
+func httpHandleAPIAuthLogout() http.HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		claims := authClaimsFromRequest(req)
+		log.Debugf("httpHandleAPIAuthLogout: Received API 'logout' request for user<%v>", claims.Username)
+
+		if err := disttrace.RevokeToken(claims); err != nil {
+			http.Error(writer, "Couldn't revoke token", http.StatusInternalServerError)
+			return
+		}
+		if err := disttrace.EndSession(db, claims.Session); err != nil {
+			http.Error(writer, "Couldn't end session", http.StatusInternalServerError)
+			return
+		}
+
+		log.Infof("httpHandleAPIAuthLogout: User '%v' logged out", claims.Username)
+		writer.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// httpHandleAPIAuthPassword changes the password of the logged in user and returns a new token
+func httpHandleAPIAuthPassword() http.HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		claims := authClaimsFromRequest(req)
+		log.Debugf("httpHandleAPIAuthPassword: Received API 'password' request for user<%v>", claims.Username)
+
+		var change struct {
+			Password    string
+			NewPassword string
+		}
+		if err := json.NewDecoder(req.Body).Decode(&change); err != nil {
+			log.Debug("httpHandleAPIAuthPassword: Couldn't decode request body, Error: ", err)
+			http.Error(writer, "Couldn't decode request body", http.StatusBadRequest)
+			return
+		}
+
+		user, err := disttrace.ChangePassword(db, claims.Username, change.Password, change.NewPassword)
+		switch err {
+		case nil:
+		case disttrace.ErrWrongPassword:
+			time.Sleep(3 * time.Second)
+			http.Error(writer, err.Error(), http.StatusForbidden)
+			disttrace.AlertWarnf(req.RemoteAddr, "Wrong password while changing the password of user '%v'", claims.Username)
+			return
+		case disttrace.ErrInvalidPassword:
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		default:
+			http.Error(writer, "Couldn't change password", http.StatusInternalServerError)
+			return
+		}
+
+		// other sessions may have been started by whoever knew the previous password
+		if err := disttrace.EndUserSessions(db, user.ID, claims.Session); err != nil {
+			http.Error(writer, "Couldn't end other sessions", http.StatusInternalServerError)
+			return
+		}
+
+		// the token of the request may only allow to change the password, the session stays the same
+		token, expires, err := disttrace.GetToken(disttrace.NewAuthClaims(user, claims.Session))
+		if err != nil {
+			log.Warn("httpHandleAPIAuthPassword: Can't generate auth token")
+			http.Error(writer, "Can't generate token", http.StatusInternalServerError)
+			return
+		}
+		if err := disttrace.RevokeToken(claims); err != nil {
+			log.Warn("httpHandleAPIAuthPassword: Couldn't revoke previous token")
+		}
+
+		generateJSONResponse(writer, req, disttrace.AuthTokens{AccessToken: string(token), ExpiresAt: expires})
 	}
 }
 
@@ -71,6 +195,12 @@ func httpHandleAPIStatus() http.HandlerFunc {
 	return func(writer http.ResponseWriter, req *http.Request) {
 		log.Debug("httpHandleAPIStatus: Received API 'status' request")
 
+		// users restricted to target groups only see alerts of their targets
+		scope, ok := targetScope(writer, req)
+		if !ok {
+			return
+		}
+
 		var timeSinceSlaveCfg string
 		if !lastTransmittedSlaveConfigTime.IsZero() {
 			timeSinceSlaveCfg = time.Since(lastTransmittedSlaveConfigTime).Truncate(time.Second).String()
@@ -85,7 +215,7 @@ func httpHandleAPIStatus() http.HandlerFunc {
 			disttrace.GetUptime().Truncate(time.Second).String(),
 			timeSinceSlaveCfg,
 			lastTransmittedSlaveConfig,
-			disttrace.GetAlerts(),
+			disttrace.GetAlerts(scope),
 		}
 
 		generateJSONResponse(writer, req, response)
@@ -105,104 +235,79 @@ func httpHandleAPIGraphData() http.HandlerFunc {
 			http.Error(writer, "Parameter dest missing or empty", http.StatusBadRequest)
 			return
 		}
+		if !checkTargetScope(writer, req, destID) {
+			return
+		}
 
-		query := `
-			SELECT MIN(dtStart) as start, MAX(dtStart) AS end, json_group_array(json_array(prevHopAddress, strHopIPAddress, cnt, avgDuration)) as links
-			FROM (
-				SELECT h.nHopIndex, t.dtStart, COALESCE(prev.strHopIPAddress, '0') as prevHopAddress,
-				h.strHopDNSName,
-				h.strHopIPAddress, COUNT(*) as cnt, AVG(h.dDurationSec)*1000 as avgDuration,
-				h.strHopIPAddress || h.nHopIndex || COALESCE(prev.strHopIPAddress, '') AS LinkId,
-				tg.strDestination
-
-				FROM t_Hops h  
-				JOIN t_Traceroutes t ON t.strTracerouteId = h.strTracerouteId
-				JOIN t_Targets tg ON t.strTargetId = tg.strTargetId
-				JOIN t_Slaves s ON t.strSlaveId = s.strSlaveId
-				LEFT JOIN t_Hops prev ON h.strPreviousHopId = prev.strHopId
-
-				WHERE tg.strTargetID = ? AND s.strSlaveId = ? AND h.nHopIndex > ?
-
-				GROUP BY h.strHopIPAddress, h.nHopIndex, prevHopAddress
-				ORDER BY h.nHopIndex
-			) t
-			GROUP BY t.strDestination
-			`
-
-		resRow := db.QueryRow(query, destID, slaveID, skip)
-		var resStart, resEnd, resGraphData string
-		if err := resRow.Scan(&resStart, &resEnd, &resGraphData); err != nil {
-			if err == sql.ErrNoRows {
-				log.Debug("httpHandleAPIGraphData: No data found, returning empty")
-			} else {
-				log.Warn("httpHandleAPIGraphData: Error while getting graph data, Error: ", err.Error())
+		// without a time range all data is used, including results of slaves with clocks slightly ahead
+		from, to := time.Time{}, time.Now().Add(time.Hour)
+		if req.URL.Query().Get("from") != "" || req.URL.Query().Get("to") != "" {
+			if from, to, err = parseTimeRange(req, 24*time.Hour); err != nil {
+				log.Debug("httpHandleAPIGraphData: Invalid time range, Error: ", err)
+				http.Error(writer, "Invalid time range, use RFC3339 timestamps", http.StatusBadRequest)
+				return
 			}
-			resGraphData = "{}"
 		}
 
-		response := fmt.Sprintf("{ \"Start\": \"%v\", \"End\": \"%v\", \"Data\": %v }", resStart, resEnd, resGraphData)
-
-		if _, err := io.WriteString(writer, response); err != nil {
-			log.Warn("httpHandleAPIGraphData: Couldn't write response: ", err)
+		graph, err := disttrace.GetGraphData(db, destID, slaveID, skip, from, to)
+		if err != nil {
+			log.Warn("httpHandleAPIGraphData: Error while getting graph data, Error: ", err)
+			http.Error(writer, "Error while getting graph data", http.StatusInternalServerError)
+			return
 		}
 
+		generateJSONResponse(writer, req, graph)
+
 		log.Debug("httpHandleAPIGraphData: Replying with success.")
 	}
 }
 
+func httpHandleAPIPaths() http.HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		destID, _ := uuid.Parse(req.URL.Query().Get("destID"))
+		slaveID, _ := uuid.Parse(req.URL.Query().Get("slaveID"))
+
+		log.Debugf("httpHandleAPIPaths: Received API 'paths' request, dest: <%v>, slave: <%v>", destID, slaveID)
+
+		if destID == uuid.Nil {
+			log.Info("httpHandleAPIPaths: Parameter dest missing or empty, returning error.")
+			http.Error(writer, "Parameter dest missing or empty", http.StatusBadRequest)
+			return
+		}
+		if !checkTargetScope(writer, req, destID) {
+			return
+		}
+
+		paths, err := disttrace.GetPathSightings(db, destID, slaveID)
+		if err != nil {
+			log.Warn("httpHandleAPIPaths: Error while getting paths, Error: ", err)
+			http.Error(writer, "Error while getting paths", http.StatusInternalServerError)
+			return
+		}
+
+		generateJSONResponse(writer, req, paths)
+
+		log.Debug("httpHandleAPIPaths: Replying with success.")
+	}
+}
+
 func httpHandleAPITraceHistory() http.HandlerFunc {
 	return func(writer http.ResponseWriter, req *http.Request) {
 		limit, _ := strconv.Atoi(req.URL.Query().Get("limit"))
 
 		log.Debugf("httpHandleAPITraceHistory: Received API 'tracehistory' request, limit: <%v>", limit)
 
-		lastResultsQuery := `
-		SELECT t.strTracerouteId, s.strSlaveId, s.strSlaveName, tg.strTargetId, tg.strDestination, strftime("%d.%m.%Y %H:%M", t.dtStart) AS dtStart, COUNT(h.strHopId) AS nHopCount, 
-			json_group_object(h.nHopIndex, json_object('IP', h.strHopIPAddress, 'DNS', h.strHopDNSName, 'Duration', h.dDurationSec)) AS strHopDetails
-		FROM t_Traceroutes t 
-		JOIN t_Slaves s ON t.strSlaveId = s.strSlaveId 
-		JOIN t_Targets tg ON t.strTargetId = tg.strTargetId
-		LEFT JOIN t_Hops h ON t.strTracerouteId = h.strTracerouteId
-		GROUP BY t.strTracerouteId
-		ORDER BY t.dtStart DESC 
-		`
-
-		if limit != 0 {
-			lastResultsQuery += "LIMIT " + strconv.Itoa(limit)
+		scope, ok := targetScope(writer, req)
+		if !ok {
+			return
 		}
 
-		var resRows *sql.Rows
-		var err error
-
-		if resRows, err = db.Query(lastResultsQuery); err != nil {
+		rows, err := disttrace.GetTraceHistory(db, limit, scope)
+		if err != nil {
 			log.Warn("httpHandleAPITraceHistory: Couldn't get last results from DB, Error: ", err)
 			http.Error(writer, "Couldn't get last results from DB", http.StatusInternalServerError)
 			return
 		}
-		defer resRows.Close()
-
-		type trace struct {
-			TraceID    uuid.UUID
-			SlaveID    uuid.UUID
-			SlaveName  string
-			DestID     uuid.UUID
-			DestName   string
-			StartTime  string
-			HopCnt     int64
-			DetailJSON string
-		}
-
-		rows := []trace{}
-
-		for resRows.Next() {
-			var t trace
-			if err = resRows.Scan(&t.TraceID, &t.SlaveID, &t.SlaveName, &t.DestID, &t.DestName, &t.StartTime, &t.HopCnt, &t.DetailJSON); err != nil {
-				log.Warn("httpHandleAPITraceHistory: Couldn't read DB result set, Error: ", err)
-				http.Error(writer, "Couldn't read DB result set", http.StatusInternalServerError)
-				return
-			}
-			rows = append(rows, t)
-		}
 
 		generateJSONResponse(writer, req, rows)
 	}
@@ -256,6 +361,7 @@ func httpHandleSlaveResults() http.HandlerFunc {
 			return
 		}
 		result.Slave.ID = slaveID
+		disttrace.RecordSlaveActivity(db, slaveID, disttrace.SlaveActivityResult, result.Slave.Status, remoteIP(req))
 
 		// check data
 		if target, err := disttrace.GetTarget(result.Target.ID, db); err != nil {
@@ -267,6 +373,15 @@ func httpHandleSlaveResults() http.HandlerFunc {
 			http.Error(writer, "Supplied target ID doesn't match a target in the DB", http.StatusBadRequest)
 			disttrace.AlertInfof("Slave: "+result.Slave.Name, "Discarding result for invalid target ID: '%v'", result.Target.ID)
 			return
+		} else if target.Archived != nil {
+			// slaves may still run a target archived after their last config poll, tell them not to retry
+			log.Debugf("httpHandleSlaveResults: Discarding result for archived target '%v'", target.ID)
+			generateJSONResponse(writer, req, disttrace.SubmitResult{
+				Success:       false,
+				Error:         "Target is archived",
+				RetryPossible: false,
+			})
+			return
 		}
 
 		log.Infof("httpHandleSlaveResults: Received results from slave '%v' for target '%v'. Success: %v, Hops: %v.",
@@ -281,94 +396,16 @@ func httpHandleSlaveResults() http.HandlerFunc {
 		}
 
 		// store submitted result
-		var tx *disttrace.Tx
-		var errDb error
-		errDb = nil
-
-		if tx, errDb = db.Begin(); err != nil {
-			log.Warn("httpHandleSlaveResults: Error creating database transaction while storing result, Error: ", errDb)
-			http.Error(writer, "Database error", http.StatusInternalServerError)
-			return
-		}
-		// catch errors and rollback!
-		defer func() {
-			if errDb != nil {
-				log.Warn("httpHandleSlaveResults: Caught error during database operations, rolling transaction back!")
-				tx.Rollback()
-			}
-		}()
-
-		// prepare traceroute insert
-		traceStmt, errDb := tx.Prepare(`
-			INSERT INTO t_Traceroutes (strTracerouteId, strSlaveId, strTargetId, dtStart, strAnnotations) 
-			VALUES (?, ?, ?, ?, ?) 
-			`)
-		defer traceStmt.Close()
-		if errDb != nil {
-			log.Warn("httpHandleSlaveResults: Error while preparing database statement, Error: ", errDb)
-			http.Error(writer, "Database error", http.StatusInternalServerError)
-			return
-		}
-
-		// prepare hop insert
-		hopStmt, errDb := tx.Prepare(`
-			INSERT INTO t_Hops (strHopId, strTracerouteId, nHopIndex, strHopIPAddress, strHopDNSName, dDurationSec, strPreviousHopId)	
-			VALUES (?, ?, ?, ?, ?, ?, ?) 
-			`)
-		defer hopStmt.Close()
-		if errDb != nil {
-			log.Warn("httpHandleSlaveResults: Error while preparing database statement, Error: ", errDb)
-			http.Error(writer, "Database error", http.StatusInternalServerError)
-			return
-		}
-
-		log.Debug("httpHandleSlaveResults: Finished preparing queries, inserting data...")
-
-		// Insert result info
-		traceID := uuid.New()
-		if _, errDb := traceStmt.Exec(traceID, result.Slave.ID, result.Target.ID, result.DateTime.Format(time.RFC3339), ""); errDb != nil {
-			log.Warn("httpHandleSlaveResults: Error while inserting result, Error: ", errDb)
-			http.Error(writer, "Database error", http.StatusInternalServerError)
-			return
-		}
-
-		if errDb != nil {
-			log.Warn("httpHandleSlaveResults: Error while getting last inserted ID of traceroute, Error: ", errDb)
+		traceID, err := disttrace.StoreTraceResult(db, result)
+		if err != nil {
+			log.Warn("httpHandleSlaveResults: Error while storing result, Error: ", err)
 			http.Error(writer, "Database error", http.StatusInternalServerError)
 			return
 		}
-		log.Debug("httpHandleSlaveResults: Inserted result with ID: ", traceID)
-
-		// Insert hops info
-		var prevHopID uuid.UUID
-		for _, hop := range result.Hops {
-
-			hopID := uuid.New()
-			// prev hop is null on first hop
-			if hop.TTL == 0 {
-				_, errDb = hopStmt.Exec(hopID, traceID, hop.TTL, hop.AddressString(), hop.Host, hop.ElapsedTime.Seconds(), nil)
-			} else {
-				_, errDb = hopStmt.Exec(hopID, traceID, hop.TTL, hop.AddressString(), hop.Host, hop.ElapsedTime.Seconds(), prevHopID)
-			}
-			if errDb != nil {
-				log.Warn("httpHandleSlaveResults: Error while inserting hop, Error: ", errDb)
-				http.Error(writer, "Database error", http.StatusInternalServerError)
-				return
-			}
-			prevHopID = hopID
-			if errDb != nil {
-				log.Warn("httpHandleSlaveResults: Error while getting last inserted ID of hop, Error: ", errDb)
-				http.Error(writer, "Database error", http.StatusInternalServerError)
-				return
-			}
-		}
-		log.Debug("httpHandleSlaveResults: Successfully inserted trace info and hops, commiting transaction...")
+		metricResultsReceived.Inc(result.Slave.Name)
 
-		if errDb = tx.Commit(); errDb != nil {
-			log.Warn("httpHandleSlaveResults: Error while commiting transaction, Error: ", errDb)
-			http.Error(writer, "Database error", http.StatusInternalServerError)
-			return
-		}
+		// stream stored result to the export sinks
+		disttrace.ExportResult(traceID, result)
 
 		// reply with success
 		response := disttrace.SubmitResult{
@@ -408,33 +445,18 @@ func httpHandleSlaveConfig() http.HandlerFunc {
 		if !auth {
 			return
 		}
+		disttrace.RecordSlaveActivity(db, slaveID, disttrace.SlaveActivityConfigPoll, slave.Status, remoteIP(req))
 
 		// read config from db
 		slaveConf := disttrace.SlaveConfig{ID: slaveID}
 
-		query := "SELECT strTargetId, strDescription, strDestination, nRetries, nMaxHops, nTimeoutMSec FROM t_Targets"
-		rows, err := db.Query(query)
-		if err != nil {
+		if slaveConf.Targets, err = disttrace.GetTargetsForSlave(db, slaveID); err != nil {
 			http.Error(writer, "Error: Can't read targets from db", http.StatusInternalServerError)
 			log.Warn("httpHandleSlaveConfig: Can't read targets from db, Error: ", err)
 			lastTransmittedSlaveConfig = "Error: Can't read targets from db: " + err.Error()
 			lastTransmittedSlaveConfigTime = time.Now()
 			return
 		}
-		defer rows.Close()
-
-		for rows.Next() {
-			var tgt = disttrace.TraceTarget{}
-
-			if err := rows.Scan(&tgt.ID, &tgt.Name, &tgt.Address, &tgt.Retries, &tgt.MaxHops, &tgt.TimeoutMs); err != nil {
-				http.Error(writer, "Error: Can't scan target rows", http.StatusInternalServerError)
-				log.Warn("httpHandleSlaveConfig: Can't scan target rows, Error: ", err)
-				lastTransmittedSlaveConfig = "Error: Can't scan target rows: " + err.Error()
-				lastTransmittedSlaveConfigTime = time.Now()
-				return
-			}
-			slaveConf.Targets = append(slaveConf.Targets, tgt)
-		}
 
 		// validate config
 		if ok, e := valid.ValidateStruct(slaveConf); !ok || e != nil {
@@ -480,19 +502,130 @@ func checkJWTAuth(writer http.ResponseWriter, req *http.Request, next http.Handl
 	}
 
 	// check for a verifiable token
-	if err := disttrace.VerifyToken([]byte(disttrace.TokenFromAuthHeader(authHeader))); err != nil {
+	claims, err := disttrace.VerifyToken([]byte(disttrace.TokenFromAuthHeader(authHeader)))
+	if err != nil {
 		log.Debug("checkAuth: Couldn't verify supplied token, returning unauthorized...")
 		http.Error(writer, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 
-	// call next handler in chain
-	next(writer, req)
+	// users with an initial or reset password have to change it first
+	if claims.PasswordNeedsChange && req.URL.Path != "/api/auth/password" && req.URL.Path != "/api/auth/logout" {
+		log.Debugf("checkAuth: User '%v' has to change their password, returning forbidden...", claims.Username)
+		http.Error(writer, "Password needs to be changed", http.StatusForbidden)
+		return
+	}
+
+	// call next handler in chain, make claims available to it
+	ctx := context.WithValue(req.Context(), ctxKeyAuthClaims, claims)
+	next(writer, req.WithContext(ctx))
+}
+
+// authClaimsFromRequest returns the verified claims of the request's auth token
+func authClaimsFromRequest(req *http.Request) disttrace.AuthClaims {
+	claims, _ := req.Context().Value(ctxKeyAuthClaims).(disttrace.AuthClaims)
+	return claims
+}
+
+// requireRole only passes requests of users with at least the given role to the handler, runs after checkJWTAuth
+func requireRole(role string, next http.HandlerFunc) http.HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		claims := authClaimsFromRequest(req)
+		if !disttrace.HasRole(claims.Role, role) {
+			log.Debugf("requireRole: User '%v' with role '%v' needs role '%v' for '%v %v', returning forbidden...",
+				claims.Username, claims.Role, role, req.Method, req.URL.Path)
+			http.Error(writer, "Forbidden, role '"+role+"' required", http.StatusForbidden)
+			return
+		}
+		next(writer, req)
+	}
+}
+
+// targetScope returns the targets the user of the request may access, nil allows all targets. Replies with an error
+// if the scope can't be read.
+func targetScope(writer http.ResponseWriter, req *http.Request) (scope disttrace.TargetScope, ok bool) {
+	claims := authClaimsFromRequest(req)
+	scope, err := disttrace.GetTargetScope(db, claims.Role, claims.TargetGroups)
+	if err != nil {
+		http.Error(writer, "Couldn't get accessible targets", http.StatusInternalServerError)
+		return nil, false
+	}
+	return scope, true
+}
+
+// checkTargetScope replies with forbidden if the user of the request may not access the target
+func checkTargetScope(writer http.ResponseWriter, req *http.Request, targetID uuid.UUID) bool {
+	scope, ok := targetScope(writer, req)
+	if !ok {
+		return false
+	}
+	if !scope.Allows(targetID) {
+		log.Debugf("checkTargetScope: User '%v' may not access target '%v', returning forbidden...",
+			authClaimsFromRequest(req).Username, targetID)
+		http.Error(writer, "Forbidden, target isn't accessible", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// inUserTargetGroups returns if the user of the request is restricted to target groups including the given one
+func inUserTargetGroups(req *http.Request, groupID uuid.UUID) bool {
+	for _, userGroupID := range authClaimsFromRequest(req).TargetGroups {
+		if groupID != uuid.Nil && userGroupID == groupID {
+			return true
+		}
+	}
+	return false
+}
+
+// checkUnscoped replies with forbidden if the user of the request is restricted to some targets
+func checkUnscoped(writer http.ResponseWriter, req *http.Request) bool {
+	scope, ok := targetScope(writer, req)
+	if !ok {
+		return false
+	}
+	if scope != nil {
+		log.Debugf("checkUnscoped: User '%v' is restricted to target groups, returning forbidden...", authClaimsFromRequest(req).Username)
+		http.Error(writer, "Forbidden, not allowed for users restricted to target groups", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// extendWriteDeadline allows another write timeout from now for long running responses like streams and exports
+func extendWriteDeadline(req *http.Request) {
+	timeout := disttrace.CurrentMasterConfig().HTTP.WriteTimeout
+	if conn, ok := req.Context().Value(ctxKeyConn).(net.Conn); ok && timeout > 0 {
+		conn.SetWriteDeadline(time.Now().Add(timeout))
+	}
+}
+
+// deadlineWriter extends the write timeout before every write of a long running response
+type deadlineWriter struct {
+	http.ResponseWriter
+	req *http.Request
+}
+
+func (w deadlineWriter) Write(data []byte) (int, error) {
+	extendWriteDeadline(w.req)
+	return w.ResponseWriter.Write(data)
 }
 
 func handleAccessControl(writer http.ResponseWriter, req *http.Request, next http.HandlerFunc) {
 
-	writer.Header().Add("Access-Control-Allow-Origin", "*")
+	// allow all or only the configured origins
+	origin := req.Header.Get("Origin")
+	for _, allowed := range disttrace.CurrentMasterConfig().HTTP.CORSAllowedOrigins {
+		if allowed == "*" {
+			writer.Header().Add("Access-Control-Allow-Origin", "*")
+			break
+		}
+		if origin != "" && strings.TrimSuffix(allowed, "/") == origin {
+			writer.Header().Add("Access-Control-Allow-Origin", origin)
+			writer.Header().Add("Vary", "Origin")
+			break
+		}
+	}
 	writer.Header().Add("Access-Control-Allow-Methods", "DELETE, POST, GET, OPTIONS, PUT")
 	writer.Header().Add("Access-Control-Allow-Headers", "Content-Type, Access-Control-Allow-Headers, Authorization, X-Requested-With")
 
@@ -505,17 +638,41 @@ func handleAccessControl(writer http.ResponseWriter, req *http.Request, next htt
 	next(writer, req)
 }
 
+// handleHTTPMetrics counts all responses by their status code
+func handleHTTPMetrics(writer http.ResponseWriter, req *http.Request, next http.HandlerFunc) {
+
+	next(writer, req)
+
+	status := http.StatusOK
+	if res, ok := writer.(negroni.ResponseWriter); ok && res.Status() != 0 {
+		status = res.Status()
+	}
+	metricHTTPResponses.Inc(strconv.Itoa(status), req.Method)
+}
+
 func httpHandleAPISlavesList() http.HandlerFunc {
 	return func(writer http.ResponseWriter, req *http.Request) {
 		log.Debug("httpHandleAPISlavesList: Received API 'slaves' request, method: ", req.Method)
 
-		slaves, err := disttrace.GetSlaves(db)
+		getSlaves := disttrace.GetSlaves
+		if req.URL.Query().Get("archived") == "true" {
+			getSlaves = disttrace.GetArchivedSlaves
+		}
+
+		slaves, err := getSlaves(db)
 		if err != nil {
 			log.Warn("httpHandleAPISlavesList: Error: Couldn't get slaves from db, Error: ", err)
 			http.Error(writer, "Couldn't get slaves from db", http.StatusInternalServerError)
 			return
 		}
 
+		// only admins may see the secrets the slaves authenticate with
+		if !disttrace.HasRole(authClaimsFromRequest(req).Role, disttrace.RoleAdmin) {
+			for i := range slaves {
+				slaves[i].Secret = ""
+			}
+		}
+
 		generateJSONResponse(writer, req, slaves)
 	}
 }
@@ -586,9 +743,34 @@ func httpHandleAPISlavesDelete() http.HandlerFunc {
 			return
 		}
 
-		if err = disttrace.DeleteSlave(db, slaveID); err != nil {
-			log.Warn("httpHandleAPISlavesDelete: Error while deleting slave, Error: ", err)
-			http.Error(writer, "Error while deleting slave", http.StatusInternalServerError)
+		if err = disttrace.ArchiveSlave(db, slaveID); err != nil {
+			log.Warn("httpHandleAPISlavesDelete: Error while archiving slave, Error: ", err)
+			http.Error(writer, "Error while archiving slave", http.StatusInternalServerError)
+			return
+		}
+
+		generateJSONResponse(writer, req, disttrace.Slave{ID: slaveID})
+	}
+}
+
+func httpHandleAPISlavesRestore() http.HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		vars := mux.Vars(req)
+		log.Debugf("httpHandleAPISlavesRestore: Received API 'slaves' request, method: '%v', ID: '%v'", req.Method, vars["slaveID"])
+
+		slaveID, err := uuid.Parse(vars["slaveID"])
+		if err != nil {
+			log.Debugf("httpHandleAPISlavesRestore: Received restore request for invalid slave, ID: '%v', Error: %v", slaveID, err)
+			http.Error(writer, "Received restore request for invalid slave", http.StatusBadRequest)
+			return
+		}
+
+		if err = disttrace.RestoreSlave(db, slaveID); err == disttrace.ErrNotArchived {
+			http.Error(writer, "Slave isn't archived or is already being purged", http.StatusConflict)
+			return
+		} else if err != nil {
+			log.Warn("httpHandleAPISlavesRestore: Error while restoring slave, Error: ", err)
+			http.Error(writer, "Error while restoring slave", http.StatusInternalServerError)
 			return
 		}
 
@@ -596,17 +778,63 @@ func httpHandleAPISlavesDelete() http.HandlerFunc {
 	}
 }
 
+func httpHandleAPISlavesPurge() http.HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		vars := mux.Vars(req)
+		log.Debugf("httpHandleAPISlavesPurge: Received API 'slaves' request, method: '%v', ID: '%v'", req.Method, vars["slaveID"])
+
+		slaveID, err := uuid.Parse(vars["slaveID"])
+		if err != nil {
+			log.Debugf("httpHandleAPISlavesPurge: Received purge request for invalid slave, ID: '%v', Error: %v", slaveID, err)
+			http.Error(writer, "Received purge request for invalid slave", http.StatusBadRequest)
+			return
+		}
+
+		if err = disttrace.PurgeSlave(db, slaveID); err == disttrace.ErrNotArchived {
+			http.Error(writer, "Slave isn't archived or is already being purged", http.StatusConflict)
+			return
+		} else if err != nil {
+			log.Warn("httpHandleAPISlavesPurge: Error while purging slave, Error: ", err)
+			http.Error(writer, "Error while purging slave", http.StatusInternalServerError)
+			return
+		}
+
+		// HTTP 202 Accepted, the purge runs in the background
+		writer.WriteHeader(http.StatusAccepted)
+		generateJSONResponse(writer, req, disttrace.Slave{ID: slaveID})
+	}
+}
+
 func httpHandleAPITargetsList() http.HandlerFunc {
 	return func(writer http.ResponseWriter, req *http.Request) {
 		log.Debug("httpHandleAPITargetsList: Received API 'targets' request, method: ", req.Method)
 
-		targets, err := disttrace.GetTargets(db)
+		getTargets := disttrace.GetTargets
+		if req.URL.Query().Get("archived") == "true" {
+			getTargets = disttrace.GetArchivedTargets
+		}
+
+		targets, err := getTargets(db)
 		if err != nil {
 			log.Warn("httpHandleAPITargetsList: Error: Couldn't get targets from db, Error: ", err)
 			http.Error(writer, "Couldn't get targets from db", http.StatusInternalServerError)
 			return
 		}
 
+		scope, ok := targetScope(writer, req)
+		if !ok {
+			return
+		}
+		if scope != nil {
+			accessible := []disttrace.TraceTarget{}
+			for _, target := range targets {
+				if scope.Allows(target.ID) {
+					accessible = append(accessible, target)
+				}
+			}
+			targets = accessible
+		}
+
 		generateJSONResponse(writer, req, targets)
 	}
 }
@@ -635,7 +863,32 @@ func httpHandleAPITargetsCreate() http.HandlerFunc {
 			TimeoutMs: timeout,
 		}
 
-		newTarget, err := disttrace.CreateTarget(db, target)
+		// optionally create target in a group, unset parameters default to the group's parameters
+		var group disttrace.TargetGroup
+		if val := req.URL.Query().Get("group"); val != "" {
+			groupID, err := uuid.Parse(val)
+			if err == nil {
+				group, err = disttrace.GetTargetGroup(groupID, db)
+			}
+			if err != nil || group.ID == uuid.Nil {
+				log.Debugf("httpHandleAPITargetsCreate: Invalid target group: '%v', returning bad request", val)
+				http.Error(writer, "Invalid target group", http.StatusBadRequest)
+				return
+			}
+			group.ApplyDefaults(&target)
+		}
+
+		// users restricted to target groups may only create targets in their groups
+		if !inUserTargetGroups(req, group.ID) && !checkUnscoped(writer, req) {
+			return
+		}
+
+		var groupIDs []uuid.UUID
+		if group.ID != uuid.Nil {
+			groupIDs = append(groupIDs, group.ID)
+		}
+
+		newTarget, err := disttrace.CreateTarget(db, target, groupIDs...)
 		if err != nil {
 			log.Warn("httpHandleAPITargetsCreate: Error while creating target, Error: ", err)
 			http.Error(writer, "Error while creating target", http.StatusInternalServerError)
@@ -648,6 +901,58 @@ func httpHandleAPITargetsCreate() http.HandlerFunc {
 	}
 }
 
+// maximum size of a bulk import of targets
+const maxTargetImportSize = 10 << 20
+
+func httpHandleAPITargetsImport() http.HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		log.Debug("httpHandleAPITargetsImport: Received API 'targets import' request, method: ", req.Method)
+
+		// imported targets aren't in any group, they would be hidden from users restricted to target groups
+		if !checkUnscoped(writer, req) {
+			return
+		}
+
+		dryRun := req.URL.Query().Get("dryRun") == "true"
+
+		// format by parameter or content type
+		format := req.URL.Query().Get("format")
+		if format == "" {
+			switch strings.Split(req.Header.Get("Content-Type"), ";")[0] {
+			case "text/csv":
+				format = disttrace.TargetImportCSV
+			case "text/plain":
+				format = disttrace.TargetImportList
+			default:
+				format = disttrace.TargetImportJSON
+			}
+		}
+
+		body, err := ioutil.ReadAll(http.MaxBytesReader(writer, req.Body, maxTargetImportSize))
+		if err != nil {
+			log.Warn("httpHandleAPITargetsImport: Couldn't read request body, Error: ", err)
+			http.Error(writer, "Couldn't read request body", http.StatusBadRequest)
+			return
+		}
+
+		rows, err := disttrace.ParseTargetImport(body, format)
+		if err != nil {
+			log.Debug("httpHandleAPITargetsImport: Invalid document, returning bad request, Error: ", err)
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		report, err := disttrace.ImportTargets(db, rows, dryRun)
+		if err != nil {
+			log.Warn("httpHandleAPITargetsImport: Error while importing targets, Error: ", err)
+			http.Error(writer, "Error while importing targets", http.StatusInternalServerError)
+			return
+		}
+
+		generateJSONResponse(writer, req, report)
+	}
+}
+
 func httpHandleAPITargetsUpdate() http.HandlerFunc {
 	return func(writer http.ResponseWriter, req *http.Request) {
 		log.Debugf("httpHandleAPITargetsUpdate: Received API 'targets' request, method: '%v'", req.Method)
@@ -659,6 +964,9 @@ func httpHandleAPITargetsUpdate() http.HandlerFunc {
 			http.Error(writer, "Couldn't decode request body", http.StatusBadRequest)
 			return
 		}
+		if !checkTargetScope(writer, req, target.ID) {
+			return
+		}
 
 		_, err := disttrace.UpdateTarget(db, target)
 		if err != nil {
@@ -683,9 +991,9 @@ func httpHandleAPITargetsDelete() http.HandlerFunc {
 			return
 		}
 
-		if err = disttrace.DeleteTarget(db, targetID); err != nil {
-			log.Warn("httpHandleAPITargetsDelete: Error while deleting target, Error: ", err)
-			http.Error(writer, "Error while deleting target", http.StatusInternalServerError)
+		if err = disttrace.ArchiveTarget(db, targetID); err != nil {
+			log.Warn("httpHandleAPITargetsDelete: Error while archiving target, Error: ", err)
+			http.Error(writer, "Error while archiving target", http.StatusInternalServerError)
 			return
 		}
 
@@ -693,6 +1001,61 @@ func httpHandleAPITargetsDelete() http.HandlerFunc {
 	}
 }
 
+func httpHandleAPITargetsRestore() http.HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		vars := mux.Vars(req)
+		log.Debugf("httpHandleAPITargetsRestore: Received API 'targets' request, method: '%v', ID: '%v'", req.Method, vars["targetID"])
+
+		targetID, err := uuid.Parse(vars["targetID"])
+		if err != nil {
+			log.Debugf("httpHandleAPITargetsRestore: Received restore request for invalid target, ID: '%v', Error: %v", targetID, err)
+			http.Error(writer, "Received restore request for invalid target", http.StatusBadRequest)
+			return
+		}
+		if !checkTargetScope(writer, req, targetID) {
+			return
+		}
+
+		if err = disttrace.RestoreTarget(db, targetID); err == disttrace.ErrNotArchived {
+			http.Error(writer, "Target isn't archived or is already being purged", http.StatusConflict)
+			return
+		} else if err != nil {
+			log.Warn("httpHandleAPITargetsRestore: Error while restoring target, Error: ", err)
+			http.Error(writer, "Error while restoring target", http.StatusInternalServerError)
+			return
+		}
+
+		generateJSONResponse(writer, req, disttrace.TraceTarget{ID: targetID})
+	}
+}
+
+func httpHandleAPITargetsPurge() http.HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		vars := mux.Vars(req)
+		log.Debugf("httpHandleAPITargetsPurge: Received API 'targets' request, method: '%v', ID: '%v'", req.Method, vars["targetID"])
+
+		targetID, err := uuid.Parse(vars["targetID"])
+		if err != nil {
+			log.Debugf("httpHandleAPITargetsPurge: Received purge request for invalid target, ID: '%v', Error: %v", targetID, err)
+			http.Error(writer, "Received purge request for invalid target", http.StatusBadRequest)
+			return
+		}
+
+		if err = disttrace.PurgeTarget(db, targetID); err == disttrace.ErrNotArchived {
+			http.Error(writer, "Target isn't archived or is already being purged", http.StatusConflict)
+			return
+		} else if err != nil {
+			log.Warn("httpHandleAPITargetsPurge: Error while purging target, Error: ", err)
+			http.Error(writer, "Error while purging target", http.StatusInternalServerError)
+			return
+		}
+
+		// HTTP 202 Accepted, the purge runs in the background
+		writer.WriteHeader(http.StatusAccepted)
+		generateJSONResponse(writer, req, disttrace.TraceTarget{ID: targetID})
+	}
+}
+
 func httpHandleAPIUsersList() http.HandlerFunc {
 	return func(writer http.ResponseWriter, req *http.Request) {
 		log.Debug("httpHandleAPIUsersList: Received API 'users' request, method: ", req.Method)
@@ -713,6 +1076,7 @@ func httpHandleAPIUsersCreate() http.HandlerFunc {
 		name := req.URL.Query().Get("name")
 		password := req.URL.Query().Get("password")
 		pwNeedsChange, _ := strconv.ParseBool(req.URL.Query().Get("pwNeedsChange"))
+		role := req.URL.Query().Get("role")
 
 		log.Debug("httpHandleAPIUsersCreate: Received API 'users' request, method: ", req.Method)
 
@@ -722,14 +1086,30 @@ func httpHandleAPIUsersCreate() http.HandlerFunc {
 			return
 		}
 
+		targetGroups := []uuid.UUID{}
+		for _, val := range queryList(req.URL.Query(), "targetGroup") {
+			groupID, err := uuid.Parse(val)
+			if err != nil {
+				log.Debugf("httpHandleAPIUsersCreate: Invalid target group: '%v', returning bad request", val)
+				http.Error(writer, "Invalid target group", http.StatusBadRequest)
+				return
+			}
+			targetGroups = append(targetGroups, groupID)
+		}
+
 		user := disttrace.User{
 			Name:                name,
 			Password:            []byte(password),
 			PasswordNeedsChange: pwNeedsChange,
+			Role:                role,
+			TargetGroups:        targetGroups,
 		}
 
 		newUser, err := disttrace.CreateUser(db, user)
-		if err != nil {
+		if err == disttrace.ErrInvalidRole {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		} else if err != nil {
 			log.Warn("httpHandleAPIUsersCreate: Error while creating user, Error: ", err)
 			http.Error(writer, "Error while creating user", http.StatusInternalServerError)
 			return
@@ -753,14 +1133,22 @@ func httpHandleAPIUsersUpdate() http.HandlerFunc {
 			return
 		}
 
-		_, err := disttrace.UpdateUser(db, user)
-		if err != nil {
+		updatedUser, err := disttrace.UpdateUser(db, user)
+		switch err {
+		case nil:
+		case disttrace.ErrInvalidRole:
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		case disttrace.ErrLastAdmin:
+			http.Error(writer, err.Error(), http.StatusConflict)
+			return
+		default:
 			log.Warn("httpHandleAPIUsersUpdate: Error while updating user, Error: ", err)
 			http.Error(writer, "Error while updating user", http.StatusInternalServerError)
 			return
 		}
 
-		generateJSONResponse(writer, req, user)
+		generateJSONResponse(writer, req, updatedUser)
 	}
 }
 
@@ -776,7 +1164,10 @@ func httpHandleAPIUsersDelete() http.HandlerFunc {
 			return
 		}
 
-		if err = disttrace.DeleteUser(db, userID); err != nil {
+		if err = disttrace.DeleteUser(db, userID); err == disttrace.ErrLastAdmin {
+			http.Error(writer, err.Error(), http.StatusConflict)
+			return
+		} else if err != nil {
 			log.Warn("httpHandleAPIUsersDelete: Error while deleting user, Error: ", err)
 			http.Error(writer, "Error while deleting user", http.StatusInternalServerError)
 			return
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/xmirakulix/dist-traceroute/disttrace"
+)
+
+func httpHandleAPIAlertsList() http.HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		state := req.URL.Query().Get("state")
+		limit, _ := strconv.Atoi(req.URL.Query().Get("limit"))
+
+		log.Debugf("httpHandleAPIAlertsList: Received API 'alerts' request, state: <%v>, limit: <%v>", state, limit)
+
+		if state != "" && state != disttrace.AlertStateFiring && state != disttrace.AlertStateResolved && state != disttrace.AlertStateAcknowledged {
+			log.Debugf("httpHandleAPIAlertsList: Invalid state '%v', returning bad request", state)
+			http.Error(writer, "Invalid state", http.StatusBadRequest)
+			return
+		}
+
+		// users restricted to target groups only see alerts of rules for their targets
+		scope, ok := targetScope(writer, req)
+		if !ok {
+			return
+		}
+
+		alerts, err := disttrace.GetAlertHistory(db, state, limit, scope)
+		if err != nil {
+			log.Warn("httpHandleAPIAlertsList: Error: Couldn't get alerts from db, Error: ", err)
+			http.Error(writer, "Couldn't get alerts from db", http.StatusInternalServerError)
+			return
+		}
+
+		generateJSONResponse(writer, req, alerts)
+	}
+}
+
+func httpHandleAPIAlertsAcknowledge() http.HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		vars := mux.Vars(req)
+		log.Debugf("httpHandleAPIAlertsAcknowledge: Received API 'alerts' request, method: '%v', ID: '%v'", req.Method, vars["alertID"])
+
+		alertID, err := uuid.Parse(vars["alertID"])
+		if err != nil {
+			log.Debugf("httpHandleAPIAlertsAcknowledge: Received acknowledge request for invalid alert, ID: '%v', Error: %v", alertID, err)
+			http.Error(writer, "Received acknowledge request for invalid alert", http.StatusBadRequest)
+			return
+		}
+
+		alert, err := disttrace.GetAlert(alertID, db)
+		if err != nil {
+			log.Warn("httpHandleAPIAlertsAcknowledge: Error while getting alert, Error: ", err)
+			http.Error(writer, "Error while getting alert", http.StatusInternalServerError)
+			return
+		}
+		if !checkAlertRuleScope(writer, req, alert.RuleID) {
+			return
+		}
+
+		alert, err = disttrace.AcknowledgeAlert(db, alertID, authClaimsFromRequest(req).Username)
+		if err != nil {
+			log.Warn("httpHandleAPIAlertsAcknowledge: Error while acknowledging alert, Error: ", err)
+			http.Error(writer, "Error while acknowledging alert", http.StatusConflict)
+			return
+		}
+
+		generateJSONResponse(writer, req, alert)
+	}
+}
+
+func httpHandleAPIAlertRulesList() http.HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		log.Debug("httpHandleAPIAlertRulesList: Received API 'alert rules' request, method: ", req.Method)
+
+		rules, err := disttrace.GetAlertRules(db)
+		if err != nil {
+			log.Warn("httpHandleAPIAlertRulesList: Error: Couldn't get alert rules from db, Error: ", err)
+			http.Error(writer, "Couldn't get alert rules from db", http.StatusInternalServerError)
+			return
+		}
+
+		scope, ok := targetScope(writer, req)
+		if !ok {
+			return
+		}
+		if scope != nil {
+			scoped := []disttrace.AlertRule{}
+			for _, rule := range rules {
+				if scope.Allows(rule.TargetID) {
+					scoped = append(scoped, rule)
+				}
+			}
+			rules = scoped
+		}
+
+		generateJSONResponse(writer, req, rules)
+	}
+}
+
+// decodeAlertRule reads and validates an alert rule from the request body
+func decodeAlertRule(req *http.Request) (disttrace.AlertRule, error) {
+
+	var rule disttrace.AlertRule
+	decoder := json.NewDecoder(req.Body)
+	if err := decoder.Decode(&rule); err != nil {
+		return rule, err
+	}
+
+	return rule, disttrace.ValidateAlertRule(rule)
+}
+
+// checkAlertRuleScope replies with forbidden if the user of the request may not access the target of the alert rule.
+// Users restricted to target groups may not access alerts and rules without a target.
+func checkAlertRuleScope(writer http.ResponseWriter, req *http.Request, ruleID uuid.UUID) bool {
+
+	scope, ok := targetScope(writer, req)
+	if !ok || scope == nil {
+		return ok
+	}
+
+	rule, err := disttrace.GetAlertRule(ruleID, db)
+	if err != nil {
+		http.Error(writer, "Error while getting alert rule", http.StatusInternalServerError)
+		return false
+	}
+	return checkTargetScope(writer, req, rule.TargetID)
+}
+
+func httpHandleAPIAlertRulesCreate() http.HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		log.Debug("httpHandleAPIAlertRulesCreate: Received API 'alert rules' request, method: ", req.Method)
+
+		rule, err := decodeAlertRule(req)
+		if err != nil {
+			log.Debug("httpHandleAPIAlertRulesCreate: Invalid alert rule in request body, Error: ", err)
+			http.Error(writer, "Invalid alert rule: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if !checkTargetScope(writer, req, rule.TargetID) {
+			return
+		}
+
+		newRule, err := disttrace.CreateAlertRule(db, rule)
+		if err != nil {
+			log.Warn("httpHandleAPIAlertRulesCreate: Error while creating alert rule, Error: ", err)
+			http.Error(writer, "Error while creating alert rule", http.StatusInternalServerError)
+			return
+		}
+
+		// HTTP 201 Created
+		writer.WriteHeader(201)
+		generateJSONResponse(writer, req, newRule)
+	}
+}
+
+func httpHandleAPIAlertRulesUpdate() http.HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		log.Debugf("httpHandleAPIAlertRulesUpdate: Received API 'alert rules' request, method: '%v'", req.Method)
+
+		rule, err := decodeAlertRule(req)
+		if err != nil {
+			log.Debug("httpHandleAPIAlertRulesUpdate: Invalid alert rule in request body, Error: ", err)
+			http.Error(writer, "Invalid alert rule: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// the rule may neither be taken from nor moved to an inaccessible target
+		if !checkAlertRuleScope(writer, req, rule.ID) || !checkTargetScope(writer, req, rule.TargetID) {
+			return
+		}
+
+		if _, err := disttrace.UpdateAlertRule(db, rule); err == disttrace.ErrAlertRuleNotFound {
+			http.Error(writer, "Alert rule doesn't exist", http.StatusNotFound)
+			return
+		} else if err != nil {
+			log.Warn("httpHandleAPIAlertRulesUpdate: Error while updating alert rule, Error: ", err)
+			http.Error(writer, "Error while updating alert rule", http.StatusInternalServerError)
+			return
+		}
+
+		generateJSONResponse(writer, req, rule)
+	}
+}
+
+func httpHandleAPIAlertRulesDelete() http.HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		vars := mux.Vars(req)
+		log.Debugf("httpHandleAPIAlertRulesDelete: Received API 'alert rules' request, method: '%v', ID: '%v'", req.Method, vars["ruleID"])
+
+		ruleID, err := uuid.Parse(vars["ruleID"])
+		if err != nil {
+			log.Debugf("httpHandleAPIAlertRulesDelete: Received delete request for invalid alert rule, ID: '%v', Error: %v", ruleID, err)
+			http.Error(writer, "Received delete request for invalid alert rule", http.StatusBadRequest)
+			return
+		}
+
+		if !checkAlertRuleScope(writer, req, ruleID) {
+			return
+		}
+
+		if err = disttrace.DeleteAlertRule(db, ruleID); err != nil {
+			log.Warn("httpHandleAPIAlertRulesDelete: Error while deleting alert rule, Error: ", err)
+			http.Error(writer, "Error while deleting alert rule", http.StatusInternalServerError)
+			return
+		}
+
+		generateJSONResponse(writer, req, disttrace.AlertRule{ID: ruleID})
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/xmirakulix/dist-traceroute/disttrace"
+)
+
+// eventHeartbeatInterval is the time between comments sent to keep idle event streams and proxies alive
+const eventHeartbeatInterval = 15 * time.Second
+
+// httpHandleAPIEvents streams live events as Server-Sent Events until the client disconnects
+func httpHandleAPIEvents() http.HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+
+		flusher, ok := writer.(http.Flusher)
+		if !ok {
+			log.Warn("httpHandleAPIEvents: Response writer doesn't support streaming")
+			http.Error(writer, "Streaming not supported", http.StatusInternalServerError)
+			return
+		}
+
+		scope, ok := targetScope(writer, req)
+		if !ok {
+			return
+		}
+
+		query := req.URL.Query()
+		filter := disttrace.EventFilter{
+			Types:   queryList(query, "type"),
+			Slaves:  queryList(query, "slave"),
+			Targets: queryList(query, "target"),
+			Scope:   scope,
+		}
+
+		sub, err := disttrace.SubscribeEvents(db, filter)
+		switch err {
+		case nil:
+		case disttrace.ErrUnknownEventType, disttrace.ErrUnknownSlave, disttrace.ErrUnknownTarget:
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		default:
+			http.Error(writer, "Couldn't subscribe to events", http.StatusInternalServerError)
+			return
+		}
+		defer sub.Close()
+
+		writer.Header().Set("Content-Type", "text/event-stream")
+		writer.Header().Set("Cache-Control", "no-cache")
+		writer.Header().Set("X-Accel-Buffering", "no")
+		writer.WriteHeader(http.StatusOK)
+
+		send := func(message string) bool {
+			extendWriteDeadline(req)
+			if _, err := io.WriteString(writer, message); err != nil {
+				log.Debug("httpHandleAPIEvents: Couldn't write to client, Error: ", err)
+				return false
+			}
+			flusher.Flush()
+			return true
+		}
+
+		// clients reconnect after 5s if the stream breaks
+		if !send("retry: 5000\n\n") {
+			return
+		}
+
+		heartbeat := time.NewTicker(eventHeartbeatInterval)
+		defer heartbeat.Stop()
+		quit := time.NewTicker(1 * time.Second)
+		defer quit.Stop()
+
+		for {
+			select {
+			case <-req.Context().Done():
+				return
+
+			case event, ok := <-sub.Events:
+				if !ok {
+					log.Warnf("httpHandleAPIEvents: Subscription of '%v' was dropped, closing stream", req.RemoteAddr)
+					return
+				}
+				data, err := json.Marshal(event)
+				if err != nil {
+					log.Warn("httpHandleAPIEvents: Couldn't marshal event, Error: ", err)
+					continue
+				}
+				if !send(fmt.Sprintf("id: %v\nevent: %v\ndata: %s\n\n", event.ID, event.Type, data)) {
+					return
+				}
+
+			case <-heartbeat.C:
+				if !send(": ping\n\n") {
+					return
+				}
+
+			case <-quit.C:
+				if disttrace.CheckForQuit() {
+					return
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	valid "github.com/asaskevich/govalidator"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/xmirakulix/dist-traceroute/disttrace"
+)
+
+func httpHandleAPITargetGroupsList() http.HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		log.Debug("httpHandleAPITargetGroupsList: Received API 'target groups' request, method: ", req.Method)
+
+		groups, err := disttrace.GetTargetGroups(db)
+		if err != nil {
+			log.Warn("httpHandleAPITargetGroupsList: Error: Couldn't get target groups from db, Error: ", err)
+			http.Error(writer, "Couldn't get target groups from db", http.StatusInternalServerError)
+			return
+		}
+
+		scope, ok := targetScope(writer, req)
+		if !ok {
+			return
+		}
+		if scope != nil {
+			// users restricted to target groups only see their own groups
+			scoped := []disttrace.TargetGroup{}
+			for _, group := range groups {
+				if inUserTargetGroups(req, group.ID) {
+					scoped = append(scoped, group)
+				}
+			}
+			groups = scoped
+		}
+
+		generateJSONResponse(writer, req, groups)
+	}
+}
+
+// decodeTargetGroup reads and validates a target group from the request body
+func decodeTargetGroup(req *http.Request) (disttrace.TargetGroup, error) {
+
+	var group disttrace.TargetGroup
+	decoder := json.NewDecoder(req.Body)
+	if err := decoder.Decode(&group); err != nil {
+		return group, err
+	}
+
+	if ok, err := valid.ValidateStruct(group); !ok || err != nil {
+		return group, err
+	}
+
+	return group, nil
+}
+
+func httpHandleAPITargetGroupsCreate() http.HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		log.Debug("httpHandleAPITargetGroupsCreate: Received API 'target groups' request, method: ", req.Method)
+
+		if !checkUnscoped(writer, req) {
+			return
+		}
+
+		group, err := decodeTargetGroup(req)
+		if err != nil {
+			log.Debug("httpHandleAPITargetGroupsCreate: Invalid target group in request body, Error: ", err)
+			http.Error(writer, "Invalid target group: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		newGroup, err := disttrace.CreateTargetGroup(db, group)
+		if err != nil {
+			log.Warn("httpHandleAPITargetGroupsCreate: Error while creating target group, Error: ", err)
+			http.Error(writer, "Error while creating target group", http.StatusInternalServerError)
+			return
+		}
+
+		// HTTP 201 Created
+		writer.WriteHeader(201)
+		generateJSONResponse(writer, req, newGroup)
+	}
+}
+
+func httpHandleAPITargetGroupsUpdate() http.HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		log.Debugf("httpHandleAPITargetGroupsUpdate: Received API 'target groups' request, method: '%v'", req.Method)
+
+		if !checkUnscoped(writer, req) {
+			return
+		}
+
+		group, err := decodeTargetGroup(req)
+		if err != nil {
+			log.Debug("httpHandleAPITargetGroupsUpdate: Invalid target group in request body, Error: ", err)
+			http.Error(writer, "Invalid target group: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if _, err := disttrace.UpdateTargetGroup(db, group); err != nil {
+			log.Warn("httpHandleAPITargetGroupsUpdate: Error while updating target group, Error: ", err)
+			http.Error(writer, "Error while updating target group", http.StatusInternalServerError)
+			return
+		}
+
+		generateJSONResponse(writer, req, group)
+	}
+}
+
+func httpHandleAPITargetGroupsDelete() http.HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		vars := mux.Vars(req)
+		log.Debugf("httpHandleAPITargetGroupsDelete: Received API 'target groups' request, method: '%v', ID: '%v'", req.Method, vars["groupID"])
+
+		groupID, err := uuid.Parse(vars["groupID"])
+		if err != nil {
+			log.Debugf("httpHandleAPITargetGroupsDelete: Received delete request for invalid target group, ID: '%v', Error: %v", groupID, err)
+			http.Error(writer, "Received delete request for invalid target group", http.StatusBadRequest)
+			return
+		}
+
+		if !checkUnscoped(writer, req) {
+			return
+		}
+
+		if err = disttrace.DeleteTargetGroup(db, groupID); err != nil {
+			log.Warn("httpHandleAPITargetGroupsDelete: Error while deleting target group, Error: ", err)
+			http.Error(writer, "Error while deleting target group", http.StatusInternalServerError)
+			return
+		}
+
+		generateJSONResponse(writer, req, disttrace.TargetGroup{ID: groupID})
+	}
+}
+
+func httpHandleAPISlaveGroupsList() http.HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		log.Debug("httpHandleAPISlaveGroupsList: Received API 'slave groups' request, method: ", req.Method)
+
+		groups, err := disttrace.GetSlaveGroups(db)
+		if err != nil {
+			log.Warn("httpHandleAPISlaveGroupsList: Error: Couldn't get slave groups from db, Error: ", err)
+			http.Error(writer, "Couldn't get slave groups from db", http.StatusInternalServerError)
+			return
+		}
+
+		generateJSONResponse(writer, req, groups)
+	}
+}
+
+// decodeSlaveGroup reads and validates a slave group from the request body
+func decodeSlaveGroup(req *http.Request) (disttrace.SlaveGroup, error) {
+
+	var group disttrace.SlaveGroup
+	decoder := json.NewDecoder(req.Body)
+	if err := decoder.Decode(&group); err != nil {
+		return group, err
+	}
+
+	if ok, err := valid.ValidateStruct(group); !ok || err != nil {
+		return group, err
+	}
+
+	return group, nil
+}
+
+func httpHandleAPISlaveGroupsCreate() http.HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		log.Debug("httpHandleAPISlaveGroupsCreate: Received API 'slave groups' request, method: ", req.Method)
+
+		group, err := decodeSlaveGroup(req)
+		if err != nil {
+			log.Debug("httpHandleAPISlaveGroupsCreate: Invalid slave group in request body, Error: ", err)
+			http.Error(writer, "Invalid slave group: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		newGroup, err := disttrace.CreateSlaveGroup(db, group)
+		if err != nil {
+			log.Warn("httpHandleAPISlaveGroupsCreate: Error while creating slave group, Error: ", err)
+			http.Error(writer, "Error while creating slave group", http.StatusInternalServerError)
+			return
+		}
+
+		// HTTP 201 Created
+		writer.WriteHeader(201)
+		generateJSONResponse(writer, req, newGroup)
+	}
+}
+
+func httpHandleAPISlaveGroupsUpdate() http.HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		log.Debugf("httpHandleAPISlaveGroupsUpdate: Received API 'slave groups' request, method: '%v'", req.Method)
+
+		group, err := decodeSlaveGroup(req)
+		if err != nil {
+			log.Debug("httpHandleAPISlaveGroupsUpdate: Invalid slave group in request body, Error: ", err)
+			http.Error(writer, "Invalid slave group: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if _, err := disttrace.UpdateSlaveGroup(db, group); err != nil {
+			log.Warn("httpHandleAPISlaveGroupsUpdate: Error while updating slave group, Error: ", err)
+			http.Error(writer, "Error while updating slave group", http.StatusInternalServerError)
+			return
+		}
+
+		generateJSONResponse(writer, req, group)
+	}
+}
+
+func httpHandleAPISlaveGroupsDelete() http.HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		vars := mux.Vars(req)
+		log.Debugf("httpHandleAPISlaveGroupsDelete: Received API 'slave groups' request, method: '%v', ID: '%v'", req.Method, vars["groupID"])
+
+		groupID, err := uuid.Parse(vars["groupID"])
+		if err != nil {
+			log.Debugf("httpHandleAPISlaveGroupsDelete: Received delete request for invalid slave group, ID: '%v', Error: %v", groupID, err)
+			http.Error(writer, "Received delete request for invalid slave group", http.StatusBadRequest)
+			return
+		}
+
+		if err = disttrace.DeleteSlaveGroup(db, groupID); err != nil {
+			log.Warn("httpHandleAPISlaveGroupsDelete: Error while deleting slave group, Error: ", err)
+			http.Error(writer, "Error while deleting slave group", http.StatusInternalServerError)
+			return
+		}
+
+		generateJSONResponse(writer, req, disttrace.SlaveGroup{ID: groupID})
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/xmirakulix/dist-traceroute/disttrace"
+)
+
+func httpHandleAPINotificationChannelsList() http.HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		log.Debug("httpHandleAPINotificationChannelsList: Received API 'notification channels' request, method: ", req.Method)
+
+		channels, err := disttrace.GetNotificationChannels(db)
+		if err != nil {
+			log.Warn("httpHandleAPINotificationChannelsList: Error: Couldn't get notification channels from db, Error: ", err)
+			http.Error(writer, "Couldn't get notification channels from db", http.StatusInternalServerError)
+			return
+		}
+
+		// only admins may see the credentials of the channels
+		if !disttrace.HasRole(authClaimsFromRequest(req).Role, disttrace.RoleAdmin) {
+			for i := range channels {
+				channels[i].RedactSecrets()
+			}
+		}
+
+		generateJSONResponse(writer, req, channels)
+	}
+}
+
+// decodeNotificationChannel reads and validates a notification channel from the request body
+func decodeNotificationChannel(req *http.Request) (disttrace.NotificationChannel, error) {
+
+	var channel disttrace.NotificationChannel
+	decoder := json.NewDecoder(req.Body)
+	if err := decoder.Decode(&channel); err != nil {
+		return channel, err
+	}
+
+	if ok, err := disttrace.ValidateNotificationChannel(channel); !ok || err != nil {
+		return channel, err
+	}
+
+	return channel, nil
+}
+
+func httpHandleAPINotificationChannelsCreate() http.HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		log.Debug("httpHandleAPINotificationChannelsCreate: Received API 'notification channels' request, method: ", req.Method)
+
+		channel, err := decodeNotificationChannel(req)
+		if err != nil {
+			log.Debug("httpHandleAPINotificationChannelsCreate: Invalid notification channel in request body, Error: ", err)
+			http.Error(writer, "Invalid notification channel: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		newChannel, err := disttrace.CreateNotificationChannel(db, channel)
+		if err != nil {
+			log.Warn("httpHandleAPINotificationChannelsCreate: Error while creating notification channel, Error: ", err)
+			http.Error(writer, "Error while creating notification channel", http.StatusInternalServerError)
+			return
+		}
+
+		// HTTP 201 Created
+		writer.WriteHeader(201)
+		generateJSONResponse(writer, req, newChannel)
+	}
+}
+
+func httpHandleAPINotificationChannelsUpdate() http.HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		log.Debugf("httpHandleAPINotificationChannelsUpdate: Received API 'notification channels' request, method: '%v'", req.Method)
+
+		channel, err := decodeNotificationChannel(req)
+		if err != nil {
+			log.Debug("httpHandleAPINotificationChannelsUpdate: Invalid notification channel in request body, Error: ", err)
+			http.Error(writer, "Invalid notification channel: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if _, err := disttrace.UpdateNotificationChannel(db, channel); err != nil {
+			log.Warn("httpHandleAPINotificationChannelsUpdate: Error while updating notification channel, Error: ", err)
+			http.Error(writer, "Error while updating notification channel", http.StatusInternalServerError)
+			return
+		}
+
+		generateJSONResponse(writer, req, channel)
+	}
+}
+
+func httpHandleAPINotificationChannelsDelete() http.HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		vars := mux.Vars(req)
+		log.Debugf("httpHandleAPINotificationChannelsDelete: Received API 'notification channels' request, method: '%v', ID: '%v'", req.Method, vars["channelID"])
+
+		channelID, err := uuid.Parse(vars["channelID"])
+		if err != nil {
+			log.Debugf("httpHandleAPINotificationChannelsDelete: Received delete request for invalid notification channel, ID: '%v', Error: %v", channelID, err)
+			http.Error(writer, "Received delete request for invalid notification channel", http.StatusBadRequest)
+			return
+		}
+
+		if err = disttrace.DeleteNotificationChannel(db, channelID); err != nil {
+			log.Warn("httpHandleAPINotificationChannelsDelete: Error while deleting notification channel, Error: ", err)
+			http.Error(writer, "Error while deleting notification channel", http.StatusInternalServerError)
+			return
+		}
+
+		generateJSONResponse(writer, req, disttrace.NotificationChannel{ID: channelID})
+	}
+}
+
+func httpHandleAPINotificationChannelsTest() http.HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		vars := mux.Vars(req)
+		log.Debugf("httpHandleAPINotificationChannelsTest: Received API 'notification channels' request, method: '%v', ID: '%v'", req.Method, vars["channelID"])
+
+		channelID, err := uuid.Parse(vars["channelID"])
+		if err != nil {
+			log.Debugf("httpHandleAPINotificationChannelsTest: Received test request for invalid notification channel, ID: '%v', Error: %v", channelID, err)
+			http.Error(writer, "Received test request for invalid notification channel", http.StatusBadRequest)
+			return
+		}
+
+		channel, err := disttrace.GetNotificationChannel(channelID, db)
+		if err != nil {
+			log.Warn("httpHandleAPINotificationChannelsTest: Couldn't get notification channel from db, Error: ", err)
+			http.Error(writer, "Couldn't get notification channel from db", http.StatusInternalServerError)
+			return
+		} else if channel.ID == uuid.Nil {
+			log.Debugf("httpHandleAPINotificationChannelsTest: Notification channel '%v' doesn't exist", channelID)
+			http.Error(writer, "Notification channel doesn't exist", http.StatusNotFound)
+			return
+		}
+
+		// reply with outcome of sending
+		response := disttrace.SubmitResult{Success: true}
+		if err := disttrace.SendTestNotification(channel); err != nil {
+			response = disttrace.SubmitResult{Success: false, Error: err.Error(), RetryPossible: true}
+		}
+
+		generateJSONResponse(writer, req, response)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/xmirakulix/dist-traceroute/disttrace"
+)
+
+func httpHandleSlaveTelemetry() http.HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		log.Debug("httpHandleSlaveTelemetry: Received telemetry, URL: ", req.URL)
+
+		// decode request
+		report := disttrace.SlaveTelemetry{}
+		if err := json.NewDecoder(req.Body).Decode(&report); err != nil || report.Slave == nil {
+			log.Warn("httpHandleSlaveTelemetry: Couldn't decode request body into telemetry: ", err)
+			http.Error(writer, "Couldn't decode request body", http.StatusBadRequest)
+			return
+		}
+
+		// check authorization
+		auth, slaveID := checkSlaveCredentials(report.Slave, writer, req)
+		if !auth {
+			return
+		}
+
+		if _, err := disttrace.StoreSlaveTelemetry(db, slaveID, report); err != nil {
+			log.Warn("httpHandleSlaveTelemetry: Couldn't store telemetry, Error: ", err)
+			http.Error(writer, "Database error", http.StatusInternalServerError)
+			return
+		}
+
+		log.Debugf("httpHandleSlaveTelemetry: Stored telemetry of slave '%v', queue: %v, dropped: %v, measurements: %v",
+			report.Slave.Name, report.QueueDepth, report.DroppedResults, report.Measurements)
+
+		// reply with success and current time for measuring clock offset
+		response := disttrace.TelemetryReply{
+			SubmitResult: disttrace.SubmitResult{Success: true, RetryPossible: true},
+			MasterTime:   time.Now(),
+		}
+
+		generateJSONResponse(writer, req, response)
+	}
+}
+
+// parseTimeRange reads the 'from' and 'to' query parameters as RFC3339 timestamps, missing values default to the given period until now
+func parseTimeRange(req *http.Request, defaultPeriod time.Duration) (from time.Time, to time.Time, err error) {
+
+	to = time.Now()
+	if val := req.URL.Query().Get("to"); val != "" {
+		if to, err = time.Parse(time.RFC3339, val); err != nil {
+			return
+		}
+	}
+
+	from = to.Add(-defaultPeriod)
+	if val := req.URL.Query().Get("from"); val != "" {
+		if from, err = time.Parse(time.RFC3339, val); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+func httpHandleAPISlaveTelemetry() http.HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		vars := mux.Vars(req)
+		log.Debugf("httpHandleAPISlaveTelemetry: Received API 'telemetry' request, slave: '%v'", vars["slaveID"])
+
+		slaveID, err := uuid.Parse(vars["slaveID"])
+		if err != nil {
+			log.Debugf("httpHandleAPISlaveTelemetry: Received request for invalid slave, ID: '%v', Error: %v", vars["slaveID"], err)
+			http.Error(writer, "Received request for invalid slave", http.StatusBadRequest)
+			return
+		}
+
+		from, to, err := parseTimeRange(req, 24*time.Hour)
+		if err != nil {
+			log.Debug("httpHandleAPISlaveTelemetry: Invalid time range, Error: ", err)
+			http.Error(writer, "Invalid time range, use RFC3339 timestamps", http.StatusBadRequest)
+			return
+		}
+
+		reports, err := disttrace.GetSlaveTelemetry(db, slaveID, from, to)
+		if err != nil {
+			log.Warn("httpHandleAPISlaveTelemetry: Couldn't get telemetry from db, Error: ", err)
+			http.Error(writer, "Couldn't get telemetry from db", http.StatusInternalServerError)
+			return
+		}
+
+		generateJSONResponse(writer, req, reports)
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xmirakulix/dist-traceroute/disttrace"
+)
+
+func httpHandleAPITraceroutes() http.HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		log.Debug("httpHandleAPITraceroutes: Received API 'traceroutes' request, URL: ", req.URL)
+
+		filter, err := parseTracerouteFilter(req)
+		if err != nil {
+			log.Debug("httpHandleAPITraceroutes: Invalid query, Error: ", err)
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var ok bool
+		if filter.Scope, ok = targetScope(writer, req); !ok {
+			return
+		}
+
+		page, err := disttrace.QueryTraceroutes(db, filter)
+		if err == disttrace.ErrInvalidCursor || err == disttrace.ErrNoASNDatabase {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		} else if err != nil {
+			log.Warn("httpHandleAPITraceroutes: Couldn't get traceroutes, Error: ", err)
+			http.Error(writer, "Couldn't get traceroutes", http.StatusInternalServerError)
+			return
+		}
+
+		generateJSONResponse(writer, req, page)
+	}
+}
+
+func httpHandleAPITracerouteExport() http.HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		log.Debug("httpHandleAPITracerouteExport: Received API 'traceroute export' request, URL: ", req.URL)
+
+		filter, err := parseTracerouteFilter(req)
+		if err != nil {
+			log.Debug("httpHandleAPITracerouteExport: Invalid query, Error: ", err)
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var ok bool
+		if filter.Scope, ok = targetScope(writer, req); !ok {
+			return
+		}
+
+		format := req.URL.Query().Get("format")
+		if format == "" {
+			format = disttrace.TracerouteExportCSV
+		}
+
+		// nothing is written before the first page of traceroutes is read, errors can still be reported. Large exports
+		// take longer than the write timeout, it's extended as long as data is sent.
+		writer.Header().Set("Content-Type", disttrace.TracerouteExportContentType(format))
+		writer.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"traceroutes.%v\"", format))
+		count, err := disttrace.ExportTraceroutes(db, filter, format, deadlineWriter{writer, req})
+		switch {
+		case err == disttrace.ErrUnknownExportFormat:
+			writer.Header().Del("Content-Disposition")
+			http.Error(writer, "Invalid parameter 'format', expected one of csv, ndjson or parquet", http.StatusBadRequest)
+		case err == disttrace.ErrNoASNDatabase:
+			writer.Header().Del("Content-Disposition")
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+		case err != nil && count == 0:
+			writer.Header().Del("Content-Disposition")
+			log.Warn("httpHandleAPITracerouteExport: Couldn't export traceroutes, Error: ", err)
+			http.Error(writer, "Couldn't export traceroutes", http.StatusInternalServerError)
+		case err != nil:
+			// the response is already sent, the client gets a truncated file
+			log.Warnf("httpHandleAPITracerouteExport: Export aborted after '%v' traceroutes, Error: %v", count, err)
+		}
+	}
+}
+
+// parseTracerouteFilter reads the filter of a traceroute query. slave and target can be given multiple times or as
+// comma separated list, from and to are RFC3339 timestamps, asn is a number with an optional 'AS' prefix.
+func parseTracerouteFilter(req *http.Request) (disttrace.TracerouteFilter, error) {
+
+	query := req.URL.Query()
+	filter := disttrace.TracerouteFilter{Cursor: query.Get("cursor")}
+
+	filter.Slaves, filter.Targets = queryList(query, "slave"), queryList(query, "target")
+
+	var err error
+	if val := query.Get("from"); val != "" {
+		if filter.From, err = time.Parse(time.RFC3339, val); err != nil {
+			return filter, errors.New("Invalid parameter 'from', expected an RFC3339 timestamp")
+		}
+	}
+	if val := query.Get("to"); val != "" {
+		if filter.To, err = time.Parse(time.RFC3339, val); err != nil {
+			return filter, errors.New("Invalid parameter 'to', expected an RFC3339 timestamp")
+		}
+	}
+	if val := query.Get("success"); val != "" {
+		success, err := strconv.ParseBool(val)
+		if err != nil {
+			return filter, errors.New("Invalid parameter 'success', expected true or false")
+		}
+		filter.Success = &success
+	}
+	if val := query.Get("hop"); val != "" {
+		if net.ParseIP(val) == nil {
+			return filter, errors.New("Invalid parameter 'hop', expected an IP address")
+		}
+		filter.HopIP = val
+	}
+	if val := query.Get("asn"); val != "" {
+		val = strings.TrimPrefix(strings.ToUpper(val), "AS")
+		if filter.ASN, err = strconv.Atoi(val); err != nil || filter.ASN < 1 {
+			return filter, errors.New("Invalid parameter 'asn', expected an AS number, e.g. 13335 or AS13335")
+		}
+	}
+	if val := query.Get("limit"); val != "" {
+		if filter.Limit, err = strconv.Atoi(val); err != nil || filter.Limit < 1 || filter.Limit > disttrace.MaxTracerouteLimit {
+			return filter, fmt.Errorf("Invalid parameter 'limit', expected a number from 1 to %v", disttrace.MaxTracerouteLimit)
+		}
+	}
+
+	return filter, nil
+}
+
+func httpHandleAPIGraph() http.HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		log.Debug("httpHandleAPIGraph: Received API 'graph' request, URL: ", req.URL)
+
+		filter, err := parseGraphFilter(req)
+		if err != nil {
+			log.Debug("httpHandleAPIGraph: Invalid query, Error: ", err)
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		format := req.URL.Query().Get("format")
+		switch format {
+		case "", "json", disttrace.GraphExportDOT, disttrace.GraphExportGraphML, disttrace.GraphExportJGF:
+		default:
+			log.Debug("httpHandleAPIGraph: Invalid format: ", format)
+			http.Error(writer, "Invalid parameter 'format', expected one of json, dot, graphml or jgf", http.StatusBadRequest)
+			return
+		}
+
+		graph, err := disttrace.QueryGraph(db, filter)
+		if err == disttrace.ErrUnknownTarget {
+			http.Error(writer, err.Error(), http.StatusNotFound)
+			return
+		} else if err != nil {
+			log.Warn("httpHandleAPIGraph: Couldn't get graph, Error: ", err)
+			http.Error(writer, "Couldn't get graph", http.StatusInternalServerError)
+			return
+		}
+		if !checkTargetScope(writer, req, graph.Target.ID) {
+			return
+		}
+
+		if format == "" || format == "json" {
+			generateJSONResponse(writer, req, graph)
+			return
+		}
+
+		// exports are downloaded as file named after the target
+		writer.Header().Set("Content-Type", disttrace.GraphExportContentType(format))
+		writer.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%v.%v\"", graph.Target.ID, format))
+		if err := disttrace.ExportGraph(writer, graph, format); err != nil {
+			log.Warn("httpHandleAPIGraph: Couldn't export graph, Error: ", err)
+		}
+	}
+}
+
+// parseGraphFilter reads the filter of a graph query. target is required, slave can be given multiple times or as
+// comma separated list, from and to are RFC3339 timestamps.
+func parseGraphFilter(req *http.Request) (disttrace.GraphFilter, error) {
+
+	query := req.URL.Query()
+	filter := disttrace.GraphFilter{Target: strings.TrimSpace(query.Get("target")), Slaves: queryList(query, "slave")}
+	if filter.Target == "" {
+		return filter, errors.New("Missing parameter 'target', expected the ID or name of a target")
+	}
+
+	var err error
+	if val := query.Get("from"); val != "" {
+		if filter.From, err = time.Parse(time.RFC3339, val); err != nil {
+			return filter, errors.New("Invalid parameter 'from', expected an RFC3339 timestamp")
+		}
+	}
+	if val := query.Get("to"); val != "" {
+		if filter.To, err = time.Parse(time.RFC3339, val); err != nil {
+			return filter, errors.New("Invalid parameter 'to', expected an RFC3339 timestamp")
+		}
+	}
+	if val := query.Get("skip"); val != "" {
+		if filter.Skip, err = strconv.Atoi(val); err != nil || filter.Skip < 0 {
+			return filter, errors.New("Invalid parameter 'skip', expected a number of hops")
+		}
+	}
+	if val := query.Get("minCount"); val != "" {
+		if filter.MinCount, err = strconv.ParseInt(val, 10, 64); err != nil || filter.MinCount < 0 {
+			return filter, errors.New("Invalid parameter 'minCount', expected a number of occurrences")
+		}
+	}
+
+	return filter, nil
+}
+
+// queryList returns the values of a query parameter given multiple times or as comma separated list
+func queryList(query url.Values, name string) []string {
+	values := []string{}
+	for _, param := range query[name] {
+		values = append(values, splitList(param)...)
+	}
+	return values
+}
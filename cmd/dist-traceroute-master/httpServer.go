@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"io"
+	"net"
 	"net/http"
 	"os"
 	"time"
@@ -16,41 +17,101 @@ import (
 
 var httpProcQuitDone = make(chan bool, 1)
 
-func httpServer(accessLog string) {
-	var err error
-
-	log.Info("httpServer: Start...")
+// metrics of the http server
+var (
+	metricHTTPResponses = disttrace.NewMetricCounter("disttrace_http_responses_total",
+		"HTTP responses of the master by status code and method.", "code", "method")
+	metricResultsReceived = disttrace.NewMetricCounter("disttrace_results_received_total",
+		"Traceroute results received from slaves and stored in the db.", "slave")
+)
 
-	var accessWriter io.Writer
-	if accessWriter, err = os.OpenFile(accessLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644); err != nil {
-		log.Panicf("httpServer: Can't open access log '%v', Error: %v", accessLog, err)
-	}
+// newRouters returns the routers of the slave requests, the api requests without authentication and the api requests
+// from the webinterface. All api routes have to be documented in the OpenAPI spec.
+func newRouters() (slaveRouter *mux.Router, publicRouter *mux.Router, apiRouter *mux.Router) {
 
 	// handle slaves
-	slaveRouter := mux.NewRouter()
+	slaveRouter = mux.NewRouter()
 	slaveRouter.HandleFunc("/slave/results", httpHandleSlaveResults())
 	slaveRouter.HandleFunc("/slave/config", httpHandleSlaveConfig())
+	slaveRouter.HandleFunc("/slave/telemetry", httpHandleSlaveTelemetry())
+
+	// handle api requests without authentication
+	publicRouter = mux.NewRouter()
+	publicRouter.HandleFunc("/api/auth", httpHandleAPIAuth()).Methods("POST")
+	publicRouter.HandleFunc("/api/auth/refresh", httpHandleAPIAuthRefresh()).Methods("POST")
+	publicRouter.HandleFunc("/api/openapi.json", httpHandleAPIOpenAPI()).Methods("GET")
+
+	// handle api requests from webinterface, every route requires at least the given role
+	apiRouter = mux.NewRouter()
+	apiRouter.HandleFunc("/api/auth/password", httpHandleAPIAuthPassword()).Methods("POST")
+	apiRouter.HandleFunc("/api/auth/logout", httpHandleAPIAuthLogout()).Methods("POST")
+	apiRouter.HandleFunc("/api/status", requireRole(disttrace.RoleViewer, httpHandleAPIStatus()))
+	apiRouter.HandleFunc("/api/traces", requireRole(disttrace.RoleViewer, httpHandleAPITraceHistory()))
+	apiRouter.HandleFunc("/api/graph", requireRole(disttrace.RoleViewer, httpHandleAPIGraphData()))
+	apiRouter.HandleFunc("/api/paths", requireRole(disttrace.RoleViewer, httpHandleAPIPaths())).Methods("GET")
+	apiRouter.HandleFunc("/api/v1/traceroutes", requireRole(disttrace.RoleViewer, httpHandleAPITraceroutes())).Methods("GET")
+	apiRouter.HandleFunc("/api/v1/traceroutes/export", requireRole(disttrace.RoleViewer, httpHandleAPITracerouteExport())).Methods("GET")
+	apiRouter.HandleFunc("/api/v1/graph", requireRole(disttrace.RoleViewer, httpHandleAPIGraph())).Methods("GET")
+	apiRouter.HandleFunc("/api/v1/events", requireRole(disttrace.RoleViewer, httpHandleAPIEvents())).Methods("GET")
+
+	apiRouter.HandleFunc("/api/slaves", requireRole(disttrace.RoleViewer, httpHandleAPISlavesList())).Methods("GET")
+	apiRouter.HandleFunc("/api/slaves", requireRole(disttrace.RoleAdmin, httpHandleAPISlavesCreate())).Methods("POST")
+	apiRouter.HandleFunc("/api/slaves", requireRole(disttrace.RoleAdmin, httpHandleAPISlavesUpdate())).Methods("PUT")
+	apiRouter.HandleFunc("/api/slaves/{slaveID}", requireRole(disttrace.RoleAdmin, httpHandleAPISlavesDelete())).Methods("DELETE")
+	apiRouter.HandleFunc("/api/slaves/{slaveID}/restore", requireRole(disttrace.RoleAdmin, httpHandleAPISlavesRestore())).Methods("POST")
+	apiRouter.HandleFunc("/api/slaves/{slaveID}/purge", requireRole(disttrace.RoleAdmin, httpHandleAPISlavesPurge())).Methods("POST")
+	apiRouter.HandleFunc("/api/slaves/{slaveID}/telemetry", requireRole(disttrace.RoleViewer, httpHandleAPISlaveTelemetry())).Methods("GET")
+
+	apiRouter.HandleFunc("/api/users", requireRole(disttrace.RoleAdmin, httpHandleAPIUsersList())).Methods("GET")
+	apiRouter.HandleFunc("/api/users", requireRole(disttrace.RoleAdmin, httpHandleAPIUsersCreate())).Methods("POST")
+	apiRouter.HandleFunc("/api/users", requireRole(disttrace.RoleAdmin, httpHandleAPIUsersUpdate())).Methods("PUT")
+	apiRouter.HandleFunc("/api/users/{userID}", requireRole(disttrace.RoleAdmin, httpHandleAPIUsersDelete())).Methods("DELETE")
+
+	apiRouter.HandleFunc("/api/targets", requireRole(disttrace.RoleViewer, httpHandleAPITargetsList())).Methods("GET")
+	apiRouter.HandleFunc("/api/targets", requireRole(disttrace.RoleOperator, httpHandleAPITargetsCreate())).Methods("POST")
+	apiRouter.HandleFunc("/api/targets", requireRole(disttrace.RoleOperator, httpHandleAPITargetsUpdate())).Methods("PUT")
+	apiRouter.HandleFunc("/api/targets/import", requireRole(disttrace.RoleOperator, httpHandleAPITargetsImport())).Methods("POST")
+	apiRouter.HandleFunc("/api/targets/{targetID}", requireRole(disttrace.RoleAdmin, httpHandleAPITargetsDelete())).Methods("DELETE")
+	apiRouter.HandleFunc("/api/targets/{targetID}/restore", requireRole(disttrace.RoleOperator, httpHandleAPITargetsRestore())).Methods("POST")
+	apiRouter.HandleFunc("/api/targets/{targetID}/purge", requireRole(disttrace.RoleAdmin, httpHandleAPITargetsPurge())).Methods("POST")
+
+	apiRouter.HandleFunc("/api/groups/targets", requireRole(disttrace.RoleViewer, httpHandleAPITargetGroupsList())).Methods("GET")
+	apiRouter.HandleFunc("/api/groups/targets", requireRole(disttrace.RoleOperator, httpHandleAPITargetGroupsCreate())).Methods("POST")
+	apiRouter.HandleFunc("/api/groups/targets", requireRole(disttrace.RoleOperator, httpHandleAPITargetGroupsUpdate())).Methods("PUT")
+	apiRouter.HandleFunc("/api/groups/targets/{groupID}", requireRole(disttrace.RoleOperator, httpHandleAPITargetGroupsDelete())).Methods("DELETE")
+
+	apiRouter.HandleFunc("/api/groups/slaves", requireRole(disttrace.RoleViewer, httpHandleAPISlaveGroupsList())).Methods("GET")
+	apiRouter.HandleFunc("/api/groups/slaves", requireRole(disttrace.RoleAdmin, httpHandleAPISlaveGroupsCreate())).Methods("POST")
+	apiRouter.HandleFunc("/api/groups/slaves", requireRole(disttrace.RoleAdmin, httpHandleAPISlaveGroupsUpdate())).Methods("PUT")
+	apiRouter.HandleFunc("/api/groups/slaves/{groupID}", requireRole(disttrace.RoleAdmin, httpHandleAPISlaveGroupsDelete())).Methods("DELETE")
+
+	apiRouter.HandleFunc("/api/alerts", requireRole(disttrace.RoleViewer, httpHandleAPIAlertsList())).Methods("GET")
+	apiRouter.HandleFunc("/api/alerts/rules", requireRole(disttrace.RoleViewer, httpHandleAPIAlertRulesList())).Methods("GET")
+	apiRouter.HandleFunc("/api/alerts/rules", requireRole(disttrace.RoleOperator, httpHandleAPIAlertRulesCreate())).Methods("POST")
+	apiRouter.HandleFunc("/api/alerts/rules", requireRole(disttrace.RoleOperator, httpHandleAPIAlertRulesUpdate())).Methods("PUT")
+	apiRouter.HandleFunc("/api/alerts/rules/{ruleID}", requireRole(disttrace.RoleOperator, httpHandleAPIAlertRulesDelete())).Methods("DELETE")
+	apiRouter.HandleFunc("/api/alerts/{alertID}/ack", requireRole(disttrace.RoleOperator, httpHandleAPIAlertsAcknowledge())).Methods("PUT")
+
+	apiRouter.HandleFunc("/api/notifications/channels", requireRole(disttrace.RoleOperator, httpHandleAPINotificationChannelsList())).Methods("GET")
+	apiRouter.HandleFunc("/api/notifications/channels", requireRole(disttrace.RoleAdmin, httpHandleAPINotificationChannelsCreate())).Methods("POST")
+	apiRouter.HandleFunc("/api/notifications/channels", requireRole(disttrace.RoleAdmin, httpHandleAPINotificationChannelsUpdate())).Methods("PUT")
+	apiRouter.HandleFunc("/api/notifications/channels/{channelID}", requireRole(disttrace.RoleAdmin, httpHandleAPINotificationChannelsDelete())).Methods("DELETE")
+	apiRouter.HandleFunc("/api/notifications/channels/{channelID}/test", requireRole(disttrace.RoleOperator, httpHandleAPINotificationChannelsTest())).Methods("POST")
+
+	return
+}
 
-	// handle api requests from webinterface
-	apiRouter := mux.NewRouter()
-	apiRouter.HandleFunc("/api/status", httpHandleAPIStatus())
-	apiRouter.HandleFunc("/api/traces", httpHandleAPITraceHistory())
-	apiRouter.HandleFunc("/api/graph", httpHandleAPIGraphData())
+func httpServer(cfg disttrace.MasterConfig) {
+	var err error
 
-	apiRouter.HandleFunc("/api/slaves", httpHandleAPISlavesList()).Methods("GET")
-	apiRouter.HandleFunc("/api/slaves", httpHandleAPISlavesCreate()).Methods("POST")
-	apiRouter.HandleFunc("/api/slaves", httpHandleAPISlavesUpdate()).Methods("PUT")
-	apiRouter.HandleFunc("/api/slaves/{slaveID}", httpHandleAPISlavesDelete()).Methods("DELETE")
+	log.Info("httpServer: Start...")
 
-	apiRouter.HandleFunc("/api/users", httpHandleAPIUsersList()).Methods("GET")
-	apiRouter.HandleFunc("/api/users", httpHandleAPIUsersCreate()).Methods("POST")
-	apiRouter.HandleFunc("/api/users", httpHandleAPIUsersUpdate()).Methods("PUT")
-	apiRouter.HandleFunc("/api/users/{userID}", httpHandleAPIUsersDelete()).Methods("DELETE")
+	var accessWriter io.Writer
+	if accessWriter, err = os.OpenFile(cfg.Log.AccessLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644); err != nil {
+		log.Panicf("httpServer: Can't open access log '%v', Error: %v", cfg.Log.AccessLog, err)
+	}
 
-	apiRouter.HandleFunc("/api/targets", httpHandleAPITargetsList()).Methods("GET")
-	apiRouter.HandleFunc("/api/targets", httpHandleAPITargetsCreate()).Methods("POST")
-	apiRouter.HandleFunc("/api/targets", httpHandleAPITargetsUpdate()).Methods("PUT")
-	apiRouter.HandleFunc("/api/targets/{targetID}", httpHandleAPITargetsDelete()).Methods("DELETE")
+	slaveRouter, publicRouter, apiRouter := newRouters()
 
 	authHandler := negroni.New()
 	authHandler.Use(negroni.HandlerFunc(checkJWTAuth))
@@ -59,37 +120,59 @@ func httpServer(accessLog string) {
 	// handle everything else
 	rootRouter := http.NewServeMux()
 	rootRouter.HandleFunc("/", httpDefaultHandler())
-	rootRouter.HandleFunc("/api/auth", httpHandleAPIAuth())
+	rootRouter.Handle("/api/auth", publicRouter)
+	rootRouter.Handle("/api/auth/refresh", publicRouter)
+	rootRouter.Handle("/api/openapi.json", publicRouter)
+	rootRouter.HandleFunc("/metrics", disttrace.MetricsHandler())
 	rootRouter.Handle("/slave/", slaveRouter)
 	rootRouter.Handle("/api/", authHandler)
 
 	// register middleware for all requests
 	rootHandler := negroni.New()
+	rootHandler.Use(negroni.HandlerFunc(handleHTTPMetrics))
 	rootHandler.Use(negroni.HandlerFunc(handleAccessControl))
 	rootHandler.Use(negroni.Wrap(ghandlers.CombinedLoggingHandler(accessWriter, rootRouter)))
 
-	srv := &http.Server{
-		Addr:         ":8990",
-		WriteTimeout: time.Second * 15,
-		ReadTimeout:  time.Second * 15,
-		IdleTimeout:  time.Second * 60,
-		Handler:      rootHandler,
-	}
-
-	// start server...
-	go func() {
-		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
-			log.Fatal("httpServer: HTTP Server failure, ListenAndServe: ", err)
+	// start a server for every listen address...
+	servers := []*http.Server{}
+	for _, addr := range cfg.HTTP.ListenAddresses {
+		srv := &http.Server{
+			Addr:         addr,
+			WriteTimeout: cfg.HTTP.WriteTimeout,
+			ReadTimeout:  cfg.HTTP.ReadTimeout,
+			IdleTimeout:  cfg.HTTP.IdleTimeout,
+			Handler:      rootHandler,
+			// the connection is needed to extend the write timeout of long running responses
+			ConnContext: func(ctx context.Context, conn net.Conn) context.Context {
+				return context.WithValue(ctx, ctxKeyConn, conn)
+			},
 		}
-	}()
+		servers = append(servers, srv)
+
+		go func() {
+			var err error
+			if cfg.TLS.CertFile != "" {
+				log.Infof("httpServer: Listening on '%v' with TLS...", srv.Addr)
+				err = srv.ListenAndServeTLS(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+			} else {
+				log.Infof("httpServer: Listening on '%v'...", srv.Addr)
+				err = srv.ListenAndServe()
+			}
+			if err != http.ErrServerClosed {
+				log.Fatal("httpServer: HTTP Server failure, ListenAndServe: ", err)
+			}
+		}()
+	}
 
 	// wait for quit signal...
 	for {
 		if disttrace.CheckForQuit() {
 			log.Warn("httpServer: Received signal to shutdown...")
 			ctx, cFunc := context.WithTimeout(context.Background(), 5*time.Second)
-			if err := srv.Shutdown(ctx); err != nil {
-				log.Warn("httpServer: Error while shutdown of HTTP server, Error: ", err)
+			for _, srv := range servers {
+				if err := srv.Shutdown(ctx); err != nil {
+					log.Warn("httpServer: Error while shutdown of HTTP server, Error: ", err)
+				}
 			}
 			cFunc()
 
@@ -0,0 +1,1588 @@
+package main
+
+import (
+	"io"
+	"net/http"
+)
+
+func httpHandleAPIOpenAPI() http.HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		log.Debug("httpHandleAPIOpenAPI: Received API 'openapi' request")
+
+		writer.Header().Set("Content-Type", "application/json")
+		if _, err := io.WriteString(writer, openAPISpec); err != nil {
+			log.Warn("httpHandleAPIOpenAPI: Couldn't write response: ", err)
+		}
+	}
+}
+
+// openAPISpec documents the api routes of newRouters, the requests of the slaves are internal and not part of it
+const openAPISpec = `{
+	"openapi": "3.0.3",
+	"info": {
+		"title": "dist-traceroute master API",
+		"description": "API of the dist-traceroute master used by the webinterface and other tools. Get a token from /api/auth and send it as bearer token with all other requests. Every operation requires the role in x-required-role or a higher one, roles are viewer, operator and admin. Viewers and operators restricted to target groups only get and change data of the targets in their groups. Errors are returned as plain text.",
+		"version": "1"
+	},
+	"servers": [
+		{"url": "http://localhost:8990"}
+	],
+	"security": [
+		{"bearerAuth": []}
+	],
+	"tags": [
+		{"name": "auth"},
+		{"name": "status"},
+		{"name": "traces"},
+		{"name": "slaves"},
+		{"name": "users"},
+		{"name": "targets"},
+		{"name": "groups"},
+		{"name": "alerts"},
+		{"name": "notifications"}
+	],
+	"paths": {
+		"/api/auth": {
+			"post": {
+				"tags": ["auth"],
+				"operationId": "login",
+				"summary": "Log in a user and get the tokens of a new session",
+				"security": [],
+				"requestBody": {
+					"required": true,
+					"content": {
+						"application/json": {
+							"schema": {
+								"type": "object",
+								"properties": {
+									"User": {"type": "string"},
+									"Password": {"type": "string"}
+								}
+							}
+						}
+					}
+				},
+				"responses": {
+					"200": {"$ref": "#/components/responses/AuthTokens"},
+					"400": {"$ref": "#/components/responses/BadRequest"},
+					"401": {"$ref": "#/components/responses/Unauthorized"},
+					"500": {"$ref": "#/components/responses/InternalError"}
+				}
+			}
+		},
+		"/api/auth/refresh": {
+			"post": {
+				"tags": ["auth"],
+				"operationId": "refreshToken",
+				"summary": "Get new tokens for a refresh token",
+				"description": "Every refresh token can only be used once, the response contains its successor. Reusing a refresh token ends the session.",
+				"security": [],
+				"requestBody": {
+					"required": true,
+					"content": {
+						"application/json": {
+							"schema": {
+								"type": "object",
+								"properties": {
+									"RefreshToken": {"type": "string"}
+								}
+							}
+						}
+					}
+				},
+				"responses": {
+					"200": {"$ref": "#/components/responses/AuthTokens"},
+					"400": {"$ref": "#/components/responses/BadRequest"},
+					"401": {"$ref": "#/components/responses/Unauthorized"},
+					"500": {"$ref": "#/components/responses/InternalError"}
+				}
+			}
+		},
+		"/api/auth/logout": {
+			"post": {
+				"tags": ["auth"],
+				"operationId": "logout",
+				"summary": "Revoke the auth token of the request and end its session",
+				"responses": {
+					"204": {"description": "Logged out"},
+					"401": {"$ref": "#/components/responses/Unauthorized"},
+					"500": {"$ref": "#/components/responses/InternalError"}
+				}
+			}
+		},
+		"/api/auth/password": {
+			"post": {
+				"tags": ["auth"],
+				"operationId": "changePassword",
+				"summary": "Change the password of the logged in user and get a new auth token",
+				"description": "Users whose password needs to be changed, like the initial admin user, can't send any other request until they changed it. The auth token of the request is revoked, the refresh token stays valid. All other sessions of the user are ended.",
+				"requestBody": {
+					"required": true,
+					"content": {
+						"application/json": {
+							"schema": {
+								"type": "object",
+								"properties": {
+									"Password": {"type": "string", "description": "Current password"},
+									"NewPassword": {"type": "string"}
+								}
+							}
+						}
+					}
+				},
+				"responses": {
+					"200": {"$ref": "#/components/responses/AuthTokens"},
+					"400": {"$ref": "#/components/responses/BadRequest"},
+					"401": {"$ref": "#/components/responses/Unauthorized"},
+					"403": {"$ref": "#/components/responses/Forbidden"},
+					"500": {"$ref": "#/components/responses/InternalError"}
+				}
+			}
+		},
+		"/api/openapi.json": {
+			"get": {
+				"tags": ["status"],
+				"operationId": "getOpenAPISpec",
+				"summary": "Get this OpenAPI spec",
+				"security": [],
+				"responses": {
+					"200": {
+						"description": "OpenAPI spec",
+						"content": {"application/json": {"schema": {"type": "object"}}}
+					}
+				}
+			}
+		},
+		"/api/status": {
+			"get": {
+				"tags": ["status"],
+				"operationId": "getStatus",
+				"summary": "Get the status of the master and the latest application alerts",
+				"x-required-role": "viewer",
+				"responses": {
+					"200": {
+						"description": "Status of the master",
+						"content": {"application/json": {"schema": {"$ref": "#/components/schemas/Status"}}}
+					},
+					"401": {"$ref": "#/components/responses/Unauthorized"},
+					"403": {"$ref": "#/components/responses/Forbidden"}
+				}
+			}
+		},
+		"/api/traces": {
+			"get": {
+				"tags": ["traces"],
+				"operationId": "getTraceHistory",
+				"summary": "Get the latest traceroutes, newest first",
+				"x-required-role": "viewer",
+				"parameters": [
+					{"name": "limit", "in": "query", "description": "Number of traceroutes, 0 returns all", "schema": {"type": "integer", "minimum": 0}}
+				],
+				"responses": {
+					"200": {
+						"description": "Latest traceroutes",
+						"content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/TraceHistoryEntry"}}}}
+					},
+					"401": {"$ref": "#/components/responses/Unauthorized"},
+					"403": {"$ref": "#/components/responses/Forbidden"},
+					"500": {"$ref": "#/components/responses/InternalError"}
+				}
+			}
+		},
+		"/api/graph": {
+			"get": {
+				"tags": ["traces"],
+				"operationId": "getGraphData",
+				"summary": "Get the links between hops seen by a slave on its way to a target",
+				"x-required-role": "viewer",
+				"parameters": [
+					{"name": "destID", "in": "query", "required": true, "schema": {"type": "string", "format": "uuid"}},
+					{"name": "slaveID", "in": "query", "required": true, "schema": {"type": "string", "format": "uuid"}},
+					{"name": "skip", "in": "query", "description": "Number of first hops to skip", "schema": {"type": "integer", "minimum": 0}},
+					{"name": "from", "in": "query", "description": "Start of the period, 24 hours before to if only to is given, all data if both are missing", "schema": {"type": "string", "format": "date-time"}},
+					{"name": "to", "in": "query", "description": "End of the period, now if missing", "schema": {"type": "string", "format": "date-time"}}
+				],
+				"responses": {
+					"200": {
+						"description": "Links between hops",
+						"content": {"application/json": {"schema": {"$ref": "#/components/schemas/GraphData"}}}
+					},
+					"400": {"$ref": "#/components/responses/BadRequest"},
+					"401": {"$ref": "#/components/responses/Unauthorized"},
+					"403": {"$ref": "#/components/responses/Forbidden"},
+					"500": {"$ref": "#/components/responses/InternalError"}
+				}
+			}
+		},
+		"/api/paths": {
+			"get": {
+				"tags": ["traces"],
+				"operationId": "getPaths",
+				"summary": "Get the distinct paths seen to a target",
+				"x-required-role": "viewer",
+				"parameters": [
+					{"name": "destID", "in": "query", "required": true, "schema": {"type": "string", "format": "uuid"}},
+					{"name": "slaveID", "in": "query", "description": "Only paths seen by this slave", "schema": {"type": "string", "format": "uuid"}}
+				],
+				"responses": {
+					"200": {
+						"description": "Paths with their sightings",
+						"content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/PathSighting"}}}}
+					},
+					"400": {"$ref": "#/components/responses/BadRequest"},
+					"401": {"$ref": "#/components/responses/Unauthorized"},
+					"403": {"$ref": "#/components/responses/Forbidden"},
+					"500": {"$ref": "#/components/responses/InternalError"}
+				}
+			}
+		},
+		"/api/v1/traceroutes": {
+			"get": {
+				"tags": ["traces"],
+				"operationId": "listTraceroutes",
+				"summary": "Get a page of traceroutes including their hops, newest first",
+				"x-required-role": "viewer",
+				"parameters": [
+					{"name": "slave", "in": "query", "description": "ID or name of a slave, repeated or comma separated", "style": "form", "explode": true, "schema": {"type": "array", "items": {"type": "string"}}},
+					{"name": "target", "in": "query", "description": "ID or name of a target, repeated or comma separated", "style": "form", "explode": true, "schema": {"type": "array", "items": {"type": "string"}}},
+					{"name": "from", "in": "query", "schema": {"type": "string", "format": "date-time"}},
+					{"name": "to", "in": "query", "schema": {"type": "string", "format": "date-time"}},
+					{"name": "success", "in": "query", "schema": {"type": "boolean"}},
+					{"name": "hop", "in": "query", "description": "Only traceroutes through this IP address", "schema": {"type": "string"}},
+					{"name": "asn", "in": "query", "description": "Only traceroutes through this autonomous system, e.g. 13335 or AS13335, needs an ASN database", "schema": {"type": "string"}},
+					{"name": "limit", "in": "query", "schema": {"type": "integer", "minimum": 1, "maximum": 1000, "default": 100}},
+					{"name": "cursor", "in": "query", "description": "NextCursor of the previous page", "schema": {"type": "string"}}
+				],
+				"responses": {
+					"200": {
+						"description": "Page of traceroutes",
+						"content": {"application/json": {"schema": {"$ref": "#/components/schemas/TraceroutePage"}}}
+					},
+					"400": {"$ref": "#/components/responses/BadRequest"},
+					"401": {"$ref": "#/components/responses/Unauthorized"},
+					"403": {"$ref": "#/components/responses/Forbidden"},
+					"500": {"$ref": "#/components/responses/InternalError"}
+				}
+			}
+		},
+		"/api/v1/traceroutes/export": {
+			"get": {
+				"tags": ["traces"],
+				"operationId": "exportTraceroutes",
+				"summary": "Download all matching traceroutes, newest first, as CSV or parquet file with a row per hop or as NDJSON",
+				"x-required-role": "viewer",
+				"parameters": [
+					{"name": "slave", "in": "query", "description": "ID or name of a slave, repeated or comma separated", "style": "form", "explode": true, "schema": {"type": "array", "items": {"type": "string"}}},
+					{"name": "target", "in": "query", "description": "ID or name of a target, repeated or comma separated", "style": "form", "explode": true, "schema": {"type": "array", "items": {"type": "string"}}},
+					{"name": "from", "in": "query", "schema": {"type": "string", "format": "date-time"}},
+					{"name": "to", "in": "query", "schema": {"type": "string", "format": "date-time"}},
+					{"name": "success", "in": "query", "schema": {"type": "boolean"}},
+					{"name": "hop", "in": "query", "description": "Only traceroutes through this IP address", "schema": {"type": "string"}},
+					{"name": "asn", "in": "query", "description": "Only traceroutes through this autonomous system, e.g. 13335 or AS13335, needs an ASN database", "schema": {"type": "string"}},
+					{"name": "format", "in": "query", "schema": {"type": "string", "enum": ["csv", "ndjson", "parquet"], "default": "csv"}}
+				],
+				"responses": {
+					"200": {
+						"description": "Exported traceroutes",
+						"content": {
+							"text/csv": {"schema": {"type": "string"}},
+							"application/x-ndjson": {"schema": {"type": "string"}},
+							"application/vnd.apache.parquet": {"schema": {"type": "string", "format": "binary"}}
+						}
+					},
+					"400": {"$ref": "#/components/responses/BadRequest"},
+					"401": {"$ref": "#/components/responses/Unauthorized"},
+					"403": {"$ref": "#/components/responses/Forbidden"},
+					"500": {"$ref": "#/components/responses/InternalError"}
+				}
+			}
+		},
+		"/api/v1/graph": {
+			"get": {
+				"tags": ["traces"],
+				"operationId": "getGraph",
+				"summary": "Get the graph of the hops seen by one or more slaves on their way to a target",
+				"x-required-role": "viewer",
+				"parameters": [
+					{"name": "target", "in": "query", "required": true, "description": "ID or name of the target", "schema": {"type": "string"}},
+					{"name": "slave", "in": "query", "description": "ID or name of a slave, repeated or comma separated, all slaves if missing", "style": "form", "explode": true, "schema": {"type": "array", "items": {"type": "string"}}},
+					{"name": "from", "in": "query", "schema": {"type": "string", "format": "date-time"}},
+					{"name": "to", "in": "query", "schema": {"type": "string", "format": "date-time"}},
+					{"name": "skip", "in": "query", "description": "Number of first hops to skip", "schema": {"type": "integer", "minimum": 0}},
+					{"name": "minCount", "in": "query", "description": "Hide links seen less often", "schema": {"type": "integer", "minimum": 0}},
+					{"name": "format", "in": "query", "description": "json, or export as Graphviz DOT, GraphML or JSON Graph Format", "schema": {"type": "string", "enum": ["json", "dot", "graphml", "jgf"], "default": "json"}}
+				],
+				"responses": {
+					"200": {
+						"description": "Graph of the hops",
+						"content": {
+							"application/json": {"schema": {"$ref": "#/components/schemas/Graph"}},
+							"text/vnd.graphviz": {"schema": {"type": "string"}},
+							"application/graphml+xml": {"schema": {"type": "string"}},
+							"application/vnd.jgf+json": {"schema": {"type": "object"}}
+						}
+					},
+					"400": {"$ref": "#/components/responses/BadRequest"},
+					"401": {"$ref": "#/components/responses/Unauthorized"},
+					"403": {"$ref": "#/components/responses/Forbidden"},
+					"404": {"$ref": "#/components/responses/NotFound"},
+					"500": {"$ref": "#/components/responses/InternalError"}
+				}
+			}
+		},
+		"/api/v1/events": {
+			"get": {
+				"tags": ["traces"],
+				"operationId": "streamEvents",
+				"summary": "Stream new traceroutes, route changes, alerts and slave status changes as Server-Sent Events",
+				"x-required-role": "viewer",
+				"description": "Every event is sent with its ID, its type as event name and the Event as JSON data. Comments are sent every 15s to keep the connection alive. The stream is closed if the client can't keep up, it should reconnect and reload its data.",
+				"parameters": [
+					{"name": "type", "in": "query", "description": "Event type, repeated or comma separated, all types if missing", "style": "form", "explode": true, "schema": {"type": "array", "items": {"type": "string", "enum": ["traceroute", "routeChange", "alert", "slaveStatus"]}}},
+					{"name": "slave", "in": "query", "description": "ID or name of a slave, repeated or comma separated, events without slave aren't filtered", "style": "form", "explode": true, "schema": {"type": "array", "items": {"type": "string"}}},
+					{"name": "target", "in": "query", "description": "ID or name of a target, repeated or comma separated, events without target aren't filtered", "style": "form", "explode": true, "schema": {"type": "array", "items": {"type": "string"}}}
+				],
+				"responses": {
+					"200": {
+						"description": "Stream of events",
+						"content": {"text/event-stream": {"schema": {"$ref": "#/components/schemas/Event"}}}
+					},
+					"400": {"$ref": "#/components/responses/BadRequest"},
+					"401": {"$ref": "#/components/responses/Unauthorized"},
+					"403": {"$ref": "#/components/responses/Forbidden"},
+					"500": {"$ref": "#/components/responses/InternalError"}
+				}
+			}
+		},
+		"/api/slaves": {
+			"get": {
+				"tags": ["slaves"],
+				"operationId": "listSlaves",
+				"summary": "Get all slaves including their status",
+				"x-required-role": "viewer",
+				"parameters": [
+					{"$ref": "#/components/parameters/Archived"}
+				],
+				"responses": {
+					"200": {
+						"description": "Slaves",
+						"content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Slave"}}}}
+					},
+					"401": {"$ref": "#/components/responses/Unauthorized"},
+					"403": {"$ref": "#/components/responses/Forbidden"},
+					"500": {"$ref": "#/components/responses/InternalError"}
+				}
+			},
+			"post": {
+				"tags": ["slaves"],
+				"operationId": "createSlave",
+				"summary": "Create a slave",
+				"x-required-role": "admin",
+				"parameters": [
+					{"name": "name", "in": "query", "required": true, "schema": {"type": "string"}},
+					{"name": "secret", "in": "query", "required": true, "schema": {"type": "string"}}
+				],
+				"responses": {
+					"201": {
+						"description": "Created slave",
+						"content": {"application/json": {"schema": {"$ref": "#/components/schemas/Slave"}}}
+					},
+					"400": {"$ref": "#/components/responses/BadRequest"},
+					"401": {"$ref": "#/components/responses/Unauthorized"},
+					"403": {"$ref": "#/components/responses/Forbidden"},
+					"500": {"$ref": "#/components/responses/InternalError"}
+				}
+			},
+			"put": {
+				"tags": ["slaves"],
+				"operationId": "updateSlave",
+				"summary": "Update a slave",
+				"x-required-role": "admin",
+				"requestBody": {
+					"required": true,
+					"content": {"application/json": {"schema": {"$ref": "#/components/schemas/Slave"}}}
+				},
+				"responses": {
+					"200": {
+						"description": "Updated slave",
+						"content": {"application/json": {"schema": {"$ref": "#/components/schemas/Slave"}}}
+					},
+					"400": {"$ref": "#/components/responses/BadRequest"},
+					"401": {"$ref": "#/components/responses/Unauthorized"},
+					"403": {"$ref": "#/components/responses/Forbidden"},
+					"500": {"$ref": "#/components/responses/InternalError"}
+				}
+			}
+		},
+		"/api/slaves/{slaveID}": {
+			"delete": {
+				"tags": ["slaves"],
+				"operationId": "archiveSlave",
+				"summary": "Archive a slave, its history is kept until it is purged",
+				"x-required-role": "admin",
+				"parameters": [
+					{"$ref": "#/components/parameters/SlaveID"}
+				],
+				"responses": {
+					"200": {"$ref": "#/components/responses/SlaveID"},
+					"400": {"$ref": "#/components/responses/BadRequest"},
+					"401": {"$ref": "#/components/responses/Unauthorized"},
+					"403": {"$ref": "#/components/responses/Forbidden"},
+					"500": {"$ref": "#/components/responses/InternalError"}
+				}
+			}
+		},
+		"/api/slaves/{slaveID}/restore": {
+			"post": {
+				"tags": ["slaves"],
+				"operationId": "restoreSlave",
+				"summary": "Restore an archived slave",
+				"x-required-role": "admin",
+				"parameters": [
+					{"$ref": "#/components/parameters/SlaveID"}
+				],
+				"responses": {
+					"200": {"$ref": "#/components/responses/SlaveID"},
+					"400": {"$ref": "#/components/responses/BadRequest"},
+					"401": {"$ref": "#/components/responses/Unauthorized"},
+					"403": {"$ref": "#/components/responses/Forbidden"},
+					"409": {"$ref": "#/components/responses/Conflict"},
+					"500": {"$ref": "#/components/responses/InternalError"}
+				}
+			}
+		},
+		"/api/slaves/{slaveID}/purge": {
+			"post": {
+				"tags": ["slaves"],
+				"operationId": "purgeSlave",
+				"summary": "Delete an archived slave and its history in the background",
+				"x-required-role": "admin",
+				"parameters": [
+					{"$ref": "#/components/parameters/SlaveID"}
+				],
+				"responses": {
+					"202": {"$ref": "#/components/responses/SlaveID"},
+					"400": {"$ref": "#/components/responses/BadRequest"},
+					"401": {"$ref": "#/components/responses/Unauthorized"},
+					"403": {"$ref": "#/components/responses/Forbidden"},
+					"409": {"$ref": "#/components/responses/Conflict"},
+					"500": {"$ref": "#/components/responses/InternalError"}
+				}
+			}
+		},
+		"/api/slaves/{slaveID}/telemetry": {
+			"get": {
+				"tags": ["slaves"],
+				"operationId": "getSlaveTelemetry",
+				"summary": "Get the health reports of a slave",
+				"x-required-role": "viewer",
+				"parameters": [
+					{"$ref": "#/components/parameters/SlaveID"},
+					{"name": "from", "in": "query", "description": "Start of the period, 24 hours before to if missing", "schema": {"type": "string", "format": "date-time"}},
+					{"name": "to", "in": "query", "description": "End of the period, now if missing", "schema": {"type": "string", "format": "date-time"}}
+				],
+				"responses": {
+					"200": {
+						"description": "Health reports",
+						"content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/SlaveTelemetry"}}}}
+					},
+					"400": {"$ref": "#/components/responses/BadRequest"},
+					"401": {"$ref": "#/components/responses/Unauthorized"},
+					"403": {"$ref": "#/components/responses/Forbidden"},
+					"500": {"$ref": "#/components/responses/InternalError"}
+				}
+			}
+		},
+		"/api/users": {
+			"get": {
+				"tags": ["users"],
+				"operationId": "listUsers",
+				"summary": "Get all users",
+				"x-required-role": "admin",
+				"responses": {
+					"200": {
+						"description": "Users",
+						"content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/User"}}}}
+					},
+					"401": {"$ref": "#/components/responses/Unauthorized"},
+					"403": {"$ref": "#/components/responses/Forbidden"},
+					"500": {"$ref": "#/components/responses/InternalError"}
+				}
+			},
+			"post": {
+				"tags": ["users"],
+				"operationId": "createUser",
+				"summary": "Create a user",
+				"x-required-role": "admin",
+				"parameters": [
+					{"name": "name", "in": "query", "required": true, "schema": {"type": "string"}},
+					{"name": "password", "in": "query", "required": true, "schema": {"type": "string"}},
+					{"name": "pwNeedsChange", "in": "query", "schema": {"type": "boolean"}},
+					{"name": "role", "in": "query", "description": "Role of the user, defaults to viewer", "schema": {"type": "string", "enum": ["viewer", "operator", "admin"]}},
+					{"name": "targetGroup", "in": "query", "description": "ID of a target group the user is restricted to, repeated or comma separated", "style": "form", "explode": true, "schema": {"type": "array", "items": {"type": "string", "format": "uuid"}}}
+				],
+				"responses": {
+					"201": {
+						"description": "Created user",
+						"content": {"application/json": {"schema": {"$ref": "#/components/schemas/User"}}}
+					},
+					"400": {"$ref": "#/components/responses/BadRequest"},
+					"401": {"$ref": "#/components/responses/Unauthorized"},
+					"403": {"$ref": "#/components/responses/Forbidden"},
+					"500": {"$ref": "#/components/responses/InternalError"}
+				}
+			},
+			"put": {
+				"tags": ["users"],
+				"operationId": "updateUser",
+				"summary": "Update a user",
+				"x-required-role": "admin",
+				"requestBody": {
+					"required": true,
+					"content": {"application/json": {"schema": {"$ref": "#/components/schemas/User"}}}
+				},
+				"responses": {
+					"200": {
+						"description": "Updated user",
+						"content": {"application/json": {"schema": {"$ref": "#/components/schemas/User"}}}
+					},
+					"400": {"$ref": "#/components/responses/BadRequest"},
+					"401": {"$ref": "#/components/responses/Unauthorized"},
+					"403": {"$ref": "#/components/responses/Forbidden"},
+					"409": {"$ref": "#/components/responses/Conflict"},
+					"500": {"$ref": "#/components/responses/InternalError"}
+				}
+			}
+		},
+		"/api/users/{userID}": {
+			"delete": {
+				"tags": ["users"],
+				"operationId": "deleteUser",
+				"summary": "Delete a user",
+				"x-required-role": "admin",
+				"parameters": [
+					{"name": "userID", "in": "path", "required": true, "schema": {"type": "string", "format": "uuid"}}
+				],
+				"responses": {
+					"200": {
+						"description": "ID of the deleted user",
+						"content": {"application/json": {"schema": {"$ref": "#/components/schemas/TraceTarget"}}}
+					},
+					"400": {"$ref": "#/components/responses/BadRequest"},
+					"401": {"$ref": "#/components/responses/Unauthorized"},
+					"403": {"$ref": "#/components/responses/Forbidden"},
+					"409": {"$ref": "#/components/responses/Conflict"},
+					"500": {"$ref": "#/components/responses/InternalError"}
+				}
+			}
+		},
+		"/api/targets": {
+			"get": {
+				"tags": ["targets"],
+				"operationId": "listTargets",
+				"summary": "Get all targets",
+				"x-required-role": "viewer",
+				"parameters": [
+					{"$ref": "#/components/parameters/Archived"}
+				],
+				"responses": {
+					"200": {
+						"description": "Targets",
+						"content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/TraceTarget"}}}}
+					},
+					"401": {"$ref": "#/components/responses/Unauthorized"},
+					"403": {"$ref": "#/components/responses/Forbidden"},
+					"500": {"$ref": "#/components/responses/InternalError"}
+				}
+			},
+			"post": {
+				"tags": ["targets"],
+				"operationId": "createTarget",
+				"summary": "Create a target",
+				"x-required-role": "operator",
+				"parameters": [
+					{"name": "name", "in": "query", "required": true, "schema": {"type": "string"}},
+					{"name": "address", "in": "query", "required": true, "schema": {"type": "string"}},
+					{"name": "retries", "in": "query", "schema": {"type": "integer", "minimum": 0, "maximum": 10}},
+					{"name": "maxHops", "in": "query", "schema": {"type": "integer", "minimum": 1, "maximum": 100}},
+					{"name": "timeout", "in": "query", "description": "Timeout in ms", "schema": {"type": "integer", "minimum": 1, "maximum": 10000}},
+					{"name": "group", "in": "query", "description": "ID of a target group to add the target to, unset parameters default to the group's parameters", "schema": {"type": "string", "format": "uuid"}}
+				],
+				"responses": {
+					"201": {
+						"description": "Created target",
+						"content": {"application/json": {"schema": {"$ref": "#/components/schemas/TraceTarget"}}}
+					},
+					"400": {"$ref": "#/components/responses/BadRequest"},
+					"401": {"$ref": "#/components/responses/Unauthorized"},
+					"403": {"$ref": "#/components/responses/Forbidden"},
+					"500": {"$ref": "#/components/responses/InternalError"}
+				}
+			},
+			"put": {
+				"tags": ["targets"],
+				"operationId": "updateTarget",
+				"summary": "Update a target",
+				"x-required-role": "operator",
+				"requestBody": {
+					"required": true,
+					"content": {"application/json": {"schema": {"$ref": "#/components/schemas/TraceTarget"}}}
+				},
+				"responses": {
+					"200": {
+						"description": "Updated target",
+						"content": {"application/json": {"schema": {"$ref": "#/components/schemas/TraceTarget"}}}
+					},
+					"400": {"$ref": "#/components/responses/BadRequest"},
+					"401": {"$ref": "#/components/responses/Unauthorized"},
+					"403": {"$ref": "#/components/responses/Forbidden"},
+					"500": {"$ref": "#/components/responses/InternalError"}
+				}
+			}
+		},
+		"/api/targets/import": {
+			"post": {
+				"tags": ["targets"],
+				"operationId": "importTargets",
+				"summary": "Create or update many targets at once",
+				"x-required-role": "operator",
+				"parameters": [
+					{"name": "format", "in": "query", "description": "Format of the document, taken from the content type if missing", "schema": {"type": "string", "enum": ["csv", "json", "list"]}},
+					{"name": "dryRun", "in": "query", "description": "Only report the changes", "schema": {"type": "boolean"}}
+				],
+				"requestBody": {
+					"required": true,
+					"content": {
+						"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/TargetImportRow"}}},
+						"text/csv": {"schema": {"type": "string"}},
+						"text/plain": {"schema": {"type": "string"}}
+					}
+				},
+				"responses": {
+					"200": {
+						"description": "Result of every row",
+						"content": {"application/json": {"schema": {"$ref": "#/components/schemas/TargetImportReport"}}}
+					},
+					"400": {"$ref": "#/components/responses/BadRequest"},
+					"401": {"$ref": "#/components/responses/Unauthorized"},
+					"403": {"$ref": "#/components/responses/Forbidden"},
+					"500": {"$ref": "#/components/responses/InternalError"}
+				}
+			}
+		},
+		"/api/targets/{targetID}": {
+			"delete": {
+				"tags": ["targets"],
+				"operationId": "archiveTarget",
+				"summary": "Archive a target, its history is kept until it is purged",
+				"x-required-role": "admin",
+				"parameters": [
+					{"$ref": "#/components/parameters/TargetID"}
+				],
+				"responses": {
+					"200": {"$ref": "#/components/responses/TargetID"},
+					"400": {"$ref": "#/components/responses/BadRequest"},
+					"401": {"$ref": "#/components/responses/Unauthorized"},
+					"403": {"$ref": "#/components/responses/Forbidden"},
+					"500": {"$ref": "#/components/responses/InternalError"}
+				}
+			}
+		},
+		"/api/targets/{targetID}/restore": {
+			"post": {
+				"tags": ["targets"],
+				"operationId": "restoreTarget",
+				"summary": "Restore an archived target",
+				"x-required-role": "operator",
+				"parameters": [
+					{"$ref": "#/components/parameters/TargetID"}
+				],
+				"responses": {
+					"200": {"$ref": "#/components/responses/TargetID"},
+					"400": {"$ref": "#/components/responses/BadRequest"},
+					"401": {"$ref": "#/components/responses/Unauthorized"},
+					"403": {"$ref": "#/components/responses/Forbidden"},
+					"409": {"$ref": "#/components/responses/Conflict"},
+					"500": {"$ref": "#/components/responses/InternalError"}
+				}
+			}
+		},
+		"/api/targets/{targetID}/purge": {
+			"post": {
+				"tags": ["targets"],
+				"operationId": "purgeTarget",
+				"summary": "Delete an archived target and its history in the background",
+				"x-required-role": "admin",
+				"parameters": [
+					{"$ref": "#/components/parameters/TargetID"}
+				],
+				"responses": {
+					"202": {"$ref": "#/components/responses/TargetID"},
+					"400": {"$ref": "#/components/responses/BadRequest"},
+					"401": {"$ref": "#/components/responses/Unauthorized"},
+					"403": {"$ref": "#/components/responses/Forbidden"},
+					"409": {"$ref": "#/components/responses/Conflict"},
+					"500": {"$ref": "#/components/responses/InternalError"}
+				}
+			}
+		},
+		"/api/groups/targets": {
+			"get": {
+				"tags": ["groups"],
+				"operationId": "listTargetGroups",
+				"summary": "Get all target groups",
+				"x-required-role": "viewer",
+				"responses": {
+					"200": {
+						"description": "Target groups",
+						"content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/TargetGroup"}}}}
+					},
+					"401": {"$ref": "#/components/responses/Unauthorized"},
+					"403": {"$ref": "#/components/responses/Forbidden"},
+					"500": {"$ref": "#/components/responses/InternalError"}
+				}
+			},
+			"post": {
+				"tags": ["groups"],
+				"operationId": "createTargetGroup",
+				"summary": "Create a target group",
+				"x-required-role": "operator",
+				"requestBody": {
+					"required": true,
+					"content": {"application/json": {"schema": {"$ref": "#/components/schemas/TargetGroup"}}}
+				},
+				"responses": {
+					"201": {
+						"description": "Created target group",
+						"content": {"application/json": {"schema": {"$ref": "#/components/schemas/TargetGroup"}}}
+					},
+					"400": {"$ref": "#/components/responses/BadRequest"},
+					"401": {"$ref": "#/components/responses/Unauthorized"},
+					"403": {"$ref": "#/components/responses/Forbidden"},
+					"500": {"$ref": "#/components/responses/InternalError"}
+				}
+			},
+			"put": {
+				"tags": ["groups"],
+				"operationId": "updateTargetGroup",
+				"summary": "Update a target group",
+				"x-required-role": "operator",
+				"requestBody": {
+					"required": true,
+					"content": {"application/json": {"schema": {"$ref": "#/components/schemas/TargetGroup"}}}
+				},
+				"responses": {
+					"200": {
+						"description": "Updated target group",
+						"content": {"application/json": {"schema": {"$ref": "#/components/schemas/TargetGroup"}}}
+					},
+					"400": {"$ref": "#/components/responses/BadRequest"},
+					"401": {"$ref": "#/components/responses/Unauthorized"},
+					"403": {"$ref": "#/components/responses/Forbidden"},
+					"500": {"$ref": "#/components/responses/InternalError"}
+				}
+			}
+		},
+		"/api/groups/targets/{groupID}": {
+			"delete": {
+				"tags": ["groups"],
+				"operationId": "deleteTargetGroup",
+				"summary": "Delete a target group, its targets are kept",
+				"x-required-role": "operator",
+				"parameters": [
+					{"$ref": "#/components/parameters/GroupID"}
+				],
+				"responses": {
+					"200": {
+						"description": "ID of the deleted target group",
+						"content": {"application/json": {"schema": {"$ref": "#/components/schemas/TargetGroup"}}}
+					},
+					"400": {"$ref": "#/components/responses/BadRequest"},
+					"401": {"$ref": "#/components/responses/Unauthorized"},
+					"403": {"$ref": "#/components/responses/Forbidden"},
+					"500": {"$ref": "#/components/responses/InternalError"}
+				}
+			}
+		},
+		"/api/groups/slaves": {
+			"get": {
+				"tags": ["groups"],
+				"operationId": "listSlaveGroups",
+				"summary": "Get all slave groups",
+				"x-required-role": "viewer",
+				"responses": {
+					"200": {
+						"description": "Slave groups",
+						"content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/SlaveGroup"}}}}
+					},
+					"401": {"$ref": "#/components/responses/Unauthorized"},
+					"403": {"$ref": "#/components/responses/Forbidden"},
+					"500": {"$ref": "#/components/responses/InternalError"}
+				}
+			},
+			"post": {
+				"tags": ["groups"],
+				"operationId": "createSlaveGroup",
+				"summary": "Create a slave group",
+				"x-required-role": "admin",
+				"requestBody": {
+					"required": true,
+					"content": {"application/json": {"schema": {"$ref": "#/components/schemas/SlaveGroup"}}}
+				},
+				"responses": {
+					"201": {
+						"description": "Created slave group",
+						"content": {"application/json": {"schema": {"$ref": "#/components/schemas/SlaveGroup"}}}
+					},
+					"400": {"$ref": "#/components/responses/BadRequest"},
+					"401": {"$ref": "#/components/responses/Unauthorized"},
+					"403": {"$ref": "#/components/responses/Forbidden"},
+					"500": {"$ref": "#/components/responses/InternalError"}
+				}
+			},
+			"put": {
+				"tags": ["groups"],
+				"operationId": "updateSlaveGroup",
+				"summary": "Update a slave group",
+				"x-required-role": "admin",
+				"requestBody": {
+					"required": true,
+					"content": {"application/json": {"schema": {"$ref": "#/components/schemas/SlaveGroup"}}}
+				},
+				"responses": {
+					"200": {
+						"description": "Updated slave group",
+						"content": {"application/json": {"schema": {"$ref": "#/components/schemas/SlaveGroup"}}}
+					},
+					"400": {"$ref": "#/components/responses/BadRequest"},
+					"401": {"$ref": "#/components/responses/Unauthorized"},
+					"403": {"$ref": "#/components/responses/Forbidden"},
+					"500": {"$ref": "#/components/responses/InternalError"}
+				}
+			}
+		},
+		"/api/groups/slaves/{groupID}": {
+			"delete": {
+				"tags": ["groups"],
+				"operationId": "deleteSlaveGroup",
+				"summary": "Delete a slave group, its slaves are kept",
+				"x-required-role": "admin",
+				"parameters": [
+					{"$ref": "#/components/parameters/GroupID"}
+				],
+				"responses": {
+					"200": {
+						"description": "ID of the deleted slave group",
+						"content": {"application/json": {"schema": {"$ref": "#/components/schemas/SlaveGroup"}}}
+					},
+					"400": {"$ref": "#/components/responses/BadRequest"},
+					"401": {"$ref": "#/components/responses/Unauthorized"},
+					"403": {"$ref": "#/components/responses/Forbidden"},
+					"500": {"$ref": "#/components/responses/InternalError"}
+				}
+			}
+		},
+		"/api/alerts": {
+			"get": {
+				"tags": ["alerts"],
+				"operationId": "listAlerts",
+				"summary": "Get the alert history, newest first",
+				"x-required-role": "viewer",
+				"parameters": [
+					{"name": "state", "in": "query", "schema": {"type": "string", "enum": ["firing", "resolved", "acknowledged"]}},
+					{"name": "limit", "in": "query", "schema": {"type": "integer", "minimum": 0}}
+				],
+				"responses": {
+					"200": {
+						"description": "Alerts",
+						"content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Alert"}}}}
+					},
+					"400": {"$ref": "#/components/responses/BadRequest"},
+					"401": {"$ref": "#/components/responses/Unauthorized"},
+					"403": {"$ref": "#/components/responses/Forbidden"},
+					"500": {"$ref": "#/components/responses/InternalError"}
+				}
+			}
+		},
+		"/api/alerts/{alertID}/ack": {
+			"put": {
+				"tags": ["alerts"],
+				"operationId": "acknowledgeAlert",
+				"summary": "Acknowledge a firing alert",
+				"x-required-role": "operator",
+				"parameters": [
+					{"name": "alertID", "in": "path", "required": true, "schema": {"type": "string", "format": "uuid"}}
+				],
+				"responses": {
+					"200": {
+						"description": "Acknowledged alert",
+						"content": {"application/json": {"schema": {"$ref": "#/components/schemas/Alert"}}}
+					},
+					"400": {"$ref": "#/components/responses/BadRequest"},
+					"401": {"$ref": "#/components/responses/Unauthorized"},
+					"403": {"$ref": "#/components/responses/Forbidden"},
+					"409": {"$ref": "#/components/responses/Conflict"}
+				}
+			}
+		},
+		"/api/alerts/rules": {
+			"get": {
+				"tags": ["alerts"],
+				"operationId": "listAlertRules",
+				"summary": "Get all alert rules",
+				"x-required-role": "viewer",
+				"responses": {
+					"200": {
+						"description": "Alert rules",
+						"content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/AlertRule"}}}}
+					},
+					"401": {"$ref": "#/components/responses/Unauthorized"},
+					"403": {"$ref": "#/components/responses/Forbidden"},
+					"500": {"$ref": "#/components/responses/InternalError"}
+				}
+			},
+			"post": {
+				"tags": ["alerts"],
+				"operationId": "createAlertRule",
+				"summary": "Create an alert rule",
+				"x-required-role": "operator",
+				"requestBody": {
+					"required": true,
+					"content": {"application/json": {"schema": {"$ref": "#/components/schemas/AlertRule"}}}
+				},
+				"responses": {
+					"201": {
+						"description": "Created alert rule",
+						"content": {"application/json": {"schema": {"$ref": "#/components/schemas/AlertRule"}}}
+					},
+					"400": {"$ref": "#/components/responses/BadRequest"},
+					"401": {"$ref": "#/components/responses/Unauthorized"},
+					"403": {"$ref": "#/components/responses/Forbidden"},
+					"500": {"$ref": "#/components/responses/InternalError"}
+				}
+			},
+			"put": {
+				"tags": ["alerts"],
+				"operationId": "updateAlertRule",
+				"summary": "Update an alert rule",
+				"x-required-role": "operator",
+				"requestBody": {
+					"required": true,
+					"content": {"application/json": {"schema": {"$ref": "#/components/schemas/AlertRule"}}}
+				},
+				"responses": {
+					"200": {
+						"description": "Updated alert rule",
+						"content": {"application/json": {"schema": {"$ref": "#/components/schemas/AlertRule"}}}
+					},
+					"400": {"$ref": "#/components/responses/BadRequest"},
+					"401": {"$ref": "#/components/responses/Unauthorized"},
+					"403": {"$ref": "#/components/responses/Forbidden"},
+					"404": {"$ref": "#/components/responses/NotFound"},
+					"500": {"$ref": "#/components/responses/InternalError"}
+				}
+			}
+		},
+		"/api/alerts/rules/{ruleID}": {
+			"delete": {
+				"tags": ["alerts"],
+				"operationId": "deleteAlertRule",
+				"summary": "Delete an alert rule",
+				"x-required-role": "operator",
+				"parameters": [
+					{"name": "ruleID", "in": "path", "required": true, "schema": {"type": "string", "format": "uuid"}}
+				],
+				"responses": {
+					"200": {
+						"description": "ID of the deleted alert rule",
+						"content": {"application/json": {"schema": {"$ref": "#/components/schemas/AlertRule"}}}
+					},
+					"400": {"$ref": "#/components/responses/BadRequest"},
+					"401": {"$ref": "#/components/responses/Unauthorized"},
+					"403": {"$ref": "#/components/responses/Forbidden"},
+					"500": {"$ref": "#/components/responses/InternalError"}
+				}
+			}
+		},
+		"/api/notifications/channels": {
+			"get": {
+				"tags": ["notifications"],
+				"operationId": "listNotificationChannels",
+				"summary": "Get all notification channels",
+				"description": "The SMTP password and the values of the webhook headers are only returned to admins.",
+				"x-required-role": "operator",
+				"responses": {
+					"200": {
+						"description": "Notification channels",
+						"content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/NotificationChannel"}}}}
+					},
+					"401": {"$ref": "#/components/responses/Unauthorized"},
+					"403": {"$ref": "#/components/responses/Forbidden"},
+					"500": {"$ref": "#/components/responses/InternalError"}
+				}
+			},
+			"post": {
+				"tags": ["notifications"],
+				"operationId": "createNotificationChannel",
+				"summary": "Create a notification channel",
+				"x-required-role": "admin",
+				"requestBody": {
+					"required": true,
+					"content": {"application/json": {"schema": {"$ref": "#/components/schemas/NotificationChannel"}}}
+				},
+				"responses": {
+					"201": {
+						"description": "Created notification channel",
+						"content": {"application/json": {"schema": {"$ref": "#/components/schemas/NotificationChannel"}}}
+					},
+					"400": {"$ref": "#/components/responses/BadRequest"},
+					"401": {"$ref": "#/components/responses/Unauthorized"},
+					"403": {"$ref": "#/components/responses/Forbidden"},
+					"500": {"$ref": "#/components/responses/InternalError"}
+				}
+			},
+			"put": {
+				"tags": ["notifications"],
+				"operationId": "updateNotificationChannel",
+				"summary": "Update a notification channel",
+				"x-required-role": "admin",
+				"requestBody": {
+					"required": true,
+					"content": {"application/json": {"schema": {"$ref": "#/components/schemas/NotificationChannel"}}}
+				},
+				"responses": {
+					"200": {
+						"description": "Updated notification channel",
+						"content": {"application/json": {"schema": {"$ref": "#/components/schemas/NotificationChannel"}}}
+					},
+					"400": {"$ref": "#/components/responses/BadRequest"},
+					"401": {"$ref": "#/components/responses/Unauthorized"},
+					"403": {"$ref": "#/components/responses/Forbidden"},
+					"500": {"$ref": "#/components/responses/InternalError"}
+				}
+			}
+		},
+		"/api/notifications/channels/{channelID}": {
+			"delete": {
+				"tags": ["notifications"],
+				"operationId": "deleteNotificationChannel",
+				"summary": "Delete a notification channel",
+				"x-required-role": "admin",
+				"parameters": [
+					{"$ref": "#/components/parameters/ChannelID"}
+				],
+				"responses": {
+					"200": {
+						"description": "ID of the deleted notification channel",
+						"content": {"application/json": {"schema": {"$ref": "#/components/schemas/NotificationChannel"}}}
+					},
+					"400": {"$ref": "#/components/responses/BadRequest"},
+					"401": {"$ref": "#/components/responses/Unauthorized"},
+					"403": {"$ref": "#/components/responses/Forbidden"},
+					"500": {"$ref": "#/components/responses/InternalError"}
+				}
+			}
+		},
+		"/api/notifications/channels/{channelID}/test": {
+			"post": {
+				"tags": ["notifications"],
+				"operationId": "testNotificationChannel",
+				"summary": "Send a test notification to a channel",
+				"x-required-role": "operator",
+				"parameters": [
+					{"$ref": "#/components/parameters/ChannelID"}
+				],
+				"responses": {
+					"200": {
+						"description": "Outcome of sending",
+						"content": {"application/json": {"schema": {"$ref": "#/components/schemas/SubmitResult"}}}
+					},
+					"400": {"$ref": "#/components/responses/BadRequest"},
+					"401": {"$ref": "#/components/responses/Unauthorized"},
+					"403": {"$ref": "#/components/responses/Forbidden"},
+					"404": {"$ref": "#/components/responses/NotFound"},
+					"500": {"$ref": "#/components/responses/InternalError"}
+				}
+			}
+		}
+	},
+	"components": {
+		"securitySchemes": {
+			"bearerAuth": {"type": "http", "scheme": "bearer", "bearerFormat": "JWT"}
+		},
+		"parameters": {
+			"Archived": {"name": "archived", "in": "query", "description": "Return the archived instead of the active ones", "schema": {"type": "boolean"}},
+			"SlaveID": {"name": "slaveID", "in": "path", "required": true, "schema": {"type": "string", "format": "uuid"}},
+			"TargetID": {"name": "targetID", "in": "path", "required": true, "schema": {"type": "string", "format": "uuid"}},
+			"GroupID": {"name": "groupID", "in": "path", "required": true, "schema": {"type": "string", "format": "uuid"}},
+			"ChannelID": {"name": "channelID", "in": "path", "required": true, "schema": {"type": "string", "format": "uuid"}}
+		},
+		"responses": {
+			"AuthTokens": {
+				"description": "Tokens of the session",
+				"content": {"application/json": {"schema": {"$ref": "#/components/schemas/AuthTokens"}}}
+			},
+			"SlaveID": {
+				"description": "ID of the slave",
+				"content": {"application/json": {"schema": {"$ref": "#/components/schemas/Slave"}}}
+			},
+			"TargetID": {
+				"description": "ID of the target",
+				"content": {"application/json": {"schema": {"$ref": "#/components/schemas/TraceTarget"}}}
+			},
+			"BadRequest": {"$ref": "#/components/responses/Error"},
+			"Unauthorized": {"$ref": "#/components/responses/Error"},
+			"Forbidden": {"$ref": "#/components/responses/Error"},
+			"NotFound": {"$ref": "#/components/responses/Error"},
+			"Conflict": {"$ref": "#/components/responses/Error"},
+			"InternalError": {"$ref": "#/components/responses/Error"},
+			"Error": {
+				"description": "Error message",
+				"content": {"text/plain": {"schema": {"type": "string"}}}
+			}
+		},
+		"schemas": {
+			"AuthTokens": {
+				"type": "object",
+				"properties": {
+					"AccessToken": {"type": "string", "description": "JWT to send as bearer token"},
+					"RefreshToken": {"type": "string", "description": "Gets new tokens once the access token expired, missing if the session doesn't change"},
+					"ExpiresAt": {"type": "string", "format": "date-time", "description": "Expiration time of the access token"}
+				}
+			},
+			"Status": {
+				"type": "object",
+				"properties": {
+					"Uptime": {"type": "string"},
+					"LastSlaveConfigTime": {"type": "string", "description": "Time since the last config was sent to a slave"},
+					"LastSlaveConfig": {"type": "string"},
+					"LastAlerts": {"type": "array", "items": {"$ref": "#/components/schemas/AppAlert"}}
+				}
+			},
+			"AppAlert": {
+				"type": "object",
+				"properties": {
+					"Time": {"type": "string", "format": "date-time"},
+					"Text": {"type": "string"},
+					"Source": {"type": "string"},
+					"Severity": {"type": "string"}
+				}
+			},
+			"Slave": {
+				"type": "object",
+				"properties": {
+					"ID": {"type": "string", "format": "uuid"},
+					"Name": {"type": "string"},
+					"Secret": {"type": "string"},
+					"Status": {"$ref": "#/components/schemas/SlaveStatus"},
+					"Archived": {"type": "string", "format": "date-time"},
+					"PurgeRequested": {"type": "string", "format": "date-time"}
+				}
+			},
+			"SlaveStatus": {
+				"type": "object",
+				"properties": {
+					"Version": {"type": "string"},
+					"UptimeSec": {"type": "integer"},
+					"QueueDepth": {"type": "integer"},
+					"SourceIP": {"type": "string"},
+					"LastConfigPoll": {"type": "string", "format": "date-time", "nullable": true},
+					"LastResult": {"type": "string", "format": "date-time", "nullable": true},
+					"LastSeen": {"type": "string", "format": "date-time", "nullable": true}
+				}
+			},
+			"SlaveTelemetry": {
+				"type": "object",
+				"properties": {
+					"ID": {"type": "string", "format": "uuid"},
+					"Received": {"type": "string", "format": "date-time"},
+					"SlaveTime": {"type": "string", "format": "date-time"},
+					"QueueDepth": {"type": "integer"},
+					"DroppedResults": {"type": "integer"},
+					"Measurements": {"type": "integer"},
+					"MeasurementAvgMs": {"type": "number"},
+					"MeasurementMaxMs": {"type": "number"},
+					"ErrorCounts": {"type": "object", "additionalProperties": {"type": "integer"}},
+					"ClockOffsetMs": {"type": "number"},
+					"Load1": {"type": "number"},
+					"Load5": {"type": "number"},
+					"Load15": {"type": "number"}
+				}
+			},
+			"TraceTarget": {
+				"type": "object",
+				"properties": {
+					"ID": {"type": "string", "format": "uuid"},
+					"Name": {"type": "string"},
+					"Address": {"type": "string"},
+					"Retries": {"type": "integer"},
+					"MaxHops": {"type": "integer"},
+					"TimeoutMs": {"type": "integer"},
+					"Archived": {"type": "string", "format": "date-time"},
+					"PurgeRequested": {"type": "string", "format": "date-time"}
+				}
+			},
+			"TargetImportRow": {
+				"type": "object",
+				"properties": {
+					"name": {"type": "string"},
+					"address": {"type": "string"},
+					"retries": {"type": "integer"},
+					"maxHops": {"type": "integer"},
+					"timeoutMs": {"type": "integer"},
+					"group": {"type": "string", "description": "Name or ID of a target group"}
+				}
+			},
+			"TargetImportReport": {
+				"type": "object",
+				"properties": {
+					"DryRun": {"type": "boolean"},
+					"Created": {"type": "integer"},
+					"Updated": {"type": "integer"},
+					"Unchanged": {"type": "integer"},
+					"Failed": {"type": "integer"},
+					"Results": {"type": "array", "items": {"$ref": "#/components/schemas/TargetImportResult"}}
+				}
+			},
+			"TargetImportResult": {
+				"type": "object",
+				"properties": {
+					"Row": {"type": "integer"},
+					"Name": {"type": "string"},
+					"Result": {"type": "string"},
+					"TargetID": {"type": "string", "format": "uuid"},
+					"Changes": {"type": "array", "items": {"type": "string"}},
+					"Error": {"type": "string"}
+				}
+			},
+			"User": {
+				"type": "object",
+				"properties": {
+					"ID": {"type": "string", "format": "uuid"},
+					"Name": {"type": "string"},
+					"Password": {"type": "string", "format": "byte", "description": "argon2id hash, send the password in plain text to change it"},
+					"Salt": {"type": "integer", "description": "Salt of SHA-256 hashes of older versions, 0 for argon2id hashes"},
+					"PasswordNeedsChange": {"type": "boolean"},
+					"Role": {"type": "string", "enum": ["viewer", "operator", "admin"], "description": "Kept on update if empty"},
+					"TargetGroups": {"type": "array", "items": {"type": "string", "format": "uuid"}, "description": "Target groups the user is restricted to, none allows all targets. Ignored for admins, kept on update if missing."}
+				}
+			},
+			"TargetGroup": {
+				"type": "object",
+				"properties": {
+					"ID": {"type": "string", "format": "uuid"},
+					"Name": {"type": "string"},
+					"Retries": {"type": "integer"},
+					"MaxHops": {"type": "integer"},
+					"TimeoutMs": {"type": "integer"},
+					"TargetIDs": {"type": "array", "items": {"type": "string", "format": "uuid"}}
+				}
+			},
+			"SlaveGroup": {
+				"type": "object",
+				"properties": {
+					"ID": {"type": "string", "format": "uuid"},
+					"Name": {"type": "string"},
+					"SlaveIDs": {"type": "array", "items": {"type": "string", "format": "uuid"}},
+					"TargetGroupIDs": {"type": "array", "items": {"type": "string", "format": "uuid"}}
+				}
+			},
+			"TraceHistoryEntry": {
+				"type": "object",
+				"properties": {
+					"TraceID": {"type": "string", "format": "uuid"},
+					"SlaveID": {"type": "string", "format": "uuid"},
+					"SlaveName": {"type": "string"},
+					"DestID": {"type": "string", "format": "uuid"},
+					"DestName": {"type": "string"},
+					"StartTime": {"type": "string"},
+					"HopCnt": {"type": "integer"},
+					"DetailJSON": {"type": "string", "description": "JSON array of the hops with IP, DNS and Duration"}
+				}
+			},
+			"GraphData": {
+				"type": "object",
+				"properties": {
+					"Start": {"type": "string", "format": "date-time"},
+					"End": {"type": "string", "format": "date-time"},
+					"Data": {
+						"type": "array",
+						"description": "Links between hops: previous hop address, hop address, count, average duration in ms",
+						"items": {"type": "array", "items": {"oneOf": [{"type": "string"}, {"type": "number"}]}}
+					}
+				}
+			},
+			"PathSighting": {
+				"type": "object",
+				"properties": {
+					"PathID": {"type": "string"},
+					"SlaveID": {"type": "string", "format": "uuid"},
+					"TargetID": {"type": "string", "format": "uuid"},
+					"FirstSeen": {"type": "string", "format": "date-time"},
+					"LastSeen": {"type": "string", "format": "date-time"},
+					"Count": {"type": "integer"},
+					"Hops": {"type": "array", "items": {"$ref": "#/components/schemas/PathHop"}}
+				}
+			},
+			"PathHop": {
+				"type": "object",
+				"properties": {
+					"Index": {"type": "integer"},
+					"IPAddress": {"type": "string"},
+					"DNSName": {"type": "string"},
+					"PrevIPAddress": {"type": "string"}
+				}
+			},
+			"TraceroutePage": {
+				"type": "object",
+				"properties": {
+					"Traceroutes": {"type": "array", "items": {"$ref": "#/components/schemas/Traceroute"}},
+					"NextCursor": {"type": "string", "description": "Set if there are more traceroutes"}
+				}
+			},
+			"Traceroute": {
+				"type": "object",
+				"properties": {
+					"ID": {"type": "string", "format": "uuid"},
+					"StartTime": {"type": "string", "format": "date-time"},
+					"Slave": {
+						"type": "object",
+						"properties": {
+							"ID": {"type": "string", "format": "uuid"},
+							"Name": {"type": "string"}
+						}
+					},
+					"Target": {
+						"type": "object",
+						"properties": {
+							"ID": {"type": "string", "format": "uuid"},
+							"Name": {"type": "string"},
+							"Address": {"type": "string"}
+						}
+					},
+					"Success": {"type": "boolean"},
+					"HopCount": {"type": "integer"},
+					"PathID": {"type": "string"},
+					"Hops": {"type": "array", "items": {"$ref": "#/components/schemas/TracerouteHop"}}
+				}
+			},
+			"TracerouteHop": {
+				"type": "object",
+				"properties": {
+					"Index": {"type": "integer"},
+					"IPAddress": {"type": "string"},
+					"DNSName": {"type": "string"},
+					"RTTMs": {"type": "number"},
+					"Loss": {"type": "number"},
+					"AS": {"$ref": "#/components/schemas/ASNInfo"}
+				}
+			},
+			"ASNInfo": {
+				"type": "object",
+				"properties": {
+					"Number": {"type": "integer"},
+					"Country": {"type": "string"},
+					"Description": {"type": "string"}
+				}
+			},
+			"Event": {
+				"type": "object",
+				"properties": {
+					"ID": {"type": "integer"},
+					"Type": {"type": "string", "enum": ["traceroute", "routeChange", "alert", "slaveStatus"]},
+					"Time": {"type": "string", "format": "date-time"},
+					"Data": {
+						"oneOf": [
+							{"$ref": "#/components/schemas/Traceroute"},
+							{"$ref": "#/components/schemas/RouteChange"},
+							{"$ref": "#/components/schemas/Alert"},
+							{"$ref": "#/components/schemas/SlaveStatusChange"}
+						]
+					}
+				}
+			},
+			"RouteChange": {
+				"type": "object",
+				"properties": {
+					"Slave": {
+						"type": "object",
+						"properties": {
+							"ID": {"type": "string", "format": "uuid"},
+							"Name": {"type": "string"}
+						}
+					},
+					"Target": {
+						"type": "object",
+						"properties": {
+							"ID": {"type": "string", "format": "uuid"},
+							"Name": {"type": "string"},
+							"Address": {"type": "string"}
+						}
+					},
+					"TracerouteID": {"type": "string", "format": "uuid"},
+					"PreviousPathID": {"type": "string"},
+					"PathID": {"type": "string"},
+					"PreviousHops": {"type": "array", "items": {"type": "string"}, "description": "IP addresses of the hops of the previous path"},
+					"Hops": {"type": "array", "items": {"type": "string"}, "description": "IP addresses of the hops of the new path"}
+				}
+			},
+			"SlaveStatusChange": {
+				"type": "object",
+				"properties": {
+					"Slave": {
+						"type": "object",
+						"properties": {
+							"ID": {"type": "string", "format": "uuid"},
+							"Name": {"type": "string"}
+						}
+					},
+					"Activity": {"type": "string", "enum": ["configPoll", "result"], "description": "Contact which changed the status, empty if the slave became silent"},
+					"Changes": {"type": "array", "items": {"type": "string", "enum": ["reachable", "version", "silent"]}},
+					"Status": {"$ref": "#/components/schemas/SlaveStatus"}
+				}
+			},
+			"Graph": {
+				"type": "object",
+				"properties": {
+					"Target": {
+						"type": "object",
+						"properties": {
+							"ID": {"type": "string", "format": "uuid"},
+							"Name": {"type": "string"},
+							"Address": {"type": "string"}
+						}
+					},
+					"Start": {"type": "string", "format": "date-time"},
+					"End": {"type": "string", "format": "date-time"},
+					"Nodes": {"type": "array", "items": {"$ref": "#/components/schemas/GraphNode"}},
+					"Edges": {"type": "array", "items": {"$ref": "#/components/schemas/GraphEdge"}}
+				}
+			},
+			"GraphNode": {
+				"type": "object",
+				"properties": {
+					"ID": {"type": "string", "description": "IP address of a hop, 'slave:' and the ID of a slave"},
+					"Type": {"type": "string", "enum": ["slave", "hop"]},
+					"Name": {"type": "string", "description": "Name of a slave"},
+					"IPAddress": {"type": "string"},
+					"DNSName": {"type": "string"},
+					"AS": {"$ref": "#/components/schemas/ASNInfo"},
+					"Count": {"type": "integer"},
+					"Slaves": {"type": "array", "items": {"type": "string", "format": "uuid"}}
+				}
+			},
+			"GraphEdge": {
+				"type": "object",
+				"properties": {
+					"Source": {"type": "string", "description": "ID of the source node"},
+					"Target": {"type": "string", "description": "ID of the destination node"},
+					"HopIndex": {"type": "integer"},
+					"Count": {"type": "integer"},
+					"FirstSeen": {"type": "string", "format": "date-time"},
+					"LastSeen": {"type": "string", "format": "date-time"},
+					"RTTMs": {
+						"type": "object",
+						"properties": {
+							"Min": {"type": "number"},
+							"Avg": {"type": "number"},
+							"P50": {"type": "number"},
+							"P90": {"type": "number"},
+							"P99": {"type": "number"},
+							"Max": {"type": "number"}
+						}
+					},
+					"Loss": {"type": "number", "description": "Share of lost probes, missing if only aggregated data was found"},
+					"Slaves": {
+						"type": "array",
+						"items": {
+							"type": "object",
+							"properties": {
+								"ID": {"type": "string", "format": "uuid"},
+								"Count": {"type": "integer"}
+							}
+						}
+					}
+				}
+			},
+			"Alert": {
+				"type": "object",
+				"properties": {
+					"ID": {"type": "string", "format": "uuid"},
+					"RuleID": {"type": "string", "format": "uuid"},
+					"DedupKey": {"type": "string"},
+					"Severity": {"type": "string"},
+					"Source": {"type": "string"},
+					"Text": {"type": "string"},
+					"State": {"type": "string", "enum": ["firing", "resolved", "acknowledged"]},
+					"FirstSeen": {"type": "string", "format": "date-time"},
+					"LastSeen": {"type": "string", "format": "date-time"},
+					"Resolved": {"type": "string", "format": "date-time", "nullable": true},
+					"Acknowledged": {"type": "string", "format": "date-time", "nullable": true},
+					"AcknowledgedBy": {"type": "string"},
+					"Count": {"type": "integer"}
+				}
+			},
+			"AlertRule": {
+				"type": "object",
+				"properties": {
+					"ID": {"type": "string", "format": "uuid"},
+					"Name": {"type": "string"},
+					"Type": {"type": "string", "enum": ["targetUnreachable", "rttThreshold", "hopCountChange", "pathChange", "slaveSilent"]},
+					"TargetID": {"type": "string", "format": "uuid", "description": "Required for all types except slaveSilent"},
+					"SlaveID": {"type": "string", "format": "uuid"},
+					"Threshold": {"type": "integer", "minimum": 0, "maximum": 100000},
+					"DurationMin": {"type": "integer", "minimum": 1, "maximum": 10080},
+					"Severity": {"type": "string", "enum": ["info", "warning", "error"]},
+					"Enabled": {"type": "boolean"}
+				}
+			},
+			"NotificationChannel": {
+				"type": "object",
+				"properties": {
+					"ID": {"type": "string", "format": "uuid"},
+					"Name": {"type": "string"},
+					"Type": {"type": "string", "enum": ["webhook", "smtp", "syslog"]},
+					"MinSeverity": {"type": "string", "enum": ["info", "warning", "error"]},
+					"Enabled": {"type": "boolean"},
+					"Webhook": {
+						"type": "object",
+						"properties": {
+							"URL": {"type": "string"},
+							"Template": {"type": "string"},
+							"Headers": {"type": "object", "additionalProperties": {"type": "string"}}
+						}
+					},
+					"SMTP": {
+						"type": "object",
+						"properties": {
+							"Host": {"type": "string"},
+							"Port": {"type": "integer"},
+							"Username": {"type": "string"},
+							"Password": {"type": "string"},
+							"From": {"type": "string"},
+							"To": {"type": "array", "items": {"type": "string"}}
+						}
+					},
+					"Syslog": {
+						"type": "object",
+						"properties": {
+							"Network": {"type": "string", "enum": ["udp", "tcp"]},
+							"Address": {"type": "string"},
+							"Facility": {"type": "integer"},
+							"AppName": {"type": "string"}
+						}
+					}
+				}
+			},
+			"SubmitResult": {
+				"type": "object",
+				"properties": {
+					"Success": {"type": "boolean"},
+					"Error": {"type": "string"},
+					"RetryPossible": {"type": "boolean"}
+				}
+			}
+		}
+	}
+}
+`
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// openAPIDocument is the part of the spec checked against the routes
+type openAPIDocument struct {
+	OpenAPI    string                                       `json:"openapi"`
+	Paths      map[string]map[string]openAPIOperation       `json:"paths"`
+	Components map[string]map[string]map[string]interface{} `json:"components"`
+}
+
+type openAPIOperation struct {
+	OperationID string                   `json:"operationId"`
+	Parameters  []map[string]interface{} `json:"parameters"`
+	Responses   map[string]interface{}   `json:"responses"`
+}
+
+func loadOpenAPISpec(t *testing.T) openAPIDocument {
+	var doc openAPIDocument
+	if err := json.Unmarshal([]byte(openAPISpec), &doc); err != nil {
+		t.Fatal("spec isn't valid JSON: ", err)
+	}
+	if !strings.HasPrefix(doc.OpenAPI, "3.") {
+		t.Fatalf("spec has version '%v', expected OpenAPI 3", doc.OpenAPI)
+	}
+	return doc
+}
+
+// apiRoutes returns the methods of all api routes by path template, routes without methods match every method
+func apiRoutes(t *testing.T) map[string][]string {
+	routes := make(map[string][]string)
+
+	_, publicRouter, apiRouter := newRouters()
+	for _, router := range []*mux.Router{publicRouter, apiRouter} {
+		err := router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+			path, err := route.GetPathTemplate()
+			if err != nil {
+				return err
+			}
+			methods, _ := route.GetMethods()
+			if _, ok := routes[path]; !ok || len(methods) == 0 {
+				routes[path] = nil
+			}
+			for _, method := range methods {
+				routes[path] = append(routes[path], strings.ToLower(method))
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal("can't walk routes: ", err)
+		}
+	}
+	return routes
+}
+
+func TestOpenAPISpecCoversRoutes(t *testing.T) {
+	doc := loadOpenAPISpec(t)
+	routes := apiRoutes(t)
+
+	for path, methods := range routes {
+		operations, ok := doc.Paths[path]
+		if !ok {
+			t.Errorf("route '%v' is missing in the spec", path)
+			continue
+		}
+		if len(operations) == 0 {
+			t.Errorf("route '%v' has no operations in the spec", path)
+		}
+		for _, method := range methods {
+			if _, ok := operations[method]; !ok {
+				t.Errorf("route '%v %v' is missing in the spec", strings.ToUpper(method), path)
+			}
+		}
+	}
+
+	for path, operations := range doc.Paths {
+		methods, ok := routes[path]
+		if !ok {
+			t.Errorf("spec documents '%v' which isn't routed", path)
+			continue
+		}
+		if methods == nil {
+			continue
+		}
+		for method := range operations {
+			if !contains(methods, method) {
+				t.Errorf("spec documents '%v %v' which isn't routed", strings.ToUpper(method), path)
+			}
+		}
+	}
+}
+
+func TestOpenAPISpecOperations(t *testing.T) {
+	doc := loadOpenAPISpec(t)
+	pathParam := regexp.MustCompile(`{([^}]+)}`)
+	operationIDs := make(map[string]string)
+
+	// resolve a parameter reference to its definition
+	parameter := func(param map[string]interface{}) map[string]interface{} {
+		ref, ok := param["$ref"].(string)
+		if !ok {
+			return param
+		}
+		return doc.Components["parameters"][strings.TrimPrefix(ref, "#/components/parameters/")]
+	}
+
+	for path, operations := range doc.Paths {
+		for method, op := range operations {
+			name := strings.ToUpper(method) + " " + path
+
+			if op.OperationID == "" {
+				t.Errorf("'%v' has no operationId", name)
+			} else if other, ok := operationIDs[op.OperationID]; ok {
+				t.Errorf("'%v' and '%v' have the same operationId '%v'", name, other, op.OperationID)
+			}
+			operationIDs[op.OperationID] = name
+
+			if len(op.Responses) == 0 {
+				t.Errorf("'%v' has no responses", name)
+			}
+
+			documented := []string{}
+			for _, param := range op.Parameters {
+				if param := parameter(param); param != nil && param["in"] == "path" {
+					documented = append(documented, param["name"].(string))
+				}
+			}
+			routed := []string{}
+			for _, match := range pathParam.FindAllStringSubmatch(path, -1) {
+				routed = append(routed, match[1])
+			}
+			sort.Strings(documented)
+			sort.Strings(routed)
+			if strings.Join(documented, ",") != strings.Join(routed, ",") {
+				t.Errorf("'%v' documents path parameters %v, expected %v", name, documented, routed)
+			}
+		}
+	}
+}
+
+func TestOpenAPISpecReferences(t *testing.T) {
+	doc := loadOpenAPISpec(t)
+
+	var raw interface{}
+	if err := json.Unmarshal([]byte(openAPISpec), &raw); err != nil {
+		t.Fatal("spec isn't valid JSON: ", err)
+	}
+
+	// every reference has to point to a component
+	var check func(val interface{})
+	check = func(val interface{}) {
+		switch val := val.(type) {
+		case map[string]interface{}:
+			if ref, ok := val["$ref"].(string); ok {
+				parts := strings.Split(strings.TrimPrefix(ref, "#/components/"), "/")
+				if len(parts) != 2 || doc.Components[parts[0]][parts[1]] == nil {
+					t.Errorf("reference '%v' can't be resolved", ref)
+				}
+			}
+			for _, v := range val {
+				check(v)
+			}
+		case []interface{}:
+			for _, v := range val {
+				check(v)
+			}
+		}
+	}
+	check(raw)
+}
+
+func TestOpenAPISpecServed(t *testing.T) {
+	_, publicRouter, _ := newRouters()
+
+	res := httptest.NewRecorder()
+	publicRouter.ServeHTTP(res, httptest.NewRequest("GET", "/api/openapi.json", nil))
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("got status %v, expected 200", res.Code)
+	}
+	if res.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("got content type '%v', expected application/json", res.Header().Get("Content-Type"))
+	}
+	if res.Body.String() != openAPISpec {
+		t.Error("served spec differs from openAPISpec")
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
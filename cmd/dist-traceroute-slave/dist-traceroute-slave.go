@@ -103,11 +103,14 @@ func runMeasurement(target disttrace.TraceTarget, cfg disttrace.SlaveConfig, txB
 	defer measurementRunningLock.Unlock()
 
 	// do measurement
+	measurementStart := time.Now()
 	res, err := tracert.Traceroute(target.Address, &opts, c)
 	if err != nil {
 		log.Warnf("runMeasurement[%v]: Error while doing traceroute to target '%v': %v", target.ID, target.Name, err)
+		telemetry.RecordError(disttrace.SlaveErrorTraceroute)
 		return
 	}
+	telemetry.RecordMeasurement(time.Since(measurementStart))
 
 	if len(res.Hops) == 0 {
 		log.Warnf("runMeasurement[%v]: Strange, no hops received for target '%v'. Success: false", target.ID, target.Name)
@@ -131,6 +134,7 @@ func runMeasurement(target disttrace.TraceTarget, cfg disttrace.SlaveConfig, txB
 		if _, exists := uniqueIPs[hop.Address]; exists {
 			log.Infof("runMeasurement[%v]: Found duplicate hop '%v' for target '%v' (hop # '%v') in traceroute result, discarding result...", target.ID, hop.HostOrAddressString(), target.Name, hop.TTL)
 			log.Debugf("runMeasurement[%v]: List of all hops: %v", target.ID, result.Hops)
+			telemetry.RecordError(disttrace.SlaveErrorDuplicateHop)
 			return
 		}
 		uniqueIPs[hop.Address] = true
@@ -145,6 +149,7 @@ func runMeasurement(target disttrace.TraceTarget, cfg disttrace.SlaveConfig, txB
 		)
 	default:
 		log.Warnf("Couldn't add result for '%v' to queue (current queue size: %v), result discarded. Possibly transmission to master stalled?", result.Target.Name, *txBufferSize)
+		telemetry.RecordError(disttrace.SlaveErrorQueueFull)
 	}
 	return
 }
@@ -210,6 +215,7 @@ func txResultsToMaster(buf chan disttrace.TraceResult, bufSize *int32, slave dis
 			// prepare data to be sent
 			currentResult.Slave = slave
 			currentResult.Slave.ID = cfg.ID
+			currentResult.Slave.Status = disttrace.CurrentSlaveStatus(int(atomic.LoadInt32(bufSize)))
 			resultJSON, err := json.Marshal(currentResult)
 			if err != nil {
 				log.Warn("txResultsToMaster: Error: Couldn't create result json: ", err)
@@ -220,7 +226,7 @@ func txResultsToMaster(buf chan disttrace.TraceResult, bufSize *int32, slave dis
 			log.Debugf("txResultsToMaster: Transmitting, Content: %s", resultJSON)
 
 			// send data to master
-			url := "http://" + cfg.MasterHost + ":" + cfg.MasterPort + "/slave/results"
+			url := cfg.MasterURL("/slave/results")
 
 			httpResp, err := httpClient.Post(url, "application/json", bytes.NewBuffer(resultJSON))
 			if err != nil {
@@ -280,12 +286,14 @@ func txResultsToMaster(buf chan disttrace.TraceResult, bufSize *int32, slave dis
 	endWork:
 
 		if workErr != nil {
+			telemetry.RecordError(disttrace.SlaveErrorTransmit)
 			workErrCount++
 			log.Warnf("txResultsToMaster: An error occurred when handling workitem '%v'. Will retry, retrycount: %v/%v...", currentResult.Target.Name, workErrCount, numMaxRetries)
 			time.Sleep(10 * time.Second)
 		}
 		if workErrCount >= numMaxRetries {
 			log.Warnf("txResultsToMaster: Too many retries reached for workitem '%v'. Discarding item and continuing...", currentResult.Target.Name)
+			telemetry.RecordError(disttrace.SlaveErrorRetryExceeded)
 			currentResult = disttrace.TraceResult{}
 			workReceived = false
 			workErrCount = 0
@@ -346,7 +354,8 @@ func main() {
 	var txSendBufferCnt = new(int32)
 
 	// parse cmdline arguments
-	var masterHost, masterPort, logLevel, logPathAndName string
+	var masterHost, masterPort, logLevel, logPathAndName, metricsListen string
+	var masterTLS bool
 	var slave disttrace.Slave
 
 	// check cmdline args
@@ -359,10 +368,12 @@ func main() {
 		fSet.SetOutput(outBuf)
 		fSet.StringVar(&masterHost, "master", "", "Set the `hostname`/IP of the master server")
 		fSet.StringVar(&masterPort, "master-port", "8990", "Set the listening `port (optional)` of the master server")
+		fSet.BoolVar(&masterTLS, "master-tls", false, "Connect to the master server with HTTPS")
 		fSet.StringVar(&slaveName, "name", "", "Unique `name` of this slave used on master for authentication and storage of results")
 		fSet.StringVar(&slaveSecret, "secret", "", "Shared `secret` for slave on master")
 		fSet.StringVar(&logPathAndName, "log", "./slave.log", "Logfile location `/path/to/file`")
 		fSet.StringVar(&logLevel, "loglevel", "info", "Specify loglevel, one of `warn, info, debug`")
+		fSet.StringVar(&metricsListen, "metrics-listen", "", "Serve Prometheus metrics on `[host]:port` (optional)")
 		fSet.BoolVar(&debugMode, "zDebugResults", false, "Generate fake results, e.g. when run without root permissions")
 		fSet.BoolVar(&sendHelp, "help", false, "display this message")
 		fSet.Parse(os.Args[1:])
@@ -377,6 +388,9 @@ func main() {
 		case !okSlave || (!valid.IsDNSName(masterHost) && !valid.IsIP(masterHost)) || !valid.IsPort(masterPort):
 			log.Warn("Error: No or invalid arguments for master, master-port or credentials, can't run, Bye.")
 			disttrace.PrintUsageAndExit(fSet, true)
+		case metricsListen != "" && !isListenAddress(metricsListen):
+			log.Warn("Error: Invalid metrics listen address specified, can't run, Bye.")
+			disttrace.PrintUsageAndExit(fSet, true)
 		case errLog != nil:
 			log.Warn("Error: Invalid log path specified, can't run, Bye.")
 			disttrace.PrintUsageAndExit(fSet, true)
@@ -403,7 +417,7 @@ func main() {
 	ppCfg := &pCfg
 
 	log.Info("Main: Launching config poller process...")
-	go disttrace.ConfigPoller(masterHost, masterPort, slave, ppCfg)
+	go disttrace.ConfigPoller(masterHost, masterPort, masterTLS, slave, txSendBufferCnt, ppCfg)
 
 	log.Info("Main: Launching transmit process...")
 	go txResultsToMaster(txSendBuffer, txSendBufferCnt, slave, ppCfg)
@@ -411,6 +425,14 @@ func main() {
 	log.Info("Main: Launching trace poller process...")
 	go tracePoller(txSendBuffer, txSendBufferCnt, ppCfg)
 
+	log.Info("Main: Launching telemetry process...")
+	go telemetryReporter(txSendBufferCnt, slave, ppCfg)
+
+	if metricsListen != "" {
+		log.Info("Main: Launching metrics process...")
+		go metricsServer(metricsListen, txSendBufferCnt)
+	}
+
 	// wait here until told to quit by os signal
 	log.Info("Main: startup finished, going to sleep...")
 	disttrace.WaitForOSSignalAndQuit()
@@ -424,6 +446,14 @@ func main() {
 	log.Info("Main: Waiting for transmit process to quit...")
 	txProcRunning <- true
 
+	log.Info("Main: Waiting for telemetry process to quit...")
+	telemetryProcRunning <- true
+
+	if metricsListen != "" {
+		log.Info("Main: Waiting for metrics process to quit...")
+		metricsProcRunning <- true
+	}
+
 	log.Info("Warn: Everything has gracefully ended...")
 	log.Info("Warn: Bye.")
 }
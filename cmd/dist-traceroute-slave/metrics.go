@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	valid "github.com/asaskevich/govalidator"
+	"github.com/xmirakulix/dist-traceroute/disttrace"
+)
+
+// mutex for state of metrics goroutine
+var metricsProcRunning = make(chan bool, 1)
+
+// number of results waiting for transmission to the master
+var metricQueueDepth = disttrace.NewMetricGauge("disttrace_slave_queue_depth",
+	"Number of results waiting for transmission to the master.")
+
+// metricsServer runs as process, serves the slave's metrics to Prometheus on the given address
+func metricsServer(addr string, bufSize *int32) {
+
+	// lock mutex
+	metricsProcRunning <- true
+
+	disttrace.RegisterMetricsCollector(func() {
+		metricQueueDepth.Set(float64(atomic.LoadInt32(bufSize)))
+	})
+
+	router := http.NewServeMux()
+	router.HandleFunc("/metrics", disttrace.MetricsHandler())
+	srv := &http.Server{
+		Addr:         addr,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+		Handler:      router,
+	}
+
+	go func() {
+		log.Infof("metricsServer: Listening on '%v'...", addr)
+		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+			log.Fatal("metricsServer: HTTP Server failure, ListenAndServe: ", err)
+		}
+	}()
+
+	// wait for quit signal...
+	for {
+		if disttrace.CheckForQuit() {
+			log.Warn("metricsServer: Received exit signal, bye.")
+			ctx, cFunc := context.WithTimeout(context.Background(), 5*time.Second)
+			if err := srv.Shutdown(ctx); err != nil {
+				log.Warn("metricsServer: Error while shutdown of HTTP server, Error: ", err)
+			}
+			cFunc()
+
+			<-metricsProcRunning
+			return
+		}
+
+		time.Sleep(1 * time.Second)
+	}
+}
+
+// isListenAddress checks for a port with an optional hostname or IP
+func isListenAddress(addr string) bool {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || !valid.IsPort(port) {
+		return false
+	}
+	return host == "" || valid.IsDNSName(host) || valid.IsIP(host)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/xmirakulix/dist-traceroute/disttrace"
+)
+
+// mutex for state of telemetry goroutine
+var telemetryProcRunning = make(chan bool, 1)
+
+// collects health counters of this slave between two reports
+var telemetry = disttrace.NewTelemetryCollector()
+
+// telemetryReporter runs as process, reports the slave's health to the master every minute
+func telemetryReporter(bufSize *int32, slave disttrace.Slave, ppCfg **disttrace.SlaveConfig) {
+
+	// lock mutex
+	telemetryProcRunning <- true
+
+	disttrace.WaitForValidConfig("telemetryReporter", ppCfg)
+
+	// init vars
+	var nextTime time.Time
+	var httpClient = &http.Client{
+		Timeout: time.Second * 10,
+	}
+
+	// infinite loop
+	log.Info("telemetryReporter: Start...")
+	for {
+		// check if we need to exit
+		if disttrace.CheckForQuit() {
+			log.Warn("telemetryReporter: Received exit signal, bye.")
+			<-telemetryProcRunning
+			return
+		}
+
+		// is it time to run?
+		if nextTime.Before(time.Now()) {
+			cfg := **ppCfg
+			report := telemetry.Snapshot(int(atomic.LoadInt32(bufSize)))
+			report.Slave = &slave
+
+			if err := sendTelemetry(httpClient, cfg, report); err != nil {
+				log.Warn("telemetryReporter: Couldn't send telemetry to master, Error: ", err)
+				telemetry.Restore(report)
+			}
+
+			// run again 30 seconds after the next full minute, in between the measurement runs
+			nextTime = time.Now().Truncate(time.Minute)
+			nextTime = nextTime.Add(time.Minute).Add(30 * time.Second)
+		}
+
+		// zzz...
+		time.Sleep(1 * time.Second)
+	}
+}
+
+// sendTelemetry transmits a single report to the master and measures the clock offset to the master
+func sendTelemetry(httpClient *http.Client, cfg disttrace.SlaveConfig, report disttrace.SlaveTelemetry) error {
+
+	reportJSON, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+
+	log.Debugf("sendTelemetry: Transmitting, Content: %s", reportJSON)
+
+	url := cfg.MasterURL("/slave/telemetry")
+
+	sent := time.Now()
+	httpResp, err := httpClient.Post(url, "application/json", bytes.NewBuffer(reportJSON))
+	if err != nil {
+		return err
+	}
+	defer httpResp.Body.Close()
+	received := time.Now()
+
+	httpRespBody, err := ioutil.ReadAll(httpResp.Body)
+	if err != nil {
+		return err
+	}
+
+	if httpResp.StatusCode != 200 {
+		return errors.New("Received non-OK status: " + httpResp.Status)
+	}
+
+	reply := disttrace.TelemetryReply{}
+	if err := json.Unmarshal(httpRespBody, &reply); err != nil {
+		return err
+	}
+	if !reply.Success {
+		return errors.New("Master replied success=false: " + reply.Error)
+	}
+
+	// master's clock is assumed to be read halfway through the request, reported with the next report
+	offset := reply.MasterTime.Sub(sent.Add(received.Sub(sent) / 2))
+	telemetry.RecordClockOffset(offset)
+
+	log.Debugf("sendTelemetry: Successfully transmitted telemetry, clock offset to master: %v", offset)
+	return nil
+}
@@ -0,0 +1,392 @@
+package disttrace
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AlertEvaluatorProcRunning mutex for graceful shutdown
+var AlertEvaluatorProcRunning = make(chan bool, 1)
+
+// appAlertQuietPeriod resolves application alerts, e.g. failed logins, which weren't raised again for this long
+const appAlertQuietPeriod = time.Hour
+
+// rttThresholdMinSamples is the number of traceroutes that must exceed the threshold of a rttThreshold rule, slaves
+// measure every target once a minute
+const rttThresholdMinSamples = 3
+
+// alertCondition is a single firing condition found while evaluating a rule
+type alertCondition struct {
+	DedupKey string
+	Source   string
+	Text     string
+}
+
+// AlertEvaluator runs as process, evaluates all enabled alert rules in the interval of the current master config
+func AlertEvaluator(db *DB) {
+
+	// lock mutex
+	AlertEvaluatorProcRunning <- true
+
+	// init vars
+	var nextTime time.Time
+	lastSilenceCheck := time.Now()
+
+	// infinite loop
+	log.Info("AlertEvaluator: Start...")
+	for {
+		// check if we need to exit
+		if CheckForQuit() {
+			log.Warn("AlertEvaluator: Received exit signal, bye.")
+			<-AlertEvaluatorProcRunning
+			return
+		}
+
+		// is it time to run?
+		if nextTime.Before(time.Now()) {
+			log.Debug("AlertEvaluator: Evaluating alert rules...")
+
+			if err := evaluateAlertRules(db); err != nil {
+				log.Warn("AlertEvaluator: Couldn't evaluate alert rules, Error: ", err)
+			}
+			if err := resolveQuietAppAlerts(db, appAlertQuietPeriod); err != nil {
+				log.Warn("AlertEvaluator: Couldn't resolve quiet application alerts, Error: ", err)
+			}
+
+			// slaves going silent don't contact the master, they are found here
+			now := time.Now()
+			publishSilentSlaves(db, lastSilenceCheck, now)
+			lastSilenceCheck = now
+
+			// run again at the start of the next interval
+			interval := CurrentMasterConfig().Alerting.EvaluationInterval
+			nextTime = time.Now().Truncate(interval)
+			nextTime = nextTime.Add(interval)
+		}
+
+		// zzz...
+		time.Sleep(1 * time.Second)
+	}
+}
+
+// evaluateAlertRules evaluates all enabled rules once, fires new alerts and resolves cleared ones
+func evaluateAlertRules(db *DB) error {
+
+	rules, err := GetAlertRules(db)
+	if err != nil {
+		return errors.New("Couldn't get alert rules")
+	}
+
+	for _, rule := range rules {
+		if !rule.Enabled {
+			continue
+		}
+
+		conditions, err := evaluateAlertRule(db, rule)
+		if err != nil {
+			log.Warnf("evaluateAlertRules: Couldn't evaluate rule '%v', Error: %v", rule.Name, err)
+			continue
+		}
+
+		// fire all alerts for currently met conditions
+		firing := make(map[string]bool)
+		for _, cond := range conditions {
+			firing[cond.DedupKey] = true
+			if _, err := FireAlert(db, rule.ID, rule.Severity, cond.DedupKey, cond.Source, cond.Text); err != nil {
+				log.Warnf("evaluateAlertRules: Couldn't fire alert for rule '%v', Error: %v", rule.Name, err)
+			}
+		}
+
+		// resolve all active alerts whose condition isn't met anymore
+		activeKeys, err := getActiveAlertKeysForRule(db, rule.ID)
+		if err != nil {
+			log.Warnf("evaluateAlertRules: Couldn't get active alerts for rule '%v', Error: %v", rule.Name, err)
+			continue
+		}
+		for _, key := range activeKeys {
+			if !firing[key] {
+				if err := ResolveAlert(db, key); err != nil {
+					log.Warnf("evaluateAlertRules: Couldn't resolve alert for rule '%v', Error: %v", rule.Name, err)
+				}
+			}
+		}
+	}
+
+	log.Debugf("evaluateAlertRules: Evaluated '%v' alert rules", len(rules))
+	return nil
+}
+
+// evaluateAlertRule returns all conditions for which the given rule currently fires
+func evaluateAlertRule(db *DB, rule AlertRule) ([]alertCondition, error) {
+
+	since := time.Now().Add(-time.Duration(rule.DurationMin) * time.Minute).UTC().Format(time.RFC3339)
+
+	switch rule.Type {
+	case AlertRuleTargetUnreachable:
+		return evaluateTargetUnreachable(db, rule, since)
+	case AlertRuleRTTThreshold:
+		return evaluateRTTThreshold(db, rule, since)
+	case AlertRuleHopCountChange, AlertRulePathChange:
+		return evaluateRouteChange(db, rule, since)
+	case AlertRuleSlaveSilent:
+		return evaluateSlaveSilent(db, rule)
+	}
+
+	return nil, errors.New("Unknown rule type: " + rule.Type)
+}
+
+// alertDedupKey builds the key which identifies an alert of a rule for a given slave/target
+func alertDedupKey(rule AlertRule, slaveID uuid.UUID, targetID uuid.UUID) string {
+	return fmt.Sprintf("rule:%v:slave:%v:target:%v", rule.ID, slaveID, targetID)
+}
+
+// evaluateTargetUnreachable fires if the latest traceroute of at least 'Threshold' slaves didn't reach the target
+func evaluateTargetUnreachable(db *DB, rule AlertRule, since string) ([]alertCondition, error) {
+
+	target, err := GetTarget(rule.TargetID, db)
+	if err != nil || target.ID == uuid.Nil {
+		return nil, errors.New("Rule references unknown target")
+	}
+
+	query := `
+		SELECT strSlaveId, nSuccess FROM t_Traceroutes
+		WHERE strTargetId = ? AND ` + db.Dialect.TimeExpr("dtStart") + ` >= ` + db.Dialect.TimeExpr("?") + `
+		ORDER BY ` + db.Dialect.TimeExpr("dtStart") + ` DESC
+		`
+
+	rows, err := db.Query(query, target.ID, since)
+	if err != nil {
+		return nil, errors.New("Couldn't get traceroutes")
+	}
+	defer rows.Close()
+
+	// only the latest result of every slave counts
+	latest := make(map[uuid.UUID]bool)
+	for rows.Next() {
+		var slaveID uuid.UUID
+		var success bool
+		if err := rows.Scan(&slaveID, &success); err != nil {
+			return nil, errors.New("Couldn't read traceroutes")
+		}
+		if _, exists := latest[slaveID]; !exists {
+			latest[slaveID] = success
+		}
+	}
+
+	failed := 0
+	for _, success := range latest {
+		if !success {
+			failed++
+		}
+	}
+
+	threshold := rule.Threshold
+	if threshold < 1 {
+		threshold = 1
+	}
+	if failed < threshold {
+		return nil, nil
+	}
+
+	return []alertCondition{{
+		DedupKey: alertDedupKey(rule, uuid.Nil, target.ID),
+		Source:   "Target: " + target.Name,
+		Text:     fmt.Sprintf("Target '%v' is unreachable from %v of %v slaves", target.Name, failed, len(latest)),
+	}}, nil
+}
+
+// evaluateRTTThreshold fires for every slave whose RTT to the target stayed above 'Threshold' ms for the whole period,
+// a single slow traceroute isn't enough, the period has to hold at least rttThresholdMinSamples traceroutes
+func evaluateRTTThreshold(db *DB, rule AlertRule, since string) ([]alertCondition, error) {
+
+	target, err := GetTarget(rule.TargetID, db)
+	if err != nil || target.ID == uuid.Nil {
+		return nil, errors.New("Rule references unknown target")
+	}
+
+	query := `
+		SELECT s.strSlaveId, s.strSlaveName, t.strHopRTTs
+		FROM t_Traceroutes t
+		JOIN t_Slaves s ON t.strSlaveId = s.strSlaveId
+		WHERE t.strTargetId = ? AND ` + db.Dialect.TimeExpr("t.dtStart") + ` >= ` + db.Dialect.TimeExpr("?") + `
+		`
+
+	rows, err := db.Query(query, target.ID, since)
+	if err != nil {
+		return nil, errors.New("Couldn't get traceroutes")
+	}
+	defer rows.Close()
+
+	// the RTT of a traceroute is the duration of its last hop
+	type slaveRTT struct {
+		Name  string
+		Min   float64
+		Sum   float64
+		Count int
+	}
+	rtts := make(map[uuid.UUID]*slaveRTT)
+	order := []uuid.UUID{}
+	for rows.Next() {
+		var slaveID uuid.UUID
+		var slaveName string
+		var vector sql.NullString
+		if err := rows.Scan(&slaveID, &slaveName, &vector); err != nil {
+			return nil, errors.New("Couldn't read traceroutes")
+		}
+
+		durations := decodeVector(vector)
+		if len(durations) == 0 {
+			continue
+		}
+		rtt := durations[len(durations)-1] * 1000
+
+		sr, exists := rtts[slaveID]
+		if !exists {
+			sr = &slaveRTT{Name: slaveName, Min: rtt}
+			rtts[slaveID] = sr
+			order = append(order, slaveID)
+		}
+		if rtt < sr.Min {
+			sr.Min = rtt
+		}
+		sr.Sum += rtt
+		sr.Count++
+	}
+
+	conditions := []alertCondition{}
+	for _, slaveID := range order {
+		sr := rtts[slaveID]
+		if rule.SlaveID != uuid.Nil && rule.SlaveID != slaveID {
+			continue
+		}
+
+		if sr.Count >= rttThresholdMinSamples && sr.Min > float64(rule.Threshold) {
+			conditions = append(conditions, alertCondition{
+				DedupKey: alertDedupKey(rule, slaveID, target.ID),
+				Source:   "Slave: " + sr.Name,
+				Text: fmt.Sprintf("RTT to target '%v' above %vms for %v minutes, average: %.1fms",
+					target.Name, rule.Threshold, rule.DurationMin, sr.Sum/float64(sr.Count)),
+			})
+		}
+	}
+
+	return conditions, nil
+}
+
+// evaluateRouteChange fires for every slave whose two most recent traceroutes to the target differ in hop count
+// or, for path change rules, took different paths
+func evaluateRouteChange(db *DB, rule AlertRule, since string) ([]alertCondition, error) {
+
+	target, err := GetTarget(rule.TargetID, db)
+	if err != nil || target.ID == uuid.Nil {
+		return nil, errors.New("Rule references unknown target")
+	}
+
+	query := `
+		SELECT s.strSlaveId, s.strSlaveName, COALESCE(t.strPathId, ''), COALESCE(p.nHopCount, 0)
+		FROM t_Traceroutes t
+		JOIN t_Slaves s ON t.strSlaveId = s.strSlaveId
+		LEFT JOIN t_Paths p ON p.strPathId = t.strPathId
+		WHERE t.strTargetId = ? AND ` + db.Dialect.TimeExpr("t.dtStart") + ` >= ` + db.Dialect.TimeExpr("?") + `
+		ORDER BY ` + db.Dialect.TimeExpr("t.dtStart") + ` DESC
+		`
+
+	rows, err := db.Query(query, target.ID, since)
+	if err != nil {
+		return nil, errors.New("Couldn't get traceroutes")
+	}
+	defer rows.Close()
+
+	// collect the paths and hop counts of the two latest traceroutes of every slave
+	type slaveRoutes struct {
+		Name    string
+		PathIDs []string
+		Counts  []int
+	}
+	routes := make(map[uuid.UUID]*slaveRoutes)
+	order := []uuid.UUID{}
+	for rows.Next() {
+		var slaveID uuid.UUID
+		var slaveName, pathID string
+		var count int
+		if err := rows.Scan(&slaveID, &slaveName, &pathID, &count); err != nil {
+			return nil, errors.New("Couldn't read traceroutes")
+		}
+
+		sr, exists := routes[slaveID]
+		if !exists {
+			sr = &slaveRoutes{Name: slaveName}
+			routes[slaveID] = sr
+			order = append(order, slaveID)
+		}
+		if len(sr.Counts) < 2 {
+			sr.PathIDs = append(sr.PathIDs, pathID)
+			sr.Counts = append(sr.Counts, count)
+		}
+	}
+
+	conditions := []alertCondition{}
+	for _, slaveID := range order {
+		sr := routes[slaveID]
+		if rule.SlaveID != uuid.Nil && rule.SlaveID != slaveID {
+			continue
+		}
+		if len(sr.Counts) < 2 {
+			continue
+		}
+
+		switch {
+		case rule.Type == AlertRuleHopCountChange && sr.Counts[0] != sr.Counts[1]:
+			conditions = append(conditions, alertCondition{
+				DedupKey: alertDedupKey(rule, slaveID, target.ID),
+				Source:   "Slave: " + sr.Name,
+				Text:     fmt.Sprintf("Hop count to target '%v' changed from %v to %v", target.Name, sr.Counts[1], sr.Counts[0]),
+			})
+
+		case rule.Type == AlertRulePathChange && sr.PathIDs[0] != sr.PathIDs[1]:
+			conditions = append(conditions, alertCondition{
+				DedupKey: alertDedupKey(rule, slaveID, target.ID),
+				Source:   "Slave: " + sr.Name,
+				Text:     fmt.Sprintf("Path to target '%v' changed, now %v hops (was %v)", target.Name, sr.Counts[0], sr.Counts[1]),
+			})
+		}
+	}
+
+	return conditions, nil
+}
+
+// evaluateSlaveSilent fires for every slave that neither polled its config nor reported results for 'DurationMin' minutes
+func evaluateSlaveSilent(db *DB, rule AlertRule) ([]alertCondition, error) {
+
+	slaves, err := GetSlaves(db)
+	if err != nil {
+		return nil, errors.New("Couldn't get slaves")
+	}
+
+	conditions := []alertCondition{}
+	for _, slave := range slaves {
+		if rule.SlaveID != uuid.Nil && rule.SlaveID != slave.ID {
+			continue
+		}
+
+		text := fmt.Sprintf("Slave '%v' was never seen", slave.Name)
+		if lastSeen := slave.Status.LastSeen; lastSeen != nil {
+			if time.Since(*lastSeen) < time.Duration(rule.DurationMin)*time.Minute {
+				continue
+			}
+			text = fmt.Sprintf("Slave '%v' is silent, last seen %v ago from %v", slave.Name, time.Since(*lastSeen).Truncate(time.Second), slave.Status.SourceIP)
+		}
+
+		conditions = append(conditions, alertCondition{
+			DedupKey: alertDedupKey(rule, slave.ID, uuid.Nil),
+			Source:   "Slave: " + slave.Name,
+			Text:     text,
+		})
+	}
+
+	return conditions, nil
+}
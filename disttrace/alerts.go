@@ -0,0 +1,549 @@
+package disttrace
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	valid "github.com/asaskevich/govalidator"
+	"github.com/google/uuid"
+)
+
+// alert states
+const (
+	AlertStateFiring       = "firing"
+	AlertStateResolved     = "resolved"
+	AlertStateAcknowledged = "acknowledged"
+)
+
+// alert rule types
+const (
+	AlertRuleTargetUnreachable = "targetUnreachable"
+	AlertRuleRTTThreshold      = "rttThreshold"
+	AlertRuleHopCountChange    = "hopCountChange"
+	AlertRulePathChange        = "pathChange"
+	AlertRuleSlaveSilent       = "slaveSilent"
+)
+
+// ErrAlertRuleNotFound is returned when an alert rule doesn't exist
+var ErrAlertRuleNotFound = errors.New("Alert rule doesn't exist")
+
+// ErrAlertRuleDurationTooShort is returned for a rttThreshold rule whose period can't hold enough traceroutes
+var ErrAlertRuleDurationTooShort = fmt.Errorf("DurationMin must be at least %v for rules of type rttThreshold", rttThresholdMinSamples)
+
+// ErrAlertRuleTargetRequired is returned for a rule which watches a target but has none
+var ErrAlertRuleTargetRequired = errors.New("TargetID is required for rules of type targetUnreachable, rttThreshold, hopCountChange and pathChange")
+
+// AlertRule holds a user-defined condition which raises an alert when met
+type AlertRule struct {
+	ID          uuid.UUID `valid:"-"`
+	Name        string    `valid:"required"`
+	Type        string    `valid:"in(targetUnreachable|rttThreshold|hopCountChange|pathChange|slaveSilent),	required"`
+	TargetID    uuid.UUID `valid:"-"`
+	SlaveID     uuid.UUID `valid:"-"`
+	Threshold   int       `valid:"int,	range(0|100000)"`
+	DurationMin int       `valid:"int,	required,	range(1|10080)"`
+	Severity    string    `valid:"in(info|warning|error),	required"`
+	Enabled     bool      `valid:"-"`
+}
+
+// Alert holds a single alert, either raised by a rule or by the application
+type Alert struct {
+	ID             uuid.UUID
+	RuleID         uuid.UUID
+	DedupKey       string
+	Severity       string
+	Source         string
+	Text           string
+	State          string
+	FirstSeen      time.Time
+	LastSeen       time.Time
+	Resolved       *time.Time
+	Acknowledged   *time.Time
+	AcknowledgedBy string
+	Count          int
+}
+
+// the db in which application alerts are persisted, if set
+var alertDB *DB
+
+// InitAlerting persists all further alerts in the given db
+func InitAlerting(db *DB) {
+	alertDB = db
+}
+
+const alertColumns = `strAlertId, COALESCE(strRuleId, ''), strDedupKey, strSeverity, strSource, strText, strState,
+	dtFirstSeen, dtLastSeen, dtResolved, dtAcknowledged, COALESCE(strAcknowledgedBy, ''), nCount`
+
+// scanAlert reads a single alert from the given row
+func scanAlert(row interface{ Scan(...interface{}) error }) (Alert, error) {
+	var a Alert
+	if err := row.Scan(&a.ID, &a.RuleID, &a.DedupKey, &a.Severity, &a.Source, &a.Text, &a.State,
+		&a.FirstSeen, &a.LastSeen, &a.Resolved, &a.Acknowledged, &a.AcknowledgedBy, &a.Count); err != nil {
+		return Alert{}, err
+	}
+	return a, nil
+}
+
+// GetAlert returns the specified alert from DB
+func GetAlert(alertID uuid.UUID, db *DB) (Alert, error) {
+
+	log.Debug("GetAlert: fetching alert with ID: ", alertID)
+
+	query := "SELECT " + alertColumns + " FROM t_Alerts WHERE strAlertId = ?"
+
+	alert, err := scanAlert(db.QueryRow(query, alertID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			log.Debug("GetAlert: Couldn't find specified alert in DB...")
+			return Alert{}, nil
+		}
+		log.Warn("GetAlert: Error while getting alert from DB, Error: ", err)
+		return Alert{}, errors.New("Error while getting alert from DB")
+	}
+
+	log.Debugf("GetAlert: Returning alert '%v'", alert.ID)
+	return alert, nil
+}
+
+// GetAlertHistory reads alerts from the db, newest first. Optionally filtered by state, limit 0 returns all alerts.
+// A scope only returns alerts of rules for its targets.
+func GetAlertHistory(db *DB, state string, limit int, scope TargetScope) ([]Alert, error) {
+
+	log.Debugf("GetAlertHistory: fetching alerts from db, state: '%v', limit: '%v'...", state, limit)
+	alerts := []Alert{}
+
+	conditions := []string{}
+	args := []interface{}{}
+	if state != "" {
+		conditions = append(conditions, "strState = ?")
+		args = append(args, state)
+	}
+	if scope != nil {
+		cond, condArgs := scope.condition("strTargetId")
+		conditions = append(conditions, "strRuleId IN (SELECT strRuleId FROM t_AlertRules WHERE "+cond+")")
+		args = append(args, condArgs...)
+	}
+
+	query := "SELECT " + alertColumns + " FROM t_Alerts"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY dtLastSeen DESC"
+	if limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, limit)
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		log.Warn("GetAlertHistory: Couldn't get alerts from db, Error: ", err)
+		return alerts, errors.New("Couldn't get alerts")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		alert, err := scanAlert(rows)
+		if err != nil {
+			log.Warn("GetAlertHistory: Couldn't read results from alerts, Error: ", err)
+			return []Alert{}, errors.New("Couldn't get alerts")
+		}
+		alerts = append(alerts, alert)
+	}
+
+	log.Debugf("GetAlertHistory: returning '%v' alerts from db...", len(alerts))
+	return alerts, nil
+}
+
+// FireAlert raises an alert. If an active alert with the same dedup key exists, it is updated instead.
+func FireAlert(db *DB, ruleID uuid.UUID, severity string, dedupKey string, source string, text string) (Alert, error) {
+
+	log.Debugf("FireAlert: Firing alert with key '%v'...", dedupKey)
+
+	now := time.Now().UTC()
+
+	// deduplicate, update the active alert if there is one
+	query := `UPDATE t_Alerts SET dtLastSeen = ?, nCount = nCount + 1, strText = ?, strSeverity = ?
+		WHERE strDedupKey = ? AND strState IN (?, ?)`
+
+	res, err := db.Exec(query, now, text, severity, dedupKey, AlertStateFiring, AlertStateAcknowledged)
+	if err != nil {
+		log.Warn("FireAlert: Couldn't update existing alert, Error: ", err)
+		return Alert{}, errors.New("Couldn't update alert")
+	}
+
+	numRows, err := res.RowsAffected()
+	if err != nil {
+		log.Warn("FireAlert: Error: Can't get number of affected rows, Error: ", err)
+		return Alert{}, errors.New("DB Error")
+	}
+
+	if numRows > 0 {
+		log.Debugf("FireAlert: Alert with key '%v' is already active, updated it", dedupKey)
+
+		query = "SELECT " + alertColumns + " FROM t_Alerts WHERE strDedupKey = ? AND strState IN (?, ?)"
+		alert, err := scanAlert(db.QueryRow(query, dedupKey, AlertStateFiring, AlertStateAcknowledged))
+		if err != nil {
+			log.Warn("FireAlert: Couldn't read updated alert, Error: ", err)
+			return Alert{}, errors.New("Couldn't read updated alert")
+		}
+		publishAlertEvent(alert)
+		return alert, nil
+	}
+
+	alert := Alert{
+		ID:        uuid.New(),
+		RuleID:    ruleID,
+		DedupKey:  dedupKey,
+		Severity:  severity,
+		Source:    source,
+		Text:      text,
+		State:     AlertStateFiring,
+		FirstSeen: now,
+		LastSeen:  now,
+		Count:     1,
+	}
+
+	query = `
+	INSERT INTO t_Alerts (strAlertId, strRuleId, strDedupKey, strSeverity, strSource, strText, strState, dtFirstSeen, dtLastSeen, nCount)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+
+	_, err = db.Exec(query, alert.ID, nullableID(alert.RuleID), alert.DedupKey, alert.Severity, alert.Source, alert.Text, alert.State, alert.FirstSeen, alert.LastSeen, alert.Count)
+	if err != nil {
+		log.Warn("FireAlert: Couldn't create alert, Error: ", err)
+		return Alert{}, errors.New("Couldn't create alert")
+	}
+
+	log.Infof("FireAlert: New alert '%v' raised: %v", alert.ID, alert.Text)
+	queueNotification(alert)
+	publishAlertEvent(alert)
+	return alert, nil
+}
+
+// RecordResolvedAlert stores an alert which is resolved right away, e.g. an informational message. It is kept in the
+// history but doesn't notify.
+func RecordResolvedAlert(db *DB, severity string, dedupKey string, source string, text string) (Alert, error) {
+
+	log.Debugf("RecordResolvedAlert: Recording alert with key '%v'...", dedupKey)
+
+	now := time.Now().UTC()
+	alert := Alert{
+		ID:        uuid.New(),
+		DedupKey:  dedupKey,
+		Severity:  severity,
+		Source:    source,
+		Text:      text,
+		State:     AlertStateResolved,
+		FirstSeen: now,
+		LastSeen:  now,
+		Resolved:  &now,
+		Count:     1,
+	}
+
+	query := `
+	INSERT INTO t_Alerts (strAlertId, strDedupKey, strSeverity, strSource, strText, strState, dtFirstSeen, dtLastSeen, dtResolved, nCount)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+
+	_, err := db.Exec(query, alert.ID, alert.DedupKey, alert.Severity, alert.Source, alert.Text, alert.State, alert.FirstSeen, alert.LastSeen, now, alert.Count)
+	if err != nil {
+		log.Warn("RecordResolvedAlert: Couldn't create alert, Error: ", err)
+		return Alert{}, errors.New("Couldn't create alert")
+	}
+
+	publishAlertEvent(alert)
+	return alert, nil
+}
+
+// ResolveAlert resolves the active alert with the given dedup key, if there is one
+func ResolveAlert(db *DB, dedupKey string) error {
+
+	log.Debugf("ResolveAlert: Resolving alert with key '%v'...", dedupKey)
+
+	query := "SELECT " + alertColumns + " FROM t_Alerts WHERE strDedupKey = ? AND strState IN (?, ?)"
+	alert, err := scanAlert(db.QueryRow(query, dedupKey, AlertStateFiring, AlertStateAcknowledged))
+	if err == sql.ErrNoRows {
+		log.Debugf("ResolveAlert: No active alert with key '%v', nothing to do", dedupKey)
+		return nil
+	} else if err != nil {
+		log.Warn("ResolveAlert: Couldn't get active alert, Error: ", err)
+		return errors.New("Couldn't get active alert")
+	}
+
+	now := time.Now().UTC()
+	query = `UPDATE t_Alerts SET strState = ?, dtResolved = ? WHERE strAlertId = ?`
+
+	if _, err := db.Exec(query, AlertStateResolved, now, alert.ID); err != nil {
+		log.Warn("ResolveAlert: Couldn't resolve alert, Error: ", err)
+		return errors.New("Couldn't resolve alert")
+	}
+
+	alert.State = AlertStateResolved
+	alert.Resolved = &now
+
+	log.Infof("ResolveAlert: Alert '%v' resolved: %v", alert.ID, alert.Text)
+	queueNotification(alert)
+	publishAlertEvent(alert)
+	return nil
+}
+
+// AcknowledgeAlert marks an active alert as acknowledged by the given user
+func AcknowledgeAlert(db *DB, alertID uuid.UUID, user string) (Alert, error) {
+
+	log.Debugf("AcknowledgeAlert: Acknowledging alert '%v' for user '%v'...", alertID, user)
+
+	query := `UPDATE t_Alerts SET strState = ?, dtAcknowledged = ?, strAcknowledgedBy = ? WHERE strAlertId = ? AND strState = ?`
+
+	res, err := db.Exec(query, AlertStateAcknowledged, time.Now().UTC(), user, alertID, AlertStateFiring)
+	if err != nil {
+		log.Warn("AcknowledgeAlert: Couldn't acknowledge alert, Error: ", err)
+		return Alert{}, errors.New("Couldn't acknowledge alert")
+	}
+
+	numRows, err := res.RowsAffected()
+	if err != nil {
+		log.Warn("AcknowledgeAlert: Error: Can't get number of affected rows, Error: ", err)
+		return Alert{}, errors.New("DB Error")
+	}
+
+	if numRows == 0 {
+		log.Debugf("AcknowledgeAlert: Alert '%v' doesn't exist or isn't firing", alertID)
+		return Alert{}, errors.New("Alert doesn't exist or isn't firing")
+	}
+
+	log.Debugf("AcknowledgeAlert: Alert '%v' successfully acknowledged", alertID)
+	alert, err := GetAlert(alertID, db)
+	if err == nil {
+		publishAlertEvent(alert)
+	}
+	return alert, err
+}
+
+// resolveQuietAppAlerts resolves the active alerts without a rule, e.g. failed logins, which weren't raised again
+// within the quiet period
+func resolveQuietAppAlerts(db *DB, quiet time.Duration) error {
+
+	keys := []string{}
+
+	query := "SELECT strDedupKey FROM t_Alerts WHERE strRuleId IS NULL AND strState IN (?, ?) AND " +
+		db.Dialect.TimeExpr("dtLastSeen") + " < " + db.Dialect.TimeExpr("?")
+	before := time.Now().Add(-quiet).UTC().Format(time.RFC3339)
+	rows, err := db.Query(query, AlertStateFiring, AlertStateAcknowledged, before)
+	if err != nil {
+		log.Warn("resolveQuietAppAlerts: Couldn't get quiet alerts, Error: ", err)
+		return errors.New("Couldn't get quiet alerts")
+	}
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			rows.Close()
+			log.Warn("resolveQuietAppAlerts: Couldn't read quiet alert, Error: ", err)
+			return errors.New("Couldn't get quiet alerts")
+		}
+		keys = append(keys, key)
+	}
+	rows.Close()
+
+	for _, key := range keys {
+		if err := ResolveAlert(db, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getActiveAlertKeysForRule returns the dedup keys of all active alerts raised by the given rule
+func getActiveAlertKeysForRule(db *DB, ruleID uuid.UUID) ([]string, error) {
+
+	keys := []string{}
+
+	query := "SELECT strDedupKey FROM t_Alerts WHERE strRuleId = ? AND strState IN (?, ?)"
+	rows, err := db.Query(query, ruleID, AlertStateFiring, AlertStateAcknowledged)
+	if err != nil {
+		log.Warn("getActiveAlertKeysForRule: Couldn't get alerts from db, Error: ", err)
+		return keys, errors.New("Couldn't get alerts")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			log.Warn("getActiveAlertKeysForRule: Couldn't read results from alerts, Error: ", err)
+			return []string{}, errors.New("Couldn't get alerts")
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+const alertRuleColumns = `strRuleId, strName, strType, COALESCE(strTargetId, ''), COALESCE(strSlaveId, ''),
+	nThreshold, nDurationMin, strSeverity, nEnabled`
+
+// scanAlertRule reads a single alert rule from the given row
+func scanAlertRule(row interface{ Scan(...interface{}) error }) (AlertRule, error) {
+	var r AlertRule
+	if err := row.Scan(&r.ID, &r.Name, &r.Type, &r.TargetID, &r.SlaveID, &r.Threshold, &r.DurationMin, &r.Severity, &r.Enabled); err != nil {
+		return AlertRule{}, err
+	}
+	return r, nil
+}
+
+// nullableID returns nil for an empty ID, so it is stored as NULL
+func nullableID(id uuid.UUID) interface{} {
+	if id == uuid.Nil {
+		return nil
+	}
+	return id
+}
+
+// GetAlertRule returns the specified alert rule from DB
+func GetAlertRule(ruleID uuid.UUID, db *DB) (AlertRule, error) {
+
+	log.Debug("GetAlertRule: fetching alert rule with ID: ", ruleID)
+
+	query := "SELECT " + alertRuleColumns + " FROM t_AlertRules WHERE strRuleId = ?"
+
+	rule, err := scanAlertRule(db.QueryRow(query, ruleID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			log.Debug("GetAlertRule: Couldn't find specified alert rule in DB...")
+			return AlertRule{}, nil
+		}
+		log.Warn("GetAlertRule: Error while getting alert rule from DB, Error: ", err)
+		return AlertRule{}, errors.New("Error while getting alert rule from DB")
+	}
+
+	log.Debugf("GetAlertRule: Returning alert rule name '%v' for ID '%v'", rule.Name, rule.ID)
+	return rule, nil
+}
+
+// GetAlertRules reads all alert rules from the db
+func GetAlertRules(db *DB) ([]AlertRule, error) {
+
+	log.Debug("GetAlertRules: fetching alert rules from db...")
+	rules := []AlertRule{}
+
+	query := "SELECT " + alertRuleColumns + " FROM t_AlertRules"
+	rows, err := db.Query(query)
+	if err != nil {
+		log.Warn("GetAlertRules: Couldn't get alert rules from db, Error: ", err)
+		return rules, errors.New("Couldn't get alert rules")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		rule, err := scanAlertRule(rows)
+		if err != nil {
+			log.Warn("GetAlertRules: Couldn't read results from alert rules, Error: ", err)
+			return []AlertRule{}, errors.New("Couldn't get alert rules")
+		}
+		rules = append(rules, rule)
+	}
+
+	log.Debugf("GetAlertRules: returning '%v' alert rules from db...", len(rules))
+	return rules, nil
+}
+
+// ValidateAlertRule checks the fields of an alert rule, all types except slaveSilent watch a target and the period of
+// rttThreshold rules must hold enough traceroutes
+func ValidateAlertRule(rule AlertRule) error {
+
+	if ok, err := valid.ValidateStruct(rule); !ok || err != nil {
+		return err
+	}
+	if rule.Type != AlertRuleSlaveSilent && rule.TargetID == uuid.Nil {
+		return ErrAlertRuleTargetRequired
+	}
+	if rule.Type == AlertRuleRTTThreshold && rule.DurationMin < rttThresholdMinSamples {
+		return ErrAlertRuleDurationTooShort
+	}
+
+	return nil
+}
+
+// CreateAlertRule stores a new alert rule in the db
+func CreateAlertRule(db *DB, rule AlertRule) (AlertRule, error) {
+	log.Debug("CreateAlertRule: Creating new alert rule, name: ", rule.Name)
+
+	query := `
+	INSERT INTO t_AlertRules (strRuleId, strName, strType, strTargetId, strSlaveId, nThreshold, nDurationMin, strSeverity, nEnabled)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+
+	rule.ID = uuid.New()
+	_, err := db.Exec(query, rule.ID, rule.Name, rule.Type, nullableID(rule.TargetID), nullableID(rule.SlaveID),
+		rule.Threshold, rule.DurationMin, rule.Severity, rule.Enabled)
+	if err != nil {
+		log.Warn("CreateAlertRule: Couldn't create alert rule, Error: ", err)
+		return AlertRule{}, errors.New("Couldn't create alert rule")
+	}
+
+	log.Debugf("CreateAlertRule: Alert rule '%v' created with ID<%v>", rule.Name, rule.ID)
+	return rule, nil
+}
+
+// UpdateAlertRule updates an existing alert rule in the db
+func UpdateAlertRule(db *DB, rule AlertRule) (AlertRule, error) {
+	log.Debugf("UpdateAlertRule: Updating alert rule '%v'...", rule.ID)
+
+	query := `UPDATE t_AlertRules
+	SET strName = ?, strType = ?, strTargetId = ?, strSlaveId = ?, nThreshold = ?, nDurationMin = ?, strSeverity = ?, nEnabled = ?
+	WHERE strRuleId = ?`
+
+	res, err := db.Exec(query, rule.Name, rule.Type, nullableID(rule.TargetID), nullableID(rule.SlaveID),
+		rule.Threshold, rule.DurationMin, rule.Severity, rule.Enabled, rule.ID)
+	if err != nil {
+		log.Warn("UpdateAlertRule: Couldn't update alert rule, Error: ", err)
+		return AlertRule{}, errors.New("Couldn't update alert rule")
+	}
+
+	numRows, err := res.RowsAffected()
+	if err != nil {
+		log.Warn("UpdateAlertRule: Error: Can't get number of affected rows, Error: ", err)
+		return AlertRule{}, errors.New("DB Error")
+	} else if numRows == 0 {
+		log.Debugf("UpdateAlertRule: Alert rule '%v' doesn't exist", rule.ID)
+		return AlertRule{}, ErrAlertRuleNotFound
+	}
+
+	log.Debugf("UpdateAlertRule: Alert rule '%v' successfully updated, affected rows: '%v", rule.ID, numRows)
+	return rule, nil
+}
+
+// DeleteAlertRule deletes an existing alert rule from the db and resolves its active alerts
+func DeleteAlertRule(db *DB, ruleID uuid.UUID) error {
+	log.Debugf("DeleteAlertRule: Deleting alert rule '%v'...", ruleID)
+
+	keys, err := getActiveAlertKeysForRule(db, ruleID)
+	if err != nil {
+		log.Warn("DeleteAlertRule: Couldn't get active alerts of rule, Error: ", err)
+		return errors.New("Couldn't delete alert rule")
+	}
+	for _, key := range keys {
+		if err := ResolveAlert(db, key); err != nil {
+			log.Warn("DeleteAlertRule: Couldn't resolve active alert of rule, Error: ", err)
+			return errors.New("Couldn't delete alert rule")
+		}
+	}
+
+	query := "DELETE FROM t_AlertRules WHERE strRuleId = ?"
+
+	res, err := db.Exec(query, ruleID)
+	if err != nil {
+		log.Warn("DeleteAlertRule: Couldn't delete alert rule, Error: ", err)
+		return errors.New("Couldn't delete alert rule")
+	}
+
+	numRows, err := res.RowsAffected()
+	if err != nil {
+		log.Warn("DeleteAlertRule: Error: Can't get number of affected rows, Error: ", err)
+		return errors.New("DB Error")
+	}
+
+	log.Debugf("DeleteAlertRule: Alert rule '%v' successfully deleted, rows: '%v'", ruleID, numRows)
+	return nil
+}
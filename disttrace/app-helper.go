@@ -5,17 +5,22 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"net"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"syscall"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
 
 	valid "github.com/asaskevich/govalidator"
 )
 
+// Version of the application, set at build time with -ldflags "-X github.com/xmirakulix/dist-traceroute/disttrace.Version=..."
+var Version = "dev"
+
 // Track starttime of application
 var startTime = time.Now()
 
@@ -28,7 +33,7 @@ var doExit = false
 // global logger
 var log = logrus.New()
 
-// contains ten most recent errors to display on web GUI
+// contains ten most recent errors to display on web GUI, only used if alerts aren't persisted in db
 var lastAlerts []AppAlert
 
 // AppAlert holds an application notification, e.g. errors
@@ -44,6 +49,22 @@ func alert(severity string, source string, text string, args ...interface{}) {
 
 	traceText := fmt.Sprintf(text, args...)
 
+	// persist alert, repeated alerts of same source host and text are deduplicated. Informational messages don't
+	// need any action and are stored as resolved, the others are resolved after a quiet period by the evaluator.
+	if alertDB != nil {
+		dedupKey := "app:" + alertSourceHost(source) + ":" + traceText
+		var err error
+		if severity == "info" {
+			_, err = RecordResolvedAlert(alertDB, severity, dedupKey, source, traceText)
+		} else {
+			_, err = FireAlert(alertDB, uuid.Nil, severity, dedupKey, source, traceText)
+		}
+		if err != nil {
+			log.Warn("alert: Couldn't persist alert, Error: ", err)
+		}
+		return
+	}
+
 	alert := AppAlert{
 		Time:     time.Now(),
 		Text:     traceText,
@@ -59,6 +80,15 @@ func alert(severity string, source string, text string, args ...interface{}) {
 	}
 }
 
+// alertSourceHost returns the host of sources like the remote address of a request, its port changes with every
+// connection
+func alertSourceHost(source string) string {
+	if host, _, err := net.SplitHostPort(source); err == nil {
+		return host
+	}
+	return source
+}
+
 // AlertInfof creates a new alert on web GUI of 'info' severity
 func AlertInfof(source string, text string, args ...interface{}) {
 	alert("info", source, text, args...)
@@ -74,9 +104,32 @@ func AlertErrorf(source string, text string, args ...interface{}) {
 	alert("error", source, text, args...)
 }
 
-// GetAlerts returns the last alerts
-func GetAlerts() []AppAlert {
-	return lastAlerts
+// GetAlerts returns the last alerts, oldest first. A scope only returns alerts of rules for its targets.
+func GetAlerts(scope TargetScope) []AppAlert {
+	if alertDB == nil {
+		// alerts which aren't persisted are application alerts without a target
+		if scope != nil {
+			return []AppAlert{}
+		}
+		return lastAlerts
+	}
+
+	alerts, err := GetAlertHistory(alertDB, "", 10, scope)
+	if err != nil {
+		log.Warn("GetAlerts: Couldn't get alerts from db, Error: ", err)
+		return []AppAlert{}
+	}
+
+	appAlerts := []AppAlert{}
+	for i := len(alerts) - 1; i >= 0; i-- {
+		appAlerts = append(appAlerts, AppAlert{
+			Time:     alerts[i].LastSeen,
+			Text:     alerts[i].Text,
+			Source:   alerts[i].Source,
+			Severity: alerts[i].Severity,
+		})
+	}
+	return appAlerts
 }
 
 // GetUptime returns the application's uptime since launch
@@ -100,6 +153,27 @@ func ListenForOSSignals() {
 
 }
 
+// IgnoreReloadSignal ignores SIGHUP until ListenForReloadSignal is called, the OS would otherwise end the process
+func IgnoreReloadSignal() {
+	signal.Ignore(syscall.SIGHUP)
+}
+
+// ListenForReloadSignal calls reload for every SIGHUP received from the OS
+func ListenForReloadSignal(reload func()) {
+
+	osSignal := make(chan os.Signal, 1)
+
+	signal.Notify(osSignal, syscall.SIGHUP)
+
+	// wait for signals in background...
+	go func() {
+		for sig := range osSignal {
+			log.Warn("ListenForReloadSignal: Received os signal: ", sig)
+			reload()
+		}
+	}()
+}
+
 // WaitForOSSignalAndQuit blocks until a signal from OS is received, then sends exit signal
 func WaitForOSSignalAndQuit() {
 	<-OSSigReceived
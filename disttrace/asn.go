@@ -0,0 +1,184 @@
+package disttrace
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ASNInfo holds the autonomous system an IP address is announced by
+type ASNInfo struct {
+	Number      int
+	Country     string `json:",omitempty"`
+	Description string `json:",omitempty"`
+}
+
+// asnRange is a range of IP addresses announced by the same autonomous system
+type asnRange struct {
+	start net.IP
+	end   net.IP
+	info  ASNInfo
+}
+
+// loaded ranges of the ASN database, sorted by their start address
+var asnDatabase = struct {
+	sync.RWMutex
+	ranges []asnRange
+}{}
+
+// LoadASNDatabase reads the IP to ASN mapping used to annotate and filter hops, an empty file name clears it.
+// The file has a range per line: first address, last address, AS number, country and description separated by
+// tabs, e.g. the ip2asn-combined.tsv of iptoasn.com. Ranges of AS 0 (not routed) are skipped.
+func LoadASNDatabase(fileName string) error {
+
+	ranges := []asnRange{}
+	if fileName != "" {
+		file, err := os.Open(fileName)
+		if err != nil {
+			return fmt.Errorf("Can't read ASN database: %v", err)
+		}
+		defer file.Close()
+
+		scanner := bufio.NewScanner(file)
+		for line := 1; scanner.Scan(); line++ {
+			text := strings.TrimSpace(scanner.Text())
+			if text == "" || strings.HasPrefix(text, "#") {
+				continue
+			}
+
+			fields := strings.Split(text, "\t")
+			if len(fields) < 3 {
+				return fmt.Errorf("ASN database line %v: expected first address, last address and AS number", line)
+			}
+			start, end := net.ParseIP(fields[0]).To16(), net.ParseIP(fields[1]).To16()
+			number, err := strconv.Atoi(fields[2])
+			if start == nil || end == nil || err != nil || bytes.Compare(start, end) > 0 {
+				return fmt.Errorf("ASN database line %v: invalid range or AS number", line)
+			}
+			if number == 0 {
+				continue
+			}
+
+			r := asnRange{start: start, end: end, info: ASNInfo{Number: number}}
+			if len(fields) > 3 && fields[3] != "None" {
+				r.info.Country = fields[3]
+			}
+			if len(fields) > 4 && fields[4] != "Not routed" {
+				r.info.Description = fields[4]
+			}
+			ranges = append(ranges, r)
+		}
+		if err := scanner.Err(); err != nil {
+			return fmt.Errorf("Can't read ASN database: %v", err)
+		}
+
+		sort.Slice(ranges, func(i, j int) bool { return bytes.Compare(ranges[i].start, ranges[j].start) < 0 })
+	}
+
+	asnDatabase.Lock()
+	asnDatabase.ranges = ranges
+	asnDatabase.Unlock()
+
+	log.Infof("LoadASNDatabase: Loaded %v ranges from ASN database '%v'", len(ranges), fileName)
+	return nil
+}
+
+// LookupASN returns the autonomous system of the IP address, nil if it's unknown or no ASN database is loaded
+func LookupASN(address string) *ASNInfo {
+
+	ip := net.ParseIP(address).To16()
+	if ip == nil {
+		return nil
+	}
+
+	asnDatabase.RLock()
+	defer asnDatabase.RUnlock()
+
+	// the last range starting at or before the address
+	i := sort.Search(len(asnDatabase.ranges), func(i int) bool {
+		return bytes.Compare(asnDatabase.ranges[i].start, ip) > 0
+	}) - 1
+	if i < 0 || bytes.Compare(ip, asnDatabase.ranges[i].end) > 0 {
+		return nil
+	}
+
+	info := asnDatabase.ranges[i].info
+	return &info
+}
+
+// asnNumber returns the number of the autonomous system of the IP address, 0 if it's unknown
+func asnNumber(address string) int {
+	if as := LookupASN(address); as != nil {
+		return as.Number
+	}
+	return 0
+}
+
+// UpdatePathHopASNs stores the autonomous systems of the hops of all paths as given by the loaded ASN database,
+// traceroutes are filtered by the stored autonomous systems. Has to run after every load of the ASN database.
+func UpdatePathHopASNs(db *DB) (err error) {
+
+	rows, err := db.Query("SELECT DISTINCT strHopIPAddress, nASN FROM t_PathHops")
+	if err != nil {
+		log.Warn("UpdatePathHopASNs: Couldn't get hops of paths, Error: ", err)
+		return errors.New("Couldn't get hops of paths")
+	}
+
+	changed := make(map[string]int)
+	for rows.Next() {
+		var address string
+		var stored int
+		if err = rows.Scan(&address, &stored); err != nil {
+			rows.Close()
+			log.Warn("UpdatePathHopASNs: Couldn't read hops of paths, Error: ", err)
+			return errors.New("Couldn't read hops of paths")
+		}
+		if number := asnNumber(address); number != stored {
+			changed[address] = number
+		}
+	}
+	rows.Close()
+
+	if len(changed) == 0 {
+		return nil
+	}
+
+	var tx *Tx
+	if tx, err = db.Begin(); err != nil {
+		log.Warn("UpdatePathHopASNs: Couldn't start transaction, Error: ", err)
+		return errors.New("Couldn't update hops of paths")
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	for address, number := range changed {
+		if _, err = tx.Exec("UPDATE t_PathHops SET nASN = ? WHERE strHopIPAddress = ?", number, address); err != nil {
+			log.Warn("UpdatePathHopASNs: Couldn't update hops of paths, Error: ", err)
+			return errors.New("Couldn't update hops of paths")
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		log.Warn("UpdatePathHopASNs: Couldn't commit transaction, Error: ", err)
+		return errors.New("Couldn't update hops of paths")
+	}
+
+	log.Infof("UpdatePathHopASNs: Updated the autonomous system of %v hop addresses", len(changed))
+	return nil
+}
+
+// ASNDatabaseLoaded checks if an ASN database is loaded
+func ASNDatabaseLoaded() bool {
+	asnDatabase.RLock()
+	defer asnDatabase.RUnlock()
+	return len(asnDatabase.ranges) > 0
+}
@@ -2,51 +2,299 @@ package disttrace
 
 import (
 	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gbrlsnchs/jwt/v3"
+	"github.com/gbrlsnchs/jwt/v3/jwtutil"
+	"github.com/google/uuid"
 )
 
+// ErrUnknownSigningKey is returned for tokens signed with a key which doesn't exist (anymore)
+var ErrUnknownSigningKey = errors.New("Unknown signing key")
+
+// ErrRevokedToken is returned for tokens which were revoked on logout
+var ErrRevokedToken = errors.New("Token was revoked")
+
 // AuthClaims holds the signed auth info
 type AuthClaims struct {
 	Payload  jwt.Payload
 	Username string
+	// Session is the ID of the refresh token the token was issued with
+	Session string `json:",omitempty"`
+	// PasswordNeedsChange only allows to change the password
+	PasswordNeedsChange bool `json:",omitempty"`
+	// Role and TargetGroups of the user when the token was issued
+	Role         string
+	TargetGroups []uuid.UUID `json:",omitempty"`
+}
+
+// NewAuthClaims returns the claims of a token of the user in the given session
+func NewAuthClaims(user User, session string) AuthClaims {
+	return AuthClaims{
+		Username:            user.Name,
+		Session:             session,
+		PasswordNeedsChange: user.PasswordNeedsChange,
+		Role:                user.Role,
+		TargetGroups:        user.TargetGroups,
+	}
+}
+
+// authKey is a secret used to sign auth tokens
+type authKey struct {
+	ID      string
+	Secret  []byte
+	Created time.Time
+	Retired *time.Time
+	alg     *jwt.HMACSHA
 }
 
-// holds the hashed secret
-var secret *jwt.HMACSHA
+// revokedSyncInterval is the time revoked tokens are cached before they are read from db again, revocations of
+// other masters sharing the db are seen after this time at the latest. Shorter than the minimal token lifetime.
+const revokedSyncInterval = time.Minute
 
-// initialize the secret
-func initAuth() {
+// authState holds the signing keys and the revoked tokens, persisted in db if set
+var authState struct {
+	sync.Mutex
+	db            *DB
+	keys          map[string]*authKey
+	current       *authKey
+	revoked       map[string]time.Time
+	revokedSynced time.Time
+}
+
+// InitAuth loads the signing keys and the revoked tokens from db and persists all further changes in it
+func InitAuth(db *DB) error {
+
+	authState.Lock()
+	defer authState.Unlock()
+
+	authState.db = db
+	authState.keys = map[string]*authKey{}
+	authState.current = nil
+	authState.revoked = map[string]time.Time{}
 
-	randBytes := make([]byte, 100)
-	if _, err := rand.Read(randBytes); err != nil {
-		log.Fatal("initJWT: Error while collecting randomness, Error: ", err)
+	pruneAuth(db, time.Now())
+
+	rows, err := db.Query("SELECT strKeyId, strSecret, dtCreated, dtRetired FROM t_AuthKeys")
+	if err != nil {
+		log.Warn("InitAuth: Couldn't read signing keys from db, Error: ", err)
+		return errors.New("Couldn't read signing keys from db")
 	}
+	defer rows.Close()
 
-	secret = jwt.NewHS256(randBytes)
-	log.Debug("initAuth: Secret for signing of auth tokens generated")
+	for rows.Next() {
+		key, err := scanAuthKey(rows)
+		if err != nil {
+			log.Warn("InitAuth: Couldn't read signing key, Error: ", err)
+			return errors.New("Couldn't read signing keys from db")
+		}
+		authState.keys[key.ID] = key
+		if key.Retired == nil && (authState.current == nil || key.Created.After(authState.current.Created)) {
+			authState.current = key
+		}
+	}
+	if err := rows.Err(); err != nil {
+		log.Warn("InitAuth: Couldn't read signing keys from db, Error: ", err)
+		return errors.New("Couldn't read signing keys from db")
+	}
+
+	if err := loadRevokedTokens(db, time.Now()); err != nil {
+		log.Warn("InitAuth: Couldn't read revoked tokens from db, Error: ", err)
+		return errors.New("Couldn't read revoked tokens from db")
+	}
+
+	log.Infof("InitAuth: Loaded %v signing keys and %v revoked tokens", len(authState.keys), len(authState.revoked))
+	return nil
 }
 
-// GetToken generates a new token with given claims
-func GetToken(claims AuthClaims) (token []byte, err error) {
+// loadRevokedTokens replaces the cached revoked tokens with the unexpired ones in db. authState must be locked.
+func loadRevokedTokens(db *DB, now time.Time) error {
 
-	log.Debug("GetToken: Generating new auth token...")
+	query := "SELECT strTokenId, dtExpires FROM t_RevokedTokens WHERE " +
+		db.Dialect.TimeExpr("dtExpires") + " >= " + db.Dialect.TimeExpr("?")
+	rows, err := db.Query(query, now.Format(time.RFC3339))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	revoked := map[string]time.Time{}
+	for rows.Next() {
+		var id string
+		var expires dbTime
+		if err := rows.Scan(&id, &expires); err != nil {
+			return err
+		}
+		revoked[id] = expires.Time
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	authState.revoked = revoked
+	authState.revokedSynced = now
+	return nil
+}
+
+// scanAuthKey reads a single signing key from the given row
+func scanAuthKey(row interface{ Scan(...interface{}) error }) (*authKey, error) {
+
+	var key authKey
+	var secret string
+	var created, retired dbTime
+	if err := row.Scan(&key.ID, &secret, &created, &retired); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if key.Secret, err = base64.StdEncoding.DecodeString(secret); err != nil {
+		return nil, err
+	}
+	key.Created = created.Time
+	if !retired.IsZero() {
+		key.Retired = &retired.Time
+	}
+	key.alg = jwt.NewHS256(key.Secret)
+	return &key, nil
+}
+
+// pruneAuth deletes retired keys, which can't have signed a valid token anymore, and expired revocations from db
+func pruneAuth(db *DB, now time.Time) {
+
+	cutoff := now.Add(-CurrentMasterConfig().Auth.TokenLifetime).Format(time.RFC3339)
+	query := "DELETE FROM t_AuthKeys WHERE dtRetired IS NOT NULL AND " +
+		db.Dialect.TimeExpr("dtRetired") + " < " + db.Dialect.TimeExpr("?")
+	if _, err := db.Exec(query, cutoff); err != nil {
+		log.Warn("pruneAuth: Couldn't delete retired signing keys, Error: ", err)
+	}
+
+	query = "DELETE FROM t_RevokedTokens WHERE " + db.Dialect.TimeExpr("dtExpires") + " < " + db.Dialect.TimeExpr("?")
+	if _, err := db.Exec(query, now.Format(time.RFC3339)); err != nil {
+		log.Warn("pruneAuth: Couldn't delete expired revoked tokens, Error: ", err)
+	}
+}
+
+// signingKey returns the current signing key, a new key is created if it's missing or older than the rotation
+// interval. authState must be locked.
+func signingKey(now time.Time) (*authKey, error) {
+
+	cfg := CurrentMasterConfig().Auth
+	if authState.current != nil && now.Sub(authState.current.Created) < cfg.KeyRotationInterval {
+		return authState.current, nil
+	}
+
+	secret := make([]byte, 64)
+	if _, err := rand.Read(secret); err != nil {
+		log.Warn("signingKey: Error while collecting randomness, Error: ", err)
+		return nil, errors.New("Couldn't generate signing key")
+	}
+	key := &authKey{ID: uuid.New().String(), Secret: secret, Created: now, alg: jwt.NewHS256(secret)}
+
+	// without db the key is lost on restart, e.g. in tests and tools
+	if db := authState.db; db != nil {
+		if err := storeSigningKey(db, key, now); err != nil {
+			log.Warn("signingKey: Couldn't store signing key, Error: ", err)
+			return nil, errors.New("Couldn't store signing key")
+		}
+		pruneAuth(db, now)
+	}
+
+	if authState.current != nil {
+		authState.current.Retired = &now
+	}
+	for id, old := range authState.keys {
+		if old.Retired != nil && now.Sub(*old.Retired) > cfg.TokenLifetime {
+			delete(authState.keys, id)
+		}
+	}
+	if authState.keys == nil {
+		authState.keys = map[string]*authKey{}
+	}
+	authState.keys[key.ID] = key
+	authState.current = key
+
+	log.Infof("signingKey: New signing key '%v' created", key.ID)
+	return key, nil
+}
+
+// storeSigningKey retires all signing keys and inserts the new one in a single transaction
+func storeSigningKey(db *DB, key *authKey, now time.Time) (err error) {
+
+	var tx *Tx
+	if tx, err = db.Begin(); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if _, err = tx.Exec("UPDATE t_AuthKeys SET dtRetired = ? WHERE dtRetired IS NULL", now); err != nil {
+		return err
+	}
+	query := "INSERT INTO t_AuthKeys (strKeyId, strSecret, dtCreated) VALUES (?, ?, ?)"
+	if _, err = tx.Exec(query, key.ID, base64.StdEncoding.EncodeToString(key.Secret), now); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// verificationKey returns the key with the given ID, keys created by other masters are loaded from db
+func verificationKey(id string) (*authKey, error) {
 
-	if secret == nil {
-		initAuth()
+	authState.Lock()
+	defer authState.Unlock()
+
+	if key, ok := authState.keys[id]; ok {
+		return key, nil
+	}
+	if authState.db == nil {
+		return nil, ErrUnknownSigningKey
 	}
 
+	row := authState.db.QueryRow("SELECT strKeyId, strSecret, dtCreated, dtRetired FROM t_AuthKeys WHERE strKeyId = ?", id)
+	key, err := scanAuthKey(row)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Warn("verificationKey: Couldn't read signing key from db, Error: ", err)
+		}
+		return nil, ErrUnknownSigningKey
+	}
+
+	authState.keys[key.ID] = key
+	return key, nil
+}
+
+// GetToken generates a new token with given claims and returns it with its expiration time
+func GetToken(claims AuthClaims) (token []byte, expires time.Time, err error) {
+
+	log.Debug("GetToken: Generating new auth token...")
+
+	authState.Lock()
 	now := time.Now()
+	key, err := signingKey(now)
+	authState.Unlock()
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	expires = now.Add(CurrentMasterConfig().Auth.TokenLifetime)
 	claims.Payload = jwt.Payload{
 		Issuer:         "disttrace",
 		Subject:        claims.Username,
-		ExpirationTime: jwt.NumericDate(now.Add(time.Hour)),
+		ExpirationTime: jwt.NumericDate(expires),
 		IssuedAt:       jwt.NumericDate(now),
+		JWTID:          uuid.New().String(),
 	}
 
-	token, err = jwt.Sign(claims, secret)
+	token, err = jwt.Sign(claims, key.alg, jwt.KeyID(key.ID))
 	if err != nil {
 		log.Error("GetToken: Couldn't sign claims, Error: ", err)
 	}
@@ -55,14 +303,17 @@ func GetToken(claims AuthClaims) (token []byte, err error) {
 	return
 }
 
-// VerifyToken verifies a token
-func VerifyToken(token []byte) (err error) {
+// VerifyToken verifies a token and returns its claims
+func VerifyToken(token []byte) (payload AuthClaims, err error) {
 
-	var payload AuthClaims
-
-	if secret == nil {
-		initAuth()
-	}
+	// pick the key by the "kid" header of the token
+	resolver := &jwtutil.Resolver{New: func(header jwt.Header) (jwt.Algorithm, error) {
+		key, err := verificationKey(header.KeyID)
+		if err != nil {
+			return nil, err
+		}
+		return key.alg, nil
+	}}
 
 	// Validate claims "iat" and "exp"
 	now := time.Now()
@@ -70,15 +321,103 @@ func VerifyToken(token []byte) (err error) {
 	expValidator := jwt.ExpirationTimeValidator(now)
 	validateOptions := jwt.ValidatePayload(&payload.Payload, iatValidator, expValidator)
 
-	_, err = jwt.Verify(token, secret, &payload, validateOptions)
+	_, err = jwt.Verify(token, resolver, &payload, jwt.ValidateHeader, validateOptions)
 	if err != nil {
 		log.Error("VerifyToken: Error while verifying authorization auth token, Error: ", err)
+		return AuthClaims{}, err
+	}
+
+	if isRevoked(payload, now) {
+		log.Debugf("VerifyToken: Token '%v' of user '%v' was revoked", payload.Payload.JWTID, payload.Username)
+		return AuthClaims{}, ErrRevokedToken
 	}
 
 	log.Debug("VerifyToken: Successfully verified auth token")
 	return
 }
 
+// isRevoked checks if the token or its session was revoked, the cached revoked tokens are read from db again after
+// revokedSyncInterval, they may have been revoked by another master sharing the db
+func isRevoked(claims AuthClaims, now time.Time) bool {
+
+	authState.Lock()
+	defer authState.Unlock()
+
+	if db := authState.db; db != nil && now.Sub(authState.revokedSynced) >= revokedSyncInterval {
+		if err := loadRevokedTokens(db, now); err != nil {
+			// keep the cached tokens and try again after the interval
+			log.Warn("isRevoked: Couldn't read revoked tokens from db, Error: ", err)
+			authState.revokedSynced = now
+		}
+	}
+
+	if _, revoked := authState.revoked[claims.Payload.JWTID]; revoked {
+		return true
+	}
+	_, revoked := authState.revoked[claims.Session]
+	return revoked && claims.Session != ""
+}
+
+// RevokeToken rejects the token with the given claims until it expires
+func RevokeToken(claims AuthClaims) error {
+
+	if claims.Payload.JWTID == "" || claims.Payload.ExpirationTime == nil {
+		return nil
+	}
+
+	authState.Lock()
+	defer authState.Unlock()
+
+	if err := revokeID(claims.Payload.JWTID, claims.Payload.ExpirationTime.Time); err != nil {
+		log.Warn("RevokeToken: Couldn't store revoked token, Error: ", err)
+		return errors.New("Couldn't revoke token")
+	}
+
+	log.Debugf("RevokeToken: Revoked token '%v' of user '%v'", claims.Payload.JWTID, claims.Username)
+	return nil
+}
+
+// RevokeSessions rejects all tokens issued for the given sessions, until the last of them expired
+func RevokeSessions(sessionIDs []string) error {
+
+	expires := time.Now().Add(CurrentMasterConfig().Auth.TokenLifetime)
+
+	authState.Lock()
+	defer authState.Unlock()
+
+	for _, sessionID := range sessionIDs {
+		if err := revokeID(sessionID, expires); err != nil {
+			log.Warn("RevokeSessions: Couldn't store revoked session, Error: ", err)
+			return errors.New("Couldn't revoke sessions")
+		}
+	}
+
+	log.Debugf("RevokeSessions: Revoked tokens of %v sessions", len(sessionIDs))
+	return nil
+}
+
+// revokeID rejects tokens with the given token or session ID until expires. authState must be locked.
+func revokeID(id string, expires time.Time) error {
+
+	if db := authState.db; db != nil {
+		if _, err := db.Exec("INSERT INTO t_RevokedTokens (strTokenId, dtExpires) VALUES (?, ?)", id, expires); err != nil {
+			return err
+		}
+	}
+
+	now := time.Now()
+	if authState.revoked == nil {
+		authState.revoked = map[string]time.Time{}
+	}
+	for revokedID, exp := range authState.revoked {
+		if exp.Before(now) {
+			delete(authState.revoked, revokedID)
+		}
+	}
+	authState.revoked[id] = expires
+	return nil
+}
+
 // TokenFromAuthHeader extracts the JWT token from the Authorization header
 func TokenFromAuthHeader(header string) (token string) {
 
@@ -0,0 +1,154 @@
+package disttrace
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// BackupDatabase writes a consistent copy of the SQLite database to a new file using SQLite's online backup API,
+// the database can be in use by a running master. PostgreSQL databases are backed up with pg_dump instead.
+func BackupDatabase(dataSourceName string, backupFile string) error {
+
+	dbFile, err := sqliteFileName(dataSourceName)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(dbFile); err != nil {
+		log.Warn("BackupDatabase: Can't access database file, Error: ", err)
+		return errors.New("Can't access database file " + dbFile)
+	}
+	if _, err := os.Stat(backupFile); err == nil {
+		return errors.New("Backup file " + backupFile + " already exists")
+	}
+
+	db, err := openDB(dataSourceName)
+	if err != nil {
+		log.Warn("BackupDatabase: Error while opening database connection, Error: ", err)
+		return errors.New("Error while opening database connection")
+	}
+	defer db.Close()
+
+	log.Infof("BackupDatabase: Backing up database '%v' to '%v'...", dbFile, backupFile)
+	if err := copySQLiteDatabase(db, backupFile); err != nil {
+		log.Warn("BackupDatabase: Couldn't back up database, Error: ", err)
+		os.Remove(backupFile)
+		return errors.New("Couldn't back up database")
+	}
+
+	log.Info("BackupDatabase: Finished backup to: ", backupFile)
+	return nil
+}
+
+// RestoreDatabase restores a backup into a new SQLite database file and returns the schema version of the backup.
+// The migrations applied to the backup must be known and unmodified, pending migrations are applied on the next start.
+func RestoreDatabase(backupFile string, dataSourceName string) (int, error) {
+
+	dbFile, err := sqliteFileName(dataSourceName)
+	if err != nil {
+		return -1, err
+	}
+	if _, err := os.Stat(dbFile); err == nil {
+		return -1, errors.New("Database file " + dbFile + " already exists, backups are only restored into new files")
+	}
+	if _, err := os.Stat(backupFile); err != nil {
+		log.Warn("RestoreDatabase: Can't access backup file, Error: ", err)
+		return -1, errors.New("Can't access backup file " + backupFile)
+	}
+
+	backup, err := openDB(backupFile)
+	if err != nil {
+		log.Warn("RestoreDatabase: Error while opening backup, Error: ", err)
+		return -1, errors.New("Error while opening backup")
+	}
+	defer backup.Close()
+
+	var integrity string
+	if err := backup.QueryRow("PRAGMA integrity_check").Scan(&integrity); err != nil || integrity != "ok" {
+		log.Warnf("RestoreDatabase: Integrity check of backup failed, result: '%v', Error: %v", integrity, err)
+		return -1, errors.New("Backup file is damaged or not a SQLite database")
+	}
+
+	// verifies the checksums of the applied migrations and rejects newer schema versions
+	steps, applied, _, err := backup.planMigrations(-1)
+	if err != nil {
+		log.Warn("RestoreDatabase: Schema of backup isn't supported, Error: ", err)
+		return -1, fmt.Errorf("Schema of backup isn't supported: %v", err)
+	}
+	if len(applied) == 0 {
+		return -1, errors.New("Backup doesn't contain a dist-traceroute database")
+	}
+
+	log.Infof("RestoreDatabase: Restoring backup '%v' with schema version %v into '%v', %v migrations pending...",
+		backupFile, len(applied), dbFile, len(steps))
+	if err := copySQLiteDatabase(backup, dbFile); err != nil {
+		log.Warn("RestoreDatabase: Couldn't restore backup, Error: ", err)
+		os.Remove(dbFile)
+		return -1, errors.New("Couldn't restore backup")
+	}
+
+	log.Info("RestoreDatabase: Finished restore into: ", dbFile)
+	return len(applied), nil
+}
+
+// sqliteFileName returns the database file of a SQLite data source name
+func sqliteFileName(dataSourceName string) (string, error) {
+
+	dialect, driverDSN, err := parseDataSourceName(dataSourceName)
+	if err != nil {
+		return "", err
+	}
+	if dialect.Name() != "sqlite" {
+		return "", errors.New("Only SQLite databases are supported, back up PostgreSQL databases with pg_dump")
+	}
+
+	return driverDSN, nil
+}
+
+// copySQLiteDatabase copies all pages of the database into the given file in a single step, the source
+// database stays locked for writes during the copy, so the copy is consistent
+func copySQLiteDatabase(src *DB, destFile string) error {
+
+	ctx := context.Background()
+	srcConn, err := src.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer srcConn.Close()
+
+	dest, err := sql.Open("sqlite3", destFile)
+	if err != nil {
+		return err
+	}
+	defer dest.Close()
+
+	destConn, err := dest.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer destConn.Close()
+
+	return destConn.Raw(func(destDriverConn interface{}) error {
+		return srcConn.Raw(func(srcDriverConn interface{}) error {
+			destSQLite, destOk := destDriverConn.(*sqlite3.SQLiteConn)
+			srcSQLite, srcOk := srcDriverConn.(*sqlite3.SQLiteConn)
+			if !destOk || !srcOk {
+				return errors.New("Not a SQLite connection")
+			}
+
+			backup, err := destSQLite.Backup("main", srcSQLite, "main")
+			if err != nil {
+				return err
+			}
+			if _, err := backup.Step(-1); err != nil {
+				backup.Finish()
+				return err
+			}
+			return backup.Finish()
+		})
+	})
+}
@@ -0,0 +1,232 @@
+// Package client is a client of the dist-traceroute master API, see /api/openapi.json on the master for the spec.
+//
+//	c := client.New("http://localhost:8990")
+//	if err := c.Login("admin", "secret"); err != nil {
+//		...
+//	}
+//	targets, err := c.Targets(false)
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xmirakulix/dist-traceroute/disttrace"
+)
+
+// Client sends requests to the API of a master, it is safe for concurrent use
+type Client struct {
+	// BaseURL of the master, e.g. http://localhost:8990
+	BaseURL string
+	// HTTPClient sends the requests, http.DefaultClient if nil
+	HTTPClient *http.Client
+
+	lock         sync.RWMutex
+	token        string
+	refreshToken string
+	expires      time.Time
+}
+
+// APIError is returned for all responses of the master which aren't successful
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("master replied with status %v: %v", e.StatusCode, e.Message)
+}
+
+// New returns a client of the master at the given URL, it has to log in before sending other requests
+func New(baseURL string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimSuffix(baseURL, "/"),
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Login starts a session of the user, its auth token is sent with all further requests. The token is refreshed
+// automatically once it expired.
+func (c *Client) Login(user string, password string) error {
+
+	credentials := struct {
+		User     string
+		Password string
+	}{user, password}
+
+	var tokens disttrace.AuthTokens
+	if err := c.do("POST", "/api/auth", nil, credentials, "", &tokens); err != nil {
+		return err
+	}
+
+	c.setTokens(tokens)
+	return nil
+}
+
+// Refresh gets a new auth token with the refresh token of the session
+func (c *Client) Refresh() error {
+
+	c.lock.RLock()
+	refresh := struct{ RefreshToken string }{c.refreshToken}
+	c.lock.RUnlock()
+
+	if refresh.RefreshToken == "" {
+		return errors.New("No session to refresh, log in first")
+	}
+
+	var tokens disttrace.AuthTokens
+	if err := c.do("POST", "/api/auth/refresh", nil, refresh, "", &tokens); err != nil {
+		return err
+	}
+
+	c.setTokens(tokens)
+	return nil
+}
+
+// Logout revokes the auth token and ends the session
+func (c *Client) Logout() error {
+
+	if err := c.do("POST", "/api/auth/logout", nil, nil, "", nil); err != nil {
+		return err
+	}
+
+	c.setTokens(disttrace.AuthTokens{})
+	return nil
+}
+
+// setTokens stores the tokens of a login or refresh, the refresh token is kept if tokens doesn't contain a new one
+func (c *Client) setTokens(tokens disttrace.AuthTokens) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.token = tokens.AccessToken
+	c.expires = tokens.ExpiresAt
+	if tokens.RefreshToken != "" || tokens.AccessToken == "" {
+		c.refreshToken = tokens.RefreshToken
+	}
+}
+
+// SetToken sets the auth token sent with all requests, e.g. a token of a previous login. It can't be refreshed.
+func (c *Client) SetToken(token string) {
+	c.setTokens(disttrace.AuthTokens{AccessToken: token})
+}
+
+// Token returns the current auth token
+func (c *Client) Token() string {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.token
+}
+
+// refreshIfExpired refreshes the auth token shortly before it expires, if the client has a session
+func (c *Client) refreshIfExpired() error {
+
+	c.lock.RLock()
+	expired := c.refreshToken != "" && time.Now().Add(30*time.Second).After(c.expires)
+	c.lock.RUnlock()
+
+	if !expired {
+		return nil
+	}
+	return c.Refresh()
+}
+
+// do sends a request and decodes the JSON response into result. An io.Writer result, e.g. a *bytes.Buffer, receives
+// the raw response, a nil result discards it. A func(io.Reader) error result reads the response as stream, without
+// the timeout of the HTTP client. body is sent as is if it's an io.Reader, otherwise as JSON.
+func (c *Client) do(method string, path string, query url.Values, body interface{}, contentType string, result interface{}) error {
+
+	if path != "/api/auth" && path != "/api/auth/refresh" {
+		if err := c.refreshIfExpired(); err != nil {
+			return err
+		}
+	}
+
+	var reader io.Reader
+	if r, ok := body.(io.Reader); ok {
+		reader = r
+	} else if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("Couldn't encode request body: %v", err)
+		}
+		reader = bytes.NewReader(data)
+		contentType = "application/json"
+	}
+
+	reqURL := c.BaseURL + path
+	if len(query) > 0 {
+		reqURL += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequest(method, reqURL, reader)
+	if err != nil {
+		return fmt.Errorf("Couldn't create request: %v", err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if token := c.Token(); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	if _, stream := result.(func(io.Reader) error); stream && httpClient.Timeout != 0 {
+		streamClient := *httpClient
+		streamClient.Timeout = 0
+		httpClient = &streamClient
+	}
+
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("Couldn't send request to master: %v", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		message, _ := ioutil.ReadAll(io.LimitReader(res.Body, 4096))
+		return &APIError{StatusCode: res.StatusCode, Message: strings.TrimSpace(string(message))}
+	}
+
+	switch result := result.(type) {
+	case nil:
+		_, err = io.Copy(ioutil.Discard, res.Body)
+	case io.Writer:
+		_, err = io.Copy(result, res.Body)
+	case func(io.Reader) error:
+		return result(res.Body)
+	default:
+		err = json.NewDecoder(res.Body).Decode(result)
+	}
+	if err != nil {
+		return fmt.Errorf("Couldn't read response of master: %v", err)
+	}
+
+	return nil
+}
+
+// formatTime returns a timestamp as expected by the master, empty for the zero time
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// setIfNotEmpty adds a query parameter if the value isn't empty
+func setIfNotEmpty(query url.Values, name string, value string) {
+	if value != "" {
+		query.Set(name, value)
+	}
+}
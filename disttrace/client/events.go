@@ -0,0 +1,83 @@
+package client
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/xmirakulix/dist-traceroute/disttrace"
+)
+
+// Event is a live event of the master. Data holds a disttrace.Traceroute, disttrace.RouteChange, disttrace.Alert or
+// disttrace.SlaveStatusChange depending on Type, decode it with json.Unmarshal.
+type Event struct {
+	ID   uint64
+	Type string
+	Time time.Time
+	Data json.RawMessage
+}
+
+// Events subscribes to the live events matching the filter and calls fn for every event until fn returns an error
+// or the stream ends. The master ends the stream if the client can't keep up, reload the data before subscribing
+// again.
+func (c *Client) Events(filter disttrace.EventFilter, fn func(Event) error) error {
+
+	query := url.Values{}
+	for _, eventType := range filter.Types {
+		query.Add("type", eventType)
+	}
+	for _, slave := range filter.Slaves {
+		query.Add("slave", slave)
+	}
+	for _, target := range filter.Targets {
+		query.Add("target", target)
+	}
+
+	return c.do("GET", "/api/v1/events", query, nil, "", func(body io.Reader) error {
+		return readEvents(body, fn)
+	})
+}
+
+// readEvents parses a stream of Server-Sent Events, only the data of the events is used
+func readEvents(body io.Reader, fn func(Event) error) error {
+
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	var data strings.Builder
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		// an empty line completes an event, comments and other fields are ignored
+		if line == "" {
+			if data.Len() == 0 {
+				continue
+			}
+			var event Event
+			if err := json.Unmarshal([]byte(data.String()), &event); err != nil {
+				return fmt.Errorf("Couldn't decode event: %v", err)
+			}
+			data.Reset()
+			if err := fn(event); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if strings.HasPrefix(line, "data:") {
+			if data.Len() > 0 {
+				data.WriteString("\n")
+			}
+			data.WriteString(strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("Couldn't read events of master: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+package client
+
+import (
+	"bytes"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/xmirakulix/dist-traceroute/disttrace"
+)
+
+// Slaves returns all active or all archived slaves including their status
+func (c *Client) Slaves(archived bool) ([]disttrace.Slave, error) {
+	slaves := []disttrace.Slave{}
+	err := c.do("GET", "/api/slaves", archivedQuery(archived), nil, "", &slaves)
+	return slaves, err
+}
+
+// CreateSlave creates a slave with the credentials it uses to poll its config
+func (c *Client) CreateSlave(name string, secret string) (disttrace.Slave, error) {
+
+	query := url.Values{}
+	query.Set("name", name)
+	query.Set("secret", secret)
+
+	var slave disttrace.Slave
+	err := c.do("POST", "/api/slaves", query, nil, "", &slave)
+	return slave, err
+}
+
+// UpdateSlave changes the name and secret of a slave
+func (c *Client) UpdateSlave(slave disttrace.Slave) (disttrace.Slave, error) {
+	var updated disttrace.Slave
+	err := c.do("PUT", "/api/slaves", nil, slave, "", &updated)
+	return updated, err
+}
+
+// ArchiveSlave deletes a slave, its history is kept until it is purged
+func (c *Client) ArchiveSlave(slaveID uuid.UUID) error {
+	return c.do("DELETE", "/api/slaves/"+slaveID.String(), nil, nil, "", nil)
+}
+
+// RestoreSlave restores an archived slave
+func (c *Client) RestoreSlave(slaveID uuid.UUID) error {
+	return c.do("POST", "/api/slaves/"+slaveID.String()+"/restore", nil, nil, "", nil)
+}
+
+// PurgeSlave deletes an archived slave and its history, the master purges it in the background
+func (c *Client) PurgeSlave(slaveID uuid.UUID) error {
+	return c.do("POST", "/api/slaves/"+slaveID.String()+"/purge", nil, nil, "", nil)
+}
+
+// SlaveTelemetry returns the health reports of a slave in the given period, the zero time selects the defaults
+// of the master: the last 24 hours before to, and now
+func (c *Client) SlaveTelemetry(slaveID uuid.UUID, from time.Time, to time.Time) ([]disttrace.SlaveTelemetry, error) {
+
+	query := url.Values{}
+	setIfNotEmpty(query, "from", formatTime(from))
+	setIfNotEmpty(query, "to", formatTime(to))
+
+	reports := []disttrace.SlaveTelemetry{}
+	err := c.do("GET", "/api/slaves/"+slaveID.String()+"/telemetry", query, nil, "", &reports)
+	return reports, err
+}
+
+// Targets returns all active or all archived targets
+func (c *Client) Targets(archived bool) ([]disttrace.TraceTarget, error) {
+	targets := []disttrace.TraceTarget{}
+	err := c.do("GET", "/api/targets", archivedQuery(archived), nil, "", &targets)
+	return targets, err
+}
+
+// CreateTarget creates a target, optionally in a target group. Unset probe parameters of targets in a group
+// default to the group's parameters.
+func (c *Client) CreateTarget(target disttrace.TraceTarget, groupID uuid.UUID) (disttrace.TraceTarget, error) {
+
+	query := url.Values{}
+	query.Set("name", target.Name)
+	query.Set("address", target.Address)
+	query.Set("retries", strconv.Itoa(target.Retries))
+	query.Set("maxHops", strconv.Itoa(target.MaxHops))
+	query.Set("timeout", strconv.Itoa(target.TimeoutMs))
+	if groupID != uuid.Nil {
+		query.Set("group", groupID.String())
+	}
+
+	var created disttrace.TraceTarget
+	err := c.do("POST", "/api/targets", query, nil, "", &created)
+	return created, err
+}
+
+// UpdateTarget changes a target
+func (c *Client) UpdateTarget(target disttrace.TraceTarget) (disttrace.TraceTarget, error) {
+	var updated disttrace.TraceTarget
+	err := c.do("PUT", "/api/targets", nil, target, "", &updated)
+	return updated, err
+}
+
+// ArchiveTarget deletes a target, its history is kept until it is purged
+func (c *Client) ArchiveTarget(targetID uuid.UUID) error {
+	return c.do("DELETE", "/api/targets/"+targetID.String(), nil, nil, "", nil)
+}
+
+// RestoreTarget restores an archived target
+func (c *Client) RestoreTarget(targetID uuid.UUID) error {
+	return c.do("POST", "/api/targets/"+targetID.String()+"/restore", nil, nil, "", nil)
+}
+
+// PurgeTarget deletes an archived target and its history, the master purges it in the background
+func (c *Client) PurgeTarget(targetID uuid.UUID) error {
+	return c.do("POST", "/api/targets/"+targetID.String()+"/purge", nil, nil, "", nil)
+}
+
+// ImportTargets creates or updates the targets of a document in one of the formats disttrace.TargetImportCSV,
+// disttrace.TargetImportJSON or disttrace.TargetImportList. A dry run only reports the changes.
+func (c *Client) ImportTargets(document []byte, format string, dryRun bool) (disttrace.TargetImportReport, error) {
+
+	query := url.Values{}
+	query.Set("format", format)
+	if dryRun {
+		query.Set("dryRun", "true")
+	}
+
+	contentType := "text/plain"
+	switch format {
+	case disttrace.TargetImportCSV:
+		contentType = "text/csv"
+	case disttrace.TargetImportJSON:
+		contentType = "application/json"
+	}
+
+	var report disttrace.TargetImportReport
+	err := c.do("POST", "/api/targets/import", query, bytes.NewReader(document), contentType, &report)
+	return report, err
+}
+
+// Users returns all users
+func (c *Client) Users() ([]disttrace.User, error) {
+	users := []disttrace.User{}
+	err := c.do("GET", "/api/users", nil, nil, "", &users)
+	return users, err
+}
+
+// CreateUser creates a user with one of the roles disttrace.RoleViewer, disttrace.RoleOperator or disttrace.RoleAdmin,
+// restricted to the given target groups if any. pwNeedsChange forces the user to change the password after logging in.
+func (c *Client) CreateUser(name string, password string, pwNeedsChange bool, role string, targetGroups []uuid.UUID) (disttrace.User, error) {
+
+	query := url.Values{}
+	query.Set("name", name)
+	query.Set("password", password)
+	query.Set("pwNeedsChange", strconv.FormatBool(pwNeedsChange))
+	query.Set("role", role)
+	for _, groupID := range targetGroups {
+		query.Add("targetGroup", groupID.String())
+	}
+
+	var user disttrace.User
+	err := c.do("POST", "/api/users", query, nil, "", &user)
+	return user, err
+}
+
+// UpdateUser changes a user
+func (c *Client) UpdateUser(user disttrace.User) (disttrace.User, error) {
+	var updated disttrace.User
+	err := c.do("PUT", "/api/users", nil, user, "", &updated)
+	return updated, err
+}
+
+// DeleteUser deletes a user
+func (c *Client) DeleteUser(userID uuid.UUID) error {
+	return c.do("DELETE", "/api/users/"+userID.String(), nil, nil, "", nil)
+}
+
+// archivedQuery returns the query selecting archived slaves or targets
+func archivedQuery(archived bool) url.Values {
+	query := url.Values{}
+	if archived {
+		query.Set("archived", "true")
+	}
+	return query
+}
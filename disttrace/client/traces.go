@@ -0,0 +1,176 @@
+package client
+
+import (
+	"bytes"
+	"io"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/xmirakulix/dist-traceroute/disttrace"
+)
+
+// Status holds the status of the master and its latest application alerts
+type Status struct {
+	Uptime              string
+	LastSlaveConfigTime string
+	LastSlaveConfig     string
+	LastAlerts          []disttrace.AppAlert
+}
+
+// Status returns the status of the master
+func (c *Client) Status() (Status, error) {
+	var status Status
+	err := c.do("GET", "/api/status", nil, nil, "", &status)
+	return status, err
+}
+
+// TraceHistory returns the latest traceroutes, newest first. A limit of 0 returns all traceroutes.
+func (c *Client) TraceHistory(limit int) ([]disttrace.TraceHistoryEntry, error) {
+
+	query := url.Values{}
+	query.Set("limit", strconv.Itoa(limit))
+
+	entries := []disttrace.TraceHistoryEntry{}
+	err := c.do("GET", "/api/traces", query, nil, "", &entries)
+	return entries, err
+}
+
+// Traceroutes returns a page of the traceroutes matching the filter, newest first. Pass the NextCursor of the page
+// as Cursor of the filter to get the next page.
+func (c *Client) Traceroutes(filter disttrace.TracerouteFilter) (disttrace.TraceroutePage, error) {
+
+	query := tracerouteQuery(filter)
+	if filter.Limit != 0 {
+		query.Set("limit", strconv.Itoa(filter.Limit))
+	}
+	setIfNotEmpty(query, "cursor", filter.Cursor)
+
+	var page disttrace.TraceroutePage
+	err := c.do("GET", "/api/v1/traceroutes", query, nil, "", &page)
+	return page, err
+}
+
+// ExportTraceroutes writes all traceroutes matching the filter to w in one of the formats
+// disttrace.TracerouteExportCSV, disttrace.TracerouteExportNDJSON or disttrace.TracerouteExportParquet, the export is
+// streamed without holding it in memory. Limit and Cursor of the filter are ignored.
+func (c *Client) ExportTraceroutes(filter disttrace.TracerouteFilter, format string, w io.Writer) error {
+
+	query := tracerouteQuery(filter)
+	query.Set("format", format)
+
+	return c.do("GET", "/api/v1/traceroutes/export", query, nil, "", w)
+}
+
+// tracerouteQuery returns the query selecting the traceroutes of a filter, without limit and cursor
+func tracerouteQuery(filter disttrace.TracerouteFilter) url.Values {
+
+	query := url.Values{}
+	for _, slave := range filter.Slaves {
+		query.Add("slave", slave)
+	}
+	for _, target := range filter.Targets {
+		query.Add("target", target)
+	}
+	setIfNotEmpty(query, "from", formatTime(filter.From))
+	setIfNotEmpty(query, "to", formatTime(filter.To))
+	if filter.Success != nil {
+		query.Set("success", strconv.FormatBool(*filter.Success))
+	}
+	setIfNotEmpty(query, "hop", filter.HopIP)
+	if filter.ASN != 0 {
+		query.Set("asn", strconv.Itoa(filter.ASN))
+	}
+	return query
+}
+
+// EachTraceroute calls fn for all traceroutes matching the filter, newest first, fetching them page by page.
+// It stops at the first error returned by fn.
+func (c *Client) EachTraceroute(filter disttrace.TracerouteFilter, fn func(disttrace.Traceroute) error) error {
+	for {
+		page, err := c.Traceroutes(filter)
+		if err != nil {
+			return err
+		}
+		for _, tr := range page.Traceroutes {
+			if err := fn(tr); err != nil {
+				return err
+			}
+		}
+		if page.NextCursor == "" {
+			return nil
+		}
+		filter.Cursor = page.NextCursor
+	}
+}
+
+// Paths returns the distinct paths seen to a target, only the ones seen by the slave unless slaveID is uuid.Nil
+func (c *Client) Paths(targetID uuid.UUID, slaveID uuid.UUID) ([]disttrace.PathSighting, error) {
+
+	query := url.Values{}
+	query.Set("destID", targetID.String())
+	if slaveID != uuid.Nil {
+		query.Set("slaveID", slaveID.String())
+	}
+
+	paths := []disttrace.PathSighting{}
+	err := c.do("GET", "/api/paths", query, nil, "", &paths)
+	return paths, err
+}
+
+// GraphData returns the links between hops seen by a slave on its way to a target, skipping the first hops. The
+// zero time selects the defaults of the master: all data if both are zero, otherwise the last 24 hours before to,
+// and now.
+func (c *Client) GraphData(targetID uuid.UUID, slaveID uuid.UUID, skip int, from time.Time, to time.Time) (disttrace.GraphData, error) {
+
+	query := url.Values{}
+	query.Set("destID", targetID.String())
+	query.Set("slaveID", slaveID.String())
+	query.Set("skip", strconv.Itoa(skip))
+	setIfNotEmpty(query, "from", formatTime(from))
+	setIfNotEmpty(query, "to", formatTime(to))
+
+	var graph disttrace.GraphData
+	err := c.do("GET", "/api/graph", query, nil, "", &graph)
+	return graph, err
+}
+
+// Graph returns the graph of the hops seen by the slaves of the filter on their way to its target, all slaves if
+// the filter has none
+func (c *Client) Graph(filter disttrace.GraphFilter) (disttrace.Graph, error) {
+	var graph disttrace.Graph
+	err := c.do("GET", "/api/v1/graph", graphQuery(filter), nil, "", &graph)
+	return graph, err
+}
+
+// ExportGraph returns the graph of the filter in one of the formats disttrace.GraphExportDOT,
+// disttrace.GraphExportGraphML or disttrace.GraphExportJGF
+func (c *Client) ExportGraph(filter disttrace.GraphFilter, format string) ([]byte, error) {
+
+	query := graphQuery(filter)
+	query.Set("format", format)
+
+	var export bytes.Buffer
+	err := c.do("GET", "/api/v1/graph", query, nil, "", &export)
+	return export.Bytes(), err
+}
+
+// graphQuery returns the query selecting the graph of a filter
+func graphQuery(filter disttrace.GraphFilter) url.Values {
+
+	query := url.Values{}
+	query.Set("target", filter.Target)
+	for _, slave := range filter.Slaves {
+		query.Add("slave", slave)
+	}
+	setIfNotEmpty(query, "from", formatTime(filter.From))
+	setIfNotEmpty(query, "to", formatTime(filter.To))
+	if filter.Skip != 0 {
+		query.Set("skip", strconv.Itoa(filter.Skip))
+	}
+	if filter.MinCount != 0 {
+		query.Set("minCount", strconv.FormatInt(filter.MinCount, 10))
+	}
+	return query
+}
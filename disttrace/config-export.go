@@ -0,0 +1,417 @@
+package disttrace
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	valid "github.com/asaskevich/govalidator"
+	"github.com/google/uuid"
+	"gopkg.in/yaml.v2"
+)
+
+// ConfigDocumentVersion is the version of the format of configuration documents
+const ConfigDocumentVersion = 1
+
+// formats of configuration documents
+const (
+	ConfigFormatJSON = "json"
+	ConfigFormatYAML = "yaml"
+)
+
+// ConfigDocument is a portable document holding the configuration of a master. Groups reference their members
+// by name, so the document can be edited by hand and imported into other instances.
+type ConfigDocument struct {
+	FormatVersion int                 `json:"formatVersion" yaml:"formatVersion"`
+	Slaves        []ConfigSlave       `json:"slaves" yaml:"slaves"`
+	Targets       []ConfigTarget      `json:"targets" yaml:"targets"`
+	Users         []ConfigUser        `json:"users" yaml:"users"`
+	TargetGroups  []ConfigTargetGroup `json:"targetGroups" yaml:"targetGroups"`
+	SlaveGroups   []ConfigSlaveGroup  `json:"slaveGroups" yaml:"slaveGroups"`
+}
+
+// ConfigSlave is a slave in a configuration document
+type ConfigSlave struct {
+	Name   string `json:"name" yaml:"name"`
+	Secret string `json:"secret" yaml:"secret"`
+}
+
+// ConfigTarget is a target in a configuration document
+type ConfigTarget struct {
+	Name      string `json:"name" yaml:"name"`
+	Address   string `json:"address" yaml:"address"`
+	Retries   int    `json:"retries" yaml:"retries"`
+	MaxHops   int    `json:"maxHops" yaml:"maxHops"`
+	TimeoutMs int    `json:"timeoutMs" yaml:"timeoutMs"`
+}
+
+// ConfigUser is a user in a configuration document, only the password hash is exported
+type ConfigUser struct {
+	Name                string   `json:"name" yaml:"name"`
+	PasswordHash        string   `json:"passwordHash" yaml:"passwordHash"`
+	Salt                int      `json:"salt" yaml:"salt"`
+	PasswordNeedsChange bool     `json:"passwordNeedsChange" yaml:"passwordNeedsChange"`
+	Role                string   `json:"role,omitempty" yaml:"role,omitempty"`
+	TargetGroups        []string `json:"targetGroups,omitempty" yaml:"targetGroups,omitempty"`
+}
+
+// ConfigTargetGroup is a target group in a configuration document
+type ConfigTargetGroup struct {
+	Name      string   `json:"name" yaml:"name"`
+	Retries   int      `json:"retries" yaml:"retries"`
+	MaxHops   int      `json:"maxHops" yaml:"maxHops"`
+	TimeoutMs int      `json:"timeoutMs" yaml:"timeoutMs"`
+	Targets   []string `json:"targets" yaml:"targets"`
+}
+
+// ConfigSlaveGroup is a slave group in a configuration document
+type ConfigSlaveGroup struct {
+	Name         string   `json:"name" yaml:"name"`
+	Slaves       []string `json:"slaves" yaml:"slaves"`
+	TargetGroups []string `json:"targetGroups" yaml:"targetGroups"`
+}
+
+// ConfigFormatFromFileName returns the format of a configuration document by its file extension, JSON by default
+func ConfigFormatFromFileName(fileName string) string {
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".yaml", ".yml":
+		return ConfigFormatYAML
+	}
+	return ConfigFormatJSON
+}
+
+// MarshalConfigDocument encodes a configuration document in the given format
+func MarshalConfigDocument(doc ConfigDocument, format string) ([]byte, error) {
+	if format == ConfigFormatYAML {
+		return yaml.Marshal(doc)
+	}
+	return json.MarshalIndent(doc, "", "\t")
+}
+
+// UnmarshalConfigDocument decodes a configuration document in the given format
+func UnmarshalConfigDocument(data []byte, format string) (ConfigDocument, error) {
+	doc := ConfigDocument{}
+
+	var err error
+	if format == ConfigFormatYAML {
+		err = yaml.UnmarshalStrict(data, &doc)
+	} else {
+		decoder := json.NewDecoder(strings.NewReader(string(data)))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&doc)
+	}
+	if err != nil {
+		return ConfigDocument{}, err
+	}
+
+	if doc.FormatVersion < 1 || doc.FormatVersion > ConfigDocumentVersion {
+		return ConfigDocument{}, fmt.Errorf("Unsupported format version %v, supported is version %v", doc.FormatVersion, ConfigDocumentVersion)
+	}
+	return doc, nil
+}
+
+// ExportConfig reads the slaves, targets, users and groups from the db, archived slaves and targets aren't exported
+func ExportConfig(db *DB) (ConfigDocument, error) {
+
+	log.Debug("ExportConfig: Exporting configuration...")
+	doc := ConfigDocument{
+		FormatVersion: ConfigDocumentVersion,
+		Slaves:        []ConfigSlave{},
+		Targets:       []ConfigTarget{},
+		Users:         []ConfigUser{},
+		TargetGroups:  []ConfigTargetGroup{},
+		SlaveGroups:   []ConfigSlaveGroup{},
+	}
+
+	slaves, err := GetSlaves(db)
+	if err != nil {
+		return ConfigDocument{}, err
+	}
+	slaveNames := make(map[uuid.UUID]string)
+	for _, slave := range slaves {
+		slaveNames[slave.ID] = slave.Name
+		doc.Slaves = append(doc.Slaves, ConfigSlave{Name: slave.Name, Secret: slave.Secret})
+	}
+
+	targets, err := GetTargets(db)
+	if err != nil {
+		return ConfigDocument{}, err
+	}
+	targetNames := make(map[uuid.UUID]string)
+	for _, target := range targets {
+		targetNames[target.ID] = target.Name
+		doc.Targets = append(doc.Targets, ConfigTarget{
+			Name: target.Name, Address: target.Address, Retries: target.Retries, MaxHops: target.MaxHops, TimeoutMs: target.TimeoutMs,
+		})
+	}
+
+	targetGroups, err := GetTargetGroups(db)
+	if err != nil {
+		return ConfigDocument{}, err
+	}
+	targetGroupNames := make(map[uuid.UUID]string)
+	for _, group := range targetGroups {
+		targetGroupNames[group.ID] = group.Name
+		doc.TargetGroups = append(doc.TargetGroups, ConfigTargetGroup{
+			Name: group.Name, Retries: group.Retries, MaxHops: group.MaxHops, TimeoutMs: group.TimeoutMs,
+			Targets: namesOf(group.TargetIDs, targetNames),
+		})
+	}
+
+	users, err := GetUsers(db)
+	if err != nil {
+		return ConfigDocument{}, err
+	}
+	for _, user := range users {
+		doc.Users = append(doc.Users, ConfigUser{
+			Name:                user.Name,
+			PasswordHash:        base64.StdEncoding.EncodeToString(user.Password),
+			Salt:                user.Salt,
+			PasswordNeedsChange: user.PasswordNeedsChange,
+			Role:                user.Role,
+			TargetGroups:        namesOf(user.TargetGroups, targetGroupNames),
+		})
+	}
+
+	slaveGroups, err := GetSlaveGroups(db)
+	if err != nil {
+		return ConfigDocument{}, err
+	}
+	for _, group := range slaveGroups {
+		doc.SlaveGroups = append(doc.SlaveGroups, ConfigSlaveGroup{
+			Name:         group.Name,
+			Slaves:       namesOf(group.SlaveIDs, slaveNames),
+			TargetGroups: namesOf(group.TargetGroupIDs, targetGroupNames),
+		})
+	}
+
+	log.Infof("ExportConfig: Exported %v slaves, %v targets, %v users, %v target groups and %v slave groups",
+		len(doc.Slaves), len(doc.Targets), len(doc.Users), len(doc.TargetGroups), len(doc.SlaveGroups))
+	return doc, nil
+}
+
+// namesOf returns the names of the given IDs, IDs without a name (e.g. archived) are skipped
+func namesOf(ids []uuid.UUID, names map[uuid.UUID]string) []string {
+	result := []string{}
+	for _, id := range ids {
+		if name, ok := names[id]; ok {
+			result = append(result, name)
+		}
+	}
+	return result
+}
+
+// ImportConfig creates or updates the slaves, targets, users and groups of a configuration document in a single
+// transaction. Existing entries are matched by name and updated, archived slaves and targets are restored. Nothing
+// is deleted, group memberships are replaced by the ones of the document.
+func ImportConfig(db *DB, doc ConfigDocument) (err error) {
+
+	log.Debug("ImportConfig: Importing configuration...")
+	if err := validateConfigDocument(doc); err != nil {
+		return err
+	}
+
+	var tx *Tx
+	if tx, err = db.Begin(); err != nil {
+		log.Warn("ImportConfig: Couldn't start transaction, Error: ", err)
+		return errors.New("Couldn't start transaction")
+	}
+	// catch errors and rollback!
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	slaveIDs := make(map[string]uuid.UUID)
+	for _, slave := range doc.Slaves {
+		if slaveIDs[slave.Name], err = upsertByName(tx, "t_Slaves", "strSlaveId", "strSlaveName", slave.Name,
+			[]string{"strSlaveSecret", "dtArchived", "dtPurgeRequested"}, slave.Secret, nil, nil); err != nil {
+			return fmt.Errorf("Couldn't import slave '%v'", slave.Name)
+		}
+	}
+
+	targetIDs := make(map[string]uuid.UUID)
+	for _, target := range doc.Targets {
+		if targetIDs[target.Name], err = upsertByName(tx, "t_Targets", "strTargetId", "strDescription", target.Name,
+			[]string{"strDestination", "nRetries", "nMaxHops", "nTimeoutMSec", "dtArchived", "dtPurgeRequested"},
+			target.Address, target.Retries, target.MaxHops, target.TimeoutMs, nil, nil); err != nil {
+			return fmt.Errorf("Couldn't import target '%v'", target.Name)
+		}
+	}
+
+	userIDs := make(map[string]uuid.UUID)
+	for _, user := range doc.Users {
+		// users of documents without roles had full access
+		role := user.Role
+		if role == "" {
+			role = RoleAdmin
+		}
+
+		hash, _ := base64.StdEncoding.DecodeString(user.PasswordHash)
+		if userIDs[user.Name], err = upsertByName(tx, "t_Users", "strUserId", "strUserName", user.Name,
+			[]string{"strPassword", "nSalt", "nPassNeedsChange", "strRole"}, hash, user.Salt, user.PasswordNeedsChange, role); err != nil {
+			return fmt.Errorf("Couldn't import user '%v'", user.Name)
+		}
+	}
+
+	targetGroupIDs := make(map[string]uuid.UUID)
+	for _, group := range doc.TargetGroups {
+		var groupID uuid.UUID
+		if groupID, err = upsertByName(tx, "t_TargetGroups", "strGroupId", "strName", group.Name,
+			[]string{"nRetries", "nMaxHops", "nTimeoutMSec"}, group.Retries, group.MaxHops, group.TimeoutMs); err != nil {
+			return fmt.Errorf("Couldn't import target group '%v'", group.Name)
+		}
+		targetGroupIDs[group.Name] = groupID
+
+		var members []uuid.UUID
+		if members, err = lookupIDs(tx, "t_Targets", "strTargetId", "strDescription", group.Targets, targetIDs); err != nil {
+			return fmt.Errorf("Target group '%v': %v", group.Name, err)
+		}
+		if err = replaceGroupMembers(tx, "t_TargetGroupMembers", "strGroupId", "strTargetId", groupID, members); err != nil {
+			log.Warnf("ImportConfig: Couldn't store members of target group '%v', Error: %v", group.Name, err)
+			return fmt.Errorf("Couldn't import target group '%v'", group.Name)
+		}
+	}
+
+	for _, user := range doc.Users {
+		var groups []uuid.UUID
+		if groups, err = lookupIDs(tx, "t_TargetGroups", "strGroupId", "strName", user.TargetGroups, targetGroupIDs); err != nil {
+			return fmt.Errorf("User '%v': %v", user.Name, err)
+		}
+		if err = replaceGroupMembers(tx, "t_UserTargetGroups", "strUserId", "strGroupId", userIDs[user.Name], groups); err != nil {
+			log.Warnf("ImportConfig: Couldn't store target groups of user '%v', Error: %v", user.Name, err)
+			return fmt.Errorf("Couldn't import user '%v'", user.Name)
+		}
+	}
+
+	for _, group := range doc.SlaveGroups {
+		var groupID uuid.UUID
+		if groupID, err = upsertByName(tx, "t_SlaveGroups", "strGroupId", "strName", group.Name, []string{}); err != nil {
+			return fmt.Errorf("Couldn't import slave group '%v'", group.Name)
+		}
+
+		var members, assigned []uuid.UUID
+		if members, err = lookupIDs(tx, "t_Slaves", "strSlaveId", "strSlaveName", group.Slaves, slaveIDs); err != nil {
+			return fmt.Errorf("Slave group '%v': %v", group.Name, err)
+		}
+		if assigned, err = lookupIDs(tx, "t_TargetGroups", "strGroupId", "strName", group.TargetGroups, targetGroupIDs); err != nil {
+			return fmt.Errorf("Slave group '%v': %v", group.Name, err)
+		}
+		if err = replaceGroupMembers(tx, "t_SlaveGroupMembers", "strGroupId", "strSlaveId", groupID, members); err != nil {
+			log.Warnf("ImportConfig: Couldn't store members of slave group '%v', Error: %v", group.Name, err)
+			return fmt.Errorf("Couldn't import slave group '%v'", group.Name)
+		}
+		if err = replaceGroupMembers(tx, "t_SlaveGroupTargetGroups", "strSlaveGroupId", "strTargetGroupId", groupID, assigned); err != nil {
+			log.Warnf("ImportConfig: Couldn't store target groups of slave group '%v', Error: %v", group.Name, err)
+			return fmt.Errorf("Couldn't import slave group '%v'", group.Name)
+		}
+	}
+
+	// the imported roles must leave an admin to manage the users
+	var admins int
+	if err = tx.QueryRow("SELECT COUNT(*) FROM t_Users WHERE strRole = ?", RoleAdmin).Scan(&admins); err != nil {
+		log.Warn("ImportConfig: Couldn't count admins, Error: ", err)
+		return errors.New("Couldn't count admins")
+	}
+	if admins == 0 {
+		return ErrLastAdmin
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Warn("ImportConfig: Couldn't commit transaction, Error: ", err)
+		return errors.New("Couldn't commit transaction")
+	}
+
+	log.Infof("ImportConfig: Imported %v slaves, %v targets, %v users, %v target groups and %v slave groups",
+		len(doc.Slaves), len(doc.Targets), len(doc.Users), len(doc.TargetGroups), len(doc.SlaveGroups))
+	return nil
+}
+
+// validateConfigDocument checks all entries of a configuration document with the rules of the API
+func validateConfigDocument(doc ConfigDocument) error {
+
+	for _, slave := range doc.Slaves {
+		if ok, err := valid.ValidateStruct(Slave{Name: slave.Name, Secret: slave.Secret}); !ok || err != nil {
+			return fmt.Errorf("Invalid slave '%v': %v", slave.Name, err)
+		}
+	}
+	for _, target := range doc.Targets {
+		if ok, err := valid.ValidateStruct(TraceTarget{
+			Name: target.Name, Address: target.Address, Retries: target.Retries, MaxHops: target.MaxHops, TimeoutMs: target.TimeoutMs,
+		}); !ok || err != nil {
+			return fmt.Errorf("Invalid target '%v': %v", target.Name, err)
+		}
+	}
+	for _, user := range doc.Users {
+		if hash, err := base64.StdEncoding.DecodeString(user.PasswordHash); user.Name == "" || err != nil || len(hash) == 0 {
+			return fmt.Errorf("Invalid user '%v': name and base64 encoded password hash required", user.Name)
+		}
+		if user.Role != "" && !ValidRole(user.Role) {
+			return fmt.Errorf("Invalid user '%v': %v", user.Name, ErrInvalidRole)
+		}
+	}
+	for _, group := range doc.TargetGroups {
+		if ok, err := valid.ValidateStruct(TargetGroup{
+			Name: group.Name, Retries: group.Retries, MaxHops: group.MaxHops, TimeoutMs: group.TimeoutMs,
+		}); !ok || err != nil {
+			return fmt.Errorf("Invalid target group '%v': %v", group.Name, err)
+		}
+	}
+	for _, group := range doc.SlaveGroups {
+		if ok, err := valid.ValidateStruct(SlaveGroup{Name: group.Name}); !ok || err != nil {
+			return fmt.Errorf("Invalid slave group '%v': %v", group.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// upsertByName updates the given columns of the row with the given name or inserts a new row, returns its ID
+func upsertByName(tx *Tx, table string, idColumn string, nameColumn string, name string, columns []string, values ...interface{}) (uuid.UUID, error) {
+
+	var id uuid.UUID
+	err := tx.QueryRow("SELECT "+idColumn+" FROM "+table+" WHERE "+nameColumn+" = ?", name).Scan(&id)
+	switch {
+	case err == nil:
+		if len(columns) > 0 {
+			query := "UPDATE " + table + " SET " + strings.Join(columns, " = ?, ") + " = ? WHERE " + idColumn + " = ?"
+			_, err = tx.Exec(query, append(values, id)...)
+		}
+	case err == sql.ErrNoRows:
+		id = uuid.New()
+		query := "INSERT INTO " + table + " (" + strings.Join(append([]string{idColumn, nameColumn}, columns...), ", ") +
+			") VALUES (?" + strings.Repeat(", ?", len(columns)+1) + ")"
+		_, err = tx.Exec(query, append([]interface{}{id, name}, values...)...)
+	}
+	if err != nil {
+		log.Warnf("upsertByName: Couldn't store '%v' in '%v', Error: %v", name, table, err)
+		return uuid.Nil, err
+	}
+
+	return id, nil
+}
+
+// lookupIDs returns the IDs of the given names, names of the document are looked up in the db if not just imported
+func lookupIDs(tx *Tx, table string, idColumn string, nameColumn string, names []string, imported map[string]uuid.UUID) ([]uuid.UUID, error) {
+
+	ids := []uuid.UUID{}
+	for _, name := range names {
+		if id, ok := imported[name]; ok {
+			ids = append(ids, id)
+			continue
+		}
+
+		var id uuid.UUID
+		if err := tx.QueryRow("SELECT "+idColumn+" FROM "+table+" WHERE "+nameColumn+" = ?", name).Scan(&id); err != nil {
+			return nil, fmt.Errorf("Unknown member '%v'", name)
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
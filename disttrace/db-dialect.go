@@ -0,0 +1,249 @@
+package disttrace
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	// init postgres
+	_ "github.com/lib/pq"
+	// init sqlite3
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Dialect hides the differences between the supported database systems. All queries are written in
+// portable SQL with '?' placeholders, the dialect translates them and provides the schema of the database.
+// There is no storage interface per entity, both databases share the queries. Timestamps are only compared
+// through TimeExpr, as SQLite stores them as text.
+type Dialect interface {
+	// Name returns the name of the database system
+	Name() string
+	// DriverName returns the name of the database/sql driver
+	DriverName() string
+	// Rebind translates the '?' placeholders of a query into the placeholders of the database
+	Rebind(query string) string
+	// ConvertArgs converts query arguments into types the database can store in the schema's columns
+	ConvertArgs(args []interface{}) []interface{}
+	// TableExistsQuery returns a query which returns the name of the table given as argument if it exists
+	TableExistsQuery() string
+	// TimeExpr returns an expression, which makes the given timestamp column or placeholder comparable
+	TimeExpr(expr string) string
+	// DriverDataSourceName adds the connection options the database needs to the data source name of the driver
+	DriverDataSourceName(driverDSN string) string
+	// ForeignKeysQuery returns the statement enabling or disabling the enforcement of foreign keys on the current
+	// connection, empty if the database doesn't need it to rebuild referenced tables
+	ForeignKeysQuery(enabled bool) string
+	// Migrations returns the migrations of the schema, ordered by version
+	Migrations() []Migration
+}
+
+// parseDataSourceName determines the dialect of a data source name. postgres:// and postgresql:// URLs
+// select PostgreSQL, everything else is a SQLite database file with an optional sqlite3:// prefix.
+func parseDataSourceName(dataSourceName string) (Dialect, string, error) {
+
+	switch {
+	case strings.HasPrefix(dataSourceName, "postgres://") || strings.HasPrefix(dataSourceName, "postgresql://"):
+		if _, err := url.Parse(dataSourceName); err != nil {
+			return nil, "", err
+		}
+		return postgresDialect{}, dataSourceName, nil
+
+	case strings.HasPrefix(dataSourceName, "sqlite3://"):
+		return sqliteDialect{}, strings.TrimPrefix(dataSourceName, "sqlite3://"), nil
+
+	case strings.Contains(dataSourceName, "://"):
+		return nil, "", errors.New("Unsupported database type: " + dataSourceName)
+	}
+
+	return sqliteDialect{}, dataSourceName, nil
+}
+
+// CleanDataSourceName checks a data source name, paths of SQLite database files are cleaned
+func CleanDataSourceName(dataSourceName string) (string, error) {
+
+	dialect, driverDSN, err := parseDataSourceName(dataSourceName)
+	if err != nil {
+		return "", err
+	}
+
+	if dialect.Name() != "sqlite" {
+		return dataSourceName, nil
+	}
+
+	return CleanAndCheckFileNameAndPath(driverDSN)
+}
+
+// redactDataSourceName removes the password from a data source name for logging
+func redactDataSourceName(dataSourceName string) string {
+	if u, err := url.Parse(dataSourceName); err == nil && u.User != nil {
+		if _, hasPassword := u.User.Password(); hasPassword {
+			u.User = url.UserPassword(u.User.Username(), "xxxxx")
+			return u.String()
+		}
+	}
+	return dataSourceName
+}
+
+// sqliteDialect is used for SQLite database files
+type sqliteDialect struct{}
+
+func (sqliteDialect) Name() string {
+	return "sqlite"
+}
+
+func (sqliteDialect) DriverName() string {
+	return "sqlite3"
+}
+
+func (sqliteDialect) Rebind(query string) string {
+	return query
+}
+
+func (sqliteDialect) ConvertArgs(args []interface{}) []interface{} {
+	return args
+}
+
+func (sqliteDialect) TableExistsQuery() string {
+	return "SELECT tbl_name FROM sqlite_master WHERE type='table' AND tbl_name = ?"
+}
+
+func (sqliteDialect) TimeExpr(expr string) string {
+	// timestamps are stored as text with different time zones, normalize them to UTC
+	return "datetime(" + expr + ")"
+}
+
+func (sqliteDialect) DriverDataSourceName(driverDSN string) string {
+	// foreign keys are only enforced if enabled on every connection
+	if strings.Contains(driverDSN, "_foreign_keys=") || strings.Contains(driverDSN, "_fk=") {
+		return driverDSN
+	}
+	if strings.Contains(driverDSN, "?") {
+		return driverDSN + "&_foreign_keys=1"
+	}
+	return driverDSN + "?_foreign_keys=1"
+}
+
+func (sqliteDialect) ForeignKeysQuery(enabled bool) string {
+	if enabled {
+		return "PRAGMA foreign_keys = ON"
+	}
+	return "PRAGMA foreign_keys = OFF"
+}
+
+func (sqliteDialect) Migrations() []Migration {
+	return sqliteMigrations()
+}
+
+// postgresDialect is used for PostgreSQL databases
+type postgresDialect struct{}
+
+func (postgresDialect) Name() string {
+	return "postgres"
+}
+
+func (postgresDialect) DriverName() string {
+	return "postgres"
+}
+
+func (postgresDialect) Rebind(query string) string {
+
+	// replace placeholders outside of string literals with $1, $2, ...
+	var rebound strings.Builder
+	inLiteral := false
+	count := 0
+	for _, char := range query {
+		switch {
+		case char == '\'':
+			inLiteral = !inLiteral
+		case char == '?' && !inLiteral:
+			count++
+			rebound.WriteString("$" + strconv.Itoa(count))
+			continue
+		}
+		rebound.WriteRune(char)
+	}
+
+	return rebound.String()
+}
+
+func (postgresDialect) ConvertArgs(args []interface{}) []interface{} {
+
+	// flags are stored in integer columns
+	converted := make([]interface{}, len(args))
+	for i, arg := range args {
+		converted[i] = arg
+		if flag, ok := arg.(bool); ok {
+			converted[i] = 0
+			if flag {
+				converted[i] = 1
+			}
+		}
+	}
+
+	return converted
+}
+
+func (postgresDialect) TableExistsQuery() string {
+	// unquoted identifiers are folded to lower case by PostgreSQL
+	return "SELECT table_name FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = lower(?)"
+}
+
+func (postgresDialect) TimeExpr(expr string) string {
+	return expr
+}
+
+func (postgresDialect) DriverDataSourceName(driverDSN string) string {
+	return driverDSN
+}
+
+func (postgresDialect) ForeignKeysQuery(enabled bool) string {
+	return ""
+}
+
+func (postgresDialect) Migrations() []Migration {
+	return postgresMigrations()
+}
+
+// dbTime scans timestamps, which are returned as time.Time by PostgreSQL and as text by SQLite
+type dbTime struct {
+	time.Time
+}
+
+// timeFormats lists the formats timestamps are stored with in SQLite
+var timeFormats = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999-07:00",
+	"2006-01-02 15:04:05",
+}
+
+// Scan implements the sql.Scanner interface
+func (t *dbTime) Scan(value interface{}) error {
+
+	var text string
+	switch v := value.(type) {
+	case time.Time:
+		t.Time = v
+		return nil
+	case nil:
+		t.Time = time.Time{}
+		return nil
+	case []byte:
+		text = string(v)
+	case string:
+		text = v
+	default:
+		return fmt.Errorf("Can't scan %T into timestamp", value)
+	}
+
+	for _, format := range timeFormats {
+		if parsed, err := time.Parse(format, text); err == nil {
+			t.Time = parsed
+			return nil
+		}
+	}
+
+	return errors.New("Can't parse timestamp: " + text)
+}
@@ -3,35 +3,45 @@ package disttrace
 import (
 	"database/sql"
 	"time"
-
-	// init sqlite3
-	_ "github.com/mattn/go-sqlite3"
 )
 
-// DB wraps sql.DB
+// dbQueryDuration holds the duration of all queries and statements by their kind
+var dbQueryDuration = NewMetricHistogram("disttrace_db_query_duration_seconds",
+	"Duration of database queries and statements.", MetricDurationBuckets, "operation")
+
+// DB wraps sql.DB, queries are written with '?' placeholders and translated to the database's dialect
 type DB struct {
 	*sql.DB
+	Dialect Dialect
 }
 
 // Tx wraps sql.Tx
 type Tx struct {
 	*sql.Tx
+	dialect Dialect
 }
 
 // Stmt wraps sql.Stmt
 type Stmt struct {
 	*sql.Stmt
+	dialect Dialect
 }
 
 // open creates the database connection and returns a db reference
 func openDB(dataSourceName string) (*DB, error) {
-	db, err := sql.Open("sqlite3", dataSourceName)
+	dialect, driverDSN, err := parseDataSourceName(dataSourceName)
+	if err != nil {
+		log.Warn("DB Open: Invalid data source name, Error: ", err)
+		return nil, err
+	}
+
+	db, err := sql.Open(dialect.DriverName(), dialect.DriverDataSourceName(driverDSN))
 	if err != nil {
 		log.Warn("DB Open: Error while opening the database connection, Error: ", err)
 		return nil, err
 	}
-	log.Debug("DB Open: Successfully opened database: ", dataSourceName)
-	return &DB{db}, nil
+	log.Debugf("DB Open: Successfully opened %v database: %v", dialect.Name(), redactDataSourceName(dataSourceName))
+	return &DB{db, dialect}, nil
 }
 
 // Begin creates and returns a new transaction
@@ -42,7 +52,7 @@ func (db *DB) Begin() (*Tx, error) {
 		return nil, err
 	}
 	log.Debug("DB Begin: Successfully started transaction...")
-	return &Tx{tx}, nil
+	return &Tx{tx, db.Dialect}, nil
 }
 
 // Commit commits a transaction to the database
@@ -70,7 +80,9 @@ func (db *DB) Query(query string, args ...interface{}) (*sql.Rows, error) {
 
 	startTime := time.Now()
 
-	rows, err := db.DB.Query(query, args...)
+	query = db.Dialect.Rebind(query)
+	rows, err := db.DB.Query(query, db.Dialect.ConvertArgs(args)...)
+	dbQueryDuration.Observe(time.Since(startTime).Seconds(), "query")
 	if err != nil {
 		log.Warnf("DB Query: Error while executing query <%v>, duration: %v, Error: %v", query, time.Since(startTime), err)
 		return nil, err
@@ -85,7 +97,9 @@ func (db *DB) QueryRow(query string, args ...interface{}) *sql.Row {
 
 	startTime := time.Now()
 
-	row := db.DB.QueryRow(query, args...)
+	query = db.Dialect.Rebind(query)
+	row := db.DB.QueryRow(query, db.Dialect.ConvertArgs(args)...)
+	dbQueryDuration.Observe(time.Since(startTime).Seconds(), "query")
 
 	log.Debugf("DB QueryRow: Successfully executed query <%v>, duration: %v", query, time.Since(startTime))
 	return row
@@ -96,7 +110,9 @@ func (db *DB) Exec(query string, args ...interface{}) (sql.Result, error) {
 
 	startTime := time.Now()
 
-	result, err := db.DB.Exec(query, args...)
+	query = db.Dialect.Rebind(query)
+	result, err := db.DB.Exec(query, db.Dialect.ConvertArgs(args)...)
+	dbQueryDuration.Observe(time.Since(startTime).Seconds(), "exec")
 	if err != nil {
 		log.Warnf("DB Exec: Error while executing statement <%v>, duration: %v, Error: %v", query, time.Since(startTime), err)
 		return nil, err
@@ -129,7 +145,9 @@ func (tx *Tx) Query(query string, args ...interface{}) (*sql.Rows, error) {
 
 	startTime := time.Now()
 
-	rows, err := tx.Tx.Query(query, args...)
+	query = tx.dialect.Rebind(query)
+	rows, err := tx.Tx.Query(query, tx.dialect.ConvertArgs(args)...)
+	dbQueryDuration.Observe(time.Since(startTime).Seconds(), "query")
 	if err != nil {
 		log.Warnf("DB QueryTx: Error while executing query <%v>, duration: %v, Error: %v", query, time.Since(startTime), err)
 		return nil, err
@@ -144,7 +162,9 @@ func (tx *Tx) QueryRow(query string, args ...interface{}) *sql.Row {
 
 	startTime := time.Now()
 
-	row := tx.Tx.QueryRow(query, args...)
+	query = tx.dialect.Rebind(query)
+	row := tx.Tx.QueryRow(query, tx.dialect.ConvertArgs(args)...)
+	dbQueryDuration.Observe(time.Since(startTime).Seconds(), "query")
 
 	log.Debugf("DB QueryRowTx: Successfully executed query <%v>, duration: %v", query, time.Since(startTime))
 	return row
@@ -155,7 +175,9 @@ func (tx *Tx) Exec(query string, args ...interface{}) (sql.Result, error) {
 
 	startTime := time.Now()
 
-	result, err := tx.Tx.Exec(query, args...)
+	query = tx.dialect.Rebind(query)
+	result, err := tx.Tx.Exec(query, tx.dialect.ConvertArgs(args)...)
+	dbQueryDuration.Observe(time.Since(startTime).Seconds(), "exec")
 	if err != nil {
 		log.Warnf("DB ExecTx: Error while executing statement <%v>, duration: %v, Error: %v", query, time.Since(startTime), err)
 		return nil, err
@@ -170,7 +192,9 @@ func (tx *Tx) Prepare(query string) (*Stmt, error) {
 
 	startTime := time.Now()
 
+	query = tx.dialect.Rebind(query)
 	stmt, err := tx.Tx.Prepare(query)
+	dbQueryDuration.Observe(time.Since(startTime).Seconds(), "prepare")
 
 	if err != nil {
 		log.Warnf("DB Prepare: Error while preparing statement <%v>, duration: %v, Error: %v", query, time.Since(startTime), err)
@@ -178,7 +202,7 @@ func (tx *Tx) Prepare(query string) (*Stmt, error) {
 	}
 
 	log.Debugf("DB Prepare: Successfully prepared statement for query <%v>, duration: %v", query, time.Since(startTime))
-	return &Stmt{stmt}, nil
+	return &Stmt{stmt, tx.dialect}, nil
 }
 
 // Exec executes a prepared statement
@@ -186,7 +210,8 @@ func (stmt *Stmt) Exec(args ...interface{}) (sql.Result, error) {
 
 	startTime := time.Now()
 
-	result, err := stmt.Stmt.Exec(args...)
+	result, err := stmt.Stmt.Exec(stmt.dialect.ConvertArgs(args)...)
+	dbQueryDuration.Observe(time.Since(startTime).Seconds(), "exec")
 	if err != nil {
 		log.Warnf("DB ExecStmt: Error while executing prepared statement, duration: %v, Error: %v", time.Since(startTime), err)
 		return nil, err
@@ -0,0 +1,369 @@
+package disttrace
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Migration is a single versioned change of the database schema. Migrations are applied in the order of
+// their versions, the checksum of every applied migration is recorded to detect later modifications.
+type Migration struct {
+	Version int
+	Name    string
+	// Up holds the statements which apply the migration
+	Up []string
+	// Down holds the statements which revert the migration
+	Down []string
+	// Irreversible migrations can't be reverted, e.g. because they convert data
+	Irreversible bool
+	// DisableForeignKeys executes the migration without enforcing foreign keys, SQLite would otherwise
+	// cascade the deletes when a referenced table is rebuilt
+	DisableForeignKeys bool
+}
+
+// Checksum returns the checksum of the statements which apply the migration
+func (m Migration) Checksum() string {
+	sum := sha256.Sum256([]byte(strings.Join(m.Up, "\n;\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// MigrationStep is a migration which is applied or reverted to reach the requested schema version
+type MigrationStep struct {
+	Migration
+	Revert bool
+}
+
+// Statements returns the statements executed by the step
+func (step MigrationStep) Statements() []string {
+	if step.Revert {
+		return step.Down
+	}
+	return step.Up
+}
+
+// String describes the step
+func (step MigrationStep) String() string {
+	if step.Revert {
+		return fmt.Sprintf("revert migration %v '%v'", step.Version, step.Name)
+	}
+	return fmt.Sprintf("apply migration %v '%v'", step.Version, step.Name)
+}
+
+// appliedMigration is a migration recorded as applied in the database
+type appliedMigration struct {
+	Version  int
+	Name     string
+	Checksum string
+}
+
+// tableExists checks if a table exists in the database
+func (db *DB) tableExists(table string) (bool, error) {
+
+	var foundTable string
+	err := db.QueryRow(db.Dialect.TableExistsQuery(), table).Scan(&foundTable)
+	switch {
+	case err == sql.ErrNoRows:
+		return false, nil
+	case err != nil:
+		return false, err
+	}
+
+	return strings.EqualFold(foundTable, table), nil
+}
+
+// getAppliedMigrations returns the migrations recorded in the database. Databases created before migrations
+// were recorded only store their version in t_SchemaInfo, all migrations up to that version count as applied.
+func (db *DB) getAppliedMigrations(migrations []Migration) (applied []appliedMigration, legacyVersion int, err error) {
+
+	exists, err := db.tableExists("t_SchemaMigrations")
+	if err != nil {
+		log.Warn("getAppliedMigrations: Couldn't check for migrations table, Error: ", err)
+		return nil, 0, errors.New("Couldn't determine db version")
+	}
+
+	if exists {
+		rows, err := db.Query("SELECT nVersion, strName, strChecksum FROM t_SchemaMigrations ORDER BY nVersion")
+		if err != nil {
+			log.Warn("getAppliedMigrations: Couldn't read applied migrations, Error: ", err)
+			return nil, 0, errors.New("Couldn't read applied migrations")
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var m appliedMigration
+			if err := rows.Scan(&m.Version, &m.Name, &m.Checksum); err != nil {
+				log.Warn("getAppliedMigrations: Couldn't read applied migrations, Error: ", err)
+				return nil, 0, errors.New("Couldn't read applied migrations")
+			}
+			applied = append(applied, m)
+		}
+		return applied, 0, nil
+	}
+
+	// databases of older versions
+	if exists, err = db.tableExists("t_SchemaInfo"); err != nil {
+		log.Warn("getAppliedMigrations: Couldn't check for schema info table, Error: ", err)
+		return nil, 0, errors.New("Couldn't determine db version")
+	}
+	if !exists {
+		log.Debug("getAppliedMigrations: No migrations applied yet, assuming empty database")
+		return nil, 0, nil
+	}
+
+	if err := db.QueryRow("SELECT nVersion FROM t_SchemaInfo").Scan(&legacyVersion); err != nil {
+		log.Warn("getAppliedMigrations: Couldn't read db version from schemainfo, Error: ", err)
+		return nil, 0, errors.New("Couldn't read db version from schemainfo")
+	}
+	if legacyVersion > len(migrations) {
+		return nil, 0, fmt.Errorf("Unknown legacy schema version %v", legacyVersion)
+	}
+
+	for _, m := range migrations[:legacyVersion] {
+		applied = append(applied, appliedMigration{Version: m.Version, Name: m.Name, Checksum: m.Checksum()})
+	}
+	return applied, legacyVersion, nil
+}
+
+// getSchemaVersion returns the current schema version of the database
+func (db *DB) getSchemaVersion() (int, error) {
+
+	applied, _, err := db.getAppliedMigrations(db.Dialect.Migrations())
+	if err != nil {
+		return -1, err
+	}
+
+	return len(applied), nil
+}
+
+// planMigrations verifies the applied migrations and returns the steps to reach the target version,
+// a target of -1 is the newest version
+func (db *DB) planMigrations(target int) (steps []MigrationStep, applied []appliedMigration, legacyVersion int, err error) {
+
+	migrations := db.Dialect.Migrations()
+	if len(migrations) != maxDBVersion {
+		log.Warnf("planMigrations: Dialect '%v' has %v migrations, expected %v", db.Dialect.Name(), len(migrations), maxDBVersion)
+		return nil, nil, 0, errors.New("Incomplete schema definition")
+	}
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			return nil, nil, 0, fmt.Errorf("Migration '%v' has version %v, expected %v", m.Name, m.Version, i+1)
+		}
+	}
+
+	if applied, legacyVersion, err = db.getAppliedMigrations(migrations); err != nil {
+		return nil, nil, 0, err
+	}
+
+	// the applied migrations must be unmodified and without gaps
+	for i, m := range applied {
+		switch {
+		case m.Version > len(migrations):
+			return nil, nil, 0, fmt.Errorf("Database schema version %v is newer than the newest known version %v", m.Version, len(migrations))
+		case m.Version != i+1:
+			return nil, nil, 0, fmt.Errorf("Migration %v is missing in the database", i+1)
+		case m.Checksum != migrations[i].Checksum():
+			return nil, nil, 0, fmt.Errorf("Checksum mismatch of migration %v '%v', it was modified after it was applied", m.Version, m.Name)
+		}
+	}
+
+	current := len(applied)
+	if target == -1 {
+		target = len(migrations)
+	}
+	if target < 0 || target > len(migrations) {
+		return nil, nil, 0, fmt.Errorf("Unknown schema version %v, the newest version is %v", target, len(migrations))
+	}
+
+	for version := current + 1; version <= target; version++ {
+		steps = append(steps, MigrationStep{Migration: migrations[version-1]})
+	}
+	for version := current; version > target; version-- {
+		m := migrations[version-1]
+		if m.Irreversible {
+			return nil, nil, 0, fmt.Errorf("Migration %v '%v' is irreversible, can't revert to version %v", m.Version, m.Name, target)
+		}
+		steps = append(steps, MigrationStep{Migration: m, Revert: true})
+	}
+
+	return steps, applied, legacyVersion, nil
+}
+
+// recordLegacyMigrations creates the migrations table and records the migrations of a database of an older version
+func (db *DB) recordLegacyMigrations(applied []appliedMigration, legacyVersion int) (err error) {
+
+	var tx *Tx
+	if tx, err = db.Begin(); err != nil {
+		log.Warn("recordLegacyMigrations: Couldn't start transaction, Error: ", err)
+		return errors.New("Couldn't start transaction")
+	}
+	// catch errors and rollback!
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	query := `
+		CREATE TABLE IF NOT EXISTS t_SchemaMigrations (
+			nVersion INTEGER PRIMARY KEY,
+			strName TEXT NOT NULL,
+			strChecksum TEXT NOT NULL,
+			strApplied TEXT NOT NULL
+		)`
+	if _, err = tx.Exec(query); err != nil {
+		log.Warn("recordLegacyMigrations: Couldn't create migrations table, Error: ", err)
+		return errors.New("Couldn't create migrations table")
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	for _, m := range applied {
+		query := "INSERT INTO t_SchemaMigrations (nVersion, strName, strChecksum, strApplied) VALUES (?, ?, ?, ?)"
+		if _, err = tx.Exec(query, m.Version, m.Name, m.Checksum, now); err != nil {
+			log.Warn("recordLegacyMigrations: Couldn't record migration, Error: ", err)
+			return errors.New("Couldn't record migration")
+		}
+	}
+
+	if legacyVersion > 0 {
+		if _, err = tx.Exec("DROP TABLE t_SchemaInfo"); err != nil {
+			log.Warn("recordLegacyMigrations: Couldn't drop schema info, Error: ", err)
+			return errors.New("Couldn't drop schema info")
+		}
+		log.Infof("recordLegacyMigrations: Recorded %v migrations of legacy schema version %v", len(applied), legacyVersion)
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Warn("recordLegacyMigrations: Couldn't commit transaction, Error: ", err)
+		return errors.New("Couldn't commit transaction")
+	}
+	return nil
+}
+
+// executeMigrationStep applies or reverts a migration in a single transaction
+func (db *DB) executeMigrationStep(step MigrationStep) (err error) {
+
+	// the migration runs on a dedicated connection, foreign keys are disabled per connection
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		log.Warn("executeMigrationStep: Couldn't get database connection, Error: ", err)
+		return errors.New("Couldn't get database connection")
+	}
+	defer conn.Close()
+
+	// foreign keys can't be disabled within a transaction
+	if query := db.Dialect.ForeignKeysQuery(false); step.DisableForeignKeys && query != "" {
+		if _, err = conn.ExecContext(ctx, query); err != nil {
+			log.Warn("executeMigrationStep: Couldn't disable foreign keys, Error: ", err)
+			return errors.New("Couldn't disable foreign keys")
+		}
+		defer func() {
+			if _, err := conn.ExecContext(ctx, db.Dialect.ForeignKeysQuery(true)); err != nil {
+				log.Warn("executeMigrationStep: Couldn't enable foreign keys, Error: ", err)
+			}
+		}()
+	}
+
+	var sqlTx *sql.Tx
+	if sqlTx, err = conn.BeginTx(ctx, nil); err != nil {
+		log.Warn("executeMigrationStep: Couldn't start transaction, Error: ", err)
+		return errors.New("Couldn't start transaction")
+	}
+	tx := &Tx{sqlTx, db.Dialect}
+	// catch errors and rollback!
+	defer func() {
+		if err != nil {
+			log.Warn("executeMigrationStep: Doing rollback of transaction...")
+			tx.Rollback()
+		}
+	}()
+
+	cmds := step.Statements()
+	for i, cmd := range cmds {
+		log.Debugf("executeMigrationStep: Executing command %v of %v...", i+1, len(cmds))
+		if _, err = tx.Exec(cmd); err != nil {
+			log.Warnf("executeMigrationStep: Error while executing query <%v>, Error: %v", cmd, err)
+			return errors.New("Error while executing query")
+		}
+	}
+
+	if step.Revert {
+		_, err = tx.Exec("DELETE FROM t_SchemaMigrations WHERE nVersion = ?", step.Version)
+	} else {
+		query := "INSERT INTO t_SchemaMigrations (nVersion, strName, strChecksum, strApplied) VALUES (?, ?, ?, ?)"
+		_, err = tx.Exec(query, step.Version, step.Name, step.Checksum(), time.Now().UTC().Format(time.RFC3339))
+	}
+	if err != nil {
+		log.Warn("executeMigrationStep: Couldn't record migration, Error: ", err)
+		return errors.New("Couldn't record migration")
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Warn("executeMigrationStep: Couldn't commit transaction, Error: ", err)
+		return errors.New("Couldn't commit transaction")
+	}
+	return nil
+}
+
+// migrate brings the database schema to the target version, a target of -1 is the newest version.
+// On a dry run the steps are only determined, the database isn't changed.
+func (db *DB) migrate(target int, dryRun bool) ([]MigrationStep, error) {
+
+	log.Debug("migrate: Checking if database schema needs migrating...")
+
+	steps, applied, legacyVersion, err := db.planMigrations(target)
+	if err != nil {
+		log.Warn("migrate: Couldn't plan migrations, Error: ", err)
+		return nil, err
+	}
+
+	if len(steps) == 0 {
+		log.Infof("migrate: Schema is at version %v, no migration needed", len(applied))
+		return steps, nil
+	}
+
+	if dryRun {
+		for _, step := range steps {
+			log.Infof("migrate: Dry run, would %v", step)
+		}
+		return steps, nil
+	}
+
+	// the migrations table is created on the first migration
+	if legacyVersion > 0 || len(applied) == 0 {
+		if err := db.recordLegacyMigrations(applied, legacyVersion); err != nil {
+			return nil, err
+		}
+	}
+
+	log.Warnf("migrate: Database schema needs to be migrated, current version: %v, %v migrations to execute", len(applied), len(steps))
+	for _, step := range steps {
+		log.Infof("migrate: Going to %v...", step)
+		if err := db.executeMigrationStep(step); err != nil {
+			log.Warnf("migrate: Couldn't %v, Error: %v", step, err)
+			return nil, fmt.Errorf("Couldn't %v", step)
+		}
+	}
+
+	log.Info("migrate: Finished migrating the database schema. Now on version: ", len(applied)+countApplied(steps))
+	return steps, nil
+}
+
+// countApplied returns the number of applied minus the number of reverted migrations in the steps
+func countApplied(steps []MigrationStep) int {
+	count := 0
+	for _, step := range steps {
+		if step.Revert {
+			count--
+		} else {
+			count++
+		}
+	}
+	return count
+}
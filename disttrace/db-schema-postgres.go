@@ -0,0 +1,479 @@
+package disttrace
+
+// postgresMigrations returns the migrations of the schema of PostgreSQL databases. Versions match the
+// SQLite schema, tables use native timestamp, floating point and binary types.
+func postgresMigrations() []Migration {
+
+	return []Migration{
+		{
+			Version: 1,
+			Name:    "schema info",
+			// versions were stored in t_SchemaInfo before migrations were recorded in t_SchemaMigrations
+			Up:   []string{},
+			Down: []string{},
+		},
+		{
+			Version: 2,
+			Name:    "initial schema",
+			Up: []string{
+				`CREATE TABLE IF NOT EXISTS t_Traceroutes (
+					strTracerouteId TEXT PRIMARY KEY,
+					strSlaveId TEXT NOT NULL,
+					strTargetId TEXT NOT NULL,
+					dtStart TIMESTAMPTZ NOT NULL,
+					strAnnotations TEXT
+				)`,
+
+				`CREATE TABLE IF NOT EXISTS t_Hops (
+					strHopId TEXT PRIMARY KEY,
+					strTracerouteId TEXT NOT NULL, 
+					nHopIndex INTEGER NOT NULL,
+					strHopIPAddress TEXT,
+					strHopDNSName TEXT, 
+					dDurationSec DOUBLE PRECISION,
+					strPreviousHopId TEXT,
+					strAnnotations TEXT
+				)`,
+
+				`CREATE TABLE IF NOT EXISTS t_Targets (
+					strTargetId TEXT PRIMARY KEY,
+					strDescription TEXT UNIQUE, 
+					strDestination TEXT NOT NULL,
+					nRetries INTEGER NOT NULL,
+					nMaxHops INTEGER NOT NULL,
+					nTimeoutMSec INTEGER NOT NULL
+				)`,
+
+				`CREATE TABLE IF NOT EXISTS t_Slaves (
+					strSlaveId TEXT PRIMARY KEY, 
+					strSlaveName TEXT NOT NULL UNIQUE,
+					strSlaveSecret TEXT NOT NULL 
+				)`,
+
+				`CREATE TABLE IF NOT EXISTS t_MasterConfig (
+					strConfigId TEXT PRIMARY KEY, 
+					strReportURL TEXT NOT NULL
+				)`,
+
+				`CREATE TABLE IF NOT EXISTS t_Users (
+					strUserId TEXT PRIMARY KEY, 
+					strUserName TEXT NOT NULL UNIQUE,
+					strPassword BYTEA NOT NULL,
+					nSalt INTEGER NOT NULL,
+					nPassNeedsChange INTEGER NOT NULL
+				)`,
+
+				// create admin:123 user
+				`INSERT INTO t_Users (strUserId, strUserName, strPassword, nSalt, nPassNeedsChange) 
+					VALUES ('998dd43d-86b1-44a3-8f28-d31cd2822927', 'admin', 
+					decode('d68f3b8ca9aef9120b30823ea284c7bd001fc1beef4b3796fd609bc641031db8', 'hex'), 1298498081, 1); 
+				`,
+			},
+			Down: []string{
+				`DROP TABLE t_Users`,
+				`DROP TABLE t_MasterConfig`,
+				`DROP TABLE t_Slaves`,
+				`DROP TABLE t_Targets`,
+				`DROP TABLE t_Hops`,
+				`DROP TABLE t_Traceroutes`,
+			},
+		},
+		{
+			Version: 3,
+			Name:    "skipped version",
+			// version 2 used to record itself as version 3, kept to preserve the numbering
+			Up:   []string{},
+			Down: []string{},
+		},
+		{
+			Version: 4,
+			Name:    "alerting",
+			Up: []string{
+				`ALTER TABLE t_Traceroutes ADD COLUMN nSuccess INTEGER NOT NULL DEFAULT 1`,
+
+				`CREATE TABLE IF NOT EXISTS t_AlertRules (
+					strRuleId TEXT PRIMARY KEY,
+					strName TEXT NOT NULL UNIQUE,
+					strType TEXT NOT NULL,
+					strTargetId TEXT,
+					strSlaveId TEXT,
+					nThreshold INTEGER NOT NULL,
+					nDurationMin INTEGER NOT NULL,
+					strSeverity TEXT NOT NULL,
+					nEnabled INTEGER NOT NULL
+				)`,
+
+				`CREATE TABLE IF NOT EXISTS t_Alerts (
+					strAlertId TEXT PRIMARY KEY,
+					strRuleId TEXT,
+					strDedupKey TEXT NOT NULL,
+					strSeverity TEXT NOT NULL,
+					strSource TEXT NOT NULL,
+					strText TEXT NOT NULL,
+					strState TEXT NOT NULL,
+					dtFirstSeen TIMESTAMPTZ NOT NULL,
+					dtLastSeen TIMESTAMPTZ NOT NULL,
+					dtResolved TIMESTAMPTZ,
+					dtAcknowledged TIMESTAMPTZ,
+					strAcknowledgedBy TEXT,
+					nCount INTEGER NOT NULL
+				)`,
+
+				`CREATE INDEX IF NOT EXISTS idx_Alerts_DedupKey ON t_Alerts (strDedupKey, strState)`,
+			},
+			Down: []string{
+				`DROP INDEX idx_Alerts_DedupKey`,
+				`DROP TABLE t_Alerts`,
+				`DROP TABLE t_AlertRules`,
+				`ALTER TABLE t_Traceroutes DROP COLUMN nSuccess`,
+			},
+		},
+		{
+			Version: 5,
+			Name:    "notification channels",
+			Up: []string{
+				`CREATE TABLE IF NOT EXISTS t_NotificationChannels (
+					strChannelId TEXT PRIMARY KEY,
+					strName TEXT NOT NULL UNIQUE,
+					strType TEXT NOT NULL,
+					strMinSeverity TEXT NOT NULL,
+					nEnabled INTEGER NOT NULL,
+					strSettings TEXT NOT NULL
+				)`,
+			},
+			Down: []string{
+				`DROP TABLE t_NotificationChannels`,
+			},
+		},
+		{
+			Version: 6,
+			Name:    "slave status",
+			Up: []string{
+				`CREATE TABLE IF NOT EXISTS t_SlaveStatus (
+					strSlaveId TEXT PRIMARY KEY,
+					strVersion TEXT NOT NULL,
+					nUptimeSec BIGINT NOT NULL,
+					nQueueDepth INTEGER NOT NULL,
+					strSourceIP TEXT NOT NULL,
+					dtLastConfigPoll TIMESTAMPTZ,
+					dtLastResult TIMESTAMPTZ
+				)`,
+
+				// alert on slaves that went silent by default
+				`INSERT INTO t_AlertRules (strRuleId, strName, strType, strTargetId, strSlaveId, nThreshold, nDurationMin, strSeverity, nEnabled)
+					VALUES ('4b0c6a56-3c1f-4f4e-9a59-8e2f2f0b7d1e', 'Slave silent', 'slaveSilent', NULL, NULL, 0, 5, 'warning', 1)
+				`,
+			},
+			Down: []string{
+				`DELETE FROM t_AlertRules WHERE strRuleId = '4b0c6a56-3c1f-4f4e-9a59-8e2f2f0b7d1e'`,
+				`DROP TABLE t_SlaveStatus`,
+			},
+		},
+		{
+			Version: 7,
+			Name:    "slave telemetry",
+			Up: []string{
+				`CREATE TABLE IF NOT EXISTS t_SlaveTelemetry (
+					strTelemetryId TEXT PRIMARY KEY,
+					strSlaveId TEXT NOT NULL,
+					dtReceived TIMESTAMPTZ NOT NULL,
+					dtSlaveTime TIMESTAMPTZ NOT NULL,
+					nQueueDepth INTEGER NOT NULL,
+					nDroppedResults INTEGER NOT NULL,
+					nMeasurements INTEGER NOT NULL,
+					dMeasurementAvgMs DOUBLE PRECISION NOT NULL,
+					dMeasurementMaxMs DOUBLE PRECISION NOT NULL,
+					strErrorCounts TEXT NOT NULL,
+					dClockOffsetMs DOUBLE PRECISION NOT NULL,
+					dLoad1 DOUBLE PRECISION NOT NULL,
+					dLoad5 DOUBLE PRECISION NOT NULL,
+					dLoad15 DOUBLE PRECISION NOT NULL
+				)`,
+
+				`CREATE INDEX IF NOT EXISTS idx_SlaveTelemetry_Slave ON t_SlaveTelemetry (strSlaveId, dtReceived)`,
+			},
+			Down: []string{
+				`DROP INDEX idx_SlaveTelemetry_Slave`,
+				`DROP TABLE t_SlaveTelemetry`,
+			},
+		},
+		{
+			Version: 8,
+			Name:    "target and slave groups",
+			Up: []string{
+				`CREATE TABLE IF NOT EXISTS t_TargetGroups (
+					strGroupId TEXT PRIMARY KEY,
+					strName TEXT NOT NULL UNIQUE,
+					nRetries INTEGER NOT NULL DEFAULT 0,
+					nMaxHops INTEGER NOT NULL DEFAULT 0,
+					nTimeoutMSec INTEGER NOT NULL DEFAULT 0
+				)`,
+
+				`CREATE TABLE IF NOT EXISTS t_TargetGroupMembers (
+					strGroupId TEXT NOT NULL,
+					strTargetId TEXT NOT NULL,
+					PRIMARY KEY (strGroupId, strTargetId)
+				)`,
+
+				`CREATE TABLE IF NOT EXISTS t_SlaveGroups (
+					strGroupId TEXT PRIMARY KEY,
+					strName TEXT NOT NULL UNIQUE
+				)`,
+
+				`CREATE TABLE IF NOT EXISTS t_SlaveGroupMembers (
+					strGroupId TEXT NOT NULL,
+					strSlaveId TEXT NOT NULL,
+					PRIMARY KEY (strGroupId, strSlaveId)
+				)`,
+
+				`CREATE TABLE IF NOT EXISTS t_SlaveGroupTargetGroups (
+					strSlaveGroupId TEXT NOT NULL,
+					strTargetGroupId TEXT NOT NULL,
+					PRIMARY KEY (strSlaveGroupId, strTargetGroupId)
+				)`,
+
+				`CREATE INDEX IF NOT EXISTS idx_TargetGroupMembers_Target ON t_TargetGroupMembers (strTargetId)`,
+				`CREATE INDEX IF NOT EXISTS idx_SlaveGroupMembers_Slave ON t_SlaveGroupMembers (strSlaveId)`,
+			},
+			Down: []string{
+				`DROP INDEX idx_SlaveGroupMembers_Slave`,
+				`DROP INDEX idx_TargetGroupMembers_Target`,
+				`DROP TABLE t_SlaveGroupTargetGroups`,
+				`DROP TABLE t_SlaveGroupMembers`,
+				`DROP TABLE t_SlaveGroups`,
+				`DROP TABLE t_TargetGroupMembers`,
+				`DROP TABLE t_TargetGroups`,
+			},
+		},
+		{
+			Version: 9,
+			Name:    "hop aggregates",
+			Up: []string{
+				`CREATE TABLE IF NOT EXISTS t_HopAggregates (
+					strSlaveId TEXT NOT NULL,
+					strTargetId TEXT NOT NULL,
+					strResolution TEXT NOT NULL,
+					dtBucket TIMESTAMPTZ NOT NULL,
+					nHopIndex INTEGER NOT NULL,
+					strHopIPAddress TEXT NOT NULL,
+					strPrevHopIPAddress TEXT NOT NULL,
+					nCount INTEGER NOT NULL,
+					dDurationSumSec DOUBLE PRECISION NOT NULL,
+					dDurationMinSec DOUBLE PRECISION NOT NULL,
+					dDurationMaxSec DOUBLE PRECISION NOT NULL,
+					PRIMARY KEY (strSlaveId, strTargetId, strResolution, dtBucket, nHopIndex, strHopIPAddress, strPrevHopIPAddress)
+				)`,
+
+				`CREATE INDEX IF NOT EXISTS idx_HopAggregates_Bucket ON t_HopAggregates (strResolution, dtBucket)`,
+			},
+			Down: []string{
+				`DROP INDEX idx_HopAggregates_Bucket`,
+				`DROP TABLE t_HopAggregates`,
+			},
+		},
+		{
+			Version: 10,
+			Name:    "normalized paths",
+			Up: []string{
+				// hops are converted into paths by convertLegacyHops after the upgrade
+				`ALTER TABLE t_Hops RENAME TO t_LegacyHops`,
+				`CREATE INDEX IF NOT EXISTS idx_LegacyHops_Traceroute ON t_LegacyHops (strTracerouteId)`,
+
+				`CREATE TABLE IF NOT EXISTS t_Paths (
+					strPathId TEXT PRIMARY KEY,
+					nHopCount INTEGER NOT NULL,
+					dtFirstSeen TIMESTAMPTZ NOT NULL
+				)`,
+
+				`CREATE TABLE IF NOT EXISTS t_PathHops (
+					strPathId TEXT NOT NULL,
+					nPosition INTEGER NOT NULL,
+					nHopIndex INTEGER NOT NULL,
+					strHopIPAddress TEXT NOT NULL,
+					strHopDNSName TEXT NOT NULL,
+					strPrevHopIPAddress TEXT NOT NULL,
+					PRIMARY KEY (strPathId, nPosition)
+				)`,
+
+				`CREATE TABLE IF NOT EXISTS t_PathSightings (
+					strSlaveId TEXT NOT NULL,
+					strTargetId TEXT NOT NULL,
+					strPathId TEXT NOT NULL,
+					dtFirstSeen TIMESTAMPTZ NOT NULL,
+					dtLastSeen TIMESTAMPTZ NOT NULL,
+					nCount INTEGER NOT NULL,
+					PRIMARY KEY (strTargetId, strSlaveId, strPathId)
+				)`,
+
+				`ALTER TABLE t_Traceroutes ADD COLUMN strPathId TEXT`,
+				`ALTER TABLE t_Traceroutes ADD COLUMN strHopRTTs TEXT`,
+				`ALTER TABLE t_Traceroutes ADD COLUMN strHopLoss TEXT`,
+			},
+			// hops are converted into paths by convertLegacyHops after the migration
+			Irreversible: true,
+		},
+		{
+			Version: 11,
+			Name:    "foreign keys and indexes",
+			Up:      postgresForeignKeysUp(),
+			Down:    postgresForeignKeysDown(),
+		},
+		{
+			Version: 12,
+			Name:    "archived slaves and targets",
+			Up: []string{
+				`ALTER TABLE t_Slaves ADD COLUMN dtArchived TIMESTAMPTZ`,
+				`ALTER TABLE t_Slaves ADD COLUMN dtPurgeRequested TIMESTAMPTZ`,
+				`ALTER TABLE t_Targets ADD COLUMN dtArchived TIMESTAMPTZ`,
+				`ALTER TABLE t_Targets ADD COLUMN dtPurgeRequested TIMESTAMPTZ`,
+			},
+			Down: []string{
+				`ALTER TABLE t_Targets DROP COLUMN dtArchived`,
+				`ALTER TABLE t_Targets DROP COLUMN dtPurgeRequested`,
+				`ALTER TABLE t_Slaves DROP COLUMN dtArchived`,
+				`ALTER TABLE t_Slaves DROP COLUMN dtPurgeRequested`,
+			},
+		},
+		{
+			Version: 13,
+			Name:    "auth signing keys and sessions",
+			Up: []string{
+				`CREATE TABLE IF NOT EXISTS t_AuthKeys (
+					strKeyId TEXT PRIMARY KEY,
+					strSecret TEXT NOT NULL,
+					dtCreated TIMESTAMPTZ NOT NULL,
+					dtRetired TIMESTAMPTZ
+				)`,
+
+				`CREATE TABLE IF NOT EXISTS t_RefreshTokens (
+					strTokenId TEXT PRIMARY KEY,
+					strUserId TEXT NOT NULL REFERENCES t_Users (strUserId) ON DELETE CASCADE,
+					strTokenHash TEXT NOT NULL,
+					dtCreated TIMESTAMPTZ NOT NULL,
+					dtExpires TIMESTAMPTZ NOT NULL
+				)`,
+
+				`CREATE TABLE IF NOT EXISTS t_RevokedTokens (
+					strTokenId TEXT PRIMARY KEY,
+					dtExpires TIMESTAMPTZ NOT NULL
+				)`,
+			},
+			Down: []string{
+				`DROP TABLE t_RevokedTokens`,
+				`DROP TABLE t_RefreshTokens`,
+				`DROP TABLE t_AuthKeys`,
+			},
+		},
+		{
+			Version: 14,
+			Name:    "user roles",
+			Up: []string{
+				// existing users keep access to everything
+				`ALTER TABLE t_Users ADD COLUMN strRole TEXT NOT NULL DEFAULT 'admin'`,
+
+				`CREATE TABLE IF NOT EXISTS t_UserTargetGroups (
+					strUserId TEXT NOT NULL REFERENCES t_Users (strUserId) ON DELETE CASCADE,
+					strGroupId TEXT NOT NULL REFERENCES t_TargetGroups (strGroupId) ON DELETE CASCADE,
+					PRIMARY KEY (strUserId, strGroupId)
+				)`,
+			},
+			Down: []string{
+				`DROP TABLE t_UserTargetGroups`,
+				`ALTER TABLE t_Users DROP COLUMN strRole`,
+			},
+		},
+		{
+			Version: 15,
+			Name:    "slave status backfill",
+			Up: []string{
+				// slaves with results from before the slave status was recorded aren't reported as never seen
+				`UPDATE t_SlaveStatus SET dtLastResult = (
+					SELECT MAX(dtStart) FROM t_Traceroutes WHERE t_Traceroutes.strSlaveId = t_SlaveStatus.strSlaveId
+				) WHERE dtLastResult IS NULL`,
+
+				`INSERT INTO t_SlaveStatus (strSlaveId, strVersion, nUptimeSec, nQueueDepth, strSourceIP, dtLastResult)
+					SELECT strSlaveId, '', 0, 0, '', MAX(dtStart) FROM t_Traceroutes
+					WHERE strSlaveId IN (SELECT strSlaveId FROM t_Slaves)
+						AND strSlaveId NOT IN (SELECT strSlaveId FROM t_SlaveStatus)
+					GROUP BY strSlaveId
+				`,
+			},
+			// the status is recorded again with the next contact of the slave
+			Down: []string{},
+		},
+		{
+			Version: 16,
+			Name:    "user role default",
+			Up: []string{
+				// users inserted without a role get the least privileges
+				`ALTER TABLE t_Users ALTER COLUMN strRole SET DEFAULT 'viewer'`,
+			},
+			Down: []string{
+				`ALTER TABLE t_Users ALTER COLUMN strRole SET DEFAULT 'admin'`,
+			},
+		},
+		{
+			Version: 17,
+			Name:    "hop autonomous systems",
+			Up: []string{
+				// hops of existing paths are annotated by UpdatePathHopASNs when the ASN database is loaded
+				`ALTER TABLE t_PathHops ADD COLUMN nASN INTEGER NOT NULL DEFAULT 0`,
+				`CREATE INDEX IF NOT EXISTS idx_PathHops_ASN ON t_PathHops (nASN, strPathId)`,
+			},
+			Down: []string{
+				`DROP INDEX idx_PathHops_ASN`,
+				`ALTER TABLE t_PathHops DROP COLUMN nASN`,
+			},
+		},
+	}
+}
+
+// postgresForeignKey is a foreign key constraint added to an existing table
+type postgresForeignKey struct {
+	Name       string
+	Table      string
+	Definition string
+}
+
+// postgresForeignKeys lists the foreign keys of the referencing tables
+var postgresForeignKeys = []postgresForeignKey{
+	{"fk_Traceroutes_Slave", "t_Traceroutes", "FOREIGN KEY (strSlaveId) REFERENCES t_Slaves (strSlaveId) ON DELETE CASCADE"},
+	{"fk_Traceroutes_Target", "t_Traceroutes", "FOREIGN KEY (strTargetId) REFERENCES t_Targets (strTargetId) ON DELETE CASCADE"},
+	{"fk_Traceroutes_Path", "t_Traceroutes", "FOREIGN KEY (strPathId) REFERENCES t_Paths (strPathId)"},
+	{"fk_SlaveStatus_Slave", "t_SlaveStatus", "FOREIGN KEY (strSlaveId) REFERENCES t_Slaves (strSlaveId) ON DELETE CASCADE"},
+	{"fk_SlaveTelemetry_Slave", "t_SlaveTelemetry", "FOREIGN KEY (strSlaveId) REFERENCES t_Slaves (strSlaveId) ON DELETE CASCADE"},
+	{"fk_AlertRules_Target", "t_AlertRules", "FOREIGN KEY (strTargetId) REFERENCES t_Targets (strTargetId) ON DELETE CASCADE"},
+	{"fk_AlertRules_Slave", "t_AlertRules", "FOREIGN KEY (strSlaveId) REFERENCES t_Slaves (strSlaveId) ON DELETE CASCADE"},
+	// alerts are kept when their rule is deleted
+	{"fk_Alerts_Rule", "t_Alerts", "FOREIGN KEY (strRuleId) REFERENCES t_AlertRules (strRuleId) ON DELETE SET NULL"},
+	{"fk_TargetGroupMembers_Group", "t_TargetGroupMembers", "FOREIGN KEY (strGroupId) REFERENCES t_TargetGroups (strGroupId) ON DELETE CASCADE"},
+	{"fk_TargetGroupMembers_Target", "t_TargetGroupMembers", "FOREIGN KEY (strTargetId) REFERENCES t_Targets (strTargetId) ON DELETE CASCADE"},
+	{"fk_SlaveGroupMembers_Group", "t_SlaveGroupMembers", "FOREIGN KEY (strGroupId) REFERENCES t_SlaveGroups (strGroupId) ON DELETE CASCADE"},
+	{"fk_SlaveGroupMembers_Slave", "t_SlaveGroupMembers", "FOREIGN KEY (strSlaveId) REFERENCES t_Slaves (strSlaveId) ON DELETE CASCADE"},
+	{"fk_SlaveGroupTargetGroups_SlaveGroup", "t_SlaveGroupTargetGroups", "FOREIGN KEY (strSlaveGroupId) REFERENCES t_SlaveGroups (strGroupId) ON DELETE CASCADE"},
+	{"fk_SlaveGroupTargetGroups_TargetGroup", "t_SlaveGroupTargetGroups", "FOREIGN KEY (strTargetGroupId) REFERENCES t_TargetGroups (strGroupId) ON DELETE CASCADE"},
+	{"fk_HopAggregates_Slave", "t_HopAggregates", "FOREIGN KEY (strSlaveId) REFERENCES t_Slaves (strSlaveId) ON DELETE CASCADE"},
+	{"fk_HopAggregates_Target", "t_HopAggregates", "FOREIGN KEY (strTargetId) REFERENCES t_Targets (strTargetId) ON DELETE CASCADE"},
+	{"fk_PathHops_Path", "t_PathHops", "FOREIGN KEY (strPathId) REFERENCES t_Paths (strPathId) ON DELETE CASCADE"},
+	{"fk_PathSightings_Slave", "t_PathSightings", "FOREIGN KEY (strSlaveId) REFERENCES t_Slaves (strSlaveId) ON DELETE CASCADE"},
+	{"fk_PathSightings_Target", "t_PathSightings", "FOREIGN KEY (strTargetId) REFERENCES t_Targets (strTargetId) ON DELETE CASCADE"},
+	{"fk_PathSightings_Path", "t_PathSightings", "FOREIGN KEY (strPathId) REFERENCES t_Paths (strPathId) ON DELETE CASCADE"},
+}
+
+// postgresForeignKeysUp adds the foreign keys and the missing indexes
+func postgresForeignKeysUp() []string {
+	cmds := append([]string{}, foreignKeyOrphanCleanup...)
+	for _, fk := range postgresForeignKeys {
+		cmds = append(cmds, "ALTER TABLE "+fk.Table+" ADD CONSTRAINT "+fk.Name+" "+fk.Definition)
+	}
+	return append(cmds, foreignKeyIndexes...)
+}
+
+// postgresForeignKeysDown drops the foreign keys and the added indexes
+func postgresForeignKeysDown() []string {
+	cmds := dropForeignKeyIndexes()
+	for i := len(postgresForeignKeys) - 1; i >= 0; i-- {
+		cmds = append(cmds, "ALTER TABLE "+postgresForeignKeys[i].Table+" DROP CONSTRAINT "+postgresForeignKeys[i].Name)
+	}
+	return cmds
+}
@@ -0,0 +1,728 @@
+package disttrace
+
+// sqliteMigrations returns the migrations of the schema of SQLite databases
+func sqliteMigrations() []Migration {
+
+	return []Migration{
+		{
+			Version: 1,
+			Name:    "schema info",
+			// versions were stored in t_SchemaInfo before migrations were recorded in t_SchemaMigrations
+			Up:   []string{},
+			Down: []string{},
+		},
+		{
+			Version: 2,
+			Name:    "initial schema",
+			Up: []string{
+				`CREATE TABLE IF NOT EXISTS t_Traceroutes (
+					strTracerouteId TEXT PRIMARY KEY,
+					strSlaveId TEXT NOT NULL,
+					strTargetId TEXT NOT NULL,
+					dtStart INTEGER NOT NULL,
+					strAnnotations TEXT
+				)`,
+
+				`CREATE TABLE IF NOT EXISTS t_Hops (
+					strHopId TEXT PRIMARY KEY, 
+					strTracerouteId INTEGER NOT NULL, 
+					nHopIndex INTEGER NOT NULL,
+					strHopIPAddress TEXT,
+					strHopDNSName TEXT, 
+					dDurationSec INTEGER,
+					strPreviousHopId INTEGER,
+					strAnnotations TEXT
+				)`,
+
+				`CREATE TABLE IF NOT EXISTS t_Targets (
+					strTargetId TEXT PRIMARY KEY,
+					strDescription TEXT UNIQUE, 
+					strDestination TEXT NOT NULL,
+					nRetries INTEGER NOT NULL,
+					nMaxHops INTEGER NOT NULL,
+					nTimeoutMSec INTEGER NOT NULL
+				)`,
+
+				`CREATE TABLE IF NOT EXISTS t_Slaves (
+					strSlaveId TEXT PRIMARY KEY, 
+					strSlaveName TEXT NOT NULL UNIQUE,
+					strSlaveSecret TEXT NOT NULL 
+				)`,
+
+				`CREATE TABLE IF NOT EXISTS t_MasterConfig (
+					strConfigId TEXT PRIMARY KEY, 
+					strReportURL TEXT NOT NULL
+				)`,
+
+				`CREATE TABLE IF NOT EXISTS t_Users (
+					strUserId TEXT PRIMARY KEY, 
+					strUserName TEXT NOT NULL UNIQUE,
+					strPassword TEXT NOT NULL,
+					nSalt INTEGER NOT NULL,
+					nPassNeedsChange INTEGER NOT NULL
+				)`,
+
+				// create admin:123 user
+				`INSERT INTO t_Users (strUserId, strUserName, strPassword, nSalt, nPassNeedsChange) 
+					VALUES ('998dd43d-86b1-44a3-8f28-d31cd2822927', 'admin', 
+					X'd68f3b8ca9aef9120b30823ea284c7bd001fc1beef4b3796fd609bc641031db8', 1298498081, 1); 
+				`,
+			},
+			Down: []string{
+				`DROP TABLE t_Users`,
+				`DROP TABLE t_MasterConfig`,
+				`DROP TABLE t_Slaves`,
+				`DROP TABLE t_Targets`,
+				`DROP TABLE t_Hops`,
+				`DROP TABLE t_Traceroutes`,
+			},
+		},
+		{
+			Version: 3,
+			Name:    "skipped version",
+			// version 2 used to record itself as version 3, kept to preserve the numbering
+			Up:   []string{},
+			Down: []string{},
+		},
+		{
+			Version: 4,
+			Name:    "alerting",
+			Up: []string{
+				`ALTER TABLE t_Traceroutes ADD COLUMN nSuccess INTEGER NOT NULL DEFAULT 1`,
+
+				`CREATE TABLE IF NOT EXISTS t_AlertRules (
+					strRuleId TEXT PRIMARY KEY,
+					strName TEXT NOT NULL UNIQUE,
+					strType TEXT NOT NULL,
+					strTargetId TEXT,
+					strSlaveId TEXT,
+					nThreshold INTEGER NOT NULL,
+					nDurationMin INTEGER NOT NULL,
+					strSeverity TEXT NOT NULL,
+					nEnabled INTEGER NOT NULL
+				)`,
+
+				`CREATE TABLE IF NOT EXISTS t_Alerts (
+					strAlertId TEXT PRIMARY KEY,
+					strRuleId TEXT,
+					strDedupKey TEXT NOT NULL,
+					strSeverity TEXT NOT NULL,
+					strSource TEXT NOT NULL,
+					strText TEXT NOT NULL,
+					strState TEXT NOT NULL,
+					dtFirstSeen DATETIME NOT NULL,
+					dtLastSeen DATETIME NOT NULL,
+					dtResolved DATETIME,
+					dtAcknowledged DATETIME,
+					strAcknowledgedBy TEXT,
+					nCount INTEGER NOT NULL
+				)`,
+
+				`CREATE INDEX IF NOT EXISTS idx_Alerts_DedupKey ON t_Alerts (strDedupKey, strState)`,
+			},
+			Down: append([]string{
+				`DROP INDEX idx_Alerts_DedupKey`,
+				`DROP TABLE t_Alerts`,
+				`DROP TABLE t_AlertRules`,
+			}, sqliteRebuildTable("t_Traceroutes", sqliteTraceroutesV2, "strTracerouteId, strSlaveId, strTargetId, dtStart, strAnnotations")...),
+		},
+		{
+			Version: 5,
+			Name:    "notification channels",
+			Up: []string{
+				`CREATE TABLE IF NOT EXISTS t_NotificationChannels (
+					strChannelId TEXT PRIMARY KEY,
+					strName TEXT NOT NULL UNIQUE,
+					strType TEXT NOT NULL,
+					strMinSeverity TEXT NOT NULL,
+					nEnabled INTEGER NOT NULL,
+					strSettings TEXT NOT NULL
+				)`,
+			},
+			Down: []string{
+				`DROP TABLE t_NotificationChannels`,
+			},
+		},
+		{
+			Version: 6,
+			Name:    "slave status",
+			Up: []string{
+				`CREATE TABLE IF NOT EXISTS t_SlaveStatus (
+					strSlaveId TEXT PRIMARY KEY,
+					strVersion TEXT NOT NULL,
+					nUptimeSec INTEGER NOT NULL,
+					nQueueDepth INTEGER NOT NULL,
+					strSourceIP TEXT NOT NULL,
+					dtLastConfigPoll DATETIME,
+					dtLastResult DATETIME
+				)`,
+
+				// alert on slaves that went silent by default
+				`INSERT INTO t_AlertRules (strRuleId, strName, strType, strTargetId, strSlaveId, nThreshold, nDurationMin, strSeverity, nEnabled)
+					VALUES ('4b0c6a56-3c1f-4f4e-9a59-8e2f2f0b7d1e', 'Slave silent', 'slaveSilent', NULL, NULL, 0, 5, 'warning', 1)
+				`,
+			},
+			Down: []string{
+				`DELETE FROM t_AlertRules WHERE strRuleId = '4b0c6a56-3c1f-4f4e-9a59-8e2f2f0b7d1e'`,
+				`DROP TABLE t_SlaveStatus`,
+			},
+		},
+		{
+			Version: 7,
+			Name:    "slave telemetry",
+			Up: []string{
+				`CREATE TABLE IF NOT EXISTS t_SlaveTelemetry (
+					strTelemetryId TEXT PRIMARY KEY,
+					strSlaveId TEXT NOT NULL,
+					dtReceived DATETIME NOT NULL,
+					dtSlaveTime DATETIME NOT NULL,
+					nQueueDepth INTEGER NOT NULL,
+					nDroppedResults INTEGER NOT NULL,
+					nMeasurements INTEGER NOT NULL,
+					dMeasurementAvgMs REAL NOT NULL,
+					dMeasurementMaxMs REAL NOT NULL,
+					strErrorCounts TEXT NOT NULL,
+					dClockOffsetMs REAL NOT NULL,
+					dLoad1 REAL NOT NULL,
+					dLoad5 REAL NOT NULL,
+					dLoad15 REAL NOT NULL
+				)`,
+
+				`CREATE INDEX IF NOT EXISTS idx_SlaveTelemetry_Slave ON t_SlaveTelemetry (strSlaveId, dtReceived)`,
+			},
+			Down: []string{
+				`DROP INDEX idx_SlaveTelemetry_Slave`,
+				`DROP TABLE t_SlaveTelemetry`,
+			},
+		},
+		{
+			Version: 8,
+			Name:    "target and slave groups",
+			Up: []string{
+				`CREATE TABLE IF NOT EXISTS t_TargetGroups (
+					strGroupId TEXT PRIMARY KEY,
+					strName TEXT NOT NULL UNIQUE,
+					nRetries INTEGER NOT NULL DEFAULT 0,
+					nMaxHops INTEGER NOT NULL DEFAULT 0,
+					nTimeoutMSec INTEGER NOT NULL DEFAULT 0
+				)`,
+
+				`CREATE TABLE IF NOT EXISTS t_TargetGroupMembers (
+					strGroupId TEXT NOT NULL,
+					strTargetId TEXT NOT NULL,
+					PRIMARY KEY (strGroupId, strTargetId)
+				)`,
+
+				`CREATE TABLE IF NOT EXISTS t_SlaveGroups (
+					strGroupId TEXT PRIMARY KEY,
+					strName TEXT NOT NULL UNIQUE
+				)`,
+
+				`CREATE TABLE IF NOT EXISTS t_SlaveGroupMembers (
+					strGroupId TEXT NOT NULL,
+					strSlaveId TEXT NOT NULL,
+					PRIMARY KEY (strGroupId, strSlaveId)
+				)`,
+
+				`CREATE TABLE IF NOT EXISTS t_SlaveGroupTargetGroups (
+					strSlaveGroupId TEXT NOT NULL,
+					strTargetGroupId TEXT NOT NULL,
+					PRIMARY KEY (strSlaveGroupId, strTargetGroupId)
+				)`,
+
+				`CREATE INDEX IF NOT EXISTS idx_TargetGroupMembers_Target ON t_TargetGroupMembers (strTargetId)`,
+				`CREATE INDEX IF NOT EXISTS idx_SlaveGroupMembers_Slave ON t_SlaveGroupMembers (strSlaveId)`,
+			},
+			Down: []string{
+				`DROP INDEX idx_SlaveGroupMembers_Slave`,
+				`DROP INDEX idx_TargetGroupMembers_Target`,
+				`DROP TABLE t_SlaveGroupTargetGroups`,
+				`DROP TABLE t_SlaveGroupMembers`,
+				`DROP TABLE t_SlaveGroups`,
+				`DROP TABLE t_TargetGroupMembers`,
+				`DROP TABLE t_TargetGroups`,
+			},
+		},
+		{
+			Version: 9,
+			Name:    "hop aggregates",
+			Up: []string{
+				`CREATE TABLE IF NOT EXISTS t_HopAggregates (
+					strSlaveId TEXT NOT NULL,
+					strTargetId TEXT NOT NULL,
+					strResolution TEXT NOT NULL,
+					dtBucket DATETIME NOT NULL,
+					nHopIndex INTEGER NOT NULL,
+					strHopIPAddress TEXT NOT NULL,
+					strPrevHopIPAddress TEXT NOT NULL,
+					nCount INTEGER NOT NULL,
+					dDurationSumSec REAL NOT NULL,
+					dDurationMinSec REAL NOT NULL,
+					dDurationMaxSec REAL NOT NULL,
+					PRIMARY KEY (strSlaveId, strTargetId, strResolution, dtBucket, nHopIndex, strHopIPAddress, strPrevHopIPAddress)
+				)`,
+
+				`CREATE INDEX IF NOT EXISTS idx_HopAggregates_Bucket ON t_HopAggregates (strResolution, dtBucket)`,
+			},
+			Down: []string{
+				`DROP INDEX idx_HopAggregates_Bucket`,
+				`DROP TABLE t_HopAggregates`,
+			},
+		},
+		{
+			Version: 10,
+			Name:    "normalized paths",
+			Up: []string{
+				// hops are converted into paths by convertLegacyHops after the upgrade
+				`ALTER TABLE t_Hops RENAME TO t_LegacyHops`,
+				`CREATE INDEX IF NOT EXISTS idx_LegacyHops_Traceroute ON t_LegacyHops (strTracerouteId)`,
+
+				`CREATE TABLE IF NOT EXISTS t_Paths (
+					strPathId TEXT PRIMARY KEY,
+					nHopCount INTEGER NOT NULL,
+					dtFirstSeen DATETIME NOT NULL
+				)`,
+
+				`CREATE TABLE IF NOT EXISTS t_PathHops (
+					strPathId TEXT NOT NULL,
+					nPosition INTEGER NOT NULL,
+					nHopIndex INTEGER NOT NULL,
+					strHopIPAddress TEXT NOT NULL,
+					strHopDNSName TEXT NOT NULL,
+					strPrevHopIPAddress TEXT NOT NULL,
+					PRIMARY KEY (strPathId, nPosition)
+				)`,
+
+				`CREATE TABLE IF NOT EXISTS t_PathSightings (
+					strSlaveId TEXT NOT NULL,
+					strTargetId TEXT NOT NULL,
+					strPathId TEXT NOT NULL,
+					dtFirstSeen DATETIME NOT NULL,
+					dtLastSeen DATETIME NOT NULL,
+					nCount INTEGER NOT NULL,
+					PRIMARY KEY (strTargetId, strSlaveId, strPathId)
+				)`,
+
+				`ALTER TABLE t_Traceroutes ADD COLUMN strPathId TEXT`,
+				`ALTER TABLE t_Traceroutes ADD COLUMN strHopRTTs TEXT`,
+				`ALTER TABLE t_Traceroutes ADD COLUMN strHopLoss TEXT`,
+			},
+			// hops are converted into paths by convertLegacyHops after the migration
+			Irreversible: true,
+		},
+		{
+			Version: 11,
+			Name:    "foreign keys and indexes",
+			Up:      sqliteForeignKeysUp(),
+			Down:    sqliteForeignKeysDown(),
+		},
+		{
+			Version: 12,
+			Name:    "archived slaves and targets",
+			Up: []string{
+				`ALTER TABLE t_Slaves ADD COLUMN dtArchived DATETIME`,
+				`ALTER TABLE t_Slaves ADD COLUMN dtPurgeRequested DATETIME`,
+				`ALTER TABLE t_Targets ADD COLUMN dtArchived DATETIME`,
+				`ALTER TABLE t_Targets ADD COLUMN dtPurgeRequested DATETIME`,
+			},
+			Down: append(
+				sqliteRebuildTable("t_Targets", sqliteTargetsV2, "strTargetId, strDescription, strDestination, nRetries, nMaxHops, nTimeoutMSec"),
+				sqliteRebuildTable("t_Slaves", sqliteSlavesV2, "strSlaveId, strSlaveName, strSlaveSecret")...,
+			),
+			// slaves and targets are referenced by most other tables
+			DisableForeignKeys: true,
+		},
+		{
+			Version: 13,
+			Name:    "auth signing keys and sessions",
+			Up: []string{
+				`CREATE TABLE IF NOT EXISTS t_AuthKeys (
+					strKeyId TEXT PRIMARY KEY,
+					strSecret TEXT NOT NULL,
+					dtCreated DATETIME NOT NULL,
+					dtRetired DATETIME
+				)`,
+
+				`CREATE TABLE IF NOT EXISTS t_RefreshTokens (
+					strTokenId TEXT PRIMARY KEY,
+					strUserId TEXT NOT NULL REFERENCES t_Users (strUserId) ON DELETE CASCADE,
+					strTokenHash TEXT NOT NULL,
+					dtCreated DATETIME NOT NULL,
+					dtExpires DATETIME NOT NULL
+				)`,
+
+				`CREATE TABLE IF NOT EXISTS t_RevokedTokens (
+					strTokenId TEXT PRIMARY KEY,
+					dtExpires DATETIME NOT NULL
+				)`,
+			},
+			Down: []string{
+				`DROP TABLE t_RevokedTokens`,
+				`DROP TABLE t_RefreshTokens`,
+				`DROP TABLE t_AuthKeys`,
+			},
+		},
+		{
+			Version: 14,
+			Name:    "user roles",
+			Up: []string{
+				// existing users keep access to everything
+				`ALTER TABLE t_Users ADD COLUMN strRole TEXT NOT NULL DEFAULT 'admin'`,
+
+				`CREATE TABLE IF NOT EXISTS t_UserTargetGroups (
+					strUserId TEXT NOT NULL REFERENCES t_Users (strUserId) ON DELETE CASCADE,
+					strGroupId TEXT NOT NULL REFERENCES t_TargetGroups (strGroupId) ON DELETE CASCADE,
+					PRIMARY KEY (strUserId, strGroupId)
+				)`,
+			},
+			Down: append(
+				[]string{`DROP TABLE t_UserTargetGroups`},
+				sqliteRebuildTable("t_Users", sqliteUsersV1, "strUserId, strUserName, strPassword, nSalt, nPassNeedsChange")...,
+			),
+			// sessions reference the users
+			DisableForeignKeys: true,
+		},
+		{
+			Version: 15,
+			Name:    "slave status backfill",
+			Up: []string{
+				// slaves with results from before the slave status was recorded aren't reported as never seen
+				`UPDATE t_SlaveStatus SET dtLastResult = (
+					SELECT MAX(dtStart) FROM t_Traceroutes WHERE t_Traceroutes.strSlaveId = t_SlaveStatus.strSlaveId
+				) WHERE dtLastResult IS NULL`,
+
+				`INSERT INTO t_SlaveStatus (strSlaveId, strVersion, nUptimeSec, nQueueDepth, strSourceIP, dtLastResult)
+					SELECT strSlaveId, '', 0, 0, '', MAX(dtStart) FROM t_Traceroutes
+					WHERE strSlaveId IN (SELECT strSlaveId FROM t_Slaves)
+						AND strSlaveId NOT IN (SELECT strSlaveId FROM t_SlaveStatus)
+					GROUP BY strSlaveId
+				`,
+			},
+			// the status is recorded again with the next contact of the slave
+			Down: []string{},
+		},
+		{
+			Version: 16,
+			Name:    "user role default",
+			Up:      sqliteRebuildTable("t_Users", sqliteUsersV3, "strUserId, strUserName, strPassword, nSalt, nPassNeedsChange, strRole"),
+			Down:    sqliteRebuildTable("t_Users", sqliteUsersV2, "strUserId, strUserName, strPassword, nSalt, nPassNeedsChange, strRole"),
+			// sessions and target groups reference the users
+			DisableForeignKeys: true,
+		},
+		{
+			Version: 17,
+			Name:    "hop autonomous systems",
+			Up: []string{
+				// hops of existing paths are annotated by UpdatePathHopASNs when the ASN database is loaded
+				`ALTER TABLE t_PathHops ADD COLUMN nASN INTEGER NOT NULL DEFAULT 0`,
+				`CREATE INDEX IF NOT EXISTS idx_PathHops_ASN ON t_PathHops (nASN, strPathId)`,
+			},
+			Down: append(
+				[]string{`DROP INDEX idx_PathHops_ASN`},
+				sqliteRebuildTable("t_PathHops", sqlitePathHopsV2, "strPathId, nPosition, nHopIndex, strHopIPAddress, strHopDNSName, strPrevHopIPAddress")...,
+			),
+		},
+	}
+}
+
+// sqliteTraceroutesV2 is the definition of t_Traceroutes before the success flag was added
+const sqliteTraceroutesV2 = `
+	strTracerouteId TEXT PRIMARY KEY,
+	strSlaveId TEXT NOT NULL,
+	strTargetId TEXT NOT NULL,
+	dtStart INTEGER NOT NULL,
+	strAnnotations TEXT
+`
+
+// sqliteTargetsV2 is the definition of t_Targets before the archived flag was added
+const sqliteTargetsV2 = `
+	strTargetId TEXT PRIMARY KEY,
+	strDescription TEXT UNIQUE,
+	strDestination TEXT NOT NULL,
+	nRetries INTEGER NOT NULL,
+	nMaxHops INTEGER NOT NULL,
+	nTimeoutMSec INTEGER NOT NULL
+`
+
+// sqliteSlavesV2 is the definition of t_Slaves before the archived flag was added
+const sqliteSlavesV2 = `
+	strSlaveId TEXT PRIMARY KEY,
+	strSlaveName TEXT NOT NULL UNIQUE,
+	strSlaveSecret TEXT NOT NULL
+`
+
+// sqliteUsersV1 is the definition of t_Users before roles were added
+const sqliteUsersV1 = `
+	strUserId TEXT PRIMARY KEY,
+	strUserName TEXT NOT NULL UNIQUE,
+	strPassword TEXT NOT NULL,
+	nSalt INTEGER NOT NULL,
+	nPassNeedsChange INTEGER NOT NULL
+`
+
+// sqliteUsersV2 is the definition of t_Users when roles were added
+const sqliteUsersV2 = `
+	strUserId TEXT PRIMARY KEY,
+	strUserName TEXT NOT NULL UNIQUE,
+	strPassword TEXT NOT NULL,
+	nSalt INTEGER NOT NULL,
+	nPassNeedsChange INTEGER NOT NULL,
+	strRole TEXT NOT NULL DEFAULT 'admin'
+`
+
+// sqliteUsersV3 is the definition of t_Users whose users get the least privileges if inserted without a role
+const sqliteUsersV3 = `
+	strUserId TEXT PRIMARY KEY,
+	strUserName TEXT NOT NULL UNIQUE,
+	strPassword TEXT NOT NULL,
+	nSalt INTEGER NOT NULL,
+	nPassNeedsChange INTEGER NOT NULL,
+	strRole TEXT NOT NULL DEFAULT 'viewer'
+`
+
+// sqlitePathHopsV2 is the definition of t_PathHops before the autonomous systems of the hops were added
+const sqlitePathHopsV2 = `
+	strPathId TEXT NOT NULL,
+	nPosition INTEGER NOT NULL,
+	nHopIndex INTEGER NOT NULL,
+	strHopIPAddress TEXT NOT NULL,
+	strHopDNSName TEXT NOT NULL,
+	strPrevHopIPAddress TEXT NOT NULL,
+	PRIMARY KEY (strPathId, nPosition),
+	FOREIGN KEY (strPathId) REFERENCES t_Paths (strPathId) ON DELETE CASCADE
+`
+
+// sqliteRebuildTable returns the statements to recreate a table with a new definition, SQLite can't change
+// the constraints or drop columns of existing tables. The given columns are copied, indexes are recreated.
+func sqliteRebuildTable(table string, definition string, columns string, indexes ...string) []string {
+	return append([]string{
+		"CREATE TABLE " + table + "_rebuild (" + definition + ")",
+		"INSERT INTO " + table + "_rebuild (" + columns + ") SELECT " + columns + " FROM " + table,
+		"DROP TABLE " + table,
+		"ALTER TABLE " + table + "_rebuild RENAME TO " + table,
+	}, indexes...)
+}
+
+// sqliteForeignKeyTable is a table which references other tables, defined as of schema version 10
+type sqliteForeignKeyTable struct {
+	Name        string
+	Columns     string
+	Definition  string
+	ForeignKeys string
+	Indexes     []string
+}
+
+// sqliteForeignKeyTables lists the tables which get foreign keys, referenced tables come first
+var sqliteForeignKeyTables = []sqliteForeignKeyTable{
+	{
+		Name:    "t_Traceroutes",
+		Columns: "strTracerouteId, strSlaveId, strTargetId, dtStart, strAnnotations, nSuccess, strPathId, strHopRTTs, strHopLoss",
+		Definition: `
+			strTracerouteId TEXT PRIMARY KEY,
+			strSlaveId TEXT NOT NULL,
+			strTargetId TEXT NOT NULL,
+			dtStart INTEGER NOT NULL,
+			strAnnotations TEXT,
+			nSuccess INTEGER NOT NULL DEFAULT 1,
+			strPathId TEXT,
+			strHopRTTs TEXT,
+			strHopLoss TEXT`,
+		ForeignKeys: `
+			FOREIGN KEY (strSlaveId) REFERENCES t_Slaves (strSlaveId) ON DELETE CASCADE,
+			FOREIGN KEY (strTargetId) REFERENCES t_Targets (strTargetId) ON DELETE CASCADE,
+			FOREIGN KEY (strPathId) REFERENCES t_Paths (strPathId)`,
+	},
+	{
+		Name:    "t_SlaveStatus",
+		Columns: "strSlaveId, strVersion, nUptimeSec, nQueueDepth, strSourceIP, dtLastConfigPoll, dtLastResult",
+		Definition: `
+			strSlaveId TEXT PRIMARY KEY,
+			strVersion TEXT NOT NULL,
+			nUptimeSec INTEGER NOT NULL,
+			nQueueDepth INTEGER NOT NULL,
+			strSourceIP TEXT NOT NULL,
+			dtLastConfigPoll DATETIME,
+			dtLastResult DATETIME`,
+		ForeignKeys: `
+			FOREIGN KEY (strSlaveId) REFERENCES t_Slaves (strSlaveId) ON DELETE CASCADE`,
+	},
+	{
+		Name: "t_SlaveTelemetry",
+		Columns: "strTelemetryId, strSlaveId, dtReceived, dtSlaveTime, nQueueDepth, nDroppedResults, nMeasurements, " +
+			"dMeasurementAvgMs, dMeasurementMaxMs, strErrorCounts, dClockOffsetMs, dLoad1, dLoad5, dLoad15",
+		Definition: `
+			strTelemetryId TEXT PRIMARY KEY,
+			strSlaveId TEXT NOT NULL,
+			dtReceived DATETIME NOT NULL,
+			dtSlaveTime DATETIME NOT NULL,
+			nQueueDepth INTEGER NOT NULL,
+			nDroppedResults INTEGER NOT NULL,
+			nMeasurements INTEGER NOT NULL,
+			dMeasurementAvgMs REAL NOT NULL,
+			dMeasurementMaxMs REAL NOT NULL,
+			strErrorCounts TEXT NOT NULL,
+			dClockOffsetMs REAL NOT NULL,
+			dLoad1 REAL NOT NULL,
+			dLoad5 REAL NOT NULL,
+			dLoad15 REAL NOT NULL`,
+		ForeignKeys: `
+			FOREIGN KEY (strSlaveId) REFERENCES t_Slaves (strSlaveId) ON DELETE CASCADE`,
+		Indexes: []string{
+			`CREATE INDEX IF NOT EXISTS idx_SlaveTelemetry_Slave ON t_SlaveTelemetry (strSlaveId, dtReceived)`,
+		},
+	},
+	{
+		Name:    "t_AlertRules",
+		Columns: "strRuleId, strName, strType, strTargetId, strSlaveId, nThreshold, nDurationMin, strSeverity, nEnabled",
+		Definition: `
+			strRuleId TEXT PRIMARY KEY,
+			strName TEXT NOT NULL UNIQUE,
+			strType TEXT NOT NULL,
+			strTargetId TEXT,
+			strSlaveId TEXT,
+			nThreshold INTEGER NOT NULL,
+			nDurationMin INTEGER NOT NULL,
+			strSeverity TEXT NOT NULL,
+			nEnabled INTEGER NOT NULL`,
+		ForeignKeys: `
+			FOREIGN KEY (strTargetId) REFERENCES t_Targets (strTargetId) ON DELETE CASCADE,
+			FOREIGN KEY (strSlaveId) REFERENCES t_Slaves (strSlaveId) ON DELETE CASCADE`,
+	},
+	{
+		Name: "t_Alerts",
+		Columns: "strAlertId, strRuleId, strDedupKey, strSeverity, strSource, strText, strState, " +
+			"dtFirstSeen, dtLastSeen, dtResolved, dtAcknowledged, strAcknowledgedBy, nCount",
+		Definition: `
+			strAlertId TEXT PRIMARY KEY,
+			strRuleId TEXT,
+			strDedupKey TEXT NOT NULL,
+			strSeverity TEXT NOT NULL,
+			strSource TEXT NOT NULL,
+			strText TEXT NOT NULL,
+			strState TEXT NOT NULL,
+			dtFirstSeen DATETIME NOT NULL,
+			dtLastSeen DATETIME NOT NULL,
+			dtResolved DATETIME,
+			dtAcknowledged DATETIME,
+			strAcknowledgedBy TEXT,
+			nCount INTEGER NOT NULL`,
+		// alerts are kept when their rule is deleted
+		ForeignKeys: `
+			FOREIGN KEY (strRuleId) REFERENCES t_AlertRules (strRuleId) ON DELETE SET NULL`,
+		Indexes: []string{
+			`CREATE INDEX IF NOT EXISTS idx_Alerts_DedupKey ON t_Alerts (strDedupKey, strState)`,
+		},
+	},
+	{
+		Name:    "t_TargetGroupMembers",
+		Columns: "strGroupId, strTargetId",
+		Definition: `
+			strGroupId TEXT NOT NULL,
+			strTargetId TEXT NOT NULL,
+			PRIMARY KEY (strGroupId, strTargetId)`,
+		ForeignKeys: `
+			FOREIGN KEY (strGroupId) REFERENCES t_TargetGroups (strGroupId) ON DELETE CASCADE,
+			FOREIGN KEY (strTargetId) REFERENCES t_Targets (strTargetId) ON DELETE CASCADE`,
+		Indexes: []string{
+			`CREATE INDEX IF NOT EXISTS idx_TargetGroupMembers_Target ON t_TargetGroupMembers (strTargetId)`,
+		},
+	},
+	{
+		Name:    "t_SlaveGroupMembers",
+		Columns: "strGroupId, strSlaveId",
+		Definition: `
+			strGroupId TEXT NOT NULL,
+			strSlaveId TEXT NOT NULL,
+			PRIMARY KEY (strGroupId, strSlaveId)`,
+		ForeignKeys: `
+			FOREIGN KEY (strGroupId) REFERENCES t_SlaveGroups (strGroupId) ON DELETE CASCADE,
+			FOREIGN KEY (strSlaveId) REFERENCES t_Slaves (strSlaveId) ON DELETE CASCADE`,
+		Indexes: []string{
+			`CREATE INDEX IF NOT EXISTS idx_SlaveGroupMembers_Slave ON t_SlaveGroupMembers (strSlaveId)`,
+		},
+	},
+	{
+		Name:    "t_SlaveGroupTargetGroups",
+		Columns: "strSlaveGroupId, strTargetGroupId",
+		Definition: `
+			strSlaveGroupId TEXT NOT NULL,
+			strTargetGroupId TEXT NOT NULL,
+			PRIMARY KEY (strSlaveGroupId, strTargetGroupId)`,
+		ForeignKeys: `
+			FOREIGN KEY (strSlaveGroupId) REFERENCES t_SlaveGroups (strGroupId) ON DELETE CASCADE,
+			FOREIGN KEY (strTargetGroupId) REFERENCES t_TargetGroups (strGroupId) ON DELETE CASCADE`,
+	},
+	{
+		Name: "t_HopAggregates",
+		Columns: "strSlaveId, strTargetId, strResolution, dtBucket, nHopIndex, strHopIPAddress, strPrevHopIPAddress, " +
+			"nCount, dDurationSumSec, dDurationMinSec, dDurationMaxSec",
+		Definition: `
+			strSlaveId TEXT NOT NULL,
+			strTargetId TEXT NOT NULL,
+			strResolution TEXT NOT NULL,
+			dtBucket DATETIME NOT NULL,
+			nHopIndex INTEGER NOT NULL,
+			strHopIPAddress TEXT NOT NULL,
+			strPrevHopIPAddress TEXT NOT NULL,
+			nCount INTEGER NOT NULL,
+			dDurationSumSec REAL NOT NULL,
+			dDurationMinSec REAL NOT NULL,
+			dDurationMaxSec REAL NOT NULL,
+			PRIMARY KEY (strSlaveId, strTargetId, strResolution, dtBucket, nHopIndex, strHopIPAddress, strPrevHopIPAddress)`,
+		ForeignKeys: `
+			FOREIGN KEY (strSlaveId) REFERENCES t_Slaves (strSlaveId) ON DELETE CASCADE,
+			FOREIGN KEY (strTargetId) REFERENCES t_Targets (strTargetId) ON DELETE CASCADE`,
+		Indexes: []string{
+			`CREATE INDEX IF NOT EXISTS idx_HopAggregates_Bucket ON t_HopAggregates (strResolution, dtBucket)`,
+		},
+	},
+	{
+		Name:    "t_PathHops",
+		Columns: "strPathId, nPosition, nHopIndex, strHopIPAddress, strHopDNSName, strPrevHopIPAddress",
+		Definition: `
+			strPathId TEXT NOT NULL,
+			nPosition INTEGER NOT NULL,
+			nHopIndex INTEGER NOT NULL,
+			strHopIPAddress TEXT NOT NULL,
+			strHopDNSName TEXT NOT NULL,
+			strPrevHopIPAddress TEXT NOT NULL,
+			PRIMARY KEY (strPathId, nPosition)`,
+		ForeignKeys: `
+			FOREIGN KEY (strPathId) REFERENCES t_Paths (strPathId) ON DELETE CASCADE`,
+	},
+	{
+		Name:    "t_PathSightings",
+		Columns: "strSlaveId, strTargetId, strPathId, dtFirstSeen, dtLastSeen, nCount",
+		Definition: `
+			strSlaveId TEXT NOT NULL,
+			strTargetId TEXT NOT NULL,
+			strPathId TEXT NOT NULL,
+			dtFirstSeen DATETIME NOT NULL,
+			dtLastSeen DATETIME NOT NULL,
+			nCount INTEGER NOT NULL,
+			PRIMARY KEY (strTargetId, strSlaveId, strPathId)`,
+		ForeignKeys: `
+			FOREIGN KEY (strSlaveId) REFERENCES t_Slaves (strSlaveId) ON DELETE CASCADE,
+			FOREIGN KEY (strTargetId) REFERENCES t_Targets (strTargetId) ON DELETE CASCADE,
+			FOREIGN KEY (strPathId) REFERENCES t_Paths (strPathId) ON DELETE CASCADE`,
+	},
+}
+
+// sqliteForeignKeysUp rebuilds the referencing tables with foreign keys and adds the missing indexes
+func sqliteForeignKeysUp() []string {
+	cmds := append([]string{}, foreignKeyOrphanCleanup...)
+	for _, table := range sqliteForeignKeyTables {
+		cmds = append(cmds, sqliteRebuildTable(table.Name, table.Definition+","+table.ForeignKeys, table.Columns, table.Indexes...)...)
+	}
+	return append(cmds, foreignKeyIndexes...)
+}
+
+// sqliteForeignKeysDown rebuilds the referencing tables without foreign keys, referencing tables first
+func sqliteForeignKeysDown() []string {
+	cmds := dropForeignKeyIndexes()
+	for i := len(sqliteForeignKeyTables) - 1; i >= 0; i-- {
+		table := sqliteForeignKeyTables[i]
+		cmds = append(cmds, sqliteRebuildTable(table.Name, table.Definition, table.Columns, table.Indexes...)...)
+	}
+	return cmds
+}
@@ -1,196 +1,108 @@
 package disttrace
 
 import (
-	"database/sql"
 	"errors"
+	"strings"
 )
 
-// getSchemaVersion returns the current schema version of the database
-func (db *DB) getSchemaVersion() (int, error) {
+// maxDBVersion is the newest version of the database schema, the number of migrations of every dialect
+const maxDBVersion = 17
 
-	log.Debug("getSchemaVersion: Reading current schema version of database...")
-
-	// check if schema info table exists
-	var foundTable string
-
-	query := "SELECT tbl_name FROM sqlite_master WHERE type='table' AND tbl_name='t_SchemaInfo'"
-	err := db.QueryRow(query).Scan(&foundTable)
-	switch {
-	case err == sql.ErrNoRows:
-		log.Debug("getSchemaVersion: Schema info table doesn't exist, assuming emtpy database")
-		return 0, nil
+// InitDBConnectionAndUpdate initializes a connection to the database and upgrades the schema if needed
+func InitDBConnectionAndUpdate(dataSourceName string) (*DB, error) {
+	var db *DB
+	var err error
 
-	case err != nil:
-		log.Warn("getSchemaVersion: Error: Couldn't determine db version, Error: ", err)
-		return -1, errors.New("Couldn't determine db version")
+	log.Debug("InitDBConnectionAndUpdate: Opening db connection to: ", redactDataSourceName(dataSourceName))
 
-	case foundTable != "t_SchemaInfo":
-		// what happened?!
-		log.Warnf("getSchemaVersion: Received unexpected reply '%v' while looking for table 't_SchemaInfo', can't determine db schema version", foundTable)
-		return -1, errors.New("Couldn't determine db version")
+	if db, err = openDB(dataSourceName); err != nil {
+		log.Warn("InitDBConnectionAndUpdate: Error while opening database connection, Error: ", err)
+		return nil, errors.New("Error while opening database connection")
 	}
 
-	// read db version from schema info
-	var version int
+	if _, err := db.migrate(-1, false); err != nil {
+		log.Warn("InitDBConnectionAndUpdate: Error while migrating database schema, Error: ", err)
+		return nil, errors.New("Error while migrating database schema")
+	}
 
-	query = "SELECT nVersion FROM t_SchemaInfo"
-	if err := db.QueryRow(query).Scan(&version); err != nil {
-		log.Warn("getSchemaVersion: Error: Couldn't read db version from schemainfo, Error: ", err)
-		return -1, errors.New("Couldn't read db version from schemainfo")
+	if err := convertLegacyHops(db); err != nil {
+		log.Warn("InitDBConnectionAndUpdate: Error while converting hops of existing traceroutes, Error: ", err)
+		return nil, errors.New("Error while converting hops of existing traceroutes")
 	}
 
-	log.Debug("getSchemaVersion: Read dbversion from schema info: ", version)
-	return version, nil
+	log.Debug("InitDBConnectionAndUpdate: Successfully established database connection")
+	return db, nil
 }
 
-// createAndUpdateDbSchema checks if the database schema is at newest state and upgrades it if needed
-func (db *DB) createAndUpdateDbSchema() error {
-
-	var err error
-	log.Debug("createAndUpdateDbSchema: Checking if database schema needs upgrading...")
-
-	// define the schema info for all db versions
-	const maxDBVersion = 2
-	var schemaUpdate [maxDBVersion + 1][]string
-
-	schemaUpdate[0] = []string{}
-
-	schemaUpdate[1] = []string{
-		`CREATE TABLE IF NOT EXISTS t_SchemaInfo (
-			nVersion INTEGER PRIMARY KEY
-		)`,
-
-		"INSERT INTO t_SchemaInfo VALUES (1)",
-	}
-
-	schemaUpdate[2] = []string{
-		`CREATE TABLE IF NOT EXISTS t_Traceroutes (
-			strTracerouteId TEXT PRIMARY KEY,
-			strSlaveId TEXT NOT NULL,
-			strTargetId TEXT NOT NULL,
-			dtStart INTEGER NOT NULL,
-			strAnnotations TEXT
-		)`,
-
-		`CREATE TABLE IF NOT EXISTS t_Hops (
-			strHopId TEXT PRIMARY KEY, 
-			strTracerouteId INTEGER NOT NULL, 
-			nHopIndex INTEGER NOT NULL,
-			strHopIPAddress TEXT,
-			strHopDNSName TEXT, 
-			dDurationSec INTEGER,
-			strPreviousHopId INTEGER,
-			strAnnotations TEXT
-		)`,
-
-		`CREATE TABLE IF NOT EXISTS t_Targets (
-			strTargetId TEXT PRIMARY KEY,
-			strDescription TEXT UNIQUE, 
-			strDestination TEXT NOT NULL,
-			nRetries INTEGER NOT NULL,
-			nMaxHops INTEGER NOT NULL,
-			nTimeoutMSec INTEGER NOT NULL
-		)`,
-
-		`CREATE TABLE IF NOT EXISTS t_Slaves (
-			strSlaveId TEXT PRIMARY KEY, 
-			strSlaveName TEXT NOT NULL UNIQUE,
-			strSlaveSecret TEXT NOT NULL 
-		)`,
-
-		`CREATE TABLE IF NOT EXISTS t_MasterConfig (
-			strConfigId TEXT PRIMARY KEY, 
-			strReportURL TEXT NOT NULL
-		)`,
-
-		`CREATE TABLE IF NOT EXISTS t_Users (
-			strUserId TEXT PRIMARY KEY, 
-			strUserName TEXT NOT NULL UNIQUE,
-			strPassword TEXT NOT NULL,
-			nSalt INTEGER NOT NULL,
-			nPassNeedsChange INTEGER NOT NULL
-		)`,
-
-		// create admin:123 user
-		`INSERT INTO t_Users (strUserId, strUserName, strPassword, nSalt, nPassNeedsChange) 
-			VALUES ('998dd43d-86b1-44a3-8f28-d31cd2822927', 'admin', 
-			X'd68f3b8ca9aef9120b30823ea284c7bd001fc1beef4b3796fd609bc641031db8', 1298498081, 1); 
-		`,
-
-		`UPDATE t_SchemaInfo SET nVersion = 3`,
-	}
+// MigrateDatabase migrates the database schema to the given version and returns the executed steps. A version
+// of -1 migrates to the newest version, lower versions than the current one revert migrations. On a dry run
+// the steps are only returned, the database isn't changed.
+func MigrateDatabase(dataSourceName string, version int, dryRun bool) ([]MigrationStep, error) {
 
-	// get current DB schema version
-	var currentDBVersion int
-	if currentDBVersion, err = db.getSchemaVersion(); err != nil {
-		log.Warn("createAndUpdateDbSchema: Error: Couldn't get db version: ", err)
-		return errors.New("Couldn't get db version")
+	db, err := openDB(dataSourceName)
+	if err != nil {
+		log.Warn("MigrateDatabase: Error while opening database connection, Error: ", err)
+		return nil, errors.New("Error while opening database connection")
 	}
+	defer db.Close()
 
-	// check if schema needs upgrading
-	if currentDBVersion >= maxDBVersion {
-		log.Infof("createAndUpdateDbSchema: Schema is at version %v, no upgrade needed", currentDBVersion)
-		return nil
+	steps, err := db.migrate(version, dryRun)
+	if err != nil {
+		return nil, err
 	}
 
-	log.Warnf("createAndUpdateDbSchema: Database schema needs to be upgraded, current version: %v, upgrading to: %v", currentDBVersion, maxDBVersion)
-
-	// execute upgrade commands beginning at the next version
-	for _, cmds := range schemaUpdate[currentDBVersion+1:] {
-		currentDBVersion++
-		log.Infof("createAndUpdateDbSchema: Upgrading database schema to version: %v", currentDBVersion)
-		var tx *Tx
-		if tx, err = db.Begin(); err != nil {
-			log.Warn("createAndUpdateDbSchema: Couldn't start transaction, Error: ", err)
-			return errors.New("Couldn't start transaction")
-		}
-
-		// loop through upgrade comnands
-		count := 0
-		for _, cmd := range cmds {
-			count++
-			log.Debugf("createAndUpdateDbSchema: Executing command %v of %v...", count, len(cmds))
-			// exec Update
-			if _, err := tx.Exec(cmd); err != nil {
-				log.Warnf("createAndUpdateDbSchema: Error while executing query <%v>, Error: %v", cmd, err)
-				log.Warn("createAndUpdateDbSchema: Doing rollback of transaction...")
-				// error, rollback
-				if err := tx.Rollback(); err != nil {
-					log.Warn("createAndUpdateDbSchema: Couldn't rollback transaction, Error: ", err)
-					return errors.New("Couldn't rollback transaction")
-				}
-				log.Warn("createAndUpdateDbSchema: Rollback complete")
-				return errors.New("Error while executing query")
-			}
+	if !dryRun {
+		if err := convertLegacyHops(db); err != nil {
+			log.Warn("MigrateDatabase: Error while converting hops of existing traceroutes, Error: ", err)
+			return nil, errors.New("Error while converting hops of existing traceroutes")
 		}
-		if err := tx.Commit(); err != nil {
-			log.Warn("createAndUpdateDbSchema: Couldn't commit transaction, Error: ", err)
-			return errors.New("Couldn't commit transaction")
-		}
-		log.Debug("createAndUpdateDbSchema: Successfully upgraded database schema to version: ", currentDBVersion)
 	}
 
-	log.Info("createAndUpdateDbSchema: Finished upgrading the database schema. Now on version: ", currentDBVersion)
-	return nil
+	return steps, nil
 }
 
-// InitDBConnectionAndUpdate initializes a connection to the database and upgrades the schema if needed
-func InitDBConnectionAndUpdate(dataSourceName string) (*DB, error) {
-	var db *DB
-	var err error
-
-	log.Debug("InitDBConnectionAndUpdate: Opening db connection to: ", dataSourceName)
+// foreignKeyOrphanCleanup removes rows referencing deleted rows before foreign keys are added
+var foreignKeyOrphanCleanup = []string{
+	`DELETE FROM t_Traceroutes WHERE strSlaveId NOT IN (SELECT strSlaveId FROM t_Slaves)
+		OR strTargetId NOT IN (SELECT strTargetId FROM t_Targets)
+		OR (strPathId IS NOT NULL AND strPathId NOT IN (SELECT strPathId FROM t_Paths))`,
+	`DELETE FROM t_SlaveStatus WHERE strSlaveId NOT IN (SELECT strSlaveId FROM t_Slaves)`,
+	`DELETE FROM t_SlaveTelemetry WHERE strSlaveId NOT IN (SELECT strSlaveId FROM t_Slaves)`,
+	`DELETE FROM t_AlertRules WHERE strTargetId NOT IN (SELECT strTargetId FROM t_Targets)
+		OR strSlaveId NOT IN (SELECT strSlaveId FROM t_Slaves)`,
+	`UPDATE t_Alerts SET strRuleId = NULL WHERE strRuleId IS NOT NULL AND strRuleId NOT IN (SELECT strRuleId FROM t_AlertRules)`,
+	`DELETE FROM t_TargetGroupMembers WHERE strGroupId NOT IN (SELECT strGroupId FROM t_TargetGroups)
+		OR strTargetId NOT IN (SELECT strTargetId FROM t_Targets)`,
+	`DELETE FROM t_SlaveGroupMembers WHERE strGroupId NOT IN (SELECT strGroupId FROM t_SlaveGroups)
+		OR strSlaveId NOT IN (SELECT strSlaveId FROM t_Slaves)`,
+	`DELETE FROM t_SlaveGroupTargetGroups WHERE strSlaveGroupId NOT IN (SELECT strGroupId FROM t_SlaveGroups)
+		OR strTargetGroupId NOT IN (SELECT strGroupId FROM t_TargetGroups)`,
+	`DELETE FROM t_HopAggregates WHERE strSlaveId NOT IN (SELECT strSlaveId FROM t_Slaves)
+		OR strTargetId NOT IN (SELECT strTargetId FROM t_Targets)`,
+	`DELETE FROM t_PathHops WHERE strPathId NOT IN (SELECT strPathId FROM t_Paths)`,
+	`DELETE FROM t_PathSightings WHERE strSlaveId NOT IN (SELECT strSlaveId FROM t_Slaves)
+		OR strTargetId NOT IN (SELECT strTargetId FROM t_Targets) OR strPathId NOT IN (SELECT strPathId FROM t_Paths)`,
+}
 
-	if db, err = openDB(dataSourceName); err != nil {
-		log.Warn("InitDBConnectionAndUpdate: Error while opening database connection, Error: ", err)
-		return nil, errors.New("Error while opening database connection")
-	}
+// foreignKeyIndexes are the indexes for the queries on traceroutes and for cascading deletes
+var foreignKeyIndexes = []string{
+	`CREATE INDEX IF NOT EXISTS idx_Traceroutes_Target ON t_Traceroutes (strTargetId, strSlaveId, dtStart)`,
+	`CREATE INDEX IF NOT EXISTS idx_Traceroutes_Slave ON t_Traceroutes (strSlaveId)`,
+	`CREATE INDEX IF NOT EXISTS idx_Traceroutes_Start ON t_Traceroutes (dtStart)`,
+	`CREATE INDEX IF NOT EXISTS idx_Traceroutes_Path ON t_Traceroutes (strPathId)`,
+	`CREATE INDEX IF NOT EXISTS idx_Alerts_Rule ON t_Alerts (strRuleId)`,
+	`CREATE INDEX IF NOT EXISTS idx_SlaveGroupTargetGroups_TargetGroup ON t_SlaveGroupTargetGroups (strTargetGroupId)`,
+	`CREATE INDEX IF NOT EXISTS idx_HopAggregates_Target ON t_HopAggregates (strTargetId)`,
+	`CREATE INDEX IF NOT EXISTS idx_PathSightings_Slave ON t_PathSightings (strSlaveId)`,
+	`CREATE INDEX IF NOT EXISTS idx_PathSightings_Path ON t_PathSightings (strPathId)`,
+}
 
-	if err := db.createAndUpdateDbSchema(); err != nil {
-		log.Warn("InitDBConnectionAndUpdate: Error while checking database schema, Error: ", err)
-		return nil, errors.New("Error while checking database schema")
+// dropForeignKeyIndexes returns the statements to drop the foreignKeyIndexes
+func dropForeignKeyIndexes() []string {
+	drops := []string{}
+	for _, create := range foreignKeyIndexes {
+		name := strings.Fields(strings.TrimPrefix(create, "CREATE INDEX IF NOT EXISTS "))[0]
+		drops = append(drops, "DROP INDEX IF EXISTS "+name)
 	}
-
-	log.Debug("InitDBConnectionAndUpdate: Successfully established database connection")
-	return db, nil
+	return drops
 }
@@ -0,0 +1,346 @@
+package disttrace
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// types of live events
+const (
+	EventTraceroute  = "traceroute"
+	EventRouteChange = "routeChange"
+	EventAlert       = "alert"
+	EventSlaveStatus = "slaveStatus"
+)
+
+// ErrUnknownEventType is returned for subscriptions of event types which don't exist
+var ErrUnknownEventType = errors.New("Unknown event type")
+
+// ErrUnknownSlave is returned for subscriptions of slaves which don't exist
+var ErrUnknownSlave = errors.New("Unknown slave")
+
+// eventBufferSize is the number of events buffered per subscriber, slower subscribers are dropped
+const eventBufferSize = 256
+
+// Event is a change broadcast to the subscribers of the live stream, the type of Data depends on the event type:
+// Traceroute, RouteChange, Alert or SlaveStatusChange
+type Event struct {
+	ID   uint64
+	Type string
+	Time time.Time
+	Data interface{}
+
+	// the slave and target the event concerns, uuid.Nil if none
+	slaveID  uuid.UUID
+	targetID uuid.UUID
+}
+
+// EventFilter selects the events of a subscription, empty fields don't filter. Slaves and Targets hold IDs or names,
+// they only filter events which concern a slave or target, e.g. not application alerts.
+type EventFilter struct {
+	Types   []string
+	Slaves  []string
+	Targets []string
+	// Scope restricts the events to the targets a user may access
+	Scope TargetScope
+}
+
+// RouteChange is the data of routeChange events, a traceroute took another path than the previous one of the slave
+// to the target. The paths are given as IP addresses of their hops.
+type RouteChange struct {
+	Slave          TracerouteSlave
+	Target         TracerouteTarget
+	TracerouteID   uuid.UUID
+	PreviousPathID string
+	PathID         string
+	PreviousHops   []string
+	Hops           []string
+}
+
+// SlaveStatusChange is the data of slaveStatus events, a slave became reachable or silent or reported another version.
+// Activity is the config poll or result submission which changed the status, empty if the slave became silent.
+type SlaveStatusChange struct {
+	Slave    TracerouteSlave
+	Activity string
+	Changes  []string
+	Status   SlaveStatus
+}
+
+// EventSubscription receives the matching events on Events until it's closed. Events is closed if the subscriber
+// can't keep up, it has to subscribe again and reload its state.
+type EventSubscription struct {
+	Events <-chan Event
+
+	events   chan Event
+	types    map[string]bool
+	slaves   map[uuid.UUID]bool
+	targets  map[uuid.UUID]bool
+	scope    TargetScope
+	isClosed bool
+}
+
+// metrics of the live events
+var (
+	metricEventsPublished = NewMetricCounter("disttrace_events_published_total",
+		"Live events published to subscribers.", "type")
+	metricEventSubscribers = NewMetricGauge("disttrace_event_subscribers",
+		"Current subscribers of live events.")
+	metricEventSubscribersDropped = NewMetricCounter("disttrace_event_subscribers_dropped_total",
+		"Subscribers of live events dropped because they couldn't keep up.")
+)
+
+// subscribers of live events
+var eventHub = struct {
+	sync.Mutex
+	lastID      uint64
+	subscribers map[*EventSubscription]bool
+}{subscribers: make(map[*EventSubscription]bool)}
+
+// SubscribeEvents starts a subscription of the live events matching the filter, the names of slaves and targets are
+// resolved once. Returns ErrUnknownEventType, ErrUnknownSlave or ErrUnknownTarget for invalid filters.
+func SubscribeEvents(db *DB, filter EventFilter) (*EventSubscription, error) {
+
+	sub := &EventSubscription{
+		events:  make(chan Event, eventBufferSize),
+		types:   make(map[string]bool),
+		slaves:  make(map[uuid.UUID]bool),
+		targets: make(map[uuid.UUID]bool),
+		scope:   filter.Scope,
+	}
+	sub.Events = sub.events
+
+	for _, eventType := range filter.Types {
+		switch eventType {
+		case EventTraceroute, EventRouteChange, EventAlert, EventSlaveStatus:
+			sub.types[eventType] = true
+		default:
+			return nil, ErrUnknownEventType
+		}
+	}
+
+	resolve := func(query string, values []string, ids map[uuid.UUID]bool, errUnknown error) error {
+		for _, value := range values {
+			var id uuid.UUID
+			parsed, _ := uuid.Parse(value)
+			if err := db.QueryRow(query, parsed, value).Scan(&id); err == sql.ErrNoRows {
+				return errUnknown
+			} else if err != nil {
+				log.Warn("SubscribeEvents: Couldn't resolve subscription filter, Error: ", err)
+				return errors.New("Couldn't resolve subscription filter")
+			}
+			ids[id] = true
+		}
+		return nil
+	}
+	if err := resolve("SELECT strSlaveId FROM t_Slaves WHERE strSlaveId = ? OR strSlaveName = ?",
+		filter.Slaves, sub.slaves, ErrUnknownSlave); err != nil {
+		return nil, err
+	}
+	if err := resolve("SELECT strTargetId FROM t_Targets WHERE strTargetId = ? OR strDescription = ?",
+		filter.Targets, sub.targets, ErrUnknownTarget); err != nil {
+		return nil, err
+	}
+
+	eventHub.Lock()
+	eventHub.subscribers[sub] = true
+	metricEventSubscribers.Set(float64(len(eventHub.subscribers)))
+	eventHub.Unlock()
+
+	log.Debugf("SubscribeEvents: New subscription, filter: %+v", filter)
+	return sub, nil
+}
+
+// Close ends the subscription
+func (sub *EventSubscription) Close() {
+	eventHub.Lock()
+	defer eventHub.Unlock()
+	sub.close()
+}
+
+// close removes the subscription from the hub and closes its channel, the hub must be locked
+func (sub *EventSubscription) close() {
+	if sub.isClosed {
+		return
+	}
+	sub.isClosed = true
+	delete(eventHub.subscribers, sub)
+	close(sub.events)
+	metricEventSubscribers.Set(float64(len(eventHub.subscribers)))
+}
+
+// matches returns if the event passes the filter of the subscription
+func (sub *EventSubscription) matches(event Event) bool {
+	if len(sub.types) > 0 && !sub.types[event.Type] {
+		return false
+	}
+	if len(sub.slaves) > 0 && event.slaveID != uuid.Nil && !sub.slaves[event.slaveID] {
+		return false
+	}
+	if len(sub.targets) > 0 && event.targetID != uuid.Nil && !sub.targets[event.targetID] {
+		return false
+	}
+	if event.targetID != uuid.Nil && !sub.scope.Allows(event.targetID) {
+		return false
+	}
+	return true
+}
+
+// hasEventSubscribers returns if anybody listens to live events, the data of events needn't be collected otherwise
+func hasEventSubscribers() bool {
+	eventHub.Lock()
+	defer eventHub.Unlock()
+	return len(eventHub.subscribers) > 0
+}
+
+// publishEvent sends an event to all matching subscribers, never blocks. Subscribers whose buffer is full are dropped.
+func publishEvent(eventType string, slaveID uuid.UUID, targetID uuid.UUID, data interface{}) {
+
+	eventHub.Lock()
+	defer eventHub.Unlock()
+
+	if len(eventHub.subscribers) == 0 {
+		return
+	}
+
+	eventHub.lastID++
+	event := Event{ID: eventHub.lastID, Type: eventType, Time: time.Now().UTC(), Data: data, slaveID: slaveID, targetID: targetID}
+	metricEventsPublished.Inc(eventType)
+
+	for sub := range eventHub.subscribers {
+		if !sub.matches(event) {
+			continue
+		}
+		select {
+		case sub.events <- event:
+		default:
+			log.Warnf("publishEvent: Subscriber can't keep up, dropping it after %v buffered events", len(sub.events))
+			metricEventSubscribersDropped.Inc()
+			sub.close()
+		}
+	}
+}
+
+// publishAlertEvent sends the change of an alert, alerts of rules concern the slave and target in their dedup key
+func publishAlertEvent(alert Alert) {
+
+	var slaveID, targetID uuid.UUID
+	parts := strings.Split(alert.DedupKey, ":")
+	if len(parts) == 6 && parts[0] == "rule" && parts[2] == "slave" && parts[4] == "target" {
+		slaveID, _ = uuid.Parse(parts[3])
+		targetID, _ = uuid.Parse(parts[5])
+	}
+
+	publishEvent(EventAlert, slaveID, targetID, alert)
+}
+
+// previousTraceroutePath returns the path of the traceroute of the slave to the target before the result, if any
+func previousTraceroutePath(tx *Tx, result TraceResult) (sql.NullString, error) {
+
+	var pathID sql.NullString
+	query := `
+		SELECT strPathId FROM t_Traceroutes
+		WHERE strSlaveId = ? AND strTargetId = ? AND strPathId IS NOT NULL AND ` + tx.dialect.TimeExpr("dtStart") + ` < ` + tx.dialect.TimeExpr("?") + `
+		ORDER BY ` + tx.dialect.TimeExpr("dtStart") + ` DESC LIMIT 1
+		`
+	err := tx.QueryRow(query, result.Slave.ID, result.Target.ID, result.DateTime.UTC().Format(time.RFC3339)).Scan(&pathID)
+	if err != nil && err != sql.ErrNoRows {
+		log.Warn("previousTraceroutePath: Couldn't get previous path, Error: ", err)
+		return pathID, errors.New("Couldn't get previous path")
+	}
+	return pathID, nil
+}
+
+// tracerouteFromResult returns a stored result in the form of the traceroute API
+func tracerouteFromResult(traceID uuid.UUID, pathID string, result TraceResult, hops []PathHop, rtts []float64, loss []float64) Traceroute {
+
+	tr := Traceroute{
+		ID:        traceID,
+		StartTime: result.DateTime.UTC(),
+		Slave:     TracerouteSlave{ID: result.Slave.ID, Name: result.Slave.Name},
+		Target:    TracerouteTarget{ID: result.Target.ID, Name: result.Target.Name, Address: result.Target.Address},
+		Success:   result.Success,
+		HopCount:  len(hops),
+		PathID:    pathID,
+		Hops:      []TracerouteHop{},
+	}
+	for pos, hop := range hops {
+		rtt := rtts[pos] * 1000
+		tr.Hops = append(tr.Hops, TracerouteHop{Index: hop.Index, IPAddress: hop.IPAddress, DNSName: hop.DNSName,
+			RTTMs: &rtt, Loss: &loss[pos], AS: LookupASN(hop.IPAddress)})
+	}
+	return tr
+}
+
+// publishRouteChange sends the change of the path of a slave to a target
+func publishRouteChange(db *DB, tr Traceroute, previousPathID string) {
+
+	previousHops, err := getPathHops(db, previousPathID)
+	if err != nil {
+		return
+	}
+
+	change := RouteChange{
+		Slave:          tr.Slave,
+		Target:         tr.Target,
+		TracerouteID:   tr.ID,
+		PreviousPathID: previousPathID,
+		PathID:         tr.PathID,
+		PreviousHops:   []string{},
+		Hops:           []string{},
+	}
+	for _, hop := range previousHops {
+		change.PreviousHops = append(change.PreviousHops, hop.IPAddress)
+	}
+	for _, hop := range tr.Hops {
+		change.Hops = append(change.Hops, hop.IPAddress)
+	}
+
+	publishEvent(EventRouteChange, tr.Slave.ID, tr.Target.ID, change)
+}
+
+// publishSlaveStatus sends the changed status of a slave
+func publishSlaveStatus(db *DB, slaveID uuid.UUID, activity string, changes []string) {
+
+	slave, err := GetSlave(slaveID, db)
+	if err != nil || slave.Status == nil {
+		return
+	}
+
+	change := SlaveStatusChange{
+		Slave: TracerouteSlave{ID: slave.ID, Name: slave.Name}, Activity: activity, Changes: changes, Status: *slave.Status,
+	}
+	publishEvent(EventSlaveStatus, slave.ID, uuid.Nil, change)
+}
+
+// publishSilentSlaves sends the status of the slaves which became silent after the previous check, i.e. their last
+// contact was longer than the duration of their slaveSilent rule before now but not before previous
+func publishSilentSlaves(db *DB, previous time.Time, now time.Time) {
+
+	if !hasEventSubscribers() {
+		return
+	}
+
+	slaves, err := GetSlaves(db)
+	if err != nil {
+		return
+	}
+
+	rules := slaveSilentRules(db)
+	for _, slave := range slaves {
+		if slave.Status == nil || slave.Status.LastSeen == nil {
+			continue
+		}
+		silentSince := slave.Status.LastSeen.Add(slaveSilentAfter(rules, slave.ID))
+		if silentSince.After(previous) && !silentSince.After(now) {
+			change := SlaveStatusChange{
+				Slave: TracerouteSlave{ID: slave.ID, Name: slave.Name}, Changes: []string{SlaveChangeSilent}, Status: *slave.Status,
+			}
+			publishEvent(EventSlaveStatus, slave.ID, uuid.Nil, change)
+		}
+	}
+}
@@ -0,0 +1,359 @@
+package disttrace
+
+import (
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// formats of graph exports
+const (
+	GraphExportDOT     = "dot"
+	GraphExportGraphML = "graphml"
+	GraphExportJGF     = "jgf"
+)
+
+// ErrUnknownGraphFormat is returned for graph exports in formats which aren't supported
+var ErrUnknownGraphFormat = errors.New("Unknown graph format")
+
+// graphAttribute is an attribute of the graph, its nodes or edges in an export, kind is the GraphML type of its values
+type graphAttribute struct {
+	name string
+	kind string
+}
+
+// attributes of exported graphs, values which aren't known are left out
+var (
+	graphAttributes = []graphAttribute{
+		{"target", "string"}, {"targetId", "string"}, {"address", "string"}, {"start", "string"}, {"end", "string"},
+	}
+	graphNodeAttributes = []graphAttribute{
+		{"label", "string"}, {"type", "string"}, {"ip", "string"}, {"dns", "string"},
+		{"asn", "long"}, {"asName", "string"}, {"country", "string"}, {"count", "long"}, {"slaves", "long"},
+	}
+	graphEdgeAttributes = []graphAttribute{
+		{"hopIndex", "long"}, {"count", "long"},
+		{"rttMinMs", "double"}, {"rttAvgMs", "double"}, {"rttP50Ms", "double"}, {"rttP90Ms", "double"},
+		{"rttP99Ms", "double"}, {"rttMaxMs", "double"}, {"loss", "double"},
+		{"firstSeen", "string"}, {"lastSeen", "string"}, {"slaves", "long"},
+	}
+)
+
+// GraphExportContentType returns the media type of a graph export format
+func GraphExportContentType(format string) string {
+	switch format {
+	case GraphExportDOT:
+		return "text/vnd.graphviz"
+	case GraphExportGraphML:
+		return "application/graphml+xml"
+	case GraphExportJGF:
+		return "application/vnd.jgf+json"
+	}
+	return "application/octet-stream"
+}
+
+// ExportGraph writes a graph in one of the formats GraphExportDOT (Graphviz), GraphExportGraphML or GraphExportJGF
+// (JSON Graph Format v2). Nodes carry their IP address, DNS name, AS and the country of the AS if an ASN database
+// is loaded, edges their count, RTTs and loss.
+func ExportGraph(w io.Writer, graph Graph, format string) error {
+
+	var err error
+	switch format {
+	case GraphExportDOT:
+		err = exportGraphDOT(w, graph)
+	case GraphExportGraphML:
+		err = exportGraphML(w, graph)
+	case GraphExportJGF:
+		err = exportGraphJGF(w, graph)
+	default:
+		return ErrUnknownGraphFormat
+	}
+
+	if err != nil {
+		log.Warn("ExportGraph: Couldn't write graph, Error: ", err)
+		return errors.New("Couldn't write graph")
+	}
+	return nil
+}
+
+// graphValues returns the attributes of the graph
+func graphValues(graph Graph) map[string]interface{} {
+	values := map[string]interface{}{
+		"target":   graph.Target.Name,
+		"targetId": graph.Target.ID.String(),
+		"address":  graph.Target.Address,
+	}
+	if !graph.Start.IsZero() {
+		values["start"] = graph.Start.UTC().Format(time.RFC3339)
+		values["end"] = graph.End.UTC().Format(time.RFC3339)
+	}
+	return values
+}
+
+// graphNodeValues returns the attributes of a node, hops are labeled by their DNS name if they have one
+func graphNodeValues(node GraphNode) map[string]interface{} {
+	values := map[string]interface{}{
+		"type":   node.Type,
+		"count":  node.Count,
+		"slaves": int64(len(node.Slaves)),
+	}
+
+	if node.Type == GraphNodeSlave {
+		values["label"] = node.Name
+		return values
+	}
+
+	values["label"] = node.IPAddress
+	values["ip"] = node.IPAddress
+	if node.DNSName != "" {
+		values["label"] = node.DNSName
+		values["dns"] = node.DNSName
+	}
+	if node.AS != nil {
+		values["asn"] = int64(node.AS.Number)
+		if node.AS.Description != "" {
+			values["asName"] = node.AS.Description
+		}
+		if node.AS.Country != "" {
+			values["country"] = node.AS.Country
+		}
+	}
+	return values
+}
+
+// graphEdgeValues returns the attributes of an edge, RTTs are rounded to µs
+func graphEdgeValues(edge GraphEdge) map[string]interface{} {
+	round := func(value float64) float64 {
+		return math.Round(value*1000) / 1000
+	}
+
+	values := map[string]interface{}{
+		"hopIndex":  int64(edge.HopIndex),
+		"count":     edge.Count,
+		"rttMinMs":  round(edge.RTTMs.Min),
+		"rttAvgMs":  round(edge.RTTMs.Avg),
+		"rttP50Ms":  round(edge.RTTMs.P50),
+		"rttP90Ms":  round(edge.RTTMs.P90),
+		"rttP99Ms":  round(edge.RTTMs.P99),
+		"rttMaxMs":  round(edge.RTTMs.Max),
+		"firstSeen": edge.FirstSeen.UTC().Format(time.RFC3339),
+		"lastSeen":  edge.LastSeen.UTC().Format(time.RFC3339),
+		"slaves":    int64(len(edge.Slaves)),
+	}
+	if edge.Loss != nil {
+		values["loss"] = round(*edge.Loss)
+	}
+	return values
+}
+
+// formatGraphValue returns the text of an attribute value
+func formatGraphValue(value interface{}) string {
+	switch value := value.(type) {
+	case int64:
+		return strconv.FormatInt(value, 10)
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	}
+	return fmt.Sprint(value)
+}
+
+// exportGraphDOT writes a graph as Graphviz digraph, hops are labeled with their DNS name and IP address and edges
+// with their average RTT
+func exportGraphDOT(w io.Writer, graph Graph) error {
+
+	quote := func(text string) string {
+		return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(text) + `"`
+	}
+	attributes := func(schema []graphAttribute, values map[string]interface{}) string {
+		list := []string{}
+		for _, attribute := range schema {
+			value, exists := values[attribute.name]
+			if !exists {
+				continue
+			}
+			if attribute.kind == "string" {
+				list = append(list, attribute.name+"="+quote(value.(string)))
+			} else {
+				list = append(list, attribute.name+"="+formatGraphValue(value))
+			}
+		}
+		return strings.Join(list, ", ")
+	}
+
+	out := bufio.NewWriter(w)
+	fmt.Fprintf(out, "digraph %v {\n", quote(graph.Target.Name))
+	fmt.Fprintf(out, "\tgraph [rankdir=LR, %v];\n", attributes(graphAttributes, graphValues(graph)))
+	fmt.Fprintf(out, "\tnode [shape=box];\n")
+
+	for _, node := range graph.Nodes {
+		values := graphNodeValues(node)
+		if node.Type == GraphNodeSlave {
+			fmt.Fprintf(out, "\t%v [%v, shape=ellipse];\n", quote(node.ID), attributes(graphNodeAttributes, values))
+			continue
+		}
+		if node.DNSName != "" {
+			values["label"] = node.DNSName + "\n" + node.IPAddress
+		}
+		fmt.Fprintf(out, "\t%v [%v];\n", quote(node.ID), attributes(graphNodeAttributes, values))
+	}
+
+	for _, edge := range graph.Edges {
+		label := strconv.FormatFloat(edge.RTTMs.Avg, 'f', 1, 64) + " ms"
+		fmt.Fprintf(out, "\t%v -> %v [label=%v, %v];\n", quote(edge.Source), quote(edge.Target), quote(label),
+			attributes(graphEdgeAttributes, graphEdgeValues(edge)))
+	}
+
+	fmt.Fprintf(out, "}\n")
+	return out.Flush()
+}
+
+// GraphML document, see http://graphml.graphdrawing.org
+type graphMLDocument struct {
+	XMLName xml.Name     `xml:"graphml"`
+	XMLNS   string       `xml:"xmlns,attr"`
+	Keys    []graphMLKey `xml:"key"`
+	Graph   graphMLGraph `xml:"graph"`
+}
+
+type graphMLKey struct {
+	ID   string `xml:"id,attr"`
+	For  string `xml:"for,attr"`
+	Name string `xml:"attr.name,attr"`
+	Type string `xml:"attr.type,attr"`
+}
+
+type graphMLGraph struct {
+	ID          string        `xml:"id,attr"`
+	EdgeDefault string        `xml:"edgedefault,attr"`
+	Data        []graphMLData `xml:"data"`
+	Nodes       []graphMLNode `xml:"node"`
+	Edges       []graphMLEdge `xml:"edge"`
+}
+
+type graphMLNode struct {
+	ID   string        `xml:"id,attr"`
+	Data []graphMLData `xml:"data"`
+}
+
+type graphMLEdge struct {
+	ID     string        `xml:"id,attr"`
+	Source string        `xml:"source,attr"`
+	Target string        `xml:"target,attr"`
+	Data   []graphMLData `xml:"data"`
+}
+
+type graphMLData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+// exportGraphML writes a graph as GraphML, the keys of the attributes are prefixed with the element they belong to
+func exportGraphML(w io.Writer, graph Graph) error {
+
+	doc := graphMLDocument{XMLNS: "http://graphml.graphdrawing.org/xmlns", Keys: []graphMLKey{}}
+	prefixes := map[string]string{"graph": "g_", "node": "n_", "edge": "e_"}
+	for _, element := range []struct {
+		name   string
+		schema []graphAttribute
+	}{{"graph", graphAttributes}, {"node", graphNodeAttributes}, {"edge", graphEdgeAttributes}} {
+		for _, attribute := range element.schema {
+			doc.Keys = append(doc.Keys, graphMLKey{ID: prefixes[element.name] + attribute.name, For: element.name,
+				Name: attribute.name, Type: attribute.kind})
+		}
+	}
+
+	data := func(prefix string, schema []graphAttribute, values map[string]interface{}) []graphMLData {
+		list := []graphMLData{}
+		for _, attribute := range schema {
+			if value, exists := values[attribute.name]; exists {
+				list = append(list, graphMLData{Key: prefix + attribute.name, Value: formatGraphValue(value)})
+			}
+		}
+		return list
+	}
+
+	doc.Graph = graphMLGraph{ID: graph.Target.ID.String(), EdgeDefault: "directed",
+		Data: data(prefixes["graph"], graphAttributes, graphValues(graph))}
+	for _, node := range graph.Nodes {
+		doc.Graph.Nodes = append(doc.Graph.Nodes, graphMLNode{ID: node.ID,
+			Data: data(prefixes["node"], graphNodeAttributes, graphNodeValues(node))})
+	}
+	for i, edge := range graph.Edges {
+		doc.Graph.Edges = append(doc.Graph.Edges, graphMLEdge{ID: "e" + strconv.Itoa(i), Source: edge.Source, Target: edge.Target,
+			Data: data(prefixes["edge"], graphEdgeAttributes, graphEdgeValues(edge))})
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// JSON Graph Format v2 document, see https://jsongraphformat.info
+type jgfDocument struct {
+	Graph jgfGraph `json:"graph"`
+}
+
+type jgfGraph struct {
+	ID       string                 `json:"id"`
+	Label    string                 `json:"label"`
+	Directed bool                   `json:"directed"`
+	Type     string                 `json:"type"`
+	Metadata map[string]interface{} `json:"metadata"`
+	Nodes    map[string]jgfNode     `json:"nodes"`
+	Edges    []jgfEdge              `json:"edges"`
+}
+
+type jgfNode struct {
+	Label    string                 `json:"label"`
+	Metadata map[string]interface{} `json:"metadata"`
+}
+
+type jgfEdge struct {
+	Source   string                 `json:"source"`
+	Target   string                 `json:"target"`
+	Relation string                 `json:"relation"`
+	Metadata map[string]interface{} `json:"metadata"`
+}
+
+// exportGraphJGF writes a graph in the JSON Graph Format, the attributes are the metadata of the elements
+func exportGraphJGF(w io.Writer, graph Graph) error {
+
+	doc := jgfDocument{Graph: jgfGraph{
+		ID:       graph.Target.ID.String(),
+		Label:    graph.Target.Name,
+		Directed: true,
+		Type:     "dist-traceroute",
+		Metadata: graphValues(graph),
+		Nodes:    make(map[string]jgfNode),
+		Edges:    []jgfEdge{},
+	}}
+
+	for _, node := range graph.Nodes {
+		values := graphNodeValues(node)
+		label := values["label"].(string)
+		delete(values, "label")
+		doc.Graph.Nodes[node.ID] = jgfNode{Label: label, Metadata: values}
+	}
+	for _, edge := range graph.Edges {
+		doc.Graph.Edges = append(doc.Graph.Edges, jgfEdge{Source: edge.Source, Target: edge.Target, Relation: "hop",
+			Metadata: graphEdgeValues(edge)})
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(doc)
+}
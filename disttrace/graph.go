@@ -0,0 +1,409 @@
+package disttrace
+
+import (
+	"database/sql"
+	"errors"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrUnknownTarget is returned for graphs of targets which don't exist
+var ErrUnknownTarget = errors.New("Unknown target")
+
+// types of graph nodes
+const (
+	GraphNodeSlave = "slave"
+	GraphNodeHop   = "hop"
+)
+
+// GraphFilter selects the traceroutes aggregated by QueryGraph. Target is the ID or name of the target, Slaves
+// hold IDs or names of the slaves whose paths are combined, all slaves if empty. Unset times don't limit the period.
+type GraphFilter struct {
+	Target   string
+	Slaves   []string
+	From     time.Time
+	To       time.Time
+	Skip     int
+	MinCount int64
+}
+
+// Graph holds the links between hops seen by one or more slaves on their way to a target
+type Graph struct {
+	Target TracerouteTarget
+	// Start and End are the period covered by the data, aggregated data is dated to the start of its hour or day
+	Start time.Time
+	End   time.Time
+	Nodes []GraphNode
+	Edges []GraphEdge
+}
+
+// GraphNode is a slave where paths start or a hop on a path, hops are identified by their IP address and slaves
+// by 'slave:' and their ID. Count is how often a hop was passed, for slaves the number of paths starting at them.
+type GraphNode struct {
+	ID        string
+	Type      string
+	Name      string   `json:",omitempty"`
+	IPAddress string   `json:",omitempty"`
+	DNSName   string   `json:",omitempty"`
+	AS        *ASNInfo `json:",omitempty"`
+	Count     int64
+	Slaves    []uuid.UUID
+}
+
+// GraphEdge is a link between two nodes, HopIndex is the lowest index its destination was seen at. Loss is the share
+// of probes to the destination which were lost, it's missing if the link is only known from aggregated data.
+type GraphEdge struct {
+	Source    string
+	Target    string
+	HopIndex  int
+	Count     int64
+	FirstSeen time.Time
+	LastSeen  time.Time
+	RTTMs     GraphRTT
+	Loss      *float64 `json:",omitempty"`
+	Slaves    []GraphEdgeSlave
+}
+
+// GraphEdgeSlave holds how often a slave has seen a link
+type GraphEdgeSlave struct {
+	ID    uuid.UUID
+	Count int64
+}
+
+// GraphRTT summarizes the RTTs to the destination of a link in ms. Percentiles of aggregated data are approximated
+// by the average of its hour or day.
+type GraphRTT struct {
+	Min float64
+	Avg float64
+	P50 float64
+	P90 float64
+	P99 float64
+	Max float64
+}
+
+// graphSample is a RTT in seconds observed count times
+type graphSample struct {
+	value float64
+	count int64
+}
+
+// graphLink collects the observations of a link, loss is only recorded for raw data
+type graphLink struct {
+	edge      GraphEdge
+	agg       hopAggregate
+	samples   []graphSample
+	lossSum   float64
+	lossCount int64
+	slaves    map[uuid.UUID]int64
+}
+
+// graphSlaveNodeID returns the ID of the node of a slave
+func graphSlaveNodeID(slaveID uuid.UUID) string {
+	return "slave:" + slaveID.String()
+}
+
+// QueryGraph combines the paths of the selected slaves to a target in the given period into a graph of the hops,
+// skipping the first hops and all links seen less than MinCount times. Raw data is combined with the hourly and daily
+// aggregates of older data.
+func QueryGraph(db *DB, filter GraphFilter) (Graph, error) {
+
+	log.Debugf("QueryGraph: fetching graph, filter: %+v", filter)
+	graph := Graph{Nodes: []GraphNode{}, Edges: []GraphEdge{}}
+
+	// the target is given by ID or name, targets are named by their description
+	targetID, _ := uuid.Parse(filter.Target)
+	row := db.QueryRow("SELECT strTargetId, strDescription, strDestination FROM t_Targets WHERE strTargetId = ? OR strDescription = ?",
+		targetID, filter.Target)
+	if err := row.Scan(&graph.Target.ID, &graph.Target.Name, &graph.Target.Address); err == sql.ErrNoRows {
+		return graph, ErrUnknownTarget
+	} else if err != nil {
+		log.Warn("QueryGraph: Couldn't get target from DB, Error: ", err)
+		return graph, errors.New("Couldn't get target from DB")
+	}
+
+	// conditions on the slave and the time column shared by the aggregates and the raw data
+	conditions := func(timeColumn string) (string, []interface{}) {
+		where := []string{}
+		args := []interface{}{graph.Target.ID}
+
+		alternatives := []string{}
+		for _, value := range filter.Slaves {
+			if id, err := uuid.Parse(value); err == nil {
+				alternatives = append(alternatives, "s.strSlaveId = ?")
+				args = append(args, id)
+			} else {
+				alternatives = append(alternatives, "s.strSlaveName = ?")
+				args = append(args, value)
+			}
+		}
+		if len(alternatives) > 0 {
+			where = append(where, "("+strings.Join(alternatives, " OR ")+")")
+		}
+
+		if !filter.From.IsZero() {
+			where = append(where, db.Dialect.TimeExpr(timeColumn)+" >= "+db.Dialect.TimeExpr("?"))
+			args = append(args, filter.From.UTC().Format(time.RFC3339))
+		}
+		if !filter.To.IsZero() {
+			where = append(where, db.Dialect.TimeExpr(timeColumn)+" < "+db.Dialect.TimeExpr("?"))
+			args = append(args, filter.To.UTC().Format(time.RFC3339))
+		}
+
+		if len(where) == 0 {
+			return "", args
+		}
+		return " AND " + strings.Join(where, " AND "), args
+	}
+
+	type linkKey struct {
+		Source string
+		Target string
+	}
+	links := make(map[linkKey]*graphLink)
+	slaveNames := make(map[uuid.UUID]string)
+	dnsNames := make(map[string]string)
+
+	// addLink merges the observations of a link by a slave into the graph and returns the link. The first hop after
+	// the skipped ones is linked to the slave.
+	addLink := func(slaveID uuid.UUID, hopIndex int, prevIPAddress string, ipAddress string, agg hopAggregate, samples []graphSample, first time.Time, last time.Time) *graphLink {
+		source := prevIPAddress
+		if prevIPAddress == "0" || hopIndex <= filter.Skip+1 {
+			source = graphSlaveNodeID(slaveID)
+		}
+
+		key := linkKey{Source: source, Target: ipAddress}
+		link, exists := links[key]
+		if !exists {
+			link = &graphLink{edge: GraphEdge{Source: source, Target: ipAddress, HopIndex: hopIndex, FirstSeen: first, LastSeen: last},
+				slaves: make(map[uuid.UUID]int64)}
+			links[key] = link
+		}
+
+		if hopIndex < link.edge.HopIndex {
+			link.edge.HopIndex = hopIndex
+		}
+		if first.Before(link.edge.FirstSeen) {
+			link.edge.FirstSeen = first
+		}
+		if last.After(link.edge.LastSeen) {
+			link.edge.LastSeen = last
+		}
+		link.agg.add(agg)
+		link.samples = append(link.samples, samples...)
+		link.slaves[slaveID] += agg.Count
+		return link
+	}
+
+	where, args := conditions("a.dtBucket")
+	aggregateQuery := `
+		SELECT a.strSlaveId, s.strSlaveName, a.nHopIndex, a.strPrevHopIPAddress, a.strHopIPAddress,
+			a.nCount, a.dDurationSumSec, a.dDurationMinSec, a.dDurationMaxSec, a.dtBucket
+		FROM t_HopAggregates a
+		JOIN t_Slaves s ON a.strSlaveId = s.strSlaveId
+		WHERE a.strTargetId = ?` + where
+
+	rows, err := db.Query(aggregateQuery, args...)
+	if err != nil {
+		log.Warn("QueryGraph: Error while getting aggregated graph data, Error: ", err)
+		return graph, errors.New("Error while getting graph data")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var slaveID uuid.UUID
+		var slaveName, prevIPAddress, ipAddress string
+		var hopIndex int
+		var agg hopAggregate
+		var bucket dbTime
+		if err := rows.Scan(&slaveID, &slaveName, &hopIndex, &prevIPAddress, &ipAddress,
+			&agg.Count, &agg.DurationSumSec, &agg.DurationMinSec, &agg.DurationMaxSec, &bucket); err != nil {
+			log.Warn("QueryGraph: Error while reading aggregated graph data, Error: ", err)
+			return graph, errors.New("Error while getting graph data")
+		}
+		if hopIndex <= filter.Skip || agg.Count == 0 {
+			continue
+		}
+
+		slaveNames[slaveID] = slaveName
+		average := graphSample{value: agg.DurationSumSec / float64(agg.Count), count: agg.Count}
+		addLink(slaveID, hopIndex, prevIPAddress, ipAddress, agg, []graphSample{average}, bucket.UTC(), bucket.UTC())
+	}
+	rows.Close()
+
+	// raw traceroutes only reference their path, the links are taken from the path's hops
+	where, args = conditions("t.dtStart")
+	rawQuery := `
+		SELECT t.strSlaveId, s.strSlaveName, t.strPathId, t.strHopRTTs, t.strHopLoss, t.dtStart
+		FROM t_Traceroutes t
+		JOIN t_Slaves s ON t.strSlaveId = s.strSlaveId
+		WHERE t.strTargetId = ? AND t.strPathId IS NOT NULL` + where
+
+	if rows, err = db.Query(rawQuery, args...); err != nil {
+		log.Warn("QueryGraph: Error while getting graph data, Error: ", err)
+		return graph, errors.New("Error while getting graph data")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var slaveID uuid.UUID
+		var slaveName, pathID string
+		var rtts, loss sql.NullString
+		var start dbTime
+		if err := rows.Scan(&slaveID, &slaveName, &pathID, &rtts, &loss, &start); err != nil {
+			log.Warn("QueryGraph: Error while reading graph data, Error: ", err)
+			return graph, errors.New("Error while getting graph data")
+		}
+
+		hops, err := getPathHops(db, pathID)
+		if err != nil {
+			return graph, errors.New("Error while getting graph data")
+		}
+
+		slaveNames[slaveID] = slaveName
+		durations, losses := decodeVector(rtts), decodeVector(loss)
+		for pos, hop := range hops {
+			if hop.Index <= filter.Skip {
+				continue
+			}
+			var duration float64
+			if pos < len(durations) {
+				duration = durations[pos]
+			}
+			if hop.DNSName != "" {
+				dnsNames[hop.IPAddress] = hop.DNSName
+			}
+			agg := hopAggregate{Count: 1, DurationSumSec: duration, DurationMinSec: duration, DurationMaxSec: duration}
+			link := addLink(slaveID, hop.Index, hop.PrevIPAddress, hop.IPAddress, agg, []graphSample{{value: duration, count: 1}}, start.UTC(), start.UTC())
+			if pos < len(losses) {
+				link.lossSum += losses[pos]
+				link.lossCount++
+			}
+		}
+	}
+	rows.Close()
+
+	// collect the frequent links and their nodes
+	nodes := make(map[string]*GraphNode)
+	nodeSlaves := make(map[string]map[uuid.UUID]bool)
+	nodeIndex := make(map[string]int)
+	addNode := func(id string, count int64, hopIndex int, slaves map[uuid.UUID]int64) {
+		node, exists := nodes[id]
+		if !exists {
+			if strings.HasPrefix(id, "slave:") {
+				slaveID, _ := uuid.Parse(strings.TrimPrefix(id, "slave:"))
+				node = &GraphNode{ID: id, Type: GraphNodeSlave, Name: slaveNames[slaveID]}
+			} else {
+				node = &GraphNode{ID: id, Type: GraphNodeHop, IPAddress: id, DNSName: dnsNames[id], AS: LookupASN(id)}
+			}
+			nodes[id] = node
+			nodeSlaves[id] = make(map[uuid.UUID]bool)
+			nodeIndex[id] = hopIndex
+		}
+		node.Count += count
+		for slaveID := range slaves {
+			nodeSlaves[id][slaveID] = true
+		}
+		if hopIndex < nodeIndex[id] {
+			nodeIndex[id] = hopIndex
+		}
+	}
+
+	for _, link := range links {
+		if link.agg.Count < filter.MinCount {
+			continue
+		}
+
+		edge := link.edge
+		edge.Count = link.agg.Count
+		edge.RTTMs = summarizeGraphRTT(link.agg, link.samples)
+		if link.lossCount > 0 {
+			loss := link.lossSum / float64(link.lossCount)
+			edge.Loss = &loss
+		}
+		edge.Slaves = []GraphEdgeSlave{}
+		for slaveID, count := range link.slaves {
+			edge.Slaves = append(edge.Slaves, GraphEdgeSlave{ID: slaveID, Count: count})
+		}
+		sort.Slice(edge.Slaves, func(i, j int) bool { return edge.Slaves[i].ID.String() < edge.Slaves[j].ID.String() })
+		graph.Edges = append(graph.Edges, edge)
+
+		if graph.Start.IsZero() || edge.FirstSeen.Before(graph.Start) {
+			graph.Start = edge.FirstSeen
+		}
+		if edge.LastSeen.After(graph.End) {
+			graph.End = edge.LastSeen
+		}
+
+		// slaves count the paths starting at them
+		if strings.HasPrefix(edge.Source, "slave:") {
+			addNode(edge.Source, edge.Count, 0, link.slaves)
+		} else {
+			addNode(edge.Source, 0, edge.HopIndex-1, link.slaves)
+		}
+		addNode(edge.Target, edge.Count, edge.HopIndex, link.slaves)
+	}
+
+	sort.Slice(graph.Edges, func(i, j int) bool {
+		a, b := graph.Edges[i], graph.Edges[j]
+		if a.HopIndex != b.HopIndex {
+			return a.HopIndex < b.HopIndex
+		}
+		if a.Source != b.Source {
+			return a.Source < b.Source
+		}
+		return a.Target < b.Target
+	})
+
+	for id, node := range nodes {
+		node.Slaves = []uuid.UUID{}
+		for slaveID := range nodeSlaves[id] {
+			node.Slaves = append(node.Slaves, slaveID)
+		}
+		sort.Slice(node.Slaves, func(i, j int) bool { return node.Slaves[i].String() < node.Slaves[j].String() })
+		graph.Nodes = append(graph.Nodes, *node)
+	}
+	sort.Slice(graph.Nodes, func(i, j int) bool {
+		a, b := graph.Nodes[i], graph.Nodes[j]
+		if nodeIndex[a.ID] != nodeIndex[b.ID] {
+			return nodeIndex[a.ID] < nodeIndex[b.ID]
+		}
+		return a.ID < b.ID
+	})
+
+	log.Debugf("QueryGraph: returning '%v' nodes and '%v' edges from db...", len(graph.Nodes), len(graph.Edges))
+	return graph, nil
+}
+
+// summarizeGraphRTT returns the RTT summary of a link in ms
+func summarizeGraphRTT(agg hopAggregate, samples []graphSample) GraphRTT {
+
+	if agg.Count == 0 {
+		return GraphRTT{}
+	}
+
+	sort.Slice(samples, func(i, j int) bool { return samples[i].value < samples[j].value })
+	percentile := func(p float64) float64 {
+		rank := int64(math.Ceil(p * float64(agg.Count)))
+		var seen int64
+		for _, sample := range samples {
+			seen += sample.count
+			if seen >= rank {
+				return sample.value * 1000
+			}
+		}
+		return samples[len(samples)-1].value * 1000
+	}
+
+	return GraphRTT{
+		Min: agg.DurationMinSec * 1000,
+		Avg: agg.DurationSumSec / float64(agg.Count) * 1000,
+		P50: percentile(0.5),
+		P90: percentile(0.9),
+		P99: percentile(0.99),
+		Max: agg.DurationMaxSec * 1000,
+	}
+}
@@ -0,0 +1,375 @@
+package disttrace
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/google/uuid"
+)
+
+// TargetGroup holds a set of targets and default probe parameters for targets created in the group
+type TargetGroup struct {
+	ID        uuid.UUID   `valid:"-"`
+	Name      string      `valid:"required"`
+	Retries   int         `valid:"int,	range(0|10)"`
+	MaxHops   int         `valid:"int,	range(0|100)"`
+	TimeoutMs int         `valid:"int,	range(0|10000)"`
+	TargetIDs []uuid.UUID `valid:"-"`
+}
+
+// SlaveGroup holds a set of slaves, they monitor all targets of the assigned target groups
+type SlaveGroup struct {
+	ID             uuid.UUID   `valid:"-"`
+	Name           string      `valid:"required"`
+	SlaveIDs       []uuid.UUID `valid:"-"`
+	TargetGroupIDs []uuid.UUID `valid:"-"`
+}
+
+// ApplyDefaults fills in the group's default probe parameters for all unset parameters of the target
+func (group TargetGroup) ApplyDefaults(target *TraceTarget) {
+	if target.Retries == 0 {
+		target.Retries = group.Retries
+	}
+	if target.MaxHops == 0 {
+		target.MaxHops = group.MaxHops
+	}
+	if target.TimeoutMs == 0 {
+		target.TimeoutMs = group.TimeoutMs
+	}
+}
+
+// getGroupMembers reads a list of IDs from a membership table
+func getGroupMembers(db *DB, query string, groupID uuid.UUID) ([]uuid.UUID, error) {
+
+	ids := []uuid.UUID{}
+
+	rows, err := db.Query(query, groupID)
+	if err != nil {
+		return ids, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return []uuid.UUID{}, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+// replaceGroupMembers replaces all entries of a group in a membership table within the given transaction
+func replaceGroupMembers(tx *Tx, table string, groupColumn string, memberColumn string, groupID uuid.UUID, memberIDs []uuid.UUID) error {
+
+	if _, err := tx.Exec("DELETE FROM "+table+" WHERE "+groupColumn+" = ?", groupID); err != nil {
+		return err
+	}
+
+	for _, memberID := range memberIDs {
+		if _, err := tx.Exec("INSERT INTO "+table+" ("+groupColumn+", "+memberColumn+") VALUES (?, ?)", groupID, memberID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// fillTargetGroupMembers reads the targets of the given group
+func fillTargetGroupMembers(db *DB, group *TargetGroup) error {
+	var err error
+	group.TargetIDs, err = getGroupMembers(db, "SELECT strTargetId FROM t_TargetGroupMembers WHERE strGroupId = ?", group.ID)
+	return err
+}
+
+// GetTargetGroup returns the specified target group from DB
+func GetTargetGroup(groupID uuid.UUID, db *DB) (TargetGroup, error) {
+
+	log.Debug("GetTargetGroup: fetching target group with ID: ", groupID)
+	group := TargetGroup{}
+
+	query := "SELECT strGroupId, strName, nRetries, nMaxHops, nTimeoutMSec FROM t_TargetGroups WHERE strGroupId = ?"
+
+	row := db.QueryRow(query, groupID)
+	if err := row.Scan(&group.ID, &group.Name, &group.Retries, &group.MaxHops, &group.TimeoutMs); err != nil {
+		if err == sql.ErrNoRows {
+			log.Debug("GetTargetGroup: Couldn't find specified target group in DB...")
+			return TargetGroup{}, nil
+		}
+		log.Warn("GetTargetGroup: Error while getting target group from DB, Error: ", err)
+		return TargetGroup{}, errors.New("Error while getting target group from DB")
+	}
+
+	if err := fillTargetGroupMembers(db, &group); err != nil {
+		log.Warn("GetTargetGroup: Error while getting members of target group from DB, Error: ", err)
+		return TargetGroup{}, errors.New("Error while getting target group from DB")
+	}
+
+	log.Debugf("GetTargetGroup: Returning target group name '%v' for ID '%v'", group.Name, group.ID)
+	return group, nil
+}
+
+// GetTargetGroups reads all target groups from the db
+func GetTargetGroups(db *DB) ([]TargetGroup, error) {
+
+	log.Debug("GetTargetGroups: fetching target groups from db...")
+	groups := []TargetGroup{}
+
+	query := "SELECT strGroupId, strName, nRetries, nMaxHops, nTimeoutMSec FROM t_TargetGroups"
+	rows, err := db.Query(query)
+	if err != nil {
+		log.Warn("GetTargetGroups: Couldn't get target groups from db, Error: ", err)
+		return groups, errors.New("Couldn't get target groups")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var group = TargetGroup{}
+		if err := rows.Scan(&group.ID, &group.Name, &group.Retries, &group.MaxHops, &group.TimeoutMs); err != nil {
+			log.Warn("GetTargetGroups: Couldn't read results from target groups, Error: ", err)
+			return []TargetGroup{}, errors.New("Couldn't get target groups")
+		}
+		groups = append(groups, group)
+	}
+	rows.Close()
+
+	for i := range groups {
+		if err := fillTargetGroupMembers(db, &groups[i]); err != nil {
+			log.Warn("GetTargetGroups: Couldn't get members of target groups, Error: ", err)
+			return []TargetGroup{}, errors.New("Couldn't get target groups")
+		}
+	}
+
+	log.Debugf("GetTargetGroups: returning '%v' target groups from db...", len(groups))
+	return groups, nil
+}
+
+// CreateTargetGroup stores a new target group in the db
+func CreateTargetGroup(db *DB, group TargetGroup) (TargetGroup, error) {
+	log.Debug("CreateTargetGroup: Creating new target group, name: ", group.Name)
+
+	group.ID = uuid.New()
+	if err := storeTargetGroup(db, group, true); err != nil {
+		log.Warn("CreateTargetGroup: Couldn't create target group, Error: ", err)
+		return TargetGroup{}, errors.New("Couldn't create target group")
+	}
+
+	log.Debugf("CreateTargetGroup: Target group '%v' created with ID<%v>", group.Name, group.ID)
+	return group, nil
+}
+
+// UpdateTargetGroup updates an existing target group in the db
+func UpdateTargetGroup(db *DB, group TargetGroup) (TargetGroup, error) {
+	log.Debugf("UpdateTargetGroup: Updating target group '%v'...", group.ID)
+
+	if err := storeTargetGroup(db, group, false); err != nil {
+		log.Warn("UpdateTargetGroup: Couldn't update target group, Error: ", err)
+		return TargetGroup{}, errors.New("Couldn't update target group")
+	}
+
+	log.Debugf("UpdateTargetGroup: Target group '%v' successfully updated", group.ID)
+	return group, nil
+}
+
+// storeTargetGroup inserts or updates a target group and its members in a single transaction
+func storeTargetGroup(db *DB, group TargetGroup, create bool) (err error) {
+
+	var tx *Tx
+	if tx, err = db.Begin(); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if create {
+		query := "INSERT INTO t_TargetGroups (strGroupId, strName, nRetries, nMaxHops, nTimeoutMSec) VALUES (?, ?, ?, ?, ?)"
+		_, err = tx.Exec(query, group.ID, group.Name, group.Retries, group.MaxHops, group.TimeoutMs)
+	} else {
+		query := "UPDATE t_TargetGroups SET strName = ?, nRetries = ?, nMaxHops = ?, nTimeoutMSec = ? WHERE strGroupId = ?"
+		_, err = tx.Exec(query, group.Name, group.Retries, group.MaxHops, group.TimeoutMs, group.ID)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err = replaceGroupMembers(tx, "t_TargetGroupMembers", "strGroupId", "strTargetId", group.ID, group.TargetIDs); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// AddTargetToGroup adds a single target to a target group
+func AddTargetToGroup(db *DB, groupID uuid.UUID, targetID uuid.UUID) error {
+	log.Debugf("AddTargetToGroup: Adding target '%v' to target group '%v'...", targetID, groupID)
+
+	query := "INSERT INTO t_TargetGroupMembers (strGroupId, strTargetId) VALUES (?, ?)"
+
+	if _, err := db.Exec(query, groupID, targetID); err != nil {
+		log.Warn("AddTargetToGroup: Couldn't add target to group, Error: ", err)
+		return errors.New("Couldn't add target to group")
+	}
+
+	return nil
+}
+
+// DeleteTargetGroup deletes an existing target group and its assignments from the db, its targets remain
+func DeleteTargetGroup(db *DB, groupID uuid.UUID) error {
+	log.Debugf("DeleteTargetGroup: Deleting target group '%v'...", groupID)
+
+	if _, err := db.Exec("DELETE FROM t_TargetGroups WHERE strGroupId = ?", groupID); err != nil {
+		log.Warn("DeleteTargetGroup: Couldn't delete target group, Error: ", err)
+		return errors.New("Couldn't delete target group")
+	}
+
+	log.Debugf("DeleteTargetGroup: Target group '%v' successfully deleted", groupID)
+	return nil
+}
+
+// fillSlaveGroupMembers reads the slaves and assigned target groups of the given group
+func fillSlaveGroupMembers(db *DB, group *SlaveGroup) error {
+	var err error
+	if group.SlaveIDs, err = getGroupMembers(db, "SELECT strSlaveId FROM t_SlaveGroupMembers WHERE strGroupId = ?", group.ID); err != nil {
+		return err
+	}
+	group.TargetGroupIDs, err = getGroupMembers(db, "SELECT strTargetGroupId FROM t_SlaveGroupTargetGroups WHERE strSlaveGroupId = ?", group.ID)
+	return err
+}
+
+// GetSlaveGroup returns the specified slave group from DB
+func GetSlaveGroup(groupID uuid.UUID, db *DB) (SlaveGroup, error) {
+
+	log.Debug("GetSlaveGroup: fetching slave group with ID: ", groupID)
+	group := SlaveGroup{}
+
+	query := "SELECT strGroupId, strName FROM t_SlaveGroups WHERE strGroupId = ?"
+
+	row := db.QueryRow(query, groupID)
+	if err := row.Scan(&group.ID, &group.Name); err != nil {
+		if err == sql.ErrNoRows {
+			log.Debug("GetSlaveGroup: Couldn't find specified slave group in DB...")
+			return SlaveGroup{}, nil
+		}
+		log.Warn("GetSlaveGroup: Error while getting slave group from DB, Error: ", err)
+		return SlaveGroup{}, errors.New("Error while getting slave group from DB")
+	}
+
+	if err := fillSlaveGroupMembers(db, &group); err != nil {
+		log.Warn("GetSlaveGroup: Error while getting members of slave group from DB, Error: ", err)
+		return SlaveGroup{}, errors.New("Error while getting slave group from DB")
+	}
+
+	log.Debugf("GetSlaveGroup: Returning slave group name '%v' for ID '%v'", group.Name, group.ID)
+	return group, nil
+}
+
+// GetSlaveGroups reads all slave groups from the db
+func GetSlaveGroups(db *DB) ([]SlaveGroup, error) {
+
+	log.Debug("GetSlaveGroups: fetching slave groups from db...")
+	groups := []SlaveGroup{}
+
+	query := "SELECT strGroupId, strName FROM t_SlaveGroups"
+	rows, err := db.Query(query)
+	if err != nil {
+		log.Warn("GetSlaveGroups: Couldn't get slave groups from db, Error: ", err)
+		return groups, errors.New("Couldn't get slave groups")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var group = SlaveGroup{}
+		if err := rows.Scan(&group.ID, &group.Name); err != nil {
+			log.Warn("GetSlaveGroups: Couldn't read results from slave groups, Error: ", err)
+			return []SlaveGroup{}, errors.New("Couldn't get slave groups")
+		}
+		groups = append(groups, group)
+	}
+	rows.Close()
+
+	for i := range groups {
+		if err := fillSlaveGroupMembers(db, &groups[i]); err != nil {
+			log.Warn("GetSlaveGroups: Couldn't get members of slave groups, Error: ", err)
+			return []SlaveGroup{}, errors.New("Couldn't get slave groups")
+		}
+	}
+
+	log.Debugf("GetSlaveGroups: returning '%v' slave groups from db...", len(groups))
+	return groups, nil
+}
+
+// CreateSlaveGroup stores a new slave group in the db
+func CreateSlaveGroup(db *DB, group SlaveGroup) (SlaveGroup, error) {
+	log.Debug("CreateSlaveGroup: Creating new slave group, name: ", group.Name)
+
+	group.ID = uuid.New()
+	if err := storeSlaveGroup(db, group, true); err != nil {
+		log.Warn("CreateSlaveGroup: Couldn't create slave group, Error: ", err)
+		return SlaveGroup{}, errors.New("Couldn't create slave group")
+	}
+
+	log.Debugf("CreateSlaveGroup: Slave group '%v' created with ID<%v>", group.Name, group.ID)
+	return group, nil
+}
+
+// UpdateSlaveGroup updates an existing slave group in the db
+func UpdateSlaveGroup(db *DB, group SlaveGroup) (SlaveGroup, error) {
+	log.Debugf("UpdateSlaveGroup: Updating slave group '%v'...", group.ID)
+
+	if err := storeSlaveGroup(db, group, false); err != nil {
+		log.Warn("UpdateSlaveGroup: Couldn't update slave group, Error: ", err)
+		return SlaveGroup{}, errors.New("Couldn't update slave group")
+	}
+
+	log.Debugf("UpdateSlaveGroup: Slave group '%v' successfully updated", group.ID)
+	return group, nil
+}
+
+// storeSlaveGroup inserts or updates a slave group, its members and assigned target groups in a single transaction
+func storeSlaveGroup(db *DB, group SlaveGroup, create bool) (err error) {
+
+	var tx *Tx
+	if tx, err = db.Begin(); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if create {
+		_, err = tx.Exec("INSERT INTO t_SlaveGroups (strGroupId, strName) VALUES (?, ?)", group.ID, group.Name)
+	} else {
+		_, err = tx.Exec("UPDATE t_SlaveGroups SET strName = ? WHERE strGroupId = ?", group.Name, group.ID)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err = replaceGroupMembers(tx, "t_SlaveGroupMembers", "strGroupId", "strSlaveId", group.ID, group.SlaveIDs); err != nil {
+		return err
+	}
+	if err = replaceGroupMembers(tx, "t_SlaveGroupTargetGroups", "strSlaveGroupId", "strTargetGroupId", group.ID, group.TargetGroupIDs); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteSlaveGroup deletes an existing slave group and its assignments from the db, its slaves remain
+func DeleteSlaveGroup(db *DB, groupID uuid.UUID) error {
+	log.Debugf("DeleteSlaveGroup: Deleting slave group '%v'...", groupID)
+
+	if _, err := db.Exec("DELETE FROM t_SlaveGroups WHERE strGroupId = ?", groupID); err != nil {
+		log.Warn("DeleteSlaveGroup: Couldn't delete slave group, Error: ", err)
+		return errors.New("Couldn't delete slave group")
+	}
+
+	log.Debugf("DeleteSlaveGroup: Slave group '%v' successfully deleted", groupID)
+	return nil
+}
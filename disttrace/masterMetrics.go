@@ -0,0 +1,96 @@
+package disttrace
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// metrics of the master, the gauges are read from the db on every scrape
+var (
+	metricSlaveLastSeen = NewMetricGauge("disttrace_slave_last_seen_timestamp_seconds",
+		"Unix time a slave was last seen by the master.", "slave")
+	metricTargetRTT = NewMetricGauge("disttrace_target_last_rtt_seconds",
+		"RTT of the last hop of the latest traceroute of a slave to a target.", "slave", "target", "address")
+	metricTargetHops = NewMetricGauge("disttrace_target_last_hop_count",
+		"Number of hops of the latest traceroute of a slave to a target.", "slave", "target", "address")
+	metricTargetReachable = NewMetricGauge("disttrace_target_reachable",
+		"Whether the latest traceroute of a slave reached the target.", "slave", "target", "address")
+)
+
+// RegisterMasterMetrics reads the status of the slaves and the latest traceroutes from the db on every scrape
+func RegisterMasterMetrics(db *DB) {
+	RegisterMetricsCollector(func() {
+		if err := collectSlaveMetrics(db); err != nil {
+			log.Warn("RegisterMasterMetrics: Couldn't collect slave metrics, Error: ", err)
+		}
+		if err := collectTargetMetrics(db); err != nil {
+			log.Warn("RegisterMasterMetrics: Couldn't collect target metrics, Error: ", err)
+		}
+	})
+}
+
+// collectSlaveMetrics sets the last seen time of all active slaves
+func collectSlaveMetrics(db *DB) error {
+
+	slaves, err := GetSlaves(db)
+	if err != nil {
+		return err
+	}
+
+	metricSlaveLastSeen.Reset()
+	for _, slave := range slaves {
+		if slave.Status != nil && slave.Status.LastSeen != nil {
+			metricSlaveLastSeen.Set(float64(slave.Status.LastSeen.Unix()), slave.Name)
+		}
+	}
+	return nil
+}
+
+// collectTargetMetrics sets RTT, hop count and reachability of the latest traceroute of every slave to every target
+func collectTargetMetrics(db *DB) error {
+
+	query := `
+		SELECT s.strSlaveName, tg.strDescription, tg.strDestination, t.nSuccess, t.strHopRTTs
+		FROM t_Traceroutes t
+		JOIN t_Slaves s ON t.strSlaveId = s.strSlaveId
+		JOIN t_Targets tg ON t.strTargetId = tg.strTargetId
+		WHERE s.dtArchived IS NULL AND tg.dtArchived IS NULL AND t.dtStart = (
+			SELECT MAX(l.dtStart) FROM t_Traceroutes l WHERE l.strSlaveId = t.strSlaveId AND l.strTargetId = t.strTargetId
+		)
+		`
+
+	rows, err := db.Query(query)
+	if err != nil {
+		log.Warn("collectTargetMetrics: Couldn't get latest traceroutes, Error: ", err)
+		return errors.New("Couldn't get latest traceroutes")
+	}
+	defer rows.Close()
+
+	metricTargetRTT.Reset()
+	metricTargetHops.Reset()
+	metricTargetReachable.Reset()
+
+	for rows.Next() {
+		var slaveName, targetName, address string
+		var success bool
+		var vector sql.NullString
+		if err := rows.Scan(&slaveName, &targetName, &address, &success, &vector); err != nil {
+			log.Warn("collectTargetMetrics: Couldn't read latest traceroutes, Error: ", err)
+			return errors.New("Couldn't read latest traceroutes")
+		}
+
+		reachable := 0.0
+		if success {
+			reachable = 1
+		}
+		metricTargetReachable.Set(reachable, slaveName, targetName, address)
+
+		// the RTT of a traceroute is the duration of its last hop
+		durations := decodeVector(vector)
+		metricTargetHops.Set(float64(len(durations)), slaveName, targetName, address)
+		if len(durations) > 0 {
+			metricTargetRTT.Set(durations[len(durations)-1], slaveName, targetName, address)
+		}
+	}
+	return nil
+}
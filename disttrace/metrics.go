@@ -0,0 +1,260 @@
+package disttrace
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// kinds of metrics in the Prometheus text format
+const (
+	metricCounter   = "counter"
+	metricGauge     = "gauge"
+	metricHistogram = "histogram"
+)
+
+// MetricDurationBuckets are the default histogram buckets for durations in seconds
+var MetricDurationBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60}
+
+// metricFamily holds all series of a metric, series are created on their first use
+type metricFamily struct {
+	name    string
+	help    string
+	kind    string
+	labels  []string
+	buckets []float64
+	series  map[string]*metricSeries
+}
+
+// metricSeries holds the value of a single combination of label values
+type metricSeries struct {
+	labelValues []string
+	value       float64
+	counts      []uint64
+	sum         float64
+	count       uint64
+}
+
+// metricRegistry holds all metrics of the process and the collectors updating them on scrape
+var metricRegistry = struct {
+	sync.Mutex
+	families   map[string]*metricFamily
+	collectors []func()
+}{families: make(map[string]*metricFamily)}
+
+// MetricCounter is a counter with optional labels
+type MetricCounter struct{ family *metricFamily }
+
+// MetricGauge is a gauge with optional labels
+type MetricGauge struct{ family *metricFamily }
+
+// MetricHistogram is a histogram with optional labels
+type MetricHistogram struct{ family *metricFamily }
+
+// registerMetric adds a new metric family to the registry, names must be unique
+func registerMetric(name, help, kind string, buckets []float64, labels []string) *metricFamily {
+	metricRegistry.Lock()
+	defer metricRegistry.Unlock()
+
+	if _, exists := metricRegistry.families[name]; exists {
+		panic("Metric registered twice: " + name)
+	}
+	family := &metricFamily{name: name, help: help, kind: kind, labels: labels, buckets: buckets, series: make(map[string]*metricSeries)}
+	metricRegistry.families[name] = family
+	return family
+}
+
+// NewMetricCounter registers a new counter
+func NewMetricCounter(name, help string, labels ...string) *MetricCounter {
+	return &MetricCounter{registerMetric(name, help, metricCounter, nil, labels)}
+}
+
+// NewMetricGauge registers a new gauge
+func NewMetricGauge(name, help string, labels ...string) *MetricGauge {
+	return &MetricGauge{registerMetric(name, help, metricGauge, nil, labels)}
+}
+
+// NewMetricHistogram registers a new histogram with the given upper bounds of its buckets
+func NewMetricHistogram(name, help string, buckets []float64, labels ...string) *MetricHistogram {
+	return &MetricHistogram{registerMetric(name, help, metricHistogram, buckets, labels)}
+}
+
+// RegisterMetricsCollector adds a function which is called before every scrape, e.g. to update gauges from the db
+func RegisterMetricsCollector(collector func()) {
+	metricRegistry.Lock()
+	defer metricRegistry.Unlock()
+
+	metricRegistry.collectors = append(metricRegistry.collectors, collector)
+}
+
+// get returns the series of the label values, the registry must be locked
+func (f *metricFamily) get(labelValues []string) *metricSeries {
+	if len(labelValues) != len(f.labels) {
+		panic(fmt.Sprintf("Metric %v expects %v label values, got %v", f.name, len(f.labels), len(labelValues)))
+	}
+
+	key := strings.Join(labelValues, "\xff")
+	series, exists := f.series[key]
+	if !exists {
+		series = &metricSeries{labelValues: append([]string{}, labelValues...)}
+		if f.kind == metricHistogram {
+			series.counts = make([]uint64, len(f.buckets))
+		}
+		f.series[key] = series
+	}
+	return series
+}
+
+// Inc increments the counter by one
+func (c *MetricCounter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add increments the counter by the given value
+func (c *MetricCounter) Add(value float64, labelValues ...string) {
+	metricRegistry.Lock()
+	defer metricRegistry.Unlock()
+
+	c.family.get(labelValues).value += value
+}
+
+// Set sets the gauge to the given value
+func (g *MetricGauge) Set(value float64, labelValues ...string) {
+	metricRegistry.Lock()
+	defer metricRegistry.Unlock()
+
+	g.family.get(labelValues).value = value
+}
+
+// Reset removes all series of the gauge, e.g. before they are set again for all slaves that still exist
+func (g *MetricGauge) Reset() {
+	metricRegistry.Lock()
+	defer metricRegistry.Unlock()
+
+	g.family.series = make(map[string]*metricSeries)
+}
+
+// Observe adds a single observation to the histogram
+func (h *MetricHistogram) Observe(value float64, labelValues ...string) {
+	metricRegistry.Lock()
+	defer metricRegistry.Unlock()
+
+	series := h.family.get(labelValues)
+	for i, bound := range h.family.buckets {
+		if value <= bound {
+			series.counts[i]++
+		}
+	}
+	series.sum += value
+	series.count++
+}
+
+// WriteMetrics runs all collectors and writes all metrics in the Prometheus text format
+func WriteMetrics(w io.Writer) error {
+
+	metricRegistry.Lock()
+	collectors := append([]func(){}, metricRegistry.collectors...)
+	metricRegistry.Unlock()
+
+	for _, collector := range collectors {
+		collector()
+	}
+
+	metricRegistry.Lock()
+	defer metricRegistry.Unlock()
+
+	names := []string{}
+	for name := range metricRegistry.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	out := bufio.NewWriter(w)
+	for _, name := range names {
+		family := metricRegistry.families[name]
+		if len(family.series) == 0 {
+			continue
+		}
+		fmt.Fprintf(out, "# HELP %v %v\n", name, escapeMetricHelp(family.help))
+		fmt.Fprintf(out, "# TYPE %v %v\n", name, family.kind)
+
+		keys := []string{}
+		for key := range family.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			series := family.series[key]
+			labels := formatMetricLabels(family.labels, series.labelValues, "")
+
+			if family.kind != metricHistogram {
+				fmt.Fprintf(out, "%v%v %v\n", name, labels, formatMetricValue(series.value))
+				continue
+			}
+			for i, bound := range family.buckets {
+				fmt.Fprintf(out, "%v_bucket%v %v\n", name,
+					formatMetricLabels(family.labels, series.labelValues, formatMetricValue(bound)), series.counts[i])
+			}
+			fmt.Fprintf(out, "%v_bucket%v %v\n", name, formatMetricLabels(family.labels, series.labelValues, "+Inf"), series.count)
+			fmt.Fprintf(out, "%v_sum%v %v\n", name, labels, formatMetricValue(series.sum))
+			fmt.Fprintf(out, "%v_count%v %v\n", name, labels, series.count)
+		}
+	}
+
+	return out.Flush()
+}
+
+// MetricsHandler serves all metrics to Prometheus
+func MetricsHandler() http.HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		writer.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if err := WriteMetrics(writer); err != nil {
+			log.Warn("MetricsHandler: Couldn't write metrics, Error: ", err)
+		}
+	}
+}
+
+// formatMetricLabels returns the label set of a series, le is the upper bound of a histogram bucket if set
+func formatMetricLabels(names []string, values []string, le string) string {
+	pairs := []string{}
+	for i, name := range names {
+		pairs = append(pairs, name+"=\""+escapeMetricLabel(values[i])+"\"")
+	}
+	if le != "" {
+		pairs = append(pairs, "le=\""+le+"\"")
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// formatMetricValue formats a sample value, special values as expected by Prometheus
+func formatMetricValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// escapeMetricLabel escapes backslashes, quotes and newlines in label values
+func escapeMetricLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// escapeMetricHelp escapes backslashes and newlines in help texts
+func escapeMetricHelp(help string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+}
@@ -0,0 +1,162 @@
+package disttrace
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// parseWebhookTemplate parses a webhook body template, the function 'json' encodes a value as JSON
+func parseWebhookTemplate(text string) (*template.Template, error) {
+
+	funcs := template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+	}
+
+	return template.New("webhook").Funcs(funcs).Parse(text)
+}
+
+// sendWebhook posts the alert to the configured URL
+func sendWebhook(cfg WebhookConfig, alert Alert) error {
+
+	var body []byte
+	var err error
+
+	if cfg.Template == "" {
+		if body, err = json.Marshal(alert); err != nil {
+			return err
+		}
+	} else {
+		tmpl, err := parseWebhookTemplate(cfg.Template)
+		if err != nil {
+			return err
+		}
+
+		buf := bytes.NewBuffer([]byte{})
+		if err := tmpl.Execute(buf, alert); err != nil {
+			return err
+		}
+		body = buf.Bytes()
+	}
+
+	httpReq, err := http.NewRequest("POST", cfg.URL, bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	for key, val := range cfg.Headers {
+		httpReq.Header.Set(key, val)
+	}
+
+	var httpClient = &http.Client{
+		Timeout: time.Second * 10,
+	}
+
+	httpResp, err := httpClient.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer httpResp.Body.Close()
+	ioutil.ReadAll(httpResp.Body)
+
+	if httpResp.StatusCode < 200 || httpResp.StatusCode >= 300 {
+		return errors.New("Received non-OK status: " + httpResp.Status)
+	}
+
+	return nil
+}
+
+// singleLine replaces line breaks, alert texts may contain user input which mustn't start new headers or messages
+func singleLine(text string) string {
+	return strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ").Replace(text)
+}
+
+// sendMail sends the alert as plain text email to all recipients
+func sendMail(cfg SMTPConfig, alert Alert) error {
+
+	subject := fmt.Sprintf("[dist-traceroute] [%v] %v: %v", alert.Severity, alert.State, singleLine(alert.Text))
+
+	msg := bytes.NewBuffer([]byte{})
+	fmt.Fprintf(msg, "From: %v\r\n", cfg.From)
+	fmt.Fprintf(msg, "To: %v\r\n", strings.Join(cfg.To, ", "))
+	fmt.Fprintf(msg, "Subject: %v\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(msg, "Date: %v\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprint(msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprint(msg, "Content-Type: text/plain; charset=utf-8\r\n")
+	fmt.Fprint(msg, "\r\n")
+	fmt.Fprintf(msg, "Alert:      %v\r\n", singleLine(alert.Text))
+	fmt.Fprintf(msg, "Severity:   %v\r\n", alert.Severity)
+	fmt.Fprintf(msg, "State:      %v\r\n", alert.State)
+	fmt.Fprintf(msg, "Source:     %v\r\n", alert.Source)
+	fmt.Fprintf(msg, "First seen: %v\r\n", alert.FirstSeen.Format(time.RFC3339))
+	fmt.Fprintf(msg, "Last seen:  %v\r\n", alert.LastSeen.Format(time.RFC3339))
+	fmt.Fprintf(msg, "Count:      %v\r\n", alert.Count)
+
+	var auth smtp.Auth
+	if cfg.Username != "" {
+		auth = smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.Host)
+	}
+
+	addr := net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port))
+	return smtp.SendMail(addr, auth, cfg.From, cfg.To, msg.Bytes())
+}
+
+// syslogSeverity maps an alert severity to a RFC5424 severity
+func syslogSeverity(severity string) int {
+	switch severity {
+	case "error":
+		return 3
+	case "warning":
+		return 4
+	}
+	return 6
+}
+
+// sendSyslog sends the alert as RFC5424 message to a syslog server
+func sendSyslog(cfg SyslogConfig, alert Alert) error {
+
+	appName := cfg.AppName
+	if appName == "" {
+		appName = "dist-traceroute"
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+
+	// <PRI>VERSION TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA MSG
+	pri := cfg.Facility*8 + syslogSeverity(alert.Severity)
+	msg := fmt.Sprintf("<%d>1 %v %v %v %d %v - %v: %v (source: %v, count: %v)",
+		pri, time.Now().Format(time.RFC3339), hostname, appName, os.Getpid(), alert.State,
+		alert.Severity, singleLine(alert.Text), singleLine(alert.Source), alert.Count)
+
+	conn, err := net.DialTimeout(cfg.Network, cfg.Address, 10*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	// use octet counting framing on streams, see RFC6587
+	if cfg.Network == "tcp" {
+		msg = fmt.Sprintf("%d %v", len(msg), msg)
+	}
+
+	_, err = conn.Write([]byte(msg))
+	return err
+}
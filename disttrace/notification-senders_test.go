@@ -0,0 +1,328 @@
+package disttrace
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// testAlert returns an alert whose text tries to inject a header
+func testAlert() Alert {
+	now := time.Now()
+	return Alert{
+		ID:        uuid.New(),
+		DedupKey:  "test",
+		Severity:  "warning",
+		Source:    "127.0.0.1",
+		Text:      "Unauthorized user login for user 'x\r\nBcc: victim@example.com'",
+		State:     AlertStateFiring,
+		FirstSeen: now,
+		LastSeen:  now,
+		Count:     2,
+	}
+}
+
+func TestSendWebhook(t *testing.T) {
+
+	var received Alert
+	var header http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Error("webhook body isn't the alert as JSON: ", err)
+		}
+	}))
+	defer srv.Close()
+
+	alert := testAlert()
+	cfg := WebhookConfig{URL: srv.URL, Headers: map[string]string{"Authorization": "Bearer abc"}}
+	if err := sendWebhook(cfg, alert); err != nil {
+		t.Fatal("sendWebhook failed: ", err)
+	}
+
+	if received.ID != alert.ID || received.Text != alert.Text {
+		t.Errorf("received alert %+v, expected %+v", received, alert)
+	}
+	if got := header.Get("Authorization"); got != "Bearer abc" {
+		t.Errorf("received Authorization header '%v', expected configured header", got)
+	}
+	if got := header.Get("Content-Type"); got != "application/json" {
+		t.Errorf("received Content-Type '%v', expected application/json", got)
+	}
+}
+
+func TestSendWebhookTemplate(t *testing.T) {
+
+	var body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		body = string(b)
+	}))
+	defer srv.Close()
+
+	alert := testAlert()
+	cfg := WebhookConfig{URL: srv.URL, Template: `{"text": {{json .Text}}, "count": {{.Count}}}`}
+	if err := sendWebhook(cfg, alert); err != nil {
+		t.Fatal("sendWebhook failed: ", err)
+	}
+
+	var payload struct {
+		Text  string
+		Count int
+	}
+	if err := json.Unmarshal([]byte(body), &payload); err != nil {
+		t.Fatalf("templated body '%v' isn't valid JSON: %v", body, err)
+	}
+	if payload.Text != alert.Text || payload.Count != alert.Count {
+		t.Errorf("received payload %+v, expected text and count of the alert", payload)
+	}
+}
+
+func TestSendNotificationWithRetry(t *testing.T) {
+
+	defer func(delay time.Duration) { notificationRetryDelay = delay }(notificationRetryDelay)
+	notificationRetryDelay = time.Millisecond
+
+	var mu sync.Mutex
+	requests := 0
+	failures := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+		if requests <= failures {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	channel := NotificationChannel{Name: "hook", Type: NotificationChannelWebhook, Webhook: &WebhookConfig{URL: srv.URL}}
+	attempts := CurrentMasterConfig().Alerting.NotificationAttempts
+
+	// succeeds on the last try
+	failures = attempts - 1
+	if err := sendNotificationWithRetry(channel, testAlert()); err != nil {
+		t.Error("expected success on the last try, Error: ", err)
+	}
+	if requests != attempts {
+		t.Errorf("sent %v requests, expected %v", requests, attempts)
+	}
+
+	// gives up after all tries failed
+	requests, failures = 0, attempts
+	if err := sendNotificationWithRetry(channel, testAlert()); err == nil {
+		t.Error("expected an error after all tries failed")
+	}
+	if requests != attempts {
+		t.Errorf("sent %v requests, expected %v", requests, attempts)
+	}
+}
+
+// fakeSMTPServer accepts a single mail and returns its data, the data is empty if the server rejects the mail
+func fakeSMTPServer(t *testing.T, rejectRcpt bool) (addr string, data <-chan string) {
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("can't listen: ", err)
+	}
+
+	dataCh := make(chan string, 1)
+	go func() {
+		defer listener.Close()
+		conn, err := listener.Accept()
+		if err != nil {
+			dataCh <- ""
+			return
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+		reader := bufio.NewReader(conn)
+		reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+
+		reply("220 localhost ESMTP")
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				dataCh <- ""
+				return
+			}
+			cmd := strings.ToUpper(strings.TrimSpace(line))
+			switch {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				reply("250 localhost")
+			case strings.HasPrefix(cmd, "RCPT") && rejectRcpt:
+				reply("550 no such user")
+			case strings.HasPrefix(cmd, "MAIL"), strings.HasPrefix(cmd, "RCPT"):
+				reply("250 OK")
+			case cmd == "DATA":
+				reply("354 go ahead")
+				msg := []string{}
+				for {
+					line, err := reader.ReadString('\n')
+					if err != nil || line == ".\r\n" {
+						break
+					}
+					msg = append(msg, line)
+				}
+				reply("250 OK")
+				dataCh <- strings.Join(msg, "")
+			case cmd == "QUIT":
+				reply("221 bye")
+				return
+			default:
+				reply("250 OK")
+			}
+		}
+	}()
+
+	return listener.Addr().String(), dataCh
+}
+
+func smtpTestConfig(addr string) SMTPConfig {
+	host, port, _ := net.SplitHostPort(addr)
+	portNum, _ := strconv.Atoi(port)
+	return SMTPConfig{Host: host, Port: portNum, From: "master@example.com", To: []string{"noc@example.com"}}
+}
+
+func TestSendMail(t *testing.T) {
+
+	addr, data := fakeSMTPServer(t, false)
+
+	alert := testAlert()
+	if err := sendMail(smtpTestConfig(addr), alert); err != nil {
+		t.Fatal("sendMail failed: ", err)
+	}
+
+	msg := <-data
+	parts := strings.SplitN(msg, "\r\n\r\n", 2)
+	if len(parts) != 2 {
+		t.Fatalf("mail has no header and body: %q", msg)
+	}
+
+	headers := map[string]string{}
+	for _, line := range strings.Split(parts[0], "\r\n") {
+		kv := strings.SplitN(line, ": ", 2)
+		if len(kv) != 2 {
+			t.Fatalf("invalid header line %q", line)
+		}
+		headers[kv[0]] = kv[1]
+	}
+
+	if _, ok := headers["Bcc"]; ok {
+		t.Error("alert text injected a Bcc header")
+	}
+	if headers["To"] != "noc@example.com" || headers["From"] != "master@example.com" {
+		t.Errorf("unexpected sender or recipients in headers %v", headers)
+	}
+	if !strings.Contains(headers["Subject"], "[warning] firing: Unauthorized user login") {
+		t.Errorf("unexpected subject '%v'", headers["Subject"])
+	}
+	if !strings.Contains(parts[1], "Count:      2") {
+		t.Errorf("body doesn't hold the alert count: %q", parts[1])
+	}
+}
+
+func TestSendMailRejected(t *testing.T) {
+
+	addr, _ := fakeSMTPServer(t, true)
+	if err := sendMail(smtpTestConfig(addr), testAlert()); err == nil {
+		t.Error("expected an error if the server rejects the recipient")
+	}
+}
+
+func TestSendSyslogUDP(t *testing.T) {
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("can't listen: ", err)
+	}
+	defer conn.Close()
+
+	cfg := SyslogConfig{Network: "udp", Address: conn.LocalAddr().String(), Facility: 16, AppName: "test"}
+	if err := sendSyslog(cfg, testAlert()); err != nil {
+		t.Fatal("sendSyslog failed: ", err)
+	}
+
+	buf := make([]byte, 4096)
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal("didn't receive syslog message: ", err)
+	}
+	msg := string(buf[:n])
+
+	// facility 16 * 8 + warning 4
+	if !strings.HasPrefix(msg, "<132>1 ") {
+		t.Errorf("unexpected priority and version in %q", msg)
+	}
+	if !strings.Contains(msg, " test ") || !strings.Contains(msg, "count: 2") {
+		t.Errorf("app name or count missing in %q", msg)
+	}
+	if strings.ContainsAny(msg, "\r\n") {
+		t.Errorf("message contains line breaks: %q", msg)
+	}
+}
+
+func TestSendSyslogTCP(t *testing.T) {
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("can't listen: ", err)
+	}
+	defer listener.Close()
+
+	received := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			received <- ""
+			return
+		}
+		defer conn.Close()
+		b, _ := ioutil.ReadAll(conn)
+		received <- string(b)
+	}()
+
+	cfg := SyslogConfig{Network: "tcp", Address: listener.Addr().String()}
+	if err := sendSyslog(cfg, testAlert()); err != nil {
+		t.Fatal("sendSyslog failed: ", err)
+	}
+
+	// octet counting framing: "<length> <message>"
+	msg := <-received
+	parts := strings.SplitN(msg, " ", 2)
+	if len(parts) != 2 {
+		t.Fatalf("message isn't framed: %q", msg)
+	}
+	if length, err := strconv.Atoi(parts[0]); err != nil || length != len(parts[1]) {
+		t.Errorf("frame length '%v' doesn't match message length %v", parts[0], len(parts[1]))
+	}
+	if !strings.Contains(parts[1], " dist-traceroute ") {
+		t.Errorf("default app name missing in %q", parts[1])
+	}
+}
+
+func TestSendSyslogUnreachable(t *testing.T) {
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("can't listen: ", err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	if err := sendSyslog(SyslogConfig{Network: "tcp", Address: addr}, testAlert()); err == nil {
+		t.Error("expected an error if the syslog server is unreachable")
+	}
+}
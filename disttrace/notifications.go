@@ -0,0 +1,414 @@
+package disttrace
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	valid "github.com/asaskevich/govalidator"
+	"github.com/google/uuid"
+)
+
+// notification channel types
+const (
+	NotificationChannelWebhook = "webhook"
+	NotificationChannelSMTP    = "smtp"
+	NotificationChannelSyslog  = "syslog"
+)
+
+// NotificationChannel holds the configuration of a channel alerts are sent to
+type NotificationChannel struct {
+	ID          uuid.UUID      `valid:"-"`
+	Name        string         `valid:"required"`
+	Type        string         `valid:"in(webhook|smtp|syslog),	required"`
+	MinSeverity string         `valid:"in(info|warning|error),	required"`
+	Enabled     bool           `valid:"-"`
+	Webhook     *WebhookConfig `json:",omitempty" valid:"-"`
+	SMTP        *SMTPConfig    `json:",omitempty" valid:"-"`
+	Syslog      *SyslogConfig  `json:",omitempty" valid:"-"`
+}
+
+// WebhookConfig holds the settings of a webhook channel
+type WebhookConfig struct {
+	URL string `valid:"url,	required"`
+	// Template is a Go text/template for the request body, executed with the alert. Empty sends the alert as JSON.
+	Template string            `valid:"-"`
+	Headers  map[string]string `valid:"-"`
+}
+
+// SMTPConfig holds the settings of an email channel
+type SMTPConfig struct {
+	Host     string   `valid:"host,	required"`
+	Port     int      `valid:"int,	required,	range(1|65535)"`
+	Username string   `valid:"-"`
+	Password string   `valid:"-"`
+	From     string   `valid:"email,	required"`
+	To       []string `valid:"required"`
+}
+
+// SyslogConfig holds the settings of a RFC5424 syslog channel
+type SyslogConfig struct {
+	Network  string `valid:"in(udp|tcp),	required"`
+	Address  string `valid:"dialstring,	required"`
+	Facility int    `valid:"int,	range(0|23)"`
+	AppName  string `valid:"-"`
+}
+
+// NotifierProcRunning mutex for graceful shutdown
+var NotifierProcRunning = make(chan bool, 1)
+
+// queue of alerts waiting to be sent
+var notificationQueue = make(chan Alert, 100)
+
+// notificationRetryDelay is multiplied with the number of the failed try to wait before the next one
+var notificationRetryDelay = 5 * time.Second
+
+// severityRank orders severities, higher is more severe
+func severityRank(severity string) int {
+	switch severity {
+	case "error":
+		return 3
+	case "warning":
+		return 2
+	case "info":
+		return 1
+	}
+	return 0
+}
+
+// ValidateNotificationChannel validates a channel and the settings of its type
+func ValidateNotificationChannel(channel NotificationChannel) (bool, error) {
+
+	if ok, err := valid.ValidateStruct(channel); !ok || err != nil {
+		return false, err
+	}
+
+	var settings interface{}
+	switch channel.Type {
+	case NotificationChannelWebhook:
+		if channel.Webhook == nil {
+			return false, errors.New("Webhook settings missing")
+		}
+		if _, err := parseWebhookTemplate(channel.Webhook.Template); err != nil {
+			return false, errors.New("Invalid webhook template: " + err.Error())
+		}
+		settings = channel.Webhook
+	case NotificationChannelSMTP:
+		if channel.SMTP == nil {
+			return false, errors.New("SMTP settings missing")
+		}
+		for _, to := range channel.SMTP.To {
+			if !valid.IsEmail(to) {
+				return false, errors.New("Invalid recipient: " + to)
+			}
+		}
+		settings = channel.SMTP
+	case NotificationChannelSyslog:
+		if channel.Syslog == nil {
+			return false, errors.New("Syslog settings missing")
+		}
+		settings = channel.Syslog
+	}
+
+	return valid.ValidateStruct(settings)
+}
+
+// RedactSecrets removes the SMTP password and the values of the webhook headers, which may hold tokens
+func (channel *NotificationChannel) RedactSecrets() {
+
+	if channel.SMTP != nil {
+		smtp := *channel.SMTP
+		smtp.Password = ""
+		channel.SMTP = &smtp
+	}
+	if channel.Webhook != nil {
+		webhook := *channel.Webhook
+		webhook.Headers = map[string]string{}
+		for key := range channel.Webhook.Headers {
+			webhook.Headers[key] = ""
+		}
+		channel.Webhook = &webhook
+	}
+}
+
+// channelSettings returns the json encoded settings of the channel's type
+func channelSettings(channel NotificationChannel) (string, error) {
+
+	var settings interface{}
+	switch channel.Type {
+	case NotificationChannelWebhook:
+		settings = channel.Webhook
+	case NotificationChannelSMTP:
+		settings = channel.SMTP
+	case NotificationChannelSyslog:
+		settings = channel.Syslog
+	}
+
+	settingsJSON, err := json.Marshal(settings)
+	return string(settingsJSON), err
+}
+
+// scanNotificationChannel reads a single channel from the given row
+func scanNotificationChannel(row interface{ Scan(...interface{}) error }) (NotificationChannel, error) {
+
+	var c NotificationChannel
+	var settings string
+	if err := row.Scan(&c.ID, &c.Name, &c.Type, &c.MinSeverity, &c.Enabled, &settings); err != nil {
+		return NotificationChannel{}, err
+	}
+
+	var err error
+	switch c.Type {
+	case NotificationChannelWebhook:
+		c.Webhook = &WebhookConfig{}
+		err = json.Unmarshal([]byte(settings), c.Webhook)
+	case NotificationChannelSMTP:
+		c.SMTP = &SMTPConfig{}
+		err = json.Unmarshal([]byte(settings), c.SMTP)
+	case NotificationChannelSyslog:
+		c.Syslog = &SyslogConfig{}
+		err = json.Unmarshal([]byte(settings), c.Syslog)
+	}
+	if err != nil {
+		return NotificationChannel{}, err
+	}
+
+	return c, nil
+}
+
+const notificationChannelColumns = "strChannelId, strName, strType, strMinSeverity, nEnabled, strSettings"
+
+// GetNotificationChannel returns the specified notification channel from DB
+func GetNotificationChannel(channelID uuid.UUID, db *DB) (NotificationChannel, error) {
+
+	log.Debug("GetNotificationChannel: fetching notification channel with ID: ", channelID)
+
+	query := "SELECT " + notificationChannelColumns + " FROM t_NotificationChannels WHERE strChannelId = ?"
+
+	channel, err := scanNotificationChannel(db.QueryRow(query, channelID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			log.Debug("GetNotificationChannel: Couldn't find specified notification channel in DB...")
+			return NotificationChannel{}, nil
+		}
+		log.Warn("GetNotificationChannel: Error while getting notification channel from DB, Error: ", err)
+		return NotificationChannel{}, errors.New("Error while getting notification channel from DB")
+	}
+
+	log.Debugf("GetNotificationChannel: Returning notification channel name '%v' for ID '%v'", channel.Name, channel.ID)
+	return channel, nil
+}
+
+// GetNotificationChannels reads all notification channels from the db
+func GetNotificationChannels(db *DB) ([]NotificationChannel, error) {
+
+	log.Debug("GetNotificationChannels: fetching notification channels from db...")
+	channels := []NotificationChannel{}
+
+	query := "SELECT " + notificationChannelColumns + " FROM t_NotificationChannels"
+	rows, err := db.Query(query)
+	if err != nil {
+		log.Warn("GetNotificationChannels: Couldn't get notification channels from db, Error: ", err)
+		return channels, errors.New("Couldn't get notification channels")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		channel, err := scanNotificationChannel(rows)
+		if err != nil {
+			log.Warn("GetNotificationChannels: Couldn't read results from notification channels, Error: ", err)
+			return []NotificationChannel{}, errors.New("Couldn't get notification channels")
+		}
+		channels = append(channels, channel)
+	}
+
+	log.Debugf("GetNotificationChannels: returning '%v' notification channels from db...", len(channels))
+	return channels, nil
+}
+
+// CreateNotificationChannel stores a new notification channel in the db
+func CreateNotificationChannel(db *DB, channel NotificationChannel) (NotificationChannel, error) {
+	log.Debug("CreateNotificationChannel: Creating new notification channel, name: ", channel.Name)
+
+	settings, err := channelSettings(channel)
+	if err != nil {
+		log.Warn("CreateNotificationChannel: Couldn't marshal channel settings, Error: ", err)
+		return NotificationChannel{}, errors.New("Couldn't marshal channel settings")
+	}
+
+	query := `
+	INSERT INTO t_NotificationChannels (strChannelId, strName, strType, strMinSeverity, nEnabled, strSettings)
+	VALUES (?, ?, ?, ?, ?, ?)
+	`
+
+	channel.ID = uuid.New()
+	if _, err = db.Exec(query, channel.ID, channel.Name, channel.Type, channel.MinSeverity, channel.Enabled, settings); err != nil {
+		log.Warn("CreateNotificationChannel: Couldn't create notification channel, Error: ", err)
+		return NotificationChannel{}, errors.New("Couldn't create notification channel")
+	}
+
+	log.Debugf("CreateNotificationChannel: Notification channel '%v' created with ID<%v>", channel.Name, channel.ID)
+	return channel, nil
+}
+
+// UpdateNotificationChannel updates an existing notification channel in the db
+func UpdateNotificationChannel(db *DB, channel NotificationChannel) (NotificationChannel, error) {
+	log.Debugf("UpdateNotificationChannel: Updating notification channel '%v'...", channel.ID)
+
+	settings, err := channelSettings(channel)
+	if err != nil {
+		log.Warn("UpdateNotificationChannel: Couldn't marshal channel settings, Error: ", err)
+		return NotificationChannel{}, errors.New("Couldn't marshal channel settings")
+	}
+
+	query := `UPDATE t_NotificationChannels
+	SET strName = ?, strType = ?, strMinSeverity = ?, nEnabled = ?, strSettings = ?
+	WHERE strChannelId = ?`
+
+	res, err := db.Exec(query, channel.Name, channel.Type, channel.MinSeverity, channel.Enabled, settings, channel.ID)
+	if err != nil {
+		log.Warn("UpdateNotificationChannel: Couldn't update notification channel, Error: ", err)
+		return NotificationChannel{}, errors.New("Couldn't update notification channel")
+	}
+
+	numRows, err := res.RowsAffected()
+	if err != nil {
+		log.Warn("UpdateNotificationChannel: Error: Can't get number of affected rows, Error: ", err)
+		return NotificationChannel{}, errors.New("DB Error")
+	}
+
+	log.Debugf("UpdateNotificationChannel: Notification channel '%v' successfully updated, affected rows: '%v", channel.ID, numRows)
+	return channel, nil
+}
+
+// DeleteNotificationChannel deletes an existing notification channel from the db
+func DeleteNotificationChannel(db *DB, channelID uuid.UUID) error {
+	log.Debugf("DeleteNotificationChannel: Deleting notification channel '%v'...", channelID)
+
+	query := "DELETE FROM t_NotificationChannels WHERE strChannelId = ?"
+
+	res, err := db.Exec(query, channelID)
+	if err != nil {
+		log.Warn("DeleteNotificationChannel: Couldn't delete notification channel, Error: ", err)
+		return errors.New("Couldn't delete notification channel")
+	}
+
+	numRows, err := res.RowsAffected()
+	if err != nil {
+		log.Warn("DeleteNotificationChannel: Error: Can't get number of affected rows, Error: ", err)
+		return errors.New("DB Error")
+	}
+
+	log.Debugf("DeleteNotificationChannel: Notification channel '%v' successfully deleted, rows: '%v'", channelID, numRows)
+	return nil
+}
+
+// queueNotification hands an alert to the notifier process, never blocks
+func queueNotification(alert Alert) {
+	select {
+	case notificationQueue <- alert:
+		log.Debugf("queueNotification: Queued notification for alert '%v'", alert.ID)
+	default:
+		log.Warnf("queueNotification: Notification queue is full, discarding notification for alert '%v'", alert.ID)
+	}
+}
+
+// Notifier runs as process. Takes fired and resolved alerts and sends them to all matching channels.
+func Notifier(db *DB) {
+
+	// lock mutex
+	NotifierProcRunning <- true
+
+	log.Info("Notifier: Start...")
+	for {
+		// check if we need to exit
+		if CheckForQuit() {
+			log.Warn("Notifier: Received exit signal, bye.")
+			<-NotifierProcRunning
+			return
+		}
+
+		select {
+		case alert := <-notificationQueue:
+			channels, err := GetNotificationChannels(db)
+			if err != nil {
+				log.Warnf("Notifier: Couldn't get notification channels, discarding notification for alert '%v'", alert.ID)
+				continue
+			}
+
+			for _, channel := range channels {
+				if !channel.Enabled || severityRank(alert.Severity) < severityRank(channel.MinSeverity) {
+					continue
+				}
+				sendNotificationWithRetry(channel, alert)
+			}
+		default:
+			// pause between checks
+			time.Sleep(1 * time.Second)
+		}
+	}
+}
+
+// sendNotificationWithRetry sends an alert to a channel, retries on failure and returns the error of the last try
+func sendNotificationWithRetry(channel NotificationChannel, alert Alert) (err error) {
+
+	attempts := CurrentMasterConfig().Alerting.NotificationAttempts
+	for try := 1; try <= attempts; try++ {
+		if err = SendNotification(channel, alert); err == nil {
+			log.Debugf("sendNotificationWithRetry: Sent alert '%v' to channel '%v'", alert.ID, channel.Name)
+			return nil
+		}
+
+		log.Warnf("sendNotificationWithRetry: Couldn't send alert '%v' to channel '%v', try %v/%v, Error: %v",
+			alert.ID, channel.Name, try, attempts, err)
+
+		if try < attempts && !CheckForQuit() {
+			time.Sleep(time.Duration(try) * notificationRetryDelay)
+		}
+	}
+
+	log.Warnf("sendNotificationWithRetry: Too many retries for alert '%v' on channel '%v', discarding notification", alert.ID, channel.Name)
+	return err
+}
+
+// SendNotification sends a single alert to the given channel
+func SendNotification(channel NotificationChannel, alert Alert) error {
+
+	switch channel.Type {
+	case NotificationChannelWebhook:
+		return sendWebhook(*channel.Webhook, alert)
+	case NotificationChannelSMTP:
+		return sendMail(*channel.SMTP, alert)
+	case NotificationChannelSyslog:
+		return sendSyslog(*channel.Syslog, alert)
+	}
+
+	return errors.New("Unknown channel type: " + channel.Type)
+}
+
+// SendTestNotification sends a test alert to the given channel, without retries
+func SendTestNotification(channel NotificationChannel) error {
+
+	log.Debugf("SendTestNotification: Sending test notification to channel '%v'...", channel.Name)
+
+	now := time.Now()
+	alert := Alert{
+		ID:        uuid.New(),
+		DedupKey:  "test",
+		Severity:  channel.MinSeverity,
+		Source:    "master",
+		Text:      "This is a test notification from dist-traceroute",
+		State:     AlertStateFiring,
+		FirstSeen: now,
+		LastSeen:  now,
+		Count:     1,
+	}
+
+	if err := SendNotification(channel, alert); err != nil {
+		log.Warnf("SendTestNotification: Couldn't send test notification to channel '%v', Error: %v", channel.Name, err)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,414 @@
+package disttrace
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"time"
+)
+
+// physical types of parquet columns
+const (
+	parquetBoolean   = 0
+	parquetInt32     = 1
+	parquetInt64     = 2
+	parquetDouble    = 5
+	parquetByteArray = 6
+)
+
+// converted types of parquet columns, parquetNone for plain physical values
+const (
+	parquetNone            = -1
+	parquetUTF8            = 0
+	parquetTimestampMillis = 9
+)
+
+// parquetRowGroupSize is the number of rows buffered before they are written as row group
+const parquetRowGroupSize = 10000
+
+// parquetColumn is a flat column of a parquet file, it buffers the values of the current row group
+type parquetColumn struct {
+	name      string
+	kind      int32
+	converted int32
+	optional  bool
+
+	defined []bool
+	values  bytes.Buffer
+	bits    []bool
+	count   int
+	chunks  []parquetChunk
+}
+
+// parquetChunk is the position of a column chunk written to the file
+type parquetChunk struct {
+	offset    int64
+	size      int64
+	numValues int64
+}
+
+// parquetWriter writes rows to a parquet file with flat columns, uncompressed and plain encoded. Only the current row
+// group is kept in memory, the file is complete after close.
+type parquetWriter struct {
+	out       io.Writer
+	offset    int64
+	columns   []*parquetColumn
+	rows      int64
+	groupRows []int64
+}
+
+// newParquetWriter returns a writer of a file with the given columns
+func newParquetWriter(w io.Writer, columns []*parquetColumn) *parquetWriter {
+	return &parquetWriter{out: w, columns: columns}
+}
+
+// writeRow adds a row, it has a value per column, nil for missing values of optional columns. Values are strings,
+// int32, int64, float64, bool or time.Time depending on the type of the column.
+func (p *parquetWriter) writeRow(values ...interface{}) error {
+
+	if len(values) != len(p.columns) {
+		return errors.New("Number of values doesn't match the columns")
+	}
+
+	for i, column := range p.columns {
+		if err := column.add(values[i]); err != nil {
+			return err
+		}
+	}
+	p.rows++
+
+	if p.rows >= parquetRowGroupSize {
+		return p.flush()
+	}
+	return nil
+}
+
+// add appends a value to the current row group of the column
+func (c *parquetColumn) add(value interface{}) error {
+
+	if value == nil {
+		if !c.optional {
+			return errors.New("Missing value of required column " + c.name)
+		}
+		c.defined = append(c.defined, false)
+		c.count++
+		return nil
+	}
+	c.defined = append(c.defined, true)
+	c.count++
+
+	var ok bool
+	switch c.kind {
+	case parquetBoolean:
+		var v bool
+		if v, ok = value.(bool); ok {
+			c.bits = append(c.bits, v)
+		}
+	case parquetInt32:
+		var v int32
+		if v, ok = value.(int32); ok {
+			binary.Write(&c.values, binary.LittleEndian, v)
+		}
+	case parquetInt64:
+		switch v := value.(type) {
+		case int64:
+			binary.Write(&c.values, binary.LittleEndian, v)
+			ok = true
+		case time.Time:
+			binary.Write(&c.values, binary.LittleEndian, v.UnixNano()/int64(time.Millisecond))
+			ok = true
+		}
+	case parquetDouble:
+		var v float64
+		if v, ok = value.(float64); ok {
+			binary.Write(&c.values, binary.LittleEndian, math.Float64bits(v))
+		}
+	case parquetByteArray:
+		var v string
+		if v, ok = value.(string); ok {
+			binary.Write(&c.values, binary.LittleEndian, uint32(len(v)))
+			c.values.WriteString(v)
+		}
+	}
+
+	if !ok {
+		return errors.New("Invalid value of column " + c.name)
+	}
+	return nil
+}
+
+// write writes bytes to the file and keeps track of the offset
+func (p *parquetWriter) write(data []byte) error {
+	n, err := p.out.Write(data)
+	p.offset += int64(n)
+	return err
+}
+
+// flush writes the buffered rows as row group, every column chunk is a single data page
+func (p *parquetWriter) flush() error {
+
+	if p.offset == 0 {
+		if err := p.write([]byte("PAR1")); err != nil {
+			return err
+		}
+	}
+	if p.rows == 0 {
+		return nil
+	}
+
+	for _, column := range p.columns {
+		var page bytes.Buffer
+
+		// definition levels of optional columns are RLE encoded, prefixed with their length
+		if column.optional {
+			levels := parquetRLE(column.defined)
+			binary.Write(&page, binary.LittleEndian, uint32(len(levels)))
+			page.Write(levels)
+		}
+
+		// booleans are bit packed, the other types are already plain encoded
+		if column.kind == parquetBoolean {
+			packed := make([]byte, (len(column.bits)+7)/8)
+			for i, bit := range column.bits {
+				if bit {
+					packed[i/8] |= 1 << uint(i%8)
+				}
+			}
+			page.Write(packed)
+		} else {
+			page.Write(column.values.Bytes())
+		}
+
+		// page header: data page with plain values and RLE levels
+		header := thriftWriter{}
+		header.i32(1, 0)
+		header.i32(2, int32(page.Len()))
+		header.i32(3, int32(page.Len()))
+		header.beginStruct(5)
+		header.i32(1, int32(column.count))
+		header.i32(2, 0)
+		header.i32(3, 3)
+		header.i32(4, 3)
+		header.endStruct()
+		header.stop()
+
+		chunk := parquetChunk{offset: p.offset, size: int64(header.buf.Len() + page.Len()), numValues: int64(column.count)}
+		if err := p.write(header.buf.Bytes()); err != nil {
+			return err
+		}
+		if err := p.write(page.Bytes()); err != nil {
+			return err
+		}
+		column.chunks = append(column.chunks, chunk)
+
+		column.defined, column.bits, column.count = nil, nil, 0
+		column.values.Reset()
+	}
+
+	p.groupRows = append(p.groupRows, p.rows)
+	p.rows = 0
+	return nil
+}
+
+// close writes the remaining rows and the footer with the schema and the positions of the row groups
+func (p *parquetWriter) close() error {
+
+	if err := p.flush(); err != nil {
+		return err
+	}
+
+	var numRows int64
+	for _, rows := range p.groupRows {
+		numRows += rows
+	}
+
+	meta := thriftWriter{}
+	meta.i32(1, 1)
+
+	// the schema is a root with the flat columns as children
+	meta.listBegin(2, thriftStruct, len(p.columns)+1)
+	meta.elemBegin()
+	meta.binary(4, "schema")
+	meta.i32(5, int32(len(p.columns)))
+	meta.elemEnd()
+	for _, column := range p.columns {
+		meta.elemBegin()
+		meta.i32(1, column.kind)
+		if column.optional {
+			meta.i32(3, 1)
+		} else {
+			meta.i32(3, 0)
+		}
+		meta.binary(4, column.name)
+		if column.converted != parquetNone {
+			meta.i32(6, column.converted)
+		}
+		meta.elemEnd()
+	}
+
+	meta.i64(3, numRows)
+
+	meta.listBegin(4, thriftStruct, len(p.groupRows))
+	for group, rows := range p.groupRows {
+		var size int64
+		for _, column := range p.columns {
+			size += column.chunks[group].size
+		}
+
+		meta.elemBegin()
+		meta.listBegin(1, thriftStruct, len(p.columns))
+		for _, column := range p.columns {
+			chunk := column.chunks[group]
+			meta.elemBegin()
+			meta.i64(2, chunk.offset)
+			meta.beginStruct(3)
+			meta.i32(1, column.kind)
+			meta.listBegin(2, thriftI32, 2)
+			meta.elemI32(0)
+			meta.elemI32(3)
+			meta.listBegin(3, thriftBinary, 1)
+			meta.elemBinary(column.name)
+			meta.i32(4, 0)
+			meta.i64(5, chunk.numValues)
+			meta.i64(6, chunk.size)
+			meta.i64(7, chunk.size)
+			meta.i64(9, chunk.offset)
+			meta.endStruct()
+			meta.elemEnd()
+		}
+		meta.i64(2, size)
+		meta.i64(3, rows)
+		meta.elemEnd()
+	}
+
+	meta.binary(6, "dist-traceroute")
+	meta.stop()
+
+	if err := p.write(meta.buf.Bytes()); err != nil {
+		return err
+	}
+	footer := make([]byte, 4)
+	binary.LittleEndian.PutUint32(footer, uint32(meta.buf.Len()))
+	return p.write(append(footer, []byte("PAR1")...))
+}
+
+// parquetRLE encodes definition levels of bit width 1 as runs of the RLE/bit-packing hybrid encoding
+func parquetRLE(levels []bool) []byte {
+
+	var out bytes.Buffer
+	varint := make([]byte, binary.MaxVarintLen64)
+	for start := 0; start < len(levels); {
+		end := start
+		for end < len(levels) && levels[end] == levels[start] {
+			end++
+		}
+		out.Write(varint[:binary.PutUvarint(varint, uint64(end-start)<<1)])
+		if levels[start] {
+			out.WriteByte(1)
+		} else {
+			out.WriteByte(0)
+		}
+		start = end
+	}
+	return out.Bytes()
+}
+
+// types of the thrift compact protocol
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter encodes the parquet metadata in the thrift compact protocol
+type thriftWriter struct {
+	buf   bytes.Buffer
+	last  int16
+	stack []int16
+}
+
+func (t *thriftWriter) varint(v uint64) {
+	b := make([]byte, binary.MaxVarintLen64)
+	t.buf.Write(b[:binary.PutUvarint(b, v)])
+}
+
+func (t *thriftWriter) zigzag(v int64) {
+	t.varint(uint64((v << 1) ^ (v >> 63)))
+}
+
+// field writes the header of a field, the ID is encoded as delta to the previous field if possible
+func (t *thriftWriter) field(id int16, kind byte) {
+	if delta := id - t.last; delta > 0 && delta <= 15 {
+		t.buf.WriteByte(byte(delta)<<4 | kind)
+	} else {
+		t.buf.WriteByte(kind)
+		t.zigzag(int64(id))
+	}
+	t.last = id
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.field(id, thriftI32)
+	t.zigzag(int64(v))
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.field(id, thriftI64)
+	t.zigzag(v)
+}
+
+func (t *thriftWriter) binary(id int16, v string) {
+	t.field(id, thriftBinary)
+	t.elemBinary(v)
+}
+
+// beginStruct starts a struct field, the IDs of its fields start over
+func (t *thriftWriter) beginStruct(id int16) {
+	t.field(id, thriftStruct)
+	t.elemBegin()
+}
+
+func (t *thriftWriter) endStruct() {
+	t.elemEnd()
+}
+
+// stop ends the outermost struct
+func (t *thriftWriter) stop() {
+	t.buf.WriteByte(0)
+}
+
+func (t *thriftWriter) listBegin(id int16, kind byte, size int) {
+	t.field(id, thriftList)
+	if size < 15 {
+		t.buf.WriteByte(byte(size)<<4 | kind)
+	} else {
+		t.buf.WriteByte(0xf0 | kind)
+		t.varint(uint64(size))
+	}
+}
+
+// elemBegin starts a struct element of a list
+func (t *thriftWriter) elemBegin() {
+	t.stack = append(t.stack, t.last)
+	t.last = 0
+}
+
+// elemEnd ends a struct element of a list
+func (t *thriftWriter) elemEnd() {
+	t.buf.WriteByte(0)
+	t.last = t.stack[len(t.stack)-1]
+	t.stack = t.stack[:len(t.stack)-1]
+}
+
+func (t *thriftWriter) elemI32(v int32) {
+	t.zigzag(int64(v))
+}
+
+func (t *thriftWriter) elemBinary(v string) {
+	t.varint(uint64(len(v)))
+	t.buf.WriteString(v)
+}
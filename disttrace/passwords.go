@@ -0,0 +1,88 @@
+package disttrace
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// parameters of argon2id password hashes, the second recommended option of RFC 9106 with less memory
+const (
+	passwordHashTime    = 3
+	passwordHashMemory  = 64 * 1024
+	passwordHashThreads = 2
+	passwordHashLength  = 32
+	passwordSaltLength  = 16
+)
+
+// passwordHashPrefix starts all argon2id hashes, other hashes are salted SHA-256 of older versions
+var passwordHashPrefix = []byte("$argon2id$")
+
+// hashPassword returns the argon2id hash of a password with a random salt in the PHC string format
+func hashPassword(password []byte) ([]byte, error) {
+
+	salt := make([]byte, passwordSaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		log.Warn("hashPassword: Error while collecting randomness, Error: ", err)
+		return nil, errors.New("Couldn't generate salt")
+	}
+
+	hash := argon2.IDKey(password, salt, passwordHashTime, passwordHashMemory, passwordHashThreads, passwordHashLength)
+	return []byte(fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, passwordHashMemory,
+		passwordHashTime, passwordHashThreads, base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(hash))), nil
+}
+
+// verifyPassword checks a password against its stored hash, legacySalt is the salt of SHA-256 hashes. needsRehash is
+// set for matching passwords whose hash isn't argon2id with the current parameters.
+func verifyPassword(password []byte, stored []byte, legacySalt int) (ok bool, needsRehash bool) {
+
+	if !bytes.HasPrefix(stored, passwordHashPrefix) {
+		hash := sha256.Sum256(append(append([]byte{}, password...), []byte(strconv.Itoa(legacySalt))...))
+		return subtle.ConstantTimeCompare(hash[:], stored) == 1, true
+	}
+
+	parts := bytes.Split(stored, []byte("$"))
+	if len(parts) != 6 {
+		log.Warn("verifyPassword: Invalid argon2id hash")
+		return false, false
+	}
+
+	var version int
+	var memory, iterations uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(string(parts[2]), "v=%d", &version); err != nil {
+		log.Warn("verifyPassword: Invalid version of argon2id hash, Error: ", err)
+		return false, false
+	}
+	if _, err := fmt.Sscanf(string(parts[3]), "m=%d,t=%d,p=%d", &memory, &iterations, &threads); err != nil {
+		log.Warn("verifyPassword: Invalid parameters of argon2id hash, Error: ", err)
+		return false, false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(string(parts[4]))
+	if err != nil {
+		log.Warn("verifyPassword: Invalid salt of argon2id hash, Error: ", err)
+		return false, false
+	}
+	hash, err := base64.RawStdEncoding.DecodeString(string(parts[5]))
+	if err != nil || len(hash) == 0 {
+		log.Warn("verifyPassword: Invalid argon2id hash, Error: ", err)
+		return false, false
+	}
+
+	computed := argon2.IDKey(password, salt, iterations, memory, threads, uint32(len(hash)))
+	if subtle.ConstantTimeCompare(computed, hash) != 1 {
+		return false, false
+	}
+
+	needsRehash = version != argon2.Version || memory != passwordHashMemory || iterations != passwordHashTime ||
+		threads != passwordHashThreads || len(hash) != passwordHashLength
+	return true, needsRehash
+}
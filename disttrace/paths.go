@@ -0,0 +1,411 @@
+package disttrace
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// PathHop is a single hop of a path
+type PathHop struct {
+	Index         int
+	IPAddress     string
+	DNSName       string
+	PrevIPAddress string
+}
+
+// PathSighting holds how often and when a slave has seen a path to a target
+type PathSighting struct {
+	PathID    string
+	SlaveID   uuid.UUID
+	TargetID  uuid.UUID
+	FirstSeen time.Time
+	LastSeen  time.Time
+	Count     int64
+	Hops      []PathHop
+}
+
+// pathCacheSize is the number of paths whose hops are kept in memory
+const pathCacheSize = 10000
+
+// pathCache holds the hops of recently read paths, paths never change once stored. The least recently used path is
+// evicted when the cache is full.
+var pathCache = struct {
+	lock  sync.Mutex
+	paths map[string]*list.Element
+	order *list.List
+}{paths: make(map[string]*list.Element), order: list.New()}
+
+// cachedPath is an entry of the pathCache
+type cachedPath struct {
+	ID   string
+	Hops []PathHop
+}
+
+// getCachedPath returns the hops of a path from the pathCache
+func getCachedPath(pathID string) ([]PathHop, bool) {
+
+	pathCache.lock.Lock()
+	defer pathCache.lock.Unlock()
+
+	elem, cached := pathCache.paths[pathID]
+	if !cached {
+		return nil, false
+	}
+	pathCache.order.MoveToFront(elem)
+	return elem.Value.(*cachedPath).Hops, true
+}
+
+// cachePath adds the hops of a path to the pathCache and evicts the least recently used path if it's full
+func cachePath(pathID string, hops []PathHop) {
+
+	pathCache.lock.Lock()
+	defer pathCache.lock.Unlock()
+
+	if elem, cached := pathCache.paths[pathID]; cached {
+		pathCache.order.MoveToFront(elem)
+		return
+	}
+
+	pathCache.paths[pathID] = pathCache.order.PushFront(&cachedPath{ID: pathID, Hops: hops})
+	if pathCache.order.Len() > pathCacheSize {
+		oldest := pathCache.order.Back()
+		pathCache.order.Remove(oldest)
+		delete(pathCache.paths, oldest.Value.(*cachedPath).ID)
+	}
+}
+
+// pathFingerprint returns the ID of a path, the hash of its ordered sequence of hop indexes and IP addresses
+func pathFingerprint(hops []PathHop) string {
+
+	var sequence strings.Builder
+	for _, hop := range hops {
+		fmt.Fprintf(&sequence, "%v:%v;", hop.Index, hop.IPAddress)
+	}
+
+	sum := sha256.Sum256([]byte(sequence.String()))
+	return hex.EncodeToString(sum[:])
+}
+
+// pathFromResult splits the hops of a traceroute into its path and the per hop RTT (seconds) and loss vectors
+func pathFromResult(result TraceResult) (hops []PathHop, rtts []float64, loss []float64) {
+
+	hops = make([]PathHop, 0, len(result.Hops))
+	prevIPAddress := "0"
+	for _, hop := range result.Hops {
+		hops = append(hops, PathHop{Index: hop.TTL, IPAddress: hop.AddressString(), DNSName: hop.Host, PrevIPAddress: prevIPAddress})
+		prevIPAddress = hop.AddressString()
+
+		rtts = append(rtts, hop.ElapsedTime.Seconds())
+		if hop.Success {
+			loss = append(loss, 0)
+		} else {
+			loss = append(loss, 1)
+		}
+	}
+
+	return hops, rtts, loss
+}
+
+// encodeVector converts a per hop vector into its stored form
+func encodeVector(vector []float64) string {
+	if vector == nil {
+		return "[]"
+	}
+	encoded, _ := json.Marshal(vector)
+	return string(encoded)
+}
+
+// decodeVector converts a stored per hop vector, missing vectors are returned as empty
+func decodeVector(encoded sql.NullString) []float64 {
+	vector := []float64{}
+	if encoded.Valid {
+		if err := json.Unmarshal([]byte(encoded.String), &vector); err != nil {
+			log.Warn("decodeVector: Couldn't decode hop vector, Error: ", err)
+		}
+	}
+	return vector
+}
+
+// storePath stores a path if it wasn't seen before and returns its ID
+func storePath(tx *Tx, hops []PathHop, seen time.Time) (string, error) {
+
+	pathID := pathFingerprint(hops)
+
+	res, err := tx.Exec("INSERT INTO t_Paths (strPathId, nHopCount, dtFirstSeen) VALUES (?, ?, ?) ON CONFLICT (strPathId) DO NOTHING",
+		pathID, len(hops), seen.UTC().Format(time.RFC3339))
+	if err != nil {
+		log.Warn("storePath: Couldn't insert path, Error: ", err)
+		return "", errors.New("Couldn't insert path")
+	}
+
+	// hops only need to be stored for new paths
+	if numRows, err := res.RowsAffected(); err != nil || numRows == 0 {
+		return pathID, nil
+	}
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO t_PathHops (strPathId, nPosition, nHopIndex, strHopIPAddress, strHopDNSName, strPrevHopIPAddress, nASN)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		`)
+	if err != nil {
+		log.Warn("storePath: Couldn't prepare statement, Error: ", err)
+		return "", errors.New("Couldn't prepare statement")
+	}
+	defer stmt.Close()
+
+	for pos, hop := range hops {
+		if _, err := stmt.Exec(pathID, pos, hop.Index, hop.IPAddress, hop.DNSName, hop.PrevIPAddress, asnNumber(hop.IPAddress)); err != nil {
+			log.Warn("storePath: Couldn't insert hop of path, Error: ", err)
+			return "", errors.New("Couldn't insert hop of path")
+		}
+	}
+
+	log.Debugf("storePath: Stored new path '%v' with %v hops", pathID, len(hops))
+	return pathID, nil
+}
+
+// storePathSighting records that a slave has seen a path to a target
+func storePathSighting(tx *Tx, slaveID uuid.UUID, targetID uuid.UUID, pathID string, seen time.Time, count int) error {
+
+	query := `
+		INSERT INTO t_PathSightings (strSlaveId, strTargetId, strPathId, dtFirstSeen, dtLastSeen, nCount)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (strTargetId, strSlaveId, strPathId) DO UPDATE SET
+			nCount = t_PathSightings.nCount + excluded.nCount,
+			dtFirstSeen = CASE WHEN ` + tx.dialect.TimeExpr("excluded.dtFirstSeen") + ` < ` + tx.dialect.TimeExpr("t_PathSightings.dtFirstSeen") + `
+				THEN excluded.dtFirstSeen ELSE t_PathSightings.dtFirstSeen END,
+			dtLastSeen = CASE WHEN ` + tx.dialect.TimeExpr("excluded.dtLastSeen") + ` > ` + tx.dialect.TimeExpr("t_PathSightings.dtLastSeen") + `
+				THEN excluded.dtLastSeen ELSE t_PathSightings.dtLastSeen END
+		`
+
+	timestamp := seen.UTC().Format(time.RFC3339)
+	if _, err := tx.Exec(query, slaveID, targetID, pathID, timestamp, timestamp, count); err != nil {
+		log.Warn("storePathSighting: Couldn't store path sighting, Error: ", err)
+		return errors.New("Couldn't store path sighting")
+	}
+
+	return nil
+}
+
+// getPathHops returns the hops of a path
+func getPathHops(db *DB, pathID string) ([]PathHop, error) {
+
+	hops, cached := getCachedPath(pathID)
+	if cached {
+		return hops, nil
+	}
+
+	query := `
+		SELECT nHopIndex, strHopIPAddress, strHopDNSName, strPrevHopIPAddress
+		FROM t_PathHops WHERE strPathId = ? ORDER BY nPosition
+		`
+	rows, err := db.Query(query, pathID)
+	if err != nil {
+		log.Warn("getPathHops: Couldn't get hops of path, Error: ", err)
+		return nil, errors.New("Couldn't get hops of path")
+	}
+	defer rows.Close()
+
+	hops = []PathHop{}
+	for rows.Next() {
+		var hop PathHop
+		if err := rows.Scan(&hop.Index, &hop.IPAddress, &hop.DNSName, &hop.PrevIPAddress); err != nil {
+			log.Warn("getPathHops: Couldn't read hops of path, Error: ", err)
+			return nil, errors.New("Couldn't read hops of path")
+		}
+		hops = append(hops, hop)
+	}
+
+	cachePath(pathID, hops)
+
+	return hops, nil
+}
+
+// GetPathSightings returns all paths a slave has seen to a target, most recently seen first.
+// Paths of all slaves are returned if no slave is given.
+func GetPathSightings(db *DB, targetID uuid.UUID, slaveID uuid.UUID) ([]PathSighting, error) {
+
+	log.Debugf("GetPathSightings: fetching paths of slave '%v' to target '%v'", slaveID, targetID)
+	sightings := []PathSighting{}
+
+	query := `
+		SELECT strPathId, strSlaveId, strTargetId, dtFirstSeen, dtLastSeen, nCount
+		FROM t_PathSightings
+		WHERE strTargetId = ?`
+	args := []interface{}{targetID}
+	if slaveID != uuid.Nil {
+		query += " AND strSlaveId = ?"
+		args = append(args, slaveID)
+	}
+	query += " ORDER BY " + db.Dialect.TimeExpr("dtLastSeen") + " DESC"
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		log.Warn("GetPathSightings: Couldn't get path sightings, Error: ", err)
+		return sightings, errors.New("Couldn't get path sightings")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var sighting PathSighting
+		var firstSeen, lastSeen dbTime
+		if err := rows.Scan(&sighting.PathID, &sighting.SlaveID, &sighting.TargetID, &firstSeen, &lastSeen, &sighting.Count); err != nil {
+			log.Warn("GetPathSightings: Couldn't read path sightings, Error: ", err)
+			return []PathSighting{}, errors.New("Couldn't read path sightings")
+		}
+		sighting.FirstSeen, sighting.LastSeen = firstSeen.UTC(), lastSeen.UTC()
+		sightings = append(sightings, sighting)
+	}
+	rows.Close()
+
+	for i := range sightings {
+		if sightings[i].Hops, err = getPathHops(db, sightings[i].PathID); err != nil {
+			return []PathSighting{}, err
+		}
+	}
+
+	log.Debugf("GetPathSightings: returning '%v' paths", len(sightings))
+	return sightings, nil
+}
+
+// convertLegacyHops converts the hops of traceroutes stored before paths were introduced, the legacy table
+// is dropped afterwards. Conversion is done in batches and continues on the next start if interrupted.
+func convertLegacyHops(db *DB) error {
+
+	exists, err := db.tableExists("t_LegacyHops")
+	if err != nil {
+		log.Warn("convertLegacyHops: Couldn't check for legacy hops, Error: ", err)
+		return errors.New("Couldn't check for legacy hops")
+	}
+	if !exists {
+		return nil
+	}
+
+	log.Warn("convertLegacyHops: Converting hops of existing traceroutes into paths, this may take a while...")
+	count := 0
+
+	for {
+		numTraces, err := convertLegacyHopsBatch(db, 500)
+		if err != nil {
+			return err
+		}
+		if numTraces == 0 {
+			break
+		}
+		count += numTraces
+		log.Infof("convertLegacyHops: Converted %v traceroutes...", count)
+	}
+
+	if _, err := db.Exec("DROP TABLE t_LegacyHops"); err != nil {
+		log.Warn("convertLegacyHops: Couldn't drop legacy hops, Error: ", err)
+		return errors.New("Couldn't drop legacy hops")
+	}
+
+	log.Infof("convertLegacyHops: Finished converting %v traceroutes", count)
+	return nil
+}
+
+// convertLegacyHopsBatch converts the hops of up to 'limit' traceroutes, returns the number of converted traceroutes
+func convertLegacyHopsBatch(db *DB, limit int) (numTraces int, err error) {
+
+	var tx *Tx
+	if tx, err = db.Begin(); err != nil {
+		log.Warn("convertLegacyHopsBatch: Couldn't start transaction, Error: ", err)
+		return 0, errors.New("Couldn't start transaction")
+	}
+	// catch errors and rollback!
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	type legacyTrace struct {
+		ID       uuid.UUID
+		SlaveID  uuid.UUID
+		TargetID uuid.UUID
+		Start    dbTime
+	}
+
+	query := fmt.Sprintf("SELECT strTracerouteId, strSlaveId, strTargetId, dtStart FROM t_Traceroutes WHERE strPathId IS NULL LIMIT %v", limit)
+	rows, err := tx.Query(query)
+	if err != nil {
+		log.Warn("convertLegacyHopsBatch: Couldn't get traceroutes, Error: ", err)
+		return 0, errors.New("Couldn't get traceroutes")
+	}
+	traces := []legacyTrace{}
+	for rows.Next() {
+		var trace legacyTrace
+		if err = rows.Scan(&trace.ID, &trace.SlaveID, &trace.TargetID, &trace.Start); err != nil {
+			rows.Close()
+			log.Warn("convertLegacyHopsBatch: Couldn't read traceroutes, Error: ", err)
+			return 0, errors.New("Couldn't read traceroutes")
+		}
+		traces = append(traces, trace)
+	}
+	rows.Close()
+
+	for _, trace := range traces {
+		query := `
+			SELECT nHopIndex, COALESCE(strHopIPAddress, ''), COALESCE(strHopDNSName, ''), COALESCE(dDurationSec, 0)
+			FROM t_LegacyHops WHERE strTracerouteId = ? ORDER BY nHopIndex
+			`
+		if rows, err = tx.Query(query, trace.ID); err != nil {
+			log.Warn("convertLegacyHopsBatch: Couldn't get hops, Error: ", err)
+			return 0, errors.New("Couldn't get hops")
+		}
+
+		// loss wasn't recorded before paths were introduced
+		hops, rtts, loss := []PathHop{}, []float64{}, []float64{}
+		prevIPAddress := "0"
+		for rows.Next() {
+			var hop PathHop
+			var rtt float64
+			if err = rows.Scan(&hop.Index, &hop.IPAddress, &hop.DNSName, &rtt); err != nil {
+				rows.Close()
+				log.Warn("convertLegacyHopsBatch: Couldn't read hops, Error: ", err)
+				return 0, errors.New("Couldn't read hops")
+			}
+			hop.PrevIPAddress = prevIPAddress
+			prevIPAddress = hop.IPAddress
+			hops, rtts, loss = append(hops, hop), append(rtts, rtt), append(loss, 0)
+		}
+		rows.Close()
+
+		var pathID string
+		if pathID, err = storePath(tx, hops, trace.Start.Time); err != nil {
+			return 0, err
+		}
+		if err = storePathSighting(tx, trace.SlaveID, trace.TargetID, pathID, trace.Start.Time, 1); err != nil {
+			return 0, err
+		}
+
+		query = "UPDATE t_Traceroutes SET strPathId = ?, strHopRTTs = ?, strHopLoss = ? WHERE strTracerouteId = ?"
+		if _, err = tx.Exec(query, pathID, encodeVector(rtts), encodeVector(loss), trace.ID); err != nil {
+			log.Warn("convertLegacyHopsBatch: Couldn't update traceroute, Error: ", err)
+			return 0, errors.New("Couldn't update traceroute")
+		}
+
+		if _, err = tx.Exec("DELETE FROM t_LegacyHops WHERE strTracerouteId = ?", trace.ID); err != nil {
+			log.Warn("convertLegacyHopsBatch: Couldn't delete converted hops, Error: ", err)
+			return 0, errors.New("Couldn't delete converted hops")
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Warn("convertLegacyHopsBatch: Couldn't commit transaction, Error: ", err)
+		return 0, errors.New("Couldn't commit transaction")
+	}
+
+	return len(traces), nil
+}
@@ -0,0 +1,570 @@
+package disttrace
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// kinds of provisioned objects
+const (
+	provisionSlave       = "slave"
+	provisionTarget      = "target"
+	provisionTargetGroup = "target group"
+	provisionSlaveGroup  = "slave group"
+)
+
+// actions of provisioning changes
+const (
+	ProvisionCreate  = "create"
+	ProvisionUpdate  = "update"
+	ProvisionRestore = "restore"
+	ProvisionArchive = "archive"
+	ProvisionDelete  = "delete"
+)
+
+// ProvisioningChange is a change of a single slave, target or group needed to make the db match a provisioning document
+type ProvisioningChange struct {
+	Action  string
+	Kind    string
+	Name    string
+	Details []string
+	apply   func(db *DB, ids provisionedIDs) error
+}
+
+// ProvisioningPlan holds all changes needed to make the db match a provisioning document
+type ProvisioningPlan struct {
+	Changes []ProvisioningChange
+	ids     provisionedIDs
+}
+
+// provisionedIDs maps the names of slaves, targets and target groups to their IDs, created objects are added on apply
+type provisionedIDs struct {
+	slaves       map[string]uuid.UUID
+	targets      map[string]uuid.UUID
+	targetGroups map[string]uuid.UUID
+}
+
+// String returns the change in a single line, e.g. "~ update target 'lkml' (address: lkml.org -> kernel.org)"
+func (change ProvisioningChange) String() string {
+
+	symbol := "~"
+	switch change.Action {
+	case ProvisionCreate:
+		symbol = "+"
+	case ProvisionArchive, ProvisionDelete:
+		symbol = "-"
+	}
+
+	text := fmt.Sprintf("%v %v %v '%v'", symbol, change.Action, change.Kind, change.Name)
+	if len(change.Details) > 0 {
+		text += " (" + strings.Join(change.Details, ", ") + ")"
+	}
+	return text
+}
+
+// Summary returns the number of changes by action
+func (plan ProvisioningPlan) Summary() string {
+
+	if len(plan.Changes) == 0 {
+		return "No changes, the database matches the document."
+	}
+
+	count := make(map[string]int)
+	for _, change := range plan.Changes {
+		count[change.Action]++
+	}
+	return fmt.Sprintf("%v to create, %v to update, %v to restore, %v to archive, %v to delete.",
+		count[ProvisionCreate], count[ProvisionUpdate], count[ProvisionRestore], count[ProvisionArchive], count[ProvisionDelete])
+}
+
+// add appends a change to the plan
+func (plan *ProvisioningPlan) add(action string, kind string, name string, details []string, apply func(db *DB, ids provisionedIDs) error) {
+	plan.Changes = append(plan.Changes, ProvisioningChange{Action: action, Kind: kind, Name: name, Details: details, apply: apply})
+}
+
+// ReadProvisioningDocument reads a JSON or YAML provisioning document, the format is determined by the file extension
+func ReadProvisioningDocument(fileName string) (ConfigDocument, error) {
+
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return ConfigDocument{}, fmt.Errorf("Can't read provisioning file: %v", err)
+	}
+
+	doc, err := UnmarshalConfigDocument(data, ConfigFormatFromFileName(fileName))
+	if err != nil {
+		return ConfigDocument{}, fmt.Errorf("Invalid provisioning file '%v': %v", fileName, err)
+	}
+	return doc, nil
+}
+
+// ProvisionFromFile reconciles the slaves, targets and groups in the db with the provisioning document
+func ProvisionFromFile(db *DB, fileName string) (ProvisioningPlan, error) {
+
+	log.Info("ProvisionFromFile: Reconciling database with provisioning file: ", fileName)
+
+	doc, err := ReadProvisioningDocument(fileName)
+	if err != nil {
+		return ProvisioningPlan{}, err
+	}
+
+	plan, err := PlanProvisioning(db, doc)
+	if err != nil {
+		return ProvisioningPlan{}, err
+	}
+
+	if err := ApplyProvisioning(db, plan); err != nil {
+		return plan, err
+	}
+
+	log.Info("ProvisionFromFile: Finished reconciling, ", plan.Summary())
+	return plan, nil
+}
+
+// PlanProvisioning compares the slaves, targets and groups of the document with the db and returns the changes needed
+// to match the document: missing objects are created or restored from the archive, differing ones updated, slaves and
+// targets not in the document are archived and groups not in the document are deleted. Users aren't provisioned.
+func PlanProvisioning(db *DB, doc ConfigDocument) (ProvisioningPlan, error) {
+
+	doc = applyProvisioningDefaults(doc)
+	if err := validateProvisioningDocument(doc); err != nil {
+		return ProvisioningPlan{}, err
+	}
+	if len(doc.Users) > 0 {
+		log.Warnf("PlanProvisioning: Ignoring %v users of the document, users aren't provisioned", len(doc.Users))
+	}
+
+	plan := ProvisioningPlan{ids: provisionedIDs{
+		slaves:       make(map[string]uuid.UUID),
+		targets:      make(map[string]uuid.UUID),
+		targetGroups: make(map[string]uuid.UUID),
+	}}
+
+	slaveNames, err := planSlaves(db, doc, &plan)
+	if err != nil {
+		return ProvisioningPlan{}, err
+	}
+
+	targetNames, err := planTargets(db, doc, &plan)
+	if err != nil {
+		return ProvisioningPlan{}, err
+	}
+
+	targetGroupNames, err := planTargetGroups(db, doc, targetNames, &plan)
+	if err != nil {
+		return ProvisioningPlan{}, err
+	}
+
+	if err := planSlaveGroups(db, doc, slaveNames, targetGroupNames, &plan); err != nil {
+		return ProvisioningPlan{}, err
+	}
+
+	log.Debug("PlanProvisioning: ", plan.Summary())
+	return plan, nil
+}
+
+// ApplyProvisioning executes the changes of the plan in order, it stops at the first failed change
+func ApplyProvisioning(db *DB, plan ProvisioningPlan) error {
+
+	for _, change := range plan.Changes {
+		log.Info("ApplyProvisioning: ", change)
+		if err := change.apply(db, plan.ids); err != nil {
+			log.Warnf("ApplyProvisioning: Couldn't %v %v '%v', Error: %v", change.Action, change.Kind, change.Name, err)
+			return fmt.Errorf("Couldn't %v %v '%v': %v", change.Action, change.Kind, change.Name, err)
+		}
+	}
+	return nil
+}
+
+// applyProvisioningDefaults returns the document with the unset probe parameters of its targets taken from their
+// first group in the document or the defaults of new targets
+func applyProvisioningDefaults(doc ConfigDocument) ConfigDocument {
+
+	groupOfTarget := make(map[string]TargetGroup)
+	for _, group := range doc.TargetGroups {
+		for _, name := range group.Targets {
+			if _, ok := groupOfTarget[name]; !ok {
+				groupOfTarget[name] = TargetGroup{Retries: group.Retries, MaxHops: group.MaxHops, TimeoutMs: group.TimeoutMs}
+			}
+		}
+	}
+
+	targets := []ConfigTarget{}
+	for _, docTarget := range doc.Targets {
+		target := TraceTarget{Retries: docTarget.Retries, MaxHops: docTarget.MaxHops, TimeoutMs: docTarget.TimeoutMs}
+		groupOfTarget[docTarget.Name].ApplyDefaults(&target)
+		applyTargetDefaults(&target)
+		docTarget.Retries, docTarget.MaxHops, docTarget.TimeoutMs = target.Retries, target.MaxHops, target.TimeoutMs
+		targets = append(targets, docTarget)
+	}
+
+	doc.Targets = targets
+	return doc
+}
+
+// validateProvisioningDocument checks the entries of the document, their names must be unique
+// and groups may only reference members of the document
+func validateProvisioningDocument(doc ConfigDocument) error {
+
+	if err := validateConfigDocument(doc); err != nil {
+		return err
+	}
+
+	slaves, targets, targetGroups := make(map[string]bool), make(map[string]bool), make(map[string]bool)
+	for _, slave := range doc.Slaves {
+		if slaves[slave.Name] {
+			return fmt.Errorf("Slave '%v' is defined more than once", slave.Name)
+		}
+		slaves[slave.Name] = true
+	}
+	for _, target := range doc.Targets {
+		if targets[target.Name] {
+			return fmt.Errorf("Target '%v' is defined more than once", target.Name)
+		}
+		targets[target.Name] = true
+	}
+	for _, group := range doc.TargetGroups {
+		if targetGroups[group.Name] {
+			return fmt.Errorf("Target group '%v' is defined more than once", group.Name)
+		}
+		targetGroups[group.Name] = true
+		for _, name := range group.Targets {
+			if !targets[name] {
+				return fmt.Errorf("Target group '%v' references target '%v' which isn't in the document", group.Name, name)
+			}
+		}
+	}
+
+	slaveGroups := make(map[string]bool)
+	for _, group := range doc.SlaveGroups {
+		if slaveGroups[group.Name] {
+			return fmt.Errorf("Slave group '%v' is defined more than once", group.Name)
+		}
+		slaveGroups[group.Name] = true
+		for _, name := range group.Slaves {
+			if !slaves[name] {
+				return fmt.Errorf("Slave group '%v' references slave '%v' which isn't in the document", group.Name, name)
+			}
+		}
+		for _, name := range group.TargetGroups {
+			if !targetGroups[name] {
+				return fmt.Errorf("Slave group '%v' references target group '%v' which isn't in the document", group.Name, name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// planSlaves adds the changes of the slaves to the plan, returns the names of all slaves in the db by ID
+func planSlaves(db *DB, doc ConfigDocument, plan *ProvisioningPlan) (map[uuid.UUID]string, error) {
+
+	active, err := GetSlaves(db)
+	if err != nil {
+		return nil, err
+	}
+	archived, err := GetArchivedSlaves(db)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[uuid.UUID]string)
+	existing := make(map[string]Slave)
+	for _, slave := range append(active, archived...) {
+		names[slave.ID] = slave.Name
+		existing[slave.Name] = slave
+		plan.ids.slaves[slave.Name] = slave.ID
+	}
+
+	wanted := make(map[string]bool)
+	for _, docSlave := range doc.Slaves {
+		wanted[docSlave.Name] = true
+		slave := Slave{Name: docSlave.Name, Secret: docSlave.Secret}
+
+		current, ok := existing[slave.Name]
+		if !ok {
+			plan.add(ProvisionCreate, provisionSlave, slave.Name, nil, func(db *DB, ids provisionedIDs) error {
+				created, err := CreateSlave(db, slave)
+				ids.slaves[slave.Name] = created.ID
+				return err
+			})
+			continue
+		}
+
+		slave.ID = current.ID
+		details := []string{}
+		if slave.Secret != current.Secret {
+			details = append(details, "secret changed")
+		}
+		update := func(db *DB, ids provisionedIDs) error {
+			_, err := UpdateSlave(db, slave)
+			return err
+		}
+
+		switch {
+		case current.PurgeRequested != nil:
+			return nil, fmt.Errorf("Slave '%v' is being purged, it can be provisioned again after the purge finished", slave.Name)
+		case current.Archived != nil:
+			plan.add(ProvisionRestore, provisionSlave, slave.Name, details, func(db *DB, ids provisionedIDs) error {
+				if err := RestoreSlave(db, slave.ID); err != nil {
+					return err
+				}
+				return update(db, ids)
+			})
+		case len(details) > 0:
+			plan.add(ProvisionUpdate, provisionSlave, slave.Name, details, update)
+		}
+	}
+
+	for _, slave := range active {
+		if slaveID := slave.ID; !wanted[slave.Name] {
+			plan.add(ProvisionArchive, provisionSlave, slave.Name, nil, func(db *DB, ids provisionedIDs) error {
+				return ArchiveSlave(db, slaveID)
+			})
+		}
+	}
+
+	return names, nil
+}
+
+// planTargets adds the changes of the targets to the plan, returns the names of all targets in the db by ID
+func planTargets(db *DB, doc ConfigDocument, plan *ProvisioningPlan) (map[uuid.UUID]string, error) {
+
+	active, err := GetTargets(db)
+	if err != nil {
+		return nil, err
+	}
+	archived, err := GetArchivedTargets(db)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[uuid.UUID]string)
+	existing := make(map[string]TraceTarget)
+	for _, target := range append(active, archived...) {
+		names[target.ID] = target.Name
+		existing[target.Name] = target
+		plan.ids.targets[target.Name] = target.ID
+	}
+
+	wanted := make(map[string]bool)
+	for _, docTarget := range doc.Targets {
+		wanted[docTarget.Name] = true
+		target := TraceTarget{
+			Name: docTarget.Name, Address: docTarget.Address, Retries: docTarget.Retries, MaxHops: docTarget.MaxHops, TimeoutMs: docTarget.TimeoutMs,
+		}
+
+		current, ok := existing[target.Name]
+		if !ok {
+			plan.add(ProvisionCreate, provisionTarget, target.Name, []string{"address " + target.Address}, func(db *DB, ids provisionedIDs) error {
+				created, err := CreateTarget(db, target)
+				ids.targets[target.Name] = created.ID
+				return err
+			})
+			continue
+		}
+
+		target.ID = current.ID
+		details := []string{}
+		details = appendDifference(details, "address", current.Address, target.Address)
+		details = appendDifference(details, "retries", current.Retries, target.Retries)
+		details = appendDifference(details, "maxHops", current.MaxHops, target.MaxHops)
+		details = appendDifference(details, "timeoutMs", current.TimeoutMs, target.TimeoutMs)
+		update := func(db *DB, ids provisionedIDs) error {
+			_, err := UpdateTarget(db, target)
+			return err
+		}
+
+		switch {
+		case current.PurgeRequested != nil:
+			return nil, fmt.Errorf("Target '%v' is being purged, it can be provisioned again after the purge finished", target.Name)
+		case current.Archived != nil:
+			plan.add(ProvisionRestore, provisionTarget, target.Name, details, func(db *DB, ids provisionedIDs) error {
+				if err := RestoreTarget(db, target.ID); err != nil {
+					return err
+				}
+				return update(db, ids)
+			})
+		case len(details) > 0:
+			plan.add(ProvisionUpdate, provisionTarget, target.Name, details, update)
+		}
+	}
+
+	for _, target := range active {
+		if targetID := target.ID; !wanted[target.Name] {
+			plan.add(ProvisionArchive, provisionTarget, target.Name, nil, func(db *DB, ids provisionedIDs) error {
+				return ArchiveTarget(db, targetID)
+			})
+		}
+	}
+
+	return names, nil
+}
+
+// planTargetGroups adds the changes of the target groups to the plan, returns the names of all target groups by ID
+func planTargetGroups(db *DB, doc ConfigDocument, targetNames map[uuid.UUID]string, plan *ProvisioningPlan) (map[uuid.UUID]string, error) {
+
+	groups, err := GetTargetGroups(db)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[uuid.UUID]string)
+	existing := make(map[string]TargetGroup)
+	for _, group := range groups {
+		names[group.ID] = group.Name
+		existing[group.Name] = group
+		plan.ids.targetGroups[group.Name] = group.ID
+	}
+
+	wanted := make(map[string]bool)
+	for _, docGroup := range doc.TargetGroups {
+		wanted[docGroup.Name] = true
+		group := TargetGroup{Name: docGroup.Name, Retries: docGroup.Retries, MaxHops: docGroup.MaxHops, TimeoutMs: docGroup.TimeoutMs}
+		members := docGroup.Targets
+
+		current, ok := existing[group.Name]
+		if !ok {
+			plan.add(ProvisionCreate, provisionTargetGroup, group.Name, nil, func(db *DB, ids provisionedIDs) error {
+				group.TargetIDs = idsOf(members, ids.targets)
+				created, err := CreateTargetGroup(db, group)
+				ids.targetGroups[group.Name] = created.ID
+				return err
+			})
+			continue
+		}
+
+		group.ID = current.ID
+		details := []string{}
+		details = appendDifference(details, "retries", current.Retries, group.Retries)
+		details = appendDifference(details, "maxHops", current.MaxHops, group.MaxHops)
+		details = appendDifference(details, "timeoutMs", current.TimeoutMs, group.TimeoutMs)
+		details = appendMembersDifference(details, "targets", namesOf(current.TargetIDs, targetNames), members)
+		if len(details) > 0 {
+			plan.add(ProvisionUpdate, provisionTargetGroup, group.Name, details, func(db *DB, ids provisionedIDs) error {
+				group.TargetIDs = idsOf(members, ids.targets)
+				_, err := UpdateTargetGroup(db, group)
+				return err
+			})
+		}
+	}
+
+	for _, group := range groups {
+		if groupID := group.ID; !wanted[group.Name] {
+			plan.add(ProvisionDelete, provisionTargetGroup, group.Name, nil, func(db *DB, ids provisionedIDs) error {
+				return DeleteTargetGroup(db, groupID)
+			})
+		}
+	}
+
+	return names, nil
+}
+
+// planSlaveGroups adds the changes of the slave groups to the plan
+func planSlaveGroups(db *DB, doc ConfigDocument, slaveNames map[uuid.UUID]string, targetGroupNames map[uuid.UUID]string, plan *ProvisioningPlan) error {
+
+	groups, err := GetSlaveGroups(db)
+	if err != nil {
+		return err
+	}
+
+	existing := make(map[string]SlaveGroup)
+	for _, group := range groups {
+		existing[group.Name] = group
+	}
+
+	wanted := make(map[string]bool)
+	for _, docGroup := range doc.SlaveGroups {
+		wanted[docGroup.Name] = true
+		group := SlaveGroup{Name: docGroup.Name}
+		slaves, targetGroups := docGroup.Slaves, docGroup.TargetGroups
+		fillMembers := func(ids provisionedIDs) {
+			group.SlaveIDs = idsOf(slaves, ids.slaves)
+			group.TargetGroupIDs = idsOf(targetGroups, ids.targetGroups)
+		}
+
+		current, ok := existing[group.Name]
+		if !ok {
+			plan.add(ProvisionCreate, provisionSlaveGroup, group.Name, nil, func(db *DB, ids provisionedIDs) error {
+				fillMembers(ids)
+				_, err := CreateSlaveGroup(db, group)
+				return err
+			})
+			continue
+		}
+
+		group.ID = current.ID
+		details := []string{}
+		details = appendMembersDifference(details, "slaves", namesOf(current.SlaveIDs, slaveNames), slaves)
+		details = appendMembersDifference(details, "targetGroups", namesOf(current.TargetGroupIDs, targetGroupNames), targetGroups)
+		if len(details) > 0 {
+			plan.add(ProvisionUpdate, provisionSlaveGroup, group.Name, details, func(db *DB, ids provisionedIDs) error {
+				fillMembers(ids)
+				_, err := UpdateSlaveGroup(db, group)
+				return err
+			})
+		}
+	}
+
+	for _, group := range groups {
+		if groupID := group.ID; !wanted[group.Name] {
+			plan.add(ProvisionDelete, provisionSlaveGroup, group.Name, nil, func(db *DB, ids provisionedIDs) error {
+				return DeleteSlaveGroup(db, groupID)
+			})
+		}
+	}
+
+	return nil
+}
+
+// appendDifference appends "name: current -> wanted" to the details if the values differ
+func appendDifference(details []string, name string, current interface{}, wanted interface{}) []string {
+	if current != wanted {
+		details = append(details, fmt.Sprintf("%v: %v -> %v", name, current, wanted))
+	}
+	return details
+}
+
+// appendMembersDifference appends the added and removed members of a group to the details
+func appendMembersDifference(details []string, name string, current []string, wanted []string) []string {
+
+	currentSet, wantedSet := make(map[string]bool), make(map[string]bool)
+	for _, member := range current {
+		currentSet[member] = true
+	}
+	for _, member := range wanted {
+		wantedSet[member] = true
+	}
+
+	changes := []string{}
+	for _, member := range wanted {
+		if !currentSet[member] {
+			changes = append(changes, "+"+member)
+		}
+	}
+	for _, member := range current {
+		if !wantedSet[member] {
+			changes = append(changes, "-"+member)
+		}
+	}
+	if len(changes) == 0 {
+		return details
+	}
+
+	sort.Strings(changes)
+	return append(details, name+": "+strings.Join(changes, " "))
+}
+
+// idsOf returns the IDs of the given names
+func idsOf(names []string, ids map[string]uuid.UUID) []uuid.UUID {
+	result := []uuid.UUID{}
+	for _, name := range names {
+		if id, ok := ids[name]; ok {
+			result = append(result, id)
+		}
+	}
+	return result
+}
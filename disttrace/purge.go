@@ -0,0 +1,138 @@
+package disttrace
+
+import (
+	"errors"
+	"time"
+)
+
+// PurgeProcRunning mutex for graceful shutdown
+var PurgeProcRunning = make(chan bool, 1)
+
+// ErrNotArchived is returned when restoring or purging a slave or target which isn't archived or already being purged
+var ErrNotArchived = errors.New("Not archived or already being purged")
+
+// purgeBatchSize is the number of traceroutes deleted within a single statement
+const purgeBatchSize = 1000
+
+// purgeKind describes a table whose rows can be purged together with their traceroutes
+type purgeKind struct {
+	Name   string
+	Table  string
+	Column string
+}
+
+// purgeKinds lists the tables the Purger deletes from, t_Traceroutes references them with the same column name
+var purgeKinds = []purgeKind{
+	{Name: "target", Table: "t_Targets", Column: "strTargetId"},
+	{Name: "slave", Table: "t_Slaves", Column: "strSlaveId"},
+}
+
+// Purger runs as process, deletes archived slaves and targets whose purge was requested with all their results
+func Purger(db *DB) {
+
+	// lock mutex
+	PurgeProcRunning <- true
+
+	// init vars
+	var nextTime time.Time
+
+	// infinite loop
+	log.Info("Purger: Start...")
+	for {
+		// check if we need to exit
+		if CheckForQuit() {
+			log.Warn("Purger: Received exit signal, bye.")
+			<-PurgeProcRunning
+			return
+		}
+
+		// is it time to run?
+		if nextTime.Before(time.Now()) {
+			for _, kind := range purgeKinds {
+				if err := purgeRequested(db, kind); err != nil {
+					log.Warnf("Purger: Couldn't purge %vs, Error: %v", kind.Name, err)
+				}
+			}
+
+			nextTime = time.Now().Add(10 * time.Second)
+		}
+
+		// zzz...
+		time.Sleep(1 * time.Second)
+	}
+}
+
+// purgeRequested deletes all rows of the given kind whose purge was requested
+func purgeRequested(db *DB, kind purgeKind) error {
+
+	query := "SELECT " + kind.Column + " FROM " + kind.Table + " WHERE dtPurgeRequested IS NOT NULL"
+	rows, err := db.Query(query)
+	if err != nil {
+		log.Warnf("purgeRequested: Couldn't get %vs to purge, Error: %v", kind.Name, err)
+		return errors.New("Couldn't get purge requests")
+	}
+
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			log.Warnf("purgeRequested: Couldn't read %vs to purge, Error: %v", kind.Name, err)
+			return errors.New("Couldn't read purge requests")
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	for _, id := range ids {
+		if err := purge(db, kind, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// purge deletes the traceroutes of a slave or target in batches to keep the transactions short, everything
+// else is deleted by cascade with the slave or target itself. An interrupted purge is resumed on the next start.
+func purge(db *DB, kind purgeKind, id string) error {
+
+	log.Infof("purge: Purging %v '%v'...", kind.Name, id)
+
+	query := `
+		DELETE FROM t_Traceroutes WHERE strTracerouteId IN (
+			SELECT strTracerouteId FROM t_Traceroutes WHERE ` + kind.Column + ` = ? LIMIT ?
+		)`
+
+	var numTraces int64
+	for {
+		if CheckForQuit() {
+			log.Infof("purge: Interrupted purge of %v '%v' after %v traceroutes", kind.Name, id, numTraces)
+			return nil
+		}
+
+		res, err := db.Exec(query, id, purgeBatchSize)
+		if err != nil {
+			log.Warnf("purge: Couldn't delete traceroutes of %v '%v', Error: %v", kind.Name, id, err)
+			return errors.New("Couldn't delete traceroutes")
+		}
+
+		numRows, err := res.RowsAffected()
+		if err != nil {
+			log.Warn("purge: Error: Can't get number of affected rows, Error: ", err)
+			return errors.New("DB Error")
+		}
+		if numRows == 0 {
+			break
+		}
+		numTraces += numRows
+	}
+
+	query = "DELETE FROM " + kind.Table + " WHERE " + kind.Column + " = ? AND dtPurgeRequested IS NOT NULL"
+	if _, err := db.Exec(query, id); err != nil {
+		log.Warnf("purge: Couldn't delete %v '%v', Error: %v", kind.Name, id, err)
+		return errors.New("Couldn't delete " + kind.Name)
+	}
+
+	log.Infof("purge: Purged %v '%v' and its %v traceroutes", kind.Name, id, numTraces)
+	return nil
+}
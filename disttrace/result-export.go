@@ -0,0 +1,411 @@
+package disttrace
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// types of export sinks
+const (
+	ExportSinkElasticsearch = "elasticsearch"
+	ExportSinkInfluxDB      = "influxdb"
+	ExportSinkNDJSON        = "ndjson"
+)
+
+// ExportedResult is the document of a stored traceroute result sent to the export sinks
+type ExportedResult struct {
+	ID        uuid.UUID     `json:"id"`
+	Timestamp time.Time     `json:"timestamp"`
+	SlaveID   uuid.UUID     `json:"slaveId"`
+	Slave     string        `json:"slave"`
+	TargetID  uuid.UUID     `json:"targetId"`
+	Target    string        `json:"target"`
+	Address   string        `json:"address"`
+	Success   bool          `json:"success"`
+	HopCount  int           `json:"hopCount"`
+	RTTMs     *float64      `json:"rttMs,omitempty"`
+	Hops      []ExportedHop `json:"hops"`
+}
+
+// ExportedHop is a single hop of an exported traceroute result
+type ExportedHop struct {
+	TTL     int     `json:"ttl"`
+	Address string  `json:"address"`
+	Host    string  `json:"host,omitempty"`
+	RTTMs   float64 `json:"rttMs"`
+	Success bool    `json:"success"`
+}
+
+// metrics of the export sinks
+var (
+	metricExportSent = NewMetricCounter("disttrace_export_sent_total",
+		"Results sent to an export sink.", "sink")
+	metricExportDropped = NewMetricCounter("disttrace_export_dropped_total",
+		"Results dropped by an export sink because its buffer was full or it failed too often.", "sink", "reason")
+	metricExportQueueDepth = NewMetricGauge("disttrace_export_queue_depth",
+		"Results waiting in the buffer of an export sink.", "sink")
+)
+
+// exportRetryPause is the pause after the first failed try of a batch, it doubles with every further try
+var exportRetryPause = time.Second
+
+// ResultExporterProcRunning mutex for graceful shutdown
+var ResultExporterProcRunning = make(chan bool, 1)
+
+// running export sinks, nil while the exporter isn't running
+var resultExport = struct {
+	sync.RWMutex
+	sinks []*exportSink
+}{}
+
+// exportWriter sends a batch of results to a sink
+type exportWriter interface {
+	write(batch []ExportedResult) error
+}
+
+// exportSink buffers the results of a single sink and sends them in batches
+type exportSink struct {
+	cfg    ExportSinkConfig
+	queue  chan ExportedResult
+	writer exportWriter
+}
+
+// ResultExporter runs as process, streams stored results to the configured export sinks. Every sink has its own
+// buffer, results are dropped if a sink can't keep up instead of blocking the ingestion of results.
+func ResultExporter() {
+
+	// lock mutex
+	ResultExporterProcRunning <- true
+
+	var wg sync.WaitGroup
+	sinks := []*exportSink{}
+	for _, cfg := range CurrentMasterConfig().ResultExport.Sinks {
+		sink := newExportSink(cfg)
+		sinks = append(sinks, sink)
+
+		wg.Add(1)
+		go sink.run(&wg)
+	}
+
+	resultExport.Lock()
+	resultExport.sinks = sinks
+	resultExport.Unlock()
+
+	RegisterMetricsCollector(func() {
+		for _, sink := range sinks {
+			metricExportQueueDepth.Set(float64(len(sink.queue)), sink.cfg.Name)
+		}
+	})
+
+	log.Infof("ResultExporter: Start with %v sinks...", len(sinks))
+	for {
+		// check if we need to exit
+		if CheckForQuit() {
+			log.Warn("ResultExporter: Received exit signal, sending remaining results...")
+
+			resultExport.Lock()
+			resultExport.sinks = nil
+			resultExport.Unlock()
+
+			for _, sink := range sinks {
+				close(sink.queue)
+			}
+			wg.Wait()
+
+			log.Warn("ResultExporter: All sinks stopped, bye.")
+			<-ResultExporterProcRunning
+			return
+		}
+
+		time.Sleep(1 * time.Second)
+	}
+}
+
+// ExportResult hands a stored result to all export sinks, never blocks
+func ExportResult(traceID uuid.UUID, result TraceResult) {
+
+	resultExport.RLock()
+	defer resultExport.RUnlock()
+
+	if len(resultExport.sinks) == 0 {
+		return
+	}
+
+	doc := newExportedResult(traceID, result)
+	for _, sink := range resultExport.sinks {
+		select {
+		case sink.queue <- doc:
+		default:
+			log.Warnf("ExportResult: Buffer of sink '%v' is full, dropping result '%v'", sink.cfg.Name, traceID)
+			metricExportDropped.Inc(sink.cfg.Name, "bufferFull")
+		}
+	}
+}
+
+// newExportedResult converts a result into its export document, the RTT is the duration of the last hop
+func newExportedResult(traceID uuid.UUID, result TraceResult) ExportedResult {
+
+	doc := ExportedResult{
+		ID:        traceID,
+		Timestamp: result.DateTime.UTC(),
+		SlaveID:   result.Slave.ID,
+		Slave:     result.Slave.Name,
+		TargetID:  result.Target.ID,
+		Target:    result.Target.Name,
+		Address:   result.Target.Address,
+		Success:   result.Success,
+		HopCount:  result.HopCount,
+		Hops:      []ExportedHop{},
+	}
+
+	for _, hop := range result.Hops {
+		doc.Hops = append(doc.Hops, ExportedHop{
+			TTL:     hop.TTL,
+			Address: hop.AddressString(),
+			Host:    strings.TrimSuffix(hop.Host, "."),
+			RTTMs:   float64(hop.ElapsedTime) / float64(time.Millisecond),
+			Success: hop.Success,
+		})
+	}
+	if len(doc.Hops) > 0 {
+		rtt := doc.Hops[len(doc.Hops)-1].RTTMs
+		doc.RTTMs = &rtt
+	}
+
+	return doc
+}
+
+// newExportSink creates the buffer and the writer of a sink
+func newExportSink(cfg ExportSinkConfig) *exportSink {
+
+	client := &http.Client{Timeout: cfg.Timeout}
+
+	var writer exportWriter
+	switch cfg.Type {
+	case ExportSinkElasticsearch:
+		writer = elasticsearchWriter{cfg: cfg, client: client}
+	case ExportSinkInfluxDB:
+		writer = influxDBWriter{cfg: cfg, client: client}
+	default:
+		writer = ndjsonWriter{cfg: cfg, client: client}
+	}
+
+	return &exportSink{cfg: cfg, queue: make(chan ExportedResult, cfg.BufferSize), writer: writer}
+}
+
+// run collects results into batches and sends them when a batch is full or the flush interval passed.
+// Sends the remaining results once the queue is closed.
+func (s *exportSink) run(wg *sync.WaitGroup) {
+
+	defer wg.Done()
+	log.Debugf("exportSink.run: Sink '%v' of type '%v' started", s.cfg.Name, s.cfg.Type)
+
+	ticker := time.NewTicker(s.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	batch := []ExportedResult{}
+	for {
+		select {
+		case doc, ok := <-s.queue:
+			if !ok {
+				if len(batch) > 0 {
+					s.send(batch)
+				}
+				log.Debugf("exportSink.run: Sink '%v' stopped", s.cfg.Name)
+				return
+			}
+			batch = append(batch, doc)
+			if len(batch) >= s.cfg.BatchSize {
+				s.send(batch)
+				batch = []ExportedResult{}
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				s.send(batch)
+				batch = []ExportedResult{}
+			}
+		}
+	}
+}
+
+// send writes a batch to the sink, retries with increasing pauses and drops the batch after too many attempts
+func (s *exportSink) send(batch []ExportedResult) {
+
+	for try := 1; try <= s.cfg.MaxAttempts; try++ {
+		err := s.writer.write(batch)
+		if err == nil {
+			log.Debugf("exportSink.send: Sent %v results to sink '%v'", len(batch), s.cfg.Name)
+			metricExportSent.Add(float64(len(batch)), s.cfg.Name)
+			return
+		}
+
+		log.Warnf("exportSink.send: Couldn't send %v results to sink '%v', try %v/%v, Error: %v",
+			len(batch), s.cfg.Name, try, s.cfg.MaxAttempts, err)
+
+		if try == s.cfg.MaxAttempts || CheckForQuit() {
+			break
+		}
+		pause := time.Duration(1<<uint(try-1)) * exportRetryPause
+		if pause > 30*exportRetryPause {
+			pause = 30 * exportRetryPause
+		}
+		for waited := time.Duration(0); waited < pause && !CheckForQuit(); waited += exportRetryPause {
+			time.Sleep(exportRetryPause)
+		}
+	}
+
+	log.Warnf("exportSink.send: Giving up on sink '%v', dropping %v results", s.cfg.Name, len(batch))
+	metricExportDropped.Add(float64(len(batch)), s.cfg.Name, "sendFailed")
+}
+
+// postExport posts the body to the url of the sink and returns the response body, fails on non-2xx status codes
+func postExport(client *http.Client, cfg ExportSinkConfig, url string, contentType string, body []byte) ([]byte, error) {
+
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	for name, value := range cfg.Headers {
+		req.Header.Set(name, value)
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	resBody, err := ioutil.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return nil, fmt.Errorf("Unexpected status '%v': %v", res.Status, strings.TrimSpace(string(resBody)))
+	}
+	return resBody, nil
+}
+
+// elasticsearchWriter indexes results with the bulk API, the trace ID is the document ID so retries don't
+// create duplicates
+type elasticsearchWriter struct {
+	cfg    ExportSinkConfig
+	client *http.Client
+}
+
+func (w elasticsearchWriter) write(batch []ExportedResult) error {
+
+	var body bytes.Buffer
+	for _, doc := range batch {
+		action := map[string]map[string]string{"index": {"_index": w.cfg.Index, "_id": doc.ID.String()}}
+		if err := json.NewEncoder(&body).Encode(action); err != nil {
+			return err
+		}
+		if err := json.NewEncoder(&body).Encode(doc); err != nil {
+			return err
+		}
+	}
+
+	resBody, err := postExport(w.client, w.cfg, strings.TrimSuffix(w.cfg.URL, "/")+"/_bulk", "application/x-ndjson", body.Bytes())
+	if err != nil {
+		return err
+	}
+
+	// the bulk API reports errors of single documents in the response
+	response := struct {
+		Errors bool
+		Items  []map[string]struct {
+			Status int
+			Error  json.RawMessage
+		}
+	}{}
+	if err := json.Unmarshal(resBody, &response); err != nil {
+		return fmt.Errorf("Invalid bulk response: %v", err)
+	}
+	if response.Errors {
+		for _, item := range response.Items {
+			for _, result := range item {
+				if len(result.Error) > 0 {
+					return fmt.Errorf("Bulk request failed, status: %v, error: %s", result.Status, result.Error)
+				}
+			}
+		}
+		return errors.New("Bulk request failed")
+	}
+	return nil
+}
+
+// influxDBWriter writes results in the line protocol with nanosecond timestamps, the url is the full write url,
+// e.g. http://influx:8086/api/v2/write?org=org&bucket=disttrace or http://influx:8086/write?db=disttrace
+type influxDBWriter struct {
+	cfg    ExportSinkConfig
+	client *http.Client
+}
+
+func (w influxDBWriter) write(batch []ExportedResult) error {
+
+	var body bytes.Buffer
+	for _, doc := range batch {
+		fmt.Fprintf(&body, "%v,slave=%v,target=%v,address=%v success=%v,hopCount=%vi",
+			escapeInfluxKey(w.cfg.Measurement, false), escapeInfluxKey(doc.Slave, true),
+			escapeInfluxKey(doc.Target, true), escapeInfluxKey(doc.Address, true), doc.Success, doc.HopCount)
+		if doc.RTTMs != nil {
+			body.WriteString(",rttMs=" + strconv.FormatFloat(*doc.RTTMs, 'f', -1, 64))
+		}
+		fmt.Fprintf(&body, " %v\n", doc.Timestamp.UnixNano())
+	}
+
+	_, err := postExport(w.client, w.cfg, w.cfg.URL, "text/plain; charset=utf-8", body.Bytes())
+	return err
+}
+
+// escapeInfluxKey escapes a measurement name or a tag key or value of the line protocol
+func escapeInfluxKey(key string, tag bool) string {
+	if tag {
+		return strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `).Replace(key)
+	}
+	return strings.NewReplacer(",", `\,`, " ", `\ `).Replace(key)
+}
+
+// ndjsonWriter writes a JSON document per line, either posted to a url or appended to a file
+type ndjsonWriter struct {
+	cfg    ExportSinkConfig
+	client *http.Client
+}
+
+func (w ndjsonWriter) write(batch []ExportedResult) error {
+
+	var body bytes.Buffer
+	for _, doc := range batch {
+		if err := json.NewEncoder(&body).Encode(doc); err != nil {
+			return err
+		}
+	}
+
+	if w.cfg.URL != "" {
+		_, err := postExport(w.client, w.cfg, w.cfg.URL, "application/x-ndjson", body.Bytes())
+		return err
+	}
+
+	file, err := os.OpenFile(w.cfg.File, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(body.Bytes()); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
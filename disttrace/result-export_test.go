@@ -0,0 +1,307 @@
+package disttrace
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// exportRecorder is a stand-in HTTP server of a sink, which records the bodies of all requests and fails the
+// first requests if told so
+type exportRecorder struct {
+	sync.Mutex
+	*httptest.Server
+	bodies   []string
+	headers  []http.Header
+	failures int
+	response string
+}
+
+func newExportRecorder() *exportRecorder {
+	rec := &exportRecorder{}
+	rec.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		rec.Lock()
+		defer rec.Unlock()
+		rec.bodies = append(rec.bodies, string(body))
+		rec.headers = append(rec.headers, r.Header)
+		if len(rec.bodies) <= rec.failures {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(rec.response))
+	}))
+	return rec
+}
+
+func (rec *exportRecorder) requests() []string {
+	rec.Lock()
+	defer rec.Unlock()
+	return append([]string{}, rec.bodies...)
+}
+
+// testExportSinkConfig returns the config of a sink with defaults and without pauses
+func testExportSinkConfig(sinkType string, url string) ExportSinkConfig {
+	cfg := ExportSinkConfig{Type: sinkType, URL: url, FlushInterval: time.Hour, MaxAttempts: 3}
+	cfg.applyDefaults()
+	return cfg
+}
+
+func testExportedResults(n int) []ExportedResult {
+	rtt := 12.5
+	results := []ExportedResult{}
+	for i := 0; i < n; i++ {
+		results = append(results, ExportedResult{
+			ID:        uuid.New(),
+			Timestamp: time.Date(2020, 1, 1, 0, i, 0, 0, time.UTC),
+			Slave:     "slave 1",
+			Target:    "dns,google",
+			Address:   "8.8.8.8",
+			Success:   true,
+			HopCount:  5,
+			RTTMs:     &rtt,
+			Hops:      []ExportedHop{},
+		})
+	}
+	return results
+}
+
+// runExportSink sends the docs through the sink and waits until it stopped
+func runExportSink(sink *exportSink, docs []ExportedResult) {
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go sink.run(&wg)
+	for _, doc := range docs {
+		sink.queue <- doc
+	}
+	close(sink.queue)
+	wg.Wait()
+}
+
+func TestExportSinkBatches(t *testing.T) {
+
+	rec := newExportRecorder()
+	defer rec.Close()
+
+	cfg := testExportSinkConfig(ExportSinkNDJSON, rec.URL)
+	cfg.BatchSize = 3
+	runExportSink(newExportSink(cfg), testExportedResults(7))
+
+	requests := rec.requests()
+	if len(requests) != 3 {
+		t.Fatalf("sink sent %v requests, expected batches of 3, 3 and 1", len(requests))
+	}
+	for i, expected := range []int{3, 3, 1} {
+		if lines := strings.Count(requests[i], "\n"); lines != expected {
+			t.Errorf("batch %v has %v results, expected %v", i, lines, expected)
+		}
+	}
+}
+
+func TestExportSinkFlushInterval(t *testing.T) {
+
+	rec := newExportRecorder()
+	defer rec.Close()
+
+	cfg := testExportSinkConfig(ExportSinkNDJSON, rec.URL)
+	cfg.FlushInterval = 10 * time.Millisecond
+	sink := newExportSink(cfg)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go sink.run(&wg)
+	defer func() {
+		close(sink.queue)
+		wg.Wait()
+	}()
+
+	for _, doc := range testExportedResults(2) {
+		sink.queue <- doc
+	}
+	for deadline := time.Now().Add(5 * time.Second); len(rec.requests()) == 0 && time.Now().Before(deadline); {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	requests := rec.requests()
+	if len(requests) != 1 || strings.Count(requests[0], "\n") != 2 {
+		t.Errorf("expected a single batch of 2 results after the flush interval, received %q", requests)
+	}
+}
+
+func TestExportSinkRetry(t *testing.T) {
+
+	defer func(pause time.Duration) { exportRetryPause = pause }(exportRetryPause)
+	exportRetryPause = time.Millisecond
+
+	rec := newExportRecorder()
+	defer rec.Close()
+
+	// the batch is sent again after a failure
+	rec.failures = 2
+	sink := newExportSink(testExportSinkConfig(ExportSinkNDJSON, rec.URL))
+	sink.send(testExportedResults(2))
+
+	requests := rec.requests()
+	if len(requests) != 3 {
+		t.Fatalf("sink sent %v requests, expected 2 failed tries and a successful one", len(requests))
+	}
+	if requests[0] != requests[2] {
+		t.Error("the retry didn't send the same batch")
+	}
+
+	// the batch is dropped after MaxAttempts
+	rec.Lock()
+	rec.bodies, rec.failures = nil, 10
+	rec.Unlock()
+	sink.send(testExportedResults(2))
+
+	if n := len(rec.requests()); n != sink.cfg.MaxAttempts {
+		t.Errorf("sink sent %v requests, expected to give up after %v", n, sink.cfg.MaxAttempts)
+	}
+}
+
+func TestExportResultBufferFull(t *testing.T) {
+
+	// a sink whose writer never runs can't keep up
+	cfg := testExportSinkConfig(ExportSinkNDJSON, "http://127.0.0.1:1")
+	cfg.BufferSize = 2
+	sink := newExportSink(cfg)
+
+	resultExport.Lock()
+	resultExport.sinks = []*exportSink{sink}
+	resultExport.Unlock()
+	defer func() {
+		resultExport.Lock()
+		resultExport.sinks = nil
+		resultExport.Unlock()
+	}()
+
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 10; i++ {
+			ExportResult(uuid.New(), TraceResult{DateTime: time.Now()})
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("ExportResult blocked on the full buffer of a sink")
+	}
+	if len(sink.queue) != 2 {
+		t.Errorf("buffer holds %v results, expected it to be full with 2", len(sink.queue))
+	}
+}
+
+func TestElasticsearchWriter(t *testing.T) {
+
+	rec := newExportRecorder()
+	defer rec.Close()
+	rec.response = `{"errors": false, "items": []}`
+
+	cfg := testExportSinkConfig(ExportSinkElasticsearch, rec.URL+"/")
+	cfg.Headers = map[string]string{"Authorization": "ApiKey abc"}
+	docs := testExportedResults(2)
+	if err := newExportSink(cfg).writer.write(docs); err != nil {
+		t.Fatal("write failed: ", err)
+	}
+
+	if rec.headers[0].Get("Authorization") != "ApiKey abc" || rec.headers[0].Get("Content-Type") != "application/x-ndjson" {
+		t.Errorf("unexpected request headers %v", rec.headers[0])
+	}
+
+	// action and document alternate, the trace ID is the document ID
+	scanner := bufio.NewScanner(strings.NewReader(rec.requests()[0]))
+	for _, doc := range docs {
+		var action map[string]map[string]string
+		var indexed ExportedResult
+		if !scanner.Scan() || json.Unmarshal(scanner.Bytes(), &action) != nil {
+			t.Fatal("missing bulk action")
+		}
+		if !scanner.Scan() || json.Unmarshal(scanner.Bytes(), &indexed) != nil {
+			t.Fatal("missing bulk document")
+		}
+		if action["index"]["_id"] != doc.ID.String() || action["index"]["_index"] != "disttrace-results" {
+			t.Errorf("unexpected bulk action %v", action)
+		}
+		if indexed.ID != doc.ID {
+			t.Errorf("document '%v' doesn't follow its action", indexed.ID)
+		}
+	}
+}
+
+func TestElasticsearchWriterItemErrors(t *testing.T) {
+
+	rec := newExportRecorder()
+	defer rec.Close()
+	rec.response = `{"errors": true, "items": [{"index": {"status": 400, "error": {"type": "mapper_parsing_exception"}}}]}`
+
+	err := newExportSink(testExportSinkConfig(ExportSinkElasticsearch, rec.URL)).writer.write(testExportedResults(1))
+	if err == nil || !strings.Contains(err.Error(), "mapper_parsing_exception") {
+		t.Errorf("expected the error of the document, got: %v", err)
+	}
+}
+
+func TestInfluxDBWriter(t *testing.T) {
+
+	rec := newExportRecorder()
+	defer rec.Close()
+
+	docs := testExportedResults(1)
+	if err := newExportSink(testExportSinkConfig(ExportSinkInfluxDB, rec.URL)).writer.write(docs); err != nil {
+		t.Fatal("write failed: ", err)
+	}
+
+	expected := `traceroute,slave=slave\ 1,target=dns\,google,address=8.8.8.8 success=true,hopCount=5i,rttMs=12.5 ` +
+		"1577836800000000000\n"
+	if body := rec.requests()[0]; body != expected {
+		t.Errorf("received line %q, expected %q", body, expected)
+	}
+}
+
+func TestNDJSONWriterFile(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "disttrace-export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := testExportSinkConfig(ExportSinkNDJSON, "")
+	cfg.File = filepath.Join(dir, "results.ndjson")
+	writer := newExportSink(cfg).writer
+
+	// batches are appended
+	for i := 0; i < 2; i++ {
+		if err := writer.write(testExportedResults(2)); err != nil {
+			t.Fatal("write failed: ", err)
+		}
+	}
+
+	content, err := ioutil.ReadFile(cfg.File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("file has %v lines, expected 4", len(lines))
+	}
+	for _, line := range lines {
+		var doc ExportedResult
+		if err := json.Unmarshal([]byte(line), &doc); err != nil || doc.ID == uuid.Nil {
+			t.Errorf("line isn't an exported result: %q", line)
+		}
+	}
+}
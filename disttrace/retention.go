@@ -0,0 +1,421 @@
+package disttrace
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RetentionProcRunning mutex for graceful shutdown
+var RetentionProcRunning = make(chan bool, 1)
+
+// resolutions of aggregated traceroute data
+const (
+	ResolutionHour = "hour"
+	ResolutionDay  = "day"
+)
+
+// RetentionPolicy defines how long traceroute data is kept, a value of 0 keeps the data forever
+type RetentionPolicy struct {
+	// RawDays is the number of days raw traceroutes are kept before they are aggregated hourly
+	RawDays int `yaml:"rawDays"`
+	// HourlyDays is the number of days hourly aggregates are kept before they are aggregated daily
+	HourlyDays int `yaml:"hourlyDays"`
+	// DailyMonths is the number of months daily aggregates are kept before they are deleted
+	DailyMonths int `yaml:"dailyMonths"`
+	// TelemetryDays is the number of days telemetry reports of the slaves are kept
+	TelemetryDays int `yaml:"telemetryDays"`
+	// AlertDays is the number of days resolved alerts are kept, active alerts are never deleted
+	AlertDays int `yaml:"alertDays"`
+}
+
+// hopAggregateKey identifies a link between two hops within an aggregation bucket
+type hopAggregateKey struct {
+	SlaveID          uuid.UUID
+	TargetID         uuid.UUID
+	Bucket           time.Time
+	HopIndex         int
+	HopIPAddress     string
+	PrevHopIPAddress string
+}
+
+// hopAggregate holds the latency summary of a link within an aggregation bucket
+type hopAggregate struct {
+	Count          int64
+	DurationSumSec float64
+	DurationMinSec float64
+	DurationMaxSec float64
+}
+
+// add merges another summary into the aggregate
+func (agg *hopAggregate) add(other hopAggregate) {
+	if agg.Count == 0 || other.DurationMinSec < agg.DurationMinSec {
+		agg.DurationMinSec = other.DurationMinSec
+	}
+	if agg.Count == 0 || other.DurationMaxSec > agg.DurationMaxSec {
+		agg.DurationMaxSec = other.DurationMaxSec
+	}
+	agg.Count += other.Count
+	agg.DurationSumSec += other.DurationSumSec
+}
+
+// RetentionManager runs as process, hourly aggregates old raw data and removes expired aggregates,
+// the retention policy is taken from the current master config on every run
+func RetentionManager(db *DB) {
+
+	// lock mutex
+	RetentionProcRunning <- true
+
+	// init vars
+	var nextTime time.Time
+
+	// infinite loop
+	log.Info("RetentionManager: Start...")
+	for {
+		// check if we need to exit
+		if CheckForQuit() {
+			log.Warn("RetentionManager: Received exit signal, bye.")
+			<-RetentionProcRunning
+			return
+		}
+
+		// is it time to run?
+		if nextTime.Before(time.Now()) {
+			policy := CurrentMasterConfig().Retention
+			log.Infof("RetentionManager: Keeping raw data for %v days, hourly aggregates for %v days, daily aggregates for %v months, "+
+				"telemetry for %v days and resolved alerts for %v days...",
+				policy.RawDays, policy.HourlyDays, policy.DailyMonths, policy.TelemetryDays, policy.AlertDays)
+			if err := ApplyRetentionPolicy(db, policy); err != nil {
+				log.Warn("RetentionManager: Couldn't apply retention policy, Error: ", err)
+			}
+
+			// run again on next full hour
+			nextTime = time.Now().Truncate(time.Hour)
+			nextTime = nextTime.Add(time.Hour)
+		}
+
+		// zzz...
+		time.Sleep(1 * time.Second)
+	}
+}
+
+// ApplyRetentionPolicy aggregates and deletes all data which exceeded its retention period
+func ApplyRetentionPolicy(db *DB, policy RetentionPolicy) error {
+
+	now := time.Now().UTC()
+	log.Info("ApplyRetentionPolicy: Applying retention policy...")
+
+	if policy.RawDays > 0 {
+		cutoff := now.AddDate(0, 0, -policy.RawDays).Truncate(time.Hour)
+		if err := aggregateRawData(db, cutoff); err != nil {
+			return err
+		}
+	}
+
+	if policy.HourlyDays > 0 {
+		cutoff := now.AddDate(0, 0, -policy.HourlyDays).Truncate(24 * time.Hour)
+		if err := aggregateHourlyData(db, cutoff); err != nil {
+			return err
+		}
+	}
+
+	if policy.DailyMonths > 0 {
+		cutoff := now.AddDate(0, -policy.DailyMonths, 0).Truncate(24 * time.Hour)
+		query := "DELETE FROM t_HopAggregates WHERE strResolution = ? AND " + db.Dialect.TimeExpr("dtBucket") + " < " + db.Dialect.TimeExpr("?")
+		res, err := db.Exec(query, ResolutionDay, cutoff.Format(time.RFC3339))
+		if err != nil {
+			log.Warn("ApplyRetentionPolicy: Couldn't delete expired daily aggregates, Error: ", err)
+			return errors.New("Couldn't delete expired daily aggregates")
+		}
+		if numRows, err := res.RowsAffected(); err == nil && numRows > 0 {
+			log.Infof("ApplyRetentionPolicy: Deleted %v daily aggregates older than %v", numRows, cutoff)
+		}
+	}
+
+	if policy.TelemetryDays > 0 {
+		cutoff := now.AddDate(0, 0, -policy.TelemetryDays)
+		query := "DELETE FROM t_SlaveTelemetry WHERE " + db.Dialect.TimeExpr("dtReceived") + " < " + db.Dialect.TimeExpr("?")
+		res, err := db.Exec(query, cutoff.Format(time.RFC3339))
+		if err != nil {
+			log.Warn("ApplyRetentionPolicy: Couldn't delete expired telemetry, Error: ", err)
+			return errors.New("Couldn't delete expired telemetry")
+		}
+		if numRows, err := res.RowsAffected(); err == nil && numRows > 0 {
+			log.Infof("ApplyRetentionPolicy: Deleted %v telemetry reports older than %v", numRows, cutoff)
+		}
+	}
+
+	if policy.AlertDays > 0 {
+		cutoff := now.AddDate(0, 0, -policy.AlertDays)
+		query := "DELETE FROM t_Alerts WHERE strState = ? AND " + db.Dialect.TimeExpr("dtResolved") + " < " + db.Dialect.TimeExpr("?")
+		res, err := db.Exec(query, AlertStateResolved, cutoff.Format(time.RFC3339))
+		if err != nil {
+			log.Warn("ApplyRetentionPolicy: Couldn't delete expired alerts, Error: ", err)
+			return errors.New("Couldn't delete expired alerts")
+		}
+		if numRows, err := res.RowsAffected(); err == nil && numRows > 0 {
+			log.Infof("ApplyRetentionPolicy: Deleted %v alerts resolved before %v", numRows, cutoff)
+		}
+	}
+
+	log.Info("ApplyRetentionPolicy: Finished applying retention policy")
+	return nil
+}
+
+// oldestBucket returns the start of the bucket of the oldest entry in a table before the cutoff, zero if there is none
+func oldestBucket(db *DB, table string, column string, resolution string, cutoff time.Time, where string, args ...interface{}) (time.Time, error) {
+
+	query := "SELECT MIN(" + db.Dialect.TimeExpr(column) + ") FROM " + table +
+		" WHERE " + db.Dialect.TimeExpr(column) + " < " + db.Dialect.TimeExpr("?") + where
+
+	var oldest dbTime
+	if err := db.QueryRow(query, append([]interface{}{cutoff.Format(time.RFC3339)}, args...)...).Scan(&oldest); err != nil {
+		return time.Time{}, err
+	}
+
+	if resolution == ResolutionDay {
+		return oldest.UTC().Truncate(24 * time.Hour), nil
+	}
+	return oldest.UTC().Truncate(time.Hour), nil
+}
+
+// aggregateRawData replaces all raw traceroutes before the cutoff with hourly aggregates, one hour at a time
+func aggregateRawData(db *DB, cutoff time.Time) error {
+
+	log.Infof("aggregateRawData: Aggregating raw traceroutes older than %v...", cutoff)
+	count := 0
+
+	for !CheckForQuit() {
+		bucket, err := oldestBucket(db, "t_Traceroutes", "dtStart", ResolutionHour, cutoff, "")
+		if err != nil {
+			log.Warn("aggregateRawData: Couldn't get oldest traceroute, Error: ", err)
+			return errors.New("Couldn't get oldest traceroute")
+		}
+		if bucket.IsZero() {
+			break
+		}
+
+		numTraces, err := aggregateRawBucket(db, bucket)
+		if err != nil {
+			return err
+		}
+
+		count++
+		log.Infof("aggregateRawData: Aggregated %v traceroutes of hour %v, %v hours done, remaining until %v",
+			numTraces, bucket.Format(time.RFC3339), count, cutoff.Format(time.RFC3339))
+	}
+
+	log.Infof("aggregateRawData: Finished aggregating %v hours of raw traceroutes", count)
+	return nil
+}
+
+// aggregateRawBucket aggregates and deletes all raw traceroutes of a single hour, returns the number of traceroutes
+func aggregateRawBucket(db *DB, bucket time.Time) (numTraces int64, err error) {
+
+	from, to := bucket.Format(time.RFC3339), bucket.Add(time.Hour).Format(time.RFC3339)
+	inBucket := db.Dialect.TimeExpr("t.dtStart") + " >= " + db.Dialect.TimeExpr("?") + " AND " + db.Dialect.TimeExpr("t.dtStart") + " < " + db.Dialect.TimeExpr("?")
+
+	var tx *Tx
+	if tx, err = db.Begin(); err != nil {
+		log.Warn("aggregateRawBucket: Couldn't start transaction, Error: ", err)
+		return 0, errors.New("Couldn't start transaction")
+	}
+	// catch errors and rollback!
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	query := "SELECT t.strSlaveId, t.strTargetId, t.strPathId, t.strHopRTTs FROM t_Traceroutes t WHERE t.strPathId IS NOT NULL AND " + inBucket
+
+	rows, err := tx.Query(query, from, to)
+	if err != nil {
+		log.Warn("aggregateRawBucket: Couldn't read raw traceroutes, Error: ", err)
+		return 0, errors.New("Couldn't read raw traceroutes")
+	}
+
+	type rawTrace struct {
+		SlaveID  uuid.UUID
+		TargetID uuid.UUID
+		PathID   string
+		RTTs     sql.NullString
+	}
+	traces := []rawTrace{}
+	for rows.Next() {
+		var trace rawTrace
+		if err = rows.Scan(&trace.SlaveID, &trace.TargetID, &trace.PathID, &trace.RTTs); err != nil {
+			rows.Close()
+			log.Warn("aggregateRawBucket: Couldn't scan raw traceroutes, Error: ", err)
+			return 0, errors.New("Couldn't scan raw traceroutes")
+		}
+		traces = append(traces, trace)
+	}
+	rows.Close()
+
+	// summarize the links of every traceroute's path
+	aggregates := make(map[hopAggregateKey]hopAggregate)
+	for _, trace := range traces {
+		var hops []PathHop
+		if hops, err = getPathHops(db, trace.PathID); err != nil {
+			return 0, err
+		}
+		durations := decodeVector(trace.RTTs)
+		for pos, hop := range hops {
+			var duration float64
+			if pos < len(durations) {
+				duration = durations[pos]
+			}
+			key := hopAggregateKey{SlaveID: trace.SlaveID, TargetID: trace.TargetID, Bucket: bucket,
+				HopIndex: hop.Index, HopIPAddress: hop.IPAddress, PrevHopIPAddress: hop.PrevIPAddress}
+			agg := aggregates[key]
+			agg.add(hopAggregate{Count: 1, DurationSumSec: duration, DurationMinSec: duration, DurationMaxSec: duration})
+			aggregates[key] = agg
+		}
+	}
+
+	if err = storeHopAggregates(tx, ResolutionHour, aggregates); err != nil {
+		return 0, err
+	}
+
+	// delete the raw data, paths are kept as they are referenced by the path sightings
+	query = "DELETE FROM t_Traceroutes WHERE strTracerouteId IN (SELECT t.strTracerouteId FROM t_Traceroutes t WHERE " + inBucket + ")"
+	res, err := tx.Exec(query, from, to)
+	if err != nil {
+		log.Warn("aggregateRawBucket: Couldn't delete raw traceroutes, Error: ", err)
+		return 0, errors.New("Couldn't delete raw traceroutes")
+	}
+	if numTraces, err = res.RowsAffected(); err != nil {
+		log.Warn("aggregateRawBucket: Can't get number of affected rows, Error: ", err)
+		return 0, errors.New("DB Error")
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, errors.New("Couldn't commit transaction")
+	}
+
+	return numTraces, nil
+}
+
+// aggregateHourlyData replaces all hourly aggregates before the cutoff with daily aggregates, one day at a time
+func aggregateHourlyData(db *DB, cutoff time.Time) error {
+
+	log.Infof("aggregateHourlyData: Aggregating hourly aggregates older than %v...", cutoff)
+	count := 0
+
+	for !CheckForQuit() {
+		bucket, err := oldestBucket(db, "t_HopAggregates", "dtBucket", ResolutionDay, cutoff, " AND strResolution = ?", ResolutionHour)
+		if err != nil {
+			log.Warn("aggregateHourlyData: Couldn't get oldest hourly aggregate, Error: ", err)
+			return errors.New("Couldn't get oldest hourly aggregate")
+		}
+		if bucket.IsZero() {
+			break
+		}
+
+		if err := aggregateHourlyBucket(db, bucket); err != nil {
+			return err
+		}
+
+		count++
+		log.Infof("aggregateHourlyData: Aggregated day %v, %v days done, remaining until %v",
+			bucket.Format("2006-01-02"), count, cutoff.Format("2006-01-02"))
+	}
+
+	log.Infof("aggregateHourlyData: Finished aggregating %v days of hourly aggregates", count)
+	return nil
+}
+
+// aggregateHourlyBucket aggregates and deletes all hourly aggregates of a single day
+func aggregateHourlyBucket(db *DB, bucket time.Time) (err error) {
+
+	from, to := bucket.Format(time.RFC3339), bucket.Add(24*time.Hour).Format(time.RFC3339)
+	inBucket := "strResolution = ? AND " + db.Dialect.TimeExpr("dtBucket") + " >= " + db.Dialect.TimeExpr("?") + " AND " + db.Dialect.TimeExpr("dtBucket") + " < " + db.Dialect.TimeExpr("?")
+
+	var tx *Tx
+	if tx, err = db.Begin(); err != nil {
+		log.Warn("aggregateHourlyBucket: Couldn't start transaction, Error: ", err)
+		return errors.New("Couldn't start transaction")
+	}
+	// catch errors and rollback!
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	query := `
+		SELECT strSlaveId, strTargetId, nHopIndex, strHopIPAddress, strPrevHopIPAddress,
+			SUM(nCount), SUM(dDurationSumSec), MIN(dDurationMinSec), MAX(dDurationMaxSec)
+		FROM t_HopAggregates
+		WHERE ` + inBucket + `
+		GROUP BY strSlaveId, strTargetId, nHopIndex, strHopIPAddress, strPrevHopIPAddress
+		`
+
+	rows, err := tx.Query(query, ResolutionHour, from, to)
+	if err != nil {
+		log.Warn("aggregateHourlyBucket: Couldn't read hourly aggregates, Error: ", err)
+		return errors.New("Couldn't read hourly aggregates")
+	}
+
+	aggregates := make(map[hopAggregateKey]hopAggregate)
+	for rows.Next() {
+		key := hopAggregateKey{Bucket: bucket}
+		var agg hopAggregate
+		if err = rows.Scan(&key.SlaveID, &key.TargetID, &key.HopIndex, &key.HopIPAddress, &key.PrevHopIPAddress,
+			&agg.Count, &agg.DurationSumSec, &agg.DurationMinSec, &agg.DurationMaxSec); err != nil {
+			rows.Close()
+			log.Warn("aggregateHourlyBucket: Couldn't scan hourly aggregates, Error: ", err)
+			return errors.New("Couldn't scan hourly aggregates")
+		}
+		aggregates[key] = agg
+	}
+	rows.Close()
+
+	if err = storeHopAggregates(tx, ResolutionDay, aggregates); err != nil {
+		return err
+	}
+
+	if _, err = tx.Exec("DELETE FROM t_HopAggregates WHERE "+inBucket, ResolutionHour, from, to); err != nil {
+		log.Warn("aggregateHourlyBucket: Couldn't delete hourly aggregates, Error: ", err)
+		return errors.New("Couldn't delete hourly aggregates")
+	}
+
+	if err = tx.Commit(); err != nil {
+		return errors.New("Couldn't commit transaction")
+	}
+
+	return nil
+}
+
+// storeHopAggregates adds the given summaries to the stored aggregates of the resolution
+func storeHopAggregates(tx *Tx, resolution string, aggregates map[hopAggregateKey]hopAggregate) error {
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO t_HopAggregates (strSlaveId, strTargetId, strResolution, dtBucket, nHopIndex, strHopIPAddress, strPrevHopIPAddress,
+			nCount, dDurationSumSec, dDurationMinSec, dDurationMaxSec)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (strSlaveId, strTargetId, strResolution, dtBucket, nHopIndex, strHopIPAddress, strPrevHopIPAddress) DO UPDATE SET
+			nCount = t_HopAggregates.nCount + excluded.nCount,
+			dDurationSumSec = t_HopAggregates.dDurationSumSec + excluded.dDurationSumSec,
+			dDurationMinSec = CASE WHEN excluded.dDurationMinSec < t_HopAggregates.dDurationMinSec THEN excluded.dDurationMinSec ELSE t_HopAggregates.dDurationMinSec END,
+			dDurationMaxSec = CASE WHEN excluded.dDurationMaxSec > t_HopAggregates.dDurationMaxSec THEN excluded.dDurationMaxSec ELSE t_HopAggregates.dDurationMaxSec END
+		`)
+	if err != nil {
+		log.Warn("storeHopAggregates: Couldn't prepare statement, Error: ", err)
+		return errors.New("Couldn't prepare statement")
+	}
+	defer stmt.Close()
+
+	for key, agg := range aggregates {
+		if _, err := stmt.Exec(key.SlaveID, key.TargetID, resolution, key.Bucket, key.HopIndex, key.HopIPAddress, key.PrevHopIPAddress,
+			agg.Count, agg.DurationSumSec, agg.DurationMinSec, agg.DurationMaxSec); err != nil {
+			log.Warn("storeHopAggregates: Couldn't store aggregate, Error: ", err)
+			return errors.New("Couldn't store aggregate")
+		}
+	}
+
+	return nil
+}
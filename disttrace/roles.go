@@ -0,0 +1,108 @@
+package disttrace
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// roles of users, each role may do everything the roles before it may do
+const (
+	// RoleViewer may read all data, e.g. for dashboards
+	RoleViewer = "viewer"
+	// RoleOperator may also manage targets, target groups and alert rules and acknowledge alerts
+	RoleOperator = "operator"
+	// RoleAdmin may also manage slaves, users and notification channels and delete targets
+	RoleAdmin = "admin"
+)
+
+// roleLevels orders the roles by their permissions
+var roleLevels = map[string]int{
+	RoleViewer:   1,
+	RoleOperator: 2,
+	RoleAdmin:    3,
+}
+
+// ErrInvalidRole is returned for roles which aren't one of viewer, operator or admin
+var ErrInvalidRole = errors.New("Invalid role, must be one of viewer, operator, admin")
+
+// ErrLastAdmin is returned if a change would leave no admin to manage the users
+var ErrLastAdmin = errors.New("The last admin can't be deleted or lose the admin role")
+
+// ValidRole returns if the role exists
+func ValidRole(role string) bool {
+	_, ok := roleLevels[role]
+	return ok
+}
+
+// HasRole returns if a user with the given role may do what the required role may do
+func HasRole(role string, required string) bool {
+	return ValidRole(role) && roleLevels[role] >= roleLevels[required]
+}
+
+// TargetScope holds the targets a user may access, a nil scope allows all targets
+type TargetScope map[uuid.UUID]bool
+
+// Allows returns if the target is in the scope
+func (scope TargetScope) Allows(targetID uuid.UUID) bool {
+	return scope == nil || scope[targetID]
+}
+
+// condition returns an SQL condition restricting the given target ID column to the scope
+func (scope TargetScope) condition(column string) (string, []interface{}) {
+	if len(scope) == 0 {
+		return "1 = 0", nil
+	}
+	args := []interface{}{}
+	for targetID := range scope {
+		args = append(args, targetID)
+	}
+	return column + " IN (?" + strings.Repeat(", ?", len(args)-1) + ")", args
+}
+
+// GetTargetScope returns the targets of a user with the given role and target groups. Admins and users without
+// target groups may access all targets.
+func GetTargetScope(db *DB, role string, groupIDs []uuid.UUID) (TargetScope, error) {
+
+	if role == RoleAdmin || len(groupIDs) == 0 {
+		return nil, nil
+	}
+
+	args := []interface{}{}
+	for _, groupID := range groupIDs {
+		args = append(args, groupID)
+	}
+	query := "SELECT DISTINCT strTargetId FROM t_TargetGroupMembers WHERE strGroupId IN (?" +
+		strings.Repeat(", ?", len(groupIDs)-1) + ")"
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		log.Warn("GetTargetScope: Couldn't get targets of groups, Error: ", err)
+		return nil, errors.New("Couldn't get targets of groups")
+	}
+	defer rows.Close()
+
+	scope := TargetScope{}
+	for rows.Next() {
+		var targetID uuid.UUID
+		if err := rows.Scan(&targetID); err != nil {
+			log.Warn("GetTargetScope: Couldn't read target of group, Error: ", err)
+			return nil, errors.New("Couldn't get targets of groups")
+		}
+		scope[targetID] = true
+	}
+
+	return scope, rows.Err()
+}
+
+// getUserTargetGroups returns the IDs of the target groups a user is restricted to
+func getUserTargetGroups(db *DB, userID uuid.UUID) ([]uuid.UUID, error) {
+	return getGroupMembers(db, "SELECT strGroupId FROM t_UserTargetGroups WHERE strUserId = ?", userID)
+}
+
+// countOtherAdmins returns the number of admins besides the given user
+func countOtherAdmins(db *DB, userID uuid.UUID) (count int, err error) {
+	err = db.QueryRow("SELECT COUNT(*) FROM t_Users WHERE strRole = ? AND strUserId <> ?", RoleAdmin, userID).Scan(&count)
+	return
+}
@@ -0,0 +1,230 @@
+package disttrace
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrInvalidRefreshToken is returned for refresh tokens which are unknown, expired or were already used
+var ErrInvalidRefreshToken = errors.New("Invalid refresh token")
+
+// AuthTokens are returned on login and refresh. The access token is sent with every request, the refresh token gets
+// a new access token after it expired.
+type AuthTokens struct {
+	AccessToken  string
+	RefreshToken string `json:",omitempty"`
+	ExpiresAt    time.Time
+}
+
+// newRefreshSecret returns a random secret of a refresh token and its hash which is stored in db
+func newRefreshSecret() (secret string, hash string, err error) {
+
+	randBytes := make([]byte, 32)
+	if _, err := rand.Read(randBytes); err != nil {
+		log.Warn("newRefreshSecret: Error while collecting randomness, Error: ", err)
+		return "", "", errors.New("Couldn't generate refresh token")
+	}
+
+	secret = base64.RawURLEncoding.EncodeToString(randBytes)
+	return secret, hashRefreshSecret(secret), nil
+}
+
+// hashRefreshSecret returns the hex encoded SHA-256 of a refresh token secret
+func hashRefreshSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewSession starts a session of the authenticated user and returns its tokens
+func NewSession(db *DB, user User) (AuthTokens, error) {
+
+	log.Debugf("NewSession: Starting session of user '%v'...", user.Name)
+
+	secret, hash, err := newRefreshSecret()
+	if err != nil {
+		return AuthTokens{}, err
+	}
+
+	now := time.Now()
+	sessionID := uuid.New()
+	query := `
+		INSERT INTO t_RefreshTokens (strTokenId, strUserId, strTokenHash, dtCreated, dtExpires)
+		VALUES (?, ?, ?, ?, ?)
+		`
+	if _, err := db.Exec(query, sessionID, user.ID, hash, now, refreshExpiry(now, now)); err != nil {
+		log.Warn("NewSession: Couldn't insert refresh token, Error: ", err)
+		return AuthTokens{}, errors.New("Couldn't start session")
+	}
+
+	// sessions which weren't refreshed in time are left behind by clients
+	query = "DELETE FROM t_RefreshTokens WHERE " + db.Dialect.TimeExpr("dtExpires") + " < " + db.Dialect.TimeExpr("?")
+	if _, err := db.Exec(query, now.Format(time.RFC3339)); err != nil {
+		log.Warn("NewSession: Couldn't delete expired refresh tokens, Error: ", err)
+	}
+
+	token, expires, err := GetToken(NewAuthClaims(user, sessionID.String()))
+	if err != nil {
+		return AuthTokens{}, errors.New("Couldn't generate token")
+	}
+
+	log.Infof("NewSession: Started session '%v' of user '%v'", sessionID, user.Name)
+	return AuthTokens{
+		AccessToken:  string(token),
+		RefreshToken: sessionID.String() + "." + secret,
+		ExpiresAt:    expires,
+	}, nil
+}
+
+// refreshExpiry returns the new expiration time of a refresh token, it slides with every refresh until the session
+// reaches its lifetime
+func refreshExpiry(created time.Time, now time.Time) time.Time {
+
+	cfg := CurrentMasterConfig().Auth
+	expires := now.Add(cfg.RefreshTokenLifetime)
+	if end := created.Add(cfg.SessionLifetime); expires.After(end) {
+		return end
+	}
+	return expires
+}
+
+// RefreshSession returns a new access and refresh token for a refresh token, which can't be used again. Reusing a
+// refresh token ends its session, as either the client or an attacker has a stolen copy.
+func RefreshSession(db *DB, refreshToken string) (AuthTokens, error) {
+
+	parts := strings.SplitN(refreshToken, ".", 2)
+	if len(parts) != 2 {
+		return AuthTokens{}, ErrInvalidRefreshToken
+	}
+	sessionID, err := uuid.Parse(parts[0])
+	if err != nil {
+		return AuthTokens{}, ErrInvalidRefreshToken
+	}
+
+	log.Debugf("RefreshSession: Refreshing session '%v'...", sessionID)
+
+	var userID uuid.UUID
+	var hash string
+	var created, expires dbTime
+	query := "SELECT strUserId, strTokenHash, dtCreated, dtExpires FROM t_RefreshTokens WHERE strTokenId = ?"
+	err = db.QueryRow(query, sessionID).Scan(&userID, &hash, &created, &expires)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Warn("RefreshSession: Couldn't read refresh token from db, Error: ", err)
+			return AuthTokens{}, errors.New("Couldn't read refresh token")
+		}
+		log.Debugf("RefreshSession: Session '%v' doesn't exist", sessionID)
+		return AuthTokens{}, ErrInvalidRefreshToken
+	}
+
+	// the new token gets the current role and target groups of the user
+	user, err := GetUser(userID, db)
+	if err != nil {
+		return AuthTokens{}, errors.New("Couldn't read user of session")
+	}
+
+	now := time.Now()
+	if subtle.ConstantTimeCompare([]byte(hashRefreshSecret(parts[1])), []byte(hash)) != 1 {
+		log.Warnf("RefreshSession: Refresh token of session '%v' of user '%v' was reused, ending session", sessionID, user.Name)
+		EndSession(db, sessionID.String())
+		return AuthTokens{}, ErrInvalidRefreshToken
+	}
+	if expires.Before(now) {
+		log.Debugf("RefreshSession: Session '%v' of user '%v' expired", sessionID, user.Name)
+		EndSession(db, sessionID.String())
+		return AuthTokens{}, ErrInvalidRefreshToken
+	}
+
+	secret, newHash, err := newRefreshSecret()
+	if err != nil {
+		return AuthTokens{}, err
+	}
+
+	// only the first of concurrent refreshes with the same token succeeds
+	query = "UPDATE t_RefreshTokens SET strTokenHash = ?, dtExpires = ? WHERE strTokenId = ? AND strTokenHash = ?"
+	res, err := db.Exec(query, newHash, refreshExpiry(created.Time, now), sessionID, hash)
+	if err != nil {
+		log.Warn("RefreshSession: Couldn't update refresh token, Error: ", err)
+		return AuthTokens{}, errors.New("Couldn't refresh session")
+	}
+	if n, err := res.RowsAffected(); err != nil || n != 1 {
+		log.Warnf("RefreshSession: Refresh token of session '%v' was used concurrently", sessionID)
+		return AuthTokens{}, ErrInvalidRefreshToken
+	}
+
+	token, tokenExpires, err := GetToken(NewAuthClaims(user, sessionID.String()))
+	if err != nil {
+		return AuthTokens{}, errors.New("Couldn't generate token")
+	}
+
+	log.Debugf("RefreshSession: Refreshed session '%v' of user '%v'", sessionID, user.Name)
+	return AuthTokens{
+		AccessToken:  string(token),
+		RefreshToken: sessionID.String() + "." + secret,
+		ExpiresAt:    tokenExpires,
+	}, nil
+}
+
+// EndSession deletes the refresh token of a session, access tokens issued with it stay valid until they expire or
+// are revoked
+func EndSession(db *DB, sessionID string) error {
+
+	if sessionID == "" {
+		return nil
+	}
+
+	if _, err := db.Exec("DELETE FROM t_RefreshTokens WHERE strTokenId = ?", sessionID); err != nil {
+		log.Warn("EndSession: Couldn't delete refresh token, Error: ", err)
+		return errors.New("Couldn't end session")
+	}
+
+	log.Debugf("EndSession: Ended session '%v'", sessionID)
+	return nil
+}
+
+// EndUserSessions ends all sessions of a user but the given one, which may be empty. The access tokens of the
+// sessions are revoked, the user has to login again.
+func EndUserSessions(db *DB, userID uuid.UUID, except string) error {
+
+	rows, err := db.Query("SELECT strTokenId FROM t_RefreshTokens WHERE strUserId = ? AND strTokenId <> ?", userID, except)
+	if err != nil {
+		log.Warn("EndUserSessions: Couldn't read sessions of user, Error: ", err)
+		return errors.New("Couldn't end sessions")
+	}
+	defer rows.Close()
+
+	sessionIDs := []string{}
+	for rows.Next() {
+		var sessionID string
+		if err := rows.Scan(&sessionID); err != nil {
+			log.Warn("EndUserSessions: Couldn't read session of user, Error: ", err)
+			return errors.New("Couldn't end sessions")
+		}
+		sessionIDs = append(sessionIDs, sessionID)
+	}
+	if err := rows.Err(); err != nil {
+		log.Warn("EndUserSessions: Couldn't read sessions of user, Error: ", err)
+		return errors.New("Couldn't end sessions")
+	}
+	rows.Close()
+
+	if err := RevokeSessions(sessionIDs); err != nil {
+		return errors.New("Couldn't end sessions")
+	}
+	for _, sessionID := range sessionIDs {
+		if err := EndSession(db, sessionID); err != nil {
+			return err
+		}
+	}
+
+	log.Debugf("EndUserSessions: Ended %v sessions of user '%v'", len(sessionIDs), userID)
+	return nil
+}
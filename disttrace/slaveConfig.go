@@ -6,29 +6,40 @@ import (
 	"errors"
 	"io/ioutil"
 	"net/http"
+	"sync/atomic"
 	"time"
 
 	valid "github.com/asaskevich/govalidator"
 )
 
 type pollerConfig struct {
-	MasterHost string
-	MasterPort string
-	Slave      Slave
+	MasterHost   string
+	MasterPort   string
+	MasterTLS    bool
+	Slave        Slave
+	TxBufferSize *int32
 }
 
+// metrics of the config poll, exposed by the slave's optional metrics listener
+var (
+	slaveConfigPollSuccess = NewMetricGauge("disttrace_slave_config_poll_success",
+		"Whether the last config poll on the master succeeded.")
+	slaveConfigPollLastSuccess = NewMetricGauge("disttrace_slave_config_poll_last_success_timestamp_seconds",
+		"Unix time of the last successful config poll on the master.")
+)
+
 // ConfigPollerProcRunning mutex for graceful shutdown
 var ConfigPollerProcRunning = make(chan bool, 1)
 
-// ConfigPoller runs as process, periodically polls slave configuration on master
-func ConfigPoller(masterHost string, masterPort string, slave Slave, ppCfg **SlaveConfig) {
+// ConfigPoller runs as process, periodically polls slave configuration on master and reports the slave's status
+func ConfigPoller(masterHost string, masterPort string, masterTLS bool, slave Slave, txBufferSize *int32, ppCfg **SlaveConfig) {
 
 	// lock mutex
 	ConfigPollerProcRunning <- true
 
 	// init vars
 	var nextTime time.Time
-	pollerCfg := pollerConfig{MasterHost: masterHost, MasterPort: masterPort, Slave: slave}
+	pollerCfg := pollerConfig{MasterHost: masterHost, MasterPort: masterPort, MasterTLS: masterTLS, Slave: slave, TxBufferSize: txBufferSize}
 
 	// infinite loop
 	log.Info("ConfigPoller: Start...")
@@ -48,12 +59,18 @@ func ConfigPoller(masterHost string, masterPort string, slave Slave, ppCfg **Sla
 			ppNewCfg := &pNewCfg
 			var err error
 
-			err = getConfigFromMaster(pollerCfg.MasterHost, pollerCfg.MasterPort, pollerCfg.Slave, ppNewCfg)
+			// report current status with every poll
+			pollerCfg.Slave.Status = CurrentSlaveStatus(int(atomic.LoadInt32(pollerCfg.TxBufferSize)))
+
+			err = getConfigFromMaster(pollerCfg.MasterHost, pollerCfg.MasterPort, pollerCfg.MasterTLS, pollerCfg.Slave, ppNewCfg)
 
 			if err != nil {
 				log.Warn("ConfigPoller: Couldn't get configuration")
+				slaveConfigPollSuccess.Set(0)
 
 			} else {
+				slaveConfigPollSuccess.Set(1)
+				slaveConfigPollLastSuccess.Set(float64(time.Now().Unix()))
 				newCfgJSON, _ := json.Marshal(**ppNewCfg)
 				oldCfgJSON, _ := json.Marshal(**ppCfg)
 
@@ -80,10 +97,10 @@ func ConfigPoller(masterHost string, masterPort string, slave Slave, ppCfg **Sla
 }
 
 // getConfigFromMaster fetches the slave's configuration from the master server
-func getConfigFromMaster(masterHost string, masterPort string, slave Slave, ppCfg **SlaveConfig) error {
+func getConfigFromMaster(masterHost string, masterPort string, masterTLS bool, slave Slave, ppCfg **SlaveConfig) error {
 
 	var slaveJSON, _ = json.Marshal(slave)
-	var masterURL = "http://" + masterHost + ":" + masterPort + "/slave/config"
+	var masterURL = SlaveConfig{MasterHost: masterHost, MasterPort: masterPort, MasterTLS: masterTLS}.MasterURL("/slave/config")
 
 	if !valid.IsURL(masterURL) {
 		log.Warnf("getConfigFromMaster: Cant' get config, master URL '%v' is invalid", masterURL)
@@ -146,6 +163,7 @@ func getConfigFromMaster(masterHost string, masterPort string, slave Slave, ppCf
 	// fill in master configuration, comes from cmdline arguments
 	newCfg.MasterHost = masterHost
 	newCfg.MasterPort = masterPort
+	newCfg.MasterTLS = masterTLS
 
 	log.Debug("getConfigFromMaster: Got config from master, number of configured targets: ", len(newCfg.Targets))
 	*pCfg = newCfg
@@ -0,0 +1,166 @@
+package disttrace
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// kinds of slave activity tracked by the master
+const (
+	SlaveActivityConfigPoll = "configPoll"
+	SlaveActivityResult     = "result"
+)
+
+// changes of the status of a slave published as slaveStatus events
+const (
+	// SlaveChangeReachable is a first contact, a contact after being silent or from another address
+	SlaveChangeReachable = "reachable"
+	// SlaveChangeVersion is a contact with another version than before
+	SlaveChangeVersion = "version"
+	// SlaveChangeSilent is a slave without contact for the duration of its slaveSilent rule
+	SlaveChangeSilent = "silent"
+)
+
+// defaultSlaveSilentAfter is the time without contact after which a slave is silent if no slaveSilent rule is enabled,
+// it matches the default rule
+const defaultSlaveSilentAfter = 5 * time.Minute
+
+// slaveSilentRules returns the enabled slaveSilent rules, the silence of a slave is defined by them
+func slaveSilentRules(db *DB) []AlertRule {
+
+	rules, err := GetAlertRules(db)
+	if err != nil {
+		return nil
+	}
+
+	silentRules := []AlertRule{}
+	for _, rule := range rules {
+		if rule.Enabled && rule.Type == AlertRuleSlaveSilent {
+			silentRules = append(silentRules, rule)
+		}
+	}
+	return silentRules
+}
+
+// slaveSilentAfter returns the time without contact after which the slave is silent, i.e. the shortest duration of
+// the given slaveSilent rules that apply to the slave
+func slaveSilentAfter(rules []AlertRule, slaveID uuid.UUID) time.Duration {
+
+	silentAfter := time.Duration(0)
+	for _, rule := range rules {
+		if rule.SlaveID != uuid.Nil && rule.SlaveID != slaveID {
+			continue
+		}
+		if duration := time.Duration(rule.DurationMin) * time.Minute; silentAfter == 0 || duration < silentAfter {
+			silentAfter = duration
+		}
+	}
+
+	if silentAfter == 0 {
+		return defaultSlaveSilentAfter
+	}
+	return silentAfter
+}
+
+// SlaveStatus holds liveness information about a slave. Version, uptime and queue depth are reported
+// by the slave itself, everything else is recorded by the master.
+type SlaveStatus struct {
+	Version        string     `valid:"-"`
+	UptimeSec      int64      `valid:"-"`
+	QueueDepth     int        `valid:"-"`
+	SourceIP       string     `valid:"-"`
+	LastConfigPoll *time.Time `valid:"-"`
+	LastResult     *time.Time `valid:"-"`
+	LastSeen       *time.Time `valid:"-"`
+}
+
+// CurrentSlaveStatus returns the status reported by this slave to the master
+func CurrentSlaveStatus(queueDepth int) *SlaveStatus {
+	return &SlaveStatus{
+		Version:    Version,
+		UptimeSec:  int64(GetUptime().Seconds()),
+		QueueDepth: queueDepth,
+	}
+}
+
+// setLastSeen fills in the most recent activity of the slave
+func (status *SlaveStatus) setLastSeen() {
+	status.LastSeen = status.LastConfigPoll
+	if status.LastResult != nil && (status.LastSeen == nil || status.LastResult.After(*status.LastSeen)) {
+		status.LastSeen = status.LastResult
+	}
+}
+
+// statusChanges returns the changes of the previous status of a slave by a contact at the given time, the slave was
+// silent if it had no contact for silentAfter
+func statusChanges(previous SlaveStatus, reported SlaveStatus, sourceIP string, now time.Time, silentAfter time.Duration) []string {
+
+	changes := []string{}
+	if previous.LastSeen == nil || now.Sub(*previous.LastSeen) >= silentAfter || previous.SourceIP != sourceIP {
+		changes = append(changes, SlaveChangeReachable)
+	}
+	// older slaves don't report their version
+	if previous.LastSeen != nil && reported.Version != "" && previous.Version != reported.Version {
+		changes = append(changes, SlaveChangeVersion)
+	}
+	return changes
+}
+
+// RecordSlaveActivity stores a config poll or result submission of a slave together with its reported status. A
+// slaveStatus event is published if the status changed.
+func RecordSlaveActivity(db *DB, slaveID uuid.UUID, activity string, reported *SlaveStatus, sourceIP string) error {
+
+	log.Debugf("RecordSlaveActivity: Recording activity '%v' of slave '%v' from '%v'", activity, slaveID, sourceIP)
+
+	if reported == nil {
+		reported = &SlaveStatus{}
+	}
+
+	// the previous status is only needed for events
+	var previous *SlaveStatus
+	if hasEventSubscribers() {
+		if slave, err := GetSlave(slaveID, db); err == nil && slave.Status != nil {
+			previous = slave.Status
+		}
+	}
+
+	var lastConfigPoll, lastResult interface{}
+	now := time.Now().UTC()
+	switch activity {
+	case SlaveActivityConfigPoll:
+		lastConfigPoll = now
+	case SlaveActivityResult:
+		lastResult = now
+	default:
+		return errors.New("Unknown slave activity: " + activity)
+	}
+
+	query := `
+	INSERT INTO t_SlaveStatus (strSlaveId, strVersion, nUptimeSec, nQueueDepth, strSourceIP, dtLastConfigPoll, dtLastResult)
+	VALUES (?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT (strSlaveId) DO UPDATE SET
+		strVersion = excluded.strVersion,
+		nUptimeSec = excluded.nUptimeSec,
+		nQueueDepth = excluded.nQueueDepth,
+		strSourceIP = excluded.strSourceIP,
+		dtLastConfigPoll = COALESCE(excluded.dtLastConfigPoll, t_SlaveStatus.dtLastConfigPoll),
+		dtLastResult = COALESCE(excluded.dtLastResult, t_SlaveStatus.dtLastResult)
+	`
+
+	_, err := db.Exec(query, slaveID, reported.Version, reported.UptimeSec, reported.QueueDepth, sourceIP, lastConfigPoll, lastResult)
+	if err != nil {
+		log.Warn("RecordSlaveActivity: Couldn't record slave activity, Error: ", err)
+		return errors.New("Couldn't record slave activity")
+	}
+
+	if previous != nil {
+		silentAfter := slaveSilentAfter(slaveSilentRules(db), slaveID)
+		if changes := statusChanges(*previous, *reported, sourceIP, now, silentAfter); len(changes) > 0 {
+			publishSlaveStatus(db, slaveID, activity, changes)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,247 @@
+package disttrace
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// kinds of errors counted by a slave
+const (
+	SlaveErrorTraceroute    = "traceroute"
+	SlaveErrorDuplicateHop  = "duplicateHop"
+	SlaveErrorQueueFull     = "queueFull"
+	SlaveErrorTransmit      = "transmit"
+	SlaveErrorRetryExceeded = "retryExceeded"
+)
+
+// metrics of a slave, exposed by its optional metrics listener
+var (
+	slaveProbeDuration = NewMetricHistogram("disttrace_slave_probe_duration_seconds",
+		"Duration of finished traceroute measurements.", MetricDurationBuckets)
+	slaveErrors = NewMetricCounter("disttrace_slave_errors_total",
+		"Errors of the slave by kind, failed transmits of results to the master are of kind transmit.", "kind")
+)
+
+// SlaveTelemetry holds a periodic health report of a slave, counters cover the time since the previous report
+type SlaveTelemetry struct {
+	ID               uuid.UUID      `valid:"-"`
+	Slave            *Slave         `json:",omitempty" valid:"-"`
+	Received         time.Time      `valid:"-"`
+	SlaveTime        time.Time      `valid:"-"`
+	QueueDepth       int            `valid:"-"`
+	DroppedResults   int            `valid:"-"`
+	Measurements     int            `valid:"-"`
+	MeasurementAvgMs float64        `valid:"-"`
+	MeasurementMaxMs float64        `valid:"-"`
+	ErrorCounts      map[string]int `valid:"-"`
+	ClockOffsetMs    float64        `valid:"-"`
+	Load1            float64        `valid:"-"`
+	Load5            float64        `valid:"-"`
+	Load15           float64        `valid:"-"`
+}
+
+// TelemetryReply is the master's answer to a telemetry report
+type TelemetryReply struct {
+	SubmitResult
+	MasterTime time.Time
+}
+
+// TelemetryCollector accumulates a slave's health counters between two reports
+type TelemetryCollector struct {
+	lock          sync.Mutex
+	dropped       int
+	measurements  int
+	durationSum   time.Duration
+	durationMax   time.Duration
+	errorCounts   map[string]int
+	clockOffsetMs float64
+}
+
+// NewTelemetryCollector returns an empty collector
+func NewTelemetryCollector() *TelemetryCollector {
+	return &TelemetryCollector{errorCounts: make(map[string]int)}
+}
+
+// RecordMeasurement counts a finished measurement and its duration
+func (c *TelemetryCollector) RecordMeasurement(duration time.Duration) {
+	slaveProbeDuration.Observe(duration.Seconds())
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.measurements++
+	c.durationSum += duration
+	if duration > c.durationMax {
+		c.durationMax = duration
+	}
+}
+
+// RecordError counts an error of the given kind, errors that lose a result count as dropped result as well
+func (c *TelemetryCollector) RecordError(kind string) {
+	slaveErrors.Inc(kind)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.errorCounts[kind]++
+	if kind == SlaveErrorQueueFull || kind == SlaveErrorRetryExceeded {
+		c.dropped++
+	}
+}
+
+// RecordClockOffset stores the clock offset to the master measured during the last report
+func (c *TelemetryCollector) RecordClockOffset(offset time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.clockOffsetMs = float64(offset) / float64(time.Millisecond)
+}
+
+// Snapshot returns a report of all counters and resets them, use Restore if the report couldn't be sent
+func (c *TelemetryCollector) Snapshot(queueDepth int) SlaveTelemetry {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	report := SlaveTelemetry{
+		SlaveTime:        time.Now(),
+		QueueDepth:       queueDepth,
+		DroppedResults:   c.dropped,
+		Measurements:     c.measurements,
+		MeasurementMaxMs: float64(c.durationMax) / float64(time.Millisecond),
+		ErrorCounts:      c.errorCounts,
+		ClockOffsetMs:    c.clockOffsetMs,
+	}
+	if c.measurements > 0 {
+		report.MeasurementAvgMs = float64(c.durationSum) / float64(c.measurements) / float64(time.Millisecond)
+	}
+
+	var err error
+	if report.Load1, report.Load5, report.Load15, err = readHostLoad(); err != nil {
+		log.Debug("Snapshot: Couldn't read host load, Error: ", err)
+		report.Load1, report.Load5, report.Load15 = -1, -1, -1
+	}
+
+	// reset counters for next period
+	c.dropped = 0
+	c.measurements = 0
+	c.durationSum = 0
+	c.durationMax = 0
+	c.errorCounts = make(map[string]int)
+
+	return report
+}
+
+// Restore adds the counters of a report that couldn't be sent back to the collector, so they are part of the next report
+func (c *TelemetryCollector) Restore(report SlaveTelemetry) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.dropped += report.DroppedResults
+	c.measurements += report.Measurements
+	c.durationSum += time.Duration(report.MeasurementAvgMs * float64(report.Measurements) * float64(time.Millisecond))
+	if max := time.Duration(report.MeasurementMaxMs * float64(time.Millisecond)); max > c.durationMax {
+		c.durationMax = max
+	}
+	for kind, count := range report.ErrorCounts {
+		c.errorCounts[kind] += count
+	}
+}
+
+// readHostLoad returns the 1, 5 and 15 minute load averages of the host, only available on Linux
+func readHostLoad() (float64, float64, float64, error) {
+
+	content, err := ioutil.ReadFile("/proc/loadavg")
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	fields := strings.Fields(string(content))
+	if len(fields) < 3 {
+		return 0, 0, 0, errors.New("Unexpected format of /proc/loadavg")
+	}
+
+	var loads [3]float64
+	for i := range loads {
+		if loads[i], err = strconv.ParseFloat(fields[i], 64); err != nil {
+			return 0, 0, 0, err
+		}
+	}
+
+	return loads[0], loads[1], loads[2], nil
+}
+
+// StoreSlaveTelemetry stores a telemetry report of a slave
+func StoreSlaveTelemetry(db *DB, slaveID uuid.UUID, report SlaveTelemetry) (SlaveTelemetry, error) {
+
+	log.Debugf("StoreSlaveTelemetry: Storing telemetry of slave '%v'...", slaveID)
+
+	errorCounts, err := json.Marshal(report.ErrorCounts)
+	if err != nil {
+		log.Warn("StoreSlaveTelemetry: Couldn't marshal error counts, Error: ", err)
+		return SlaveTelemetry{}, errors.New("Couldn't marshal error counts")
+	}
+
+	query := `
+	INSERT INTO t_SlaveTelemetry (strTelemetryId, strSlaveId, dtReceived, dtSlaveTime, nQueueDepth, nDroppedResults, nMeasurements,
+		dMeasurementAvgMs, dMeasurementMaxMs, strErrorCounts, dClockOffsetMs, dLoad1, dLoad5, dLoad15)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+
+	report.ID = uuid.New()
+	report.Received = time.Now().UTC()
+	_, err = db.Exec(query, report.ID, slaveID, report.Received, report.SlaveTime.UTC(), report.QueueDepth, report.DroppedResults, report.Measurements,
+		report.MeasurementAvgMs, report.MeasurementMaxMs, string(errorCounts), report.ClockOffsetMs, report.Load1, report.Load5, report.Load15)
+	if err != nil {
+		log.Warn("StoreSlaveTelemetry: Couldn't store telemetry, Error: ", err)
+		return SlaveTelemetry{}, errors.New("Couldn't store telemetry")
+	}
+
+	return report, nil
+}
+
+// GetSlaveTelemetry returns the telemetry reports of a slave received in the given period, oldest first
+func GetSlaveTelemetry(db *DB, slaveID uuid.UUID, from time.Time, to time.Time) ([]SlaveTelemetry, error) {
+
+	log.Debugf("GetSlaveTelemetry: fetching telemetry of slave '%v' from '%v' to '%v'...", slaveID, from, to)
+	reports := []SlaveTelemetry{}
+
+	received := db.Dialect.TimeExpr("dtReceived")
+	query := `
+		SELECT strTelemetryId, dtReceived, dtSlaveTime, nQueueDepth, nDroppedResults, nMeasurements,
+			dMeasurementAvgMs, dMeasurementMaxMs, strErrorCounts, dClockOffsetMs, dLoad1, dLoad5, dLoad15
+		FROM t_SlaveTelemetry
+		WHERE strSlaveId = ? AND ` + received + ` >= ` + db.Dialect.TimeExpr("?") + ` AND ` + received + ` <= ` + db.Dialect.TimeExpr("?") + `
+		ORDER BY ` + received
+
+	rows, err := db.Query(query, slaveID, from.UTC().Format(time.RFC3339), to.UTC().Format(time.RFC3339))
+	if err != nil {
+		log.Warn("GetSlaveTelemetry: Couldn't get telemetry from db, Error: ", err)
+		return reports, errors.New("Couldn't get telemetry")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r SlaveTelemetry
+		var errorCounts string
+		if err := rows.Scan(&r.ID, &r.Received, &r.SlaveTime, &r.QueueDepth, &r.DroppedResults, &r.Measurements,
+			&r.MeasurementAvgMs, &r.MeasurementMaxMs, &errorCounts, &r.ClockOffsetMs, &r.Load1, &r.Load5, &r.Load15); err != nil {
+			log.Warn("GetSlaveTelemetry: Couldn't read results from telemetry, Error: ", err)
+			return []SlaveTelemetry{}, errors.New("Couldn't get telemetry")
+		}
+		if err := json.Unmarshal([]byte(errorCounts), &r.ErrorCounts); err != nil {
+			log.Warn("GetSlaveTelemetry: Couldn't parse error counts, Error: ", err)
+			return []SlaveTelemetry{}, errors.New("Couldn't get telemetry")
+		}
+		reports = append(reports, r)
+	}
+
+	log.Debugf("GetSlaveTelemetry: returning '%v' telemetry reports from db...", len(reports))
+	return reports, nil
+}
@@ -3,6 +3,7 @@ package disttrace
 import (
 	"database/sql"
 	"errors"
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -12,24 +13,54 @@ type SlaveConfig struct {
 	ID         uuid.UUID     `json:",omitempty" valid:"-"`
 	MasterHost string        `json:"-" valid:"-"`
 	MasterPort string        `json:"-" valid:"-"`
+	MasterTLS  bool          `json:"-" valid:"-"`
 	Targets    []TraceTarget `valid:"-"`
 }
 
+// MasterURL returns the URL of the given path on the master server
+func (cfg SlaveConfig) MasterURL(path string) string {
+	scheme := "http://"
+	if cfg.MasterTLS {
+		scheme = "https://"
+	}
+	return scheme + cfg.MasterHost + ":" + cfg.MasterPort + path
+}
+
 // Slave holds all infos about a slave
 type Slave struct {
-	ID     uuid.UUID `json:",omitempty" valid:"-"`
-	Name   string    `valid:"alphanum,	required"`
-	Secret string    `valid:"alphanum,	required"`
+	ID     uuid.UUID    `json:",omitempty" valid:"-"`
+	Name   string       `valid:"alphanum,	required"`
+	Secret string       `valid:"alphanum,	required"`
+	Status *SlaveStatus `json:",omitempty" valid:"-"`
+	// Archived is set when the slave was deleted, its history is kept until it is purged
+	Archived       *time.Time `json:",omitempty" valid:"-"`
+	PurgeRequested *time.Time `json:",omitempty" valid:"-"`
+}
+
+const slaveColumns = `s.strSlaveId, s.strSlaveName, s.strSlaveSecret,
+	COALESCE(st.strVersion, ''), COALESCE(st.nUptimeSec, 0), COALESCE(st.nQueueDepth, 0), COALESCE(st.strSourceIP, ''),
+	st.dtLastConfigPoll, st.dtLastResult, s.dtArchived, s.dtPurgeRequested`
+
+// scanSlave reads a single slave including its status from the given row
+func scanSlave(row interface{ Scan(...interface{}) error }) (Slave, error) {
+	slave := Slave{Status: &SlaveStatus{}}
+	if err := row.Scan(&slave.ID, &slave.Name, &slave.Secret,
+		&slave.Status.Version, &slave.Status.UptimeSec, &slave.Status.QueueDepth, &slave.Status.SourceIP,
+		&slave.Status.LastConfigPoll, &slave.Status.LastResult, &slave.Archived, &slave.PurgeRequested); err != nil {
+		return Slave{}, err
+	}
+	slave.Status.setLastSeen()
+	return slave, nil
 }
 
-// CheckSlaveAuth checks supplied credentials for validity
+// CheckSlaveAuth checks supplied credentials for validity, archived slaves aren't allowed
 func CheckSlaveAuth(db *DB, user string, secret string) (bool, uuid.UUID) {
 	log.Debugf("CheckSlaveAuth: Checking auth for slave<%v> secret<%v> for validity...", user, secret)
 
 	query := `
 		SELECT strSlaveId 
 		FROM t_Slaves
-		WHERE strSlaveName = ? AND strSlaveSecret = ?
+		WHERE strSlaveName = ? AND strSlaveSecret = ? AND dtArchived IS NULL
 		LIMIT 1
 		`
 
@@ -49,16 +80,15 @@ func CheckSlaveAuth(db *DB, user string, secret string) (bool, uuid.UUID) {
 	return true, slaveID
 }
 
-// GetSlave returns the specified slave from DB
+// GetSlave returns the specified slave from DB, archived slaves included
 func GetSlave(slaveID uuid.UUID, db *DB) (Slave, error) {
 
 	log.Debug("GetSlave: fetching slave with ID: ", slaveID)
-	slave := Slave{}
 
-	query := "SELECT strSlaveID, strSlaveName, strSlaveSecret FROM t_Slaves WHERE strSlaveId = ?"
+	query := "SELECT " + slaveColumns + " FROM t_Slaves s LEFT JOIN t_SlaveStatus st ON st.strSlaveId = s.strSlaveId WHERE s.strSlaveId = ?"
 
-	row := db.QueryRow(query, slaveID)
-	if err := row.Scan(&slave.ID, &slave.Name, &slave.Secret); err != nil {
+	slave, err := scanSlave(db.QueryRow(query, slaveID))
+	if err != nil {
 		if err == sql.ErrNoRows {
 			log.Debug("GetSlave: Couldn't find specified slave in DB...")
 			return Slave{}, nil
@@ -71,30 +101,43 @@ func GetSlave(slaveID uuid.UUID, db *DB) (Slave, error) {
 	return slave, nil
 }
 
-// GetSlaves reads all slaves from the db
+// GetSlaves reads all active slaves from the db
 func GetSlaves(db *DB) ([]Slave, error) {
+	return getSlaves(db, false)
+}
+
+// GetArchivedSlaves reads all archived slaves from the db
+func GetArchivedSlaves(db *DB) ([]Slave, error) {
+	return getSlaves(db, true)
+}
+
+// getSlaves reads either the active or the archived slaves from the db
+func getSlaves(db *DB, archived bool) ([]Slave, error) {
 
-	log.Debug("GetSlaves: fetching slaves from db...")
+	log.Debugf("getSlaves: fetching slaves from db, archived: %v...", archived)
 	slaves := []Slave{}
 
-	query := "SELECT strSlaveId, strSlaveName, strSlaveSecret FROM t_Slaves"
+	query := "SELECT " + slaveColumns + " FROM t_Slaves s LEFT JOIN t_SlaveStatus st ON st.strSlaveId = s.strSlaveId WHERE s.dtArchived IS NULL"
+	if archived {
+		query = "SELECT " + slaveColumns + " FROM t_Slaves s LEFT JOIN t_SlaveStatus st ON st.strSlaveId = s.strSlaveId WHERE s.dtArchived IS NOT NULL"
+	}
 	rows, err := db.Query(query)
 	if err != nil {
-		log.Warn("GetSlaves: Couldn't get slaves from db, Error: ", err)
+		log.Warn("getSlaves: Couldn't get slaves from db, Error: ", err)
 		return slaves, errors.New("Couldn't get slaves")
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var slave = Slave{}
-		if err := rows.Scan(&slave.ID, &slave.Name, &slave.Secret); err != nil {
-			log.Warn("GetSlaves: Couldn't read results from db, Error: ", err)
+		slave, err := scanSlave(rows)
+		if err != nil {
+			log.Warn("getSlaves: Couldn't read results from db, Error: ", err)
 			return []Slave{}, errors.New("Couldn't get slaves")
 		}
 		slaves = append(slaves, slave)
 	}
 
-	log.Debugf("GetSlaves: returning '%v' slaves from db...", len(slaves))
+	log.Debugf("getSlaves: returning '%v' slaves from db...", len(slaves))
 	return slaves, nil
 }
 
@@ -137,25 +180,76 @@ func UpdateSlave(db *DB, slave Slave) (Slave, error) {
 	return slave, nil
 }
 
-// DeleteSlave deletes an existing slave from the db
-func DeleteSlave(db *DB, slaveID uuid.UUID) error {
-	log.Debugf("DeleteSlave: Deleting slave '%v'...", slaveID)
+// ArchiveSlave archives an existing slave, it can't connect to the master anymore but its history is kept
+func ArchiveSlave(db *DB, slaveID uuid.UUID) error {
+	log.Debugf("ArchiveSlave: Archiving slave '%v'...", slaveID)
+
+	query := "UPDATE t_Slaves SET dtArchived = ? WHERE strSlaveId = ? AND dtArchived IS NULL"
+
+	res, err := db.Exec(query, time.Now().UTC(), slaveID)
+	if err != nil {
+		log.Warn("ArchiveSlave: Couldn't archive slave, Error: ", err)
+		return errors.New("Couldn't archive slave")
+	}
+
+	numRows, err := res.RowsAffected()
+	if err != nil {
+		log.Warn("ArchiveSlave: Error: Can't get number of affected rows, Error: ", err)
+		return errors.New("DB Error")
+	}
+
+	log.Debugf("ArchiveSlave: Slave '%v' successfully archived, rows: '%v'", slaveID, numRows)
+	return nil
+}
+
+// RestoreSlave restores an archived slave together with its history
+func RestoreSlave(db *DB, slaveID uuid.UUID) error {
+	log.Debugf("RestoreSlave: Restoring slave '%v'...", slaveID)
 
-	query := "DELETE FROM t_Slaves WHERE strSlaveId = ?"
+	query := "UPDATE t_Slaves SET dtArchived = NULL WHERE strSlaveId = ? AND dtArchived IS NOT NULL AND dtPurgeRequested IS NULL"
 
 	res, err := db.Exec(query, slaveID)
 	if err != nil {
-		log.Warn("DeleteSlave: Couldn't delete slave, Error: ", err)
-		return errors.New("Couldn't delete slave")
+		log.Warn("RestoreSlave: Couldn't restore slave, Error: ", err)
+		return errors.New("Couldn't restore slave")
 	}
 
 	numRows, err := res.RowsAffected()
 	if err != nil {
-		log.Warn("DeleteSlave: Error: Can't get number of affected rows, Error: ", err)
+		log.Warn("RestoreSlave: Error: Can't get number of affected rows, Error: ", err)
 		return errors.New("DB Error")
 	}
+	if numRows == 0 {
+		log.Debugf("RestoreSlave: Slave '%v' isn't archived or is being purged", slaveID)
+		return ErrNotArchived
+	}
 
-	log.Debugf("DeleteSlave: Slave '%v' successfully deleted, rows: '%v'", slaveID, numRows)
+	log.Debugf("RestoreSlave: Slave '%v' successfully restored", slaveID)
 	return nil
+}
+
+// PurgeSlave requests the deletion of an archived slave, the Purger deletes the slave and all its results
+func PurgeSlave(db *DB, slaveID uuid.UUID) error {
+	log.Debugf("PurgeSlave: Requesting purge of slave '%v'...", slaveID)
+
+	query := "UPDATE t_Slaves SET dtPurgeRequested = ? WHERE strSlaveId = ? AND dtArchived IS NOT NULL AND dtPurgeRequested IS NULL"
 
+	res, err := db.Exec(query, time.Now().UTC(), slaveID)
+	if err != nil {
+		log.Warn("PurgeSlave: Couldn't request purge of slave, Error: ", err)
+		return errors.New("Couldn't request purge of slave")
+	}
+
+	numRows, err := res.RowsAffected()
+	if err != nil {
+		log.Warn("PurgeSlave: Error: Can't get number of affected rows, Error: ", err)
+		return errors.New("DB Error")
+	}
+	if numRows == 0 {
+		log.Debugf("PurgeSlave: Slave '%v' isn't archived or is already being purged", slaveID)
+		return ErrNotArchived
+	}
+
+	log.Debugf("PurgeSlave: Purge of slave '%v' successfully requested", slaveID)
+	return nil
 }
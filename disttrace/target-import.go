@@ -0,0 +1,388 @@
+package disttrace
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+
+	valid "github.com/asaskevich/govalidator"
+	"github.com/google/uuid"
+)
+
+// formats of target imports
+const (
+	TargetImportCSV  = "csv"
+	TargetImportJSON = "json"
+	TargetImportList = "list"
+)
+
+// results of the rows of a target import
+const (
+	TargetImportCreated   = "created"
+	TargetImportUpdated   = "updated"
+	TargetImportUnchanged = "unchanged"
+	TargetImportFailed    = "failed"
+)
+
+// TargetImportRow is a single target of a bulk import. Unset probe parameters of new targets are taken from the
+// group or the defaults, existing targets keep their values. Group is the name or ID of a target group.
+type TargetImportRow struct {
+	Name      string `json:"name"`
+	Address   string `json:"address"`
+	Retries   int    `json:"retries"`
+	MaxHops   int    `json:"maxHops"`
+	TimeoutMs int    `json:"timeoutMs"`
+	Group     string `json:"group"`
+
+	// number of the row in the document, starting at 1, and error while parsing the row
+	row int
+	err error
+}
+
+// TargetImportResult is the result of a single row of a bulk import
+type TargetImportResult struct {
+	Row      int
+	Name     string
+	Result   string
+	TargetID *uuid.UUID `json:",omitempty"`
+	Changes  []string   `json:",omitempty"`
+	Error    string     `json:",omitempty"`
+}
+
+// TargetImportReport holds the results of all rows of a bulk import
+type TargetImportReport struct {
+	DryRun    bool
+	Created   int
+	Updated   int
+	Unchanged int
+	Failed    int
+	Results   []TargetImportResult
+}
+
+// TargetImportFormatFromFileName returns the format of a target import by its file extension, lists by default
+func TargetImportFormatFromFileName(fileName string) string {
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".csv":
+		return TargetImportCSV
+	case ".json":
+		return TargetImportJSON
+	}
+	return TargetImportList
+}
+
+// ParseTargetImport reads the rows of a target import document. CSV documents need a header with the columns name,
+// address and optionally retries, maxHops, timeoutMs and group. JSON documents are an array of objects with the same
+// fields. Lists hold a hostname or address per line, optionally followed by a name, e.g. exported from a DNS zone.
+// Returns an error if the document can't be read at all, errors of single rows are reported on import.
+func ParseTargetImport(data []byte, format string) ([]TargetImportRow, error) {
+
+	switch format {
+	case TargetImportCSV:
+		return parseTargetImportCSV(data)
+	case TargetImportJSON:
+		rows := []TargetImportRow{}
+		if err := json.Unmarshal(data, &rows); err != nil {
+			return nil, fmt.Errorf("Invalid JSON document, expected an array of targets: %v", err)
+		}
+		for i := range rows {
+			rows[i].row = i + 1
+		}
+		return rows, nil
+	case TargetImportList:
+		return parseTargetImportList(data)
+	}
+
+	return nil, fmt.Errorf("Unknown format '%v', supported are %v, %v and %v", format, TargetImportCSV, TargetImportJSON, TargetImportList)
+}
+
+// parseTargetImportCSV reads the rows of a CSV document
+func parseTargetImportCSV(data []byte) ([]TargetImportRow, error) {
+
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("Can't read CSV header: %v", err)
+	}
+
+	columns := make(map[string]int)
+	for i, column := range header {
+		column = strings.ToLower(strings.TrimSpace(column))
+		switch column {
+		case "name", "address", "retries", "maxhops", "timeoutms", "group":
+			columns[column] = i
+		default:
+			return nil, fmt.Errorf("Unknown CSV column '%v', supported are name, address, retries, maxHops, timeoutMs and group", header[i])
+		}
+	}
+	if _, ok := columns["name"]; !ok {
+		return nil, errors.New("CSV header is missing the column 'name'")
+	}
+	if _, ok := columns["address"]; !ok {
+		return nil, errors.New("CSV header is missing the column 'address'")
+	}
+
+	rows := []TargetImportRow{}
+	for rowNumber := 1; ; rowNumber++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		row := TargetImportRow{row: rowNumber}
+		if err != nil {
+			row.err = err
+			rows = append(rows, row)
+			continue
+		}
+
+		field := func(column string) string {
+			if i, ok := columns[column]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		number := func(column string) int {
+			value := field(column)
+			if value == "" {
+				return 0
+			}
+			n, err := strconv.Atoi(value)
+			if err != nil && row.err == nil {
+				row.err = fmt.Errorf("%v: invalid number '%v'", column, value)
+			}
+			return n
+		}
+
+		row.Name, row.Address, row.Group = field("name"), field("address"), field("group")
+		row.Retries, row.MaxHops, row.TimeoutMs = number("retries"), number("maxhops"), number("timeoutms")
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+// parseTargetImportList reads a list with an address and an optional name per line, the name defaults to the
+// alphanumeric characters of the address. Empty lines and lines starting with # are skipped.
+func parseTargetImportList(data []byte) ([]TargetImportRow, error) {
+
+	rows := []TargetImportRow{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		row := TargetImportRow{row: len(rows) + 1, Address: strings.TrimSuffix(fields[0], ".")}
+		switch len(fields) {
+		case 1:
+			row.Name = strings.Map(func(r rune) rune {
+				if unicode.IsLetter(r) || unicode.IsDigit(r) {
+					return r
+				}
+				return -1
+			}, row.Address)
+		case 2:
+			row.Name = fields[1]
+		default:
+			row.err = errors.New("expected an address and an optional name")
+		}
+		rows = append(rows, row)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Can't read list: %v", err)
+	}
+	return rows, nil
+}
+
+// ImportTargets creates the targets of the rows or updates the existing targets with the same name and adds them to
+// their groups. Every row is validated and imported on its own, the report holds the result of every row. A dry run
+// only validates the rows and reports what would be changed.
+func ImportTargets(db *DB, rows []TargetImportRow, dryRun bool) (TargetImportReport, error) {
+
+	log.Infof("ImportTargets: Importing %v targets, dry run: %v...", len(rows), dryRun)
+	report := TargetImportReport{DryRun: dryRun, Results: []TargetImportResult{}}
+
+	active, err := GetTargets(db)
+	if err != nil {
+		return report, err
+	}
+	archived, err := GetArchivedTargets(db)
+	if err != nil {
+		return report, err
+	}
+	existing := make(map[string]TraceTarget)
+	for _, target := range append(active, archived...) {
+		existing[target.Name] = target
+	}
+
+	groups, err := GetTargetGroups(db)
+	if err != nil {
+		return report, err
+	}
+	groupsByName := make(map[string]TargetGroup)
+	for _, group := range groups {
+		groupsByName[group.Name] = group
+		groupsByName[group.ID.String()] = group
+	}
+
+	imported := make(map[string]int)
+	for _, row := range rows {
+		result := importTargetRow(db, row, existing, groupsByName, imported, dryRun)
+		switch result.Result {
+		case TargetImportCreated:
+			report.Created++
+		case TargetImportUpdated:
+			report.Updated++
+		case TargetImportUnchanged:
+			report.Unchanged++
+		default:
+			report.Failed++
+		}
+		report.Results = append(report.Results, result)
+	}
+
+	log.Infof("ImportTargets: Finished, created: %v, updated: %v, unchanged: %v, failed: %v",
+		report.Created, report.Updated, report.Unchanged, report.Failed)
+	return report, nil
+}
+
+// importTargetRow validates and imports a single row, imported holds the names of the rows imported before
+func importTargetRow(db *DB, row TargetImportRow, existing map[string]TraceTarget, groups map[string]TargetGroup,
+	imported map[string]int, dryRun bool) TargetImportResult {
+
+	result := TargetImportResult{Row: row.row, Name: row.Name, Result: TargetImportFailed}
+	if row.err != nil {
+		result.Error = row.err.Error()
+		return result
+	}
+	if previous, ok := imported[row.Name]; ok {
+		result.Error = fmt.Sprintf("Target '%v' was already imported in row %v", row.Name, previous)
+		return result
+	}
+
+	group, inGroup := groups[row.Group]
+	if row.Group != "" && !inGroup {
+		result.Error = fmt.Sprintf("Unknown target group '%v'", row.Group)
+		return result
+	}
+
+	current, exists := existing[row.Name]
+	if exists && current.Archived != nil {
+		result.Error = fmt.Sprintf("Target '%v' is archived, restore it instead", row.Name)
+		return result
+	}
+
+	// unset parameters of existing targets keep their values, new targets get the defaults
+	target := TraceTarget{Name: row.Name, Address: row.Address, Retries: row.Retries, MaxHops: row.MaxHops, TimeoutMs: row.TimeoutMs}
+	if exists {
+		target.ID = current.ID
+		keepTargetParameters(current, &target)
+	} else {
+		group.ApplyDefaults(&target)
+		applyTargetDefaults(&target)
+	}
+	if ok, err := valid.ValidateStruct(target); !ok || err != nil {
+		result.Error = fmt.Sprintf("Invalid target: %v", err)
+		return result
+	}
+
+	addToGroup := inGroup && !containsID(group.TargetIDs, target.ID)
+	if exists {
+		result.Changes = appendDifference(result.Changes, "address", current.Address, target.Address)
+		result.Changes = appendDifference(result.Changes, "retries", current.Retries, target.Retries)
+		result.Changes = appendDifference(result.Changes, "maxHops", current.MaxHops, target.MaxHops)
+		result.Changes = appendDifference(result.Changes, "timeoutMs", current.TimeoutMs, target.TimeoutMs)
+		if addToGroup {
+			result.Changes = append(result.Changes, "group: +"+group.Name)
+		}
+	}
+
+	switch {
+	case !exists:
+		result.Result = TargetImportCreated
+		if !dryRun {
+			var groupIDs []uuid.UUID
+			if addToGroup {
+				groupIDs, addToGroup = append(groupIDs, group.ID), false
+			}
+			created, err := CreateTarget(db, target, groupIDs...)
+			if err != nil {
+				result.Result, result.Error = TargetImportFailed, err.Error()
+				return result
+			}
+			target.ID = created.ID
+		}
+	case len(result.Changes) == 0:
+		result.Result = TargetImportUnchanged
+	default:
+		result.Result = TargetImportUpdated
+		if !dryRun {
+			if _, err := UpdateTarget(db, target); err != nil {
+				result.Result, result.Error = TargetImportFailed, err.Error()
+				return result
+			}
+		}
+	}
+
+	if addToGroup && !dryRun {
+		if err := AddTargetToGroup(db, group.ID, target.ID); err != nil {
+			result.Result, result.Error = TargetImportFailed, err.Error()
+			return result
+		}
+	}
+
+	imported[row.Name] = row.row
+	if !dryRun {
+		result.TargetID = &target.ID
+	}
+	return result
+}
+
+// keepTargetParameters fills in the probe parameters of the current target for all unset parameters of the target
+func keepTargetParameters(current TraceTarget, target *TraceTarget) {
+	if target.Retries == 0 {
+		target.Retries = current.Retries
+	}
+	if target.MaxHops == 0 {
+		target.MaxHops = current.MaxHops
+	}
+	if target.TimeoutMs == 0 {
+		target.TimeoutMs = current.TimeoutMs
+	}
+}
+
+// containsID checks if the list contains the ID
+func containsID(ids []uuid.UUID, id uuid.UUID) bool {
+	for _, other := range ids {
+		if other == id {
+			return true
+		}
+	}
+	return false
+}
+
+// sameIDs checks if both lists hold the same IDs, regardless of their order
+func sameIDs(ids []uuid.UUID, others []uuid.UUID) bool {
+	if len(ids) != len(others) {
+		return false
+	}
+	for _, id := range ids {
+		if !containsID(others, id) {
+			return false
+		}
+	}
+	return true
+}
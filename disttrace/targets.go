@@ -3,6 +3,7 @@ package disttrace
 import (
 	"database/sql"
 	"errors"
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -15,18 +16,32 @@ type TraceTarget struct {
 	Retries   int       `valid:"int,	required,	range(0|10)"`
 	MaxHops   int       `valid:"int,	required,	range(1|100)"`
 	TimeoutMs int       `valid:"int,	required,	range(1|10000)"`
+	// Archived is set when the target was deleted, its history is kept until it is purged
+	Archived       *time.Time `json:",omitempty" valid:"-"`
+	PurgeRequested *time.Time `json:",omitempty" valid:"-"`
 }
 
-// GetTarget returns the specified target from DB
+const targetColumns = "strTargetId, strDescription, strDestination, nRetries, nMaxHops, nTimeoutMSec, dtArchived, dtPurgeRequested"
+
+// scanTarget reads a single target from the given row
+func scanTarget(row interface{ Scan(...interface{}) error }) (TraceTarget, error) {
+	target := TraceTarget{}
+	if err := row.Scan(&target.ID, &target.Name, &target.Address, &target.Retries, &target.MaxHops, &target.TimeoutMs,
+		&target.Archived, &target.PurgeRequested); err != nil {
+		return TraceTarget{}, err
+	}
+	return target, nil
+}
+
+// GetTarget returns the specified target from DB, archived targets included
 func GetTarget(targetID uuid.UUID, db *DB) (TraceTarget, error) {
 
 	log.Debug("GetTarget: fetching target with ID: ", targetID)
-	target := TraceTarget{}
 
-	query := "SELECT strTargetId, strDescription, strDestination, nRetries, nMaxHops, nTimeoutMSec FROM t_Targets WHERE strTargetId = ?"
+	query := "SELECT " + targetColumns + " FROM t_Targets WHERE strTargetId = ?"
 
-	row := db.QueryRow(query, targetID)
-	if err := row.Scan(&target.ID, &target.Name, &target.Address, &target.Retries, &target.MaxHops, &target.TimeoutMs); err != nil {
+	target, err := scanTarget(db.QueryRow(query, targetID))
+	if err != nil {
 		if err == sql.ErrNoRows {
 			log.Debug("GetTarget: Couldn't find specified target in DB...")
 			return TraceTarget{}, nil
@@ -39,43 +54,89 @@ func GetTarget(targetID uuid.UUID, db *DB) (TraceTarget, error) {
 	return target, nil
 }
 
-// GetTargets reads all targets from the db
+// GetTargets reads all active targets from the db
 func GetTargets(db *DB) ([]TraceTarget, error) {
+	return getTargets(db, false)
+}
+
+// GetArchivedTargets reads all archived targets from the db
+func GetArchivedTargets(db *DB) ([]TraceTarget, error) {
+	return getTargets(db, true)
+}
+
+// getTargets reads either the active or the archived targets from the db
+func getTargets(db *DB, archived bool) ([]TraceTarget, error) {
 
-	log.Debug("GetTargets: fetching targets from db...")
+	log.Debugf("getTargets: fetching targets from db, archived: %v...", archived)
 	targets := []TraceTarget{}
 
-	query := "SELECT strTargetId, strDescription, strDestination, nRetries, nMaxHops, nTimeoutMSec FROM t_Targets"
+	query := "SELECT " + targetColumns + " FROM t_Targets WHERE dtArchived IS NULL"
+	if archived {
+		query = "SELECT " + targetColumns + " FROM t_Targets WHERE dtArchived IS NOT NULL"
+	}
 	rows, err := db.Query(query)
 	if err != nil {
-		log.Warn("GetTargets: Couldn't get targets from db, Error: ", err)
+		log.Warn("getTargets: Couldn't get targets from db, Error: ", err)
 		return targets, errors.New("Couldn't get targets")
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var target = TraceTarget{}
-		if err := rows.Scan(&target.ID, &target.Name, &target.Address, &target.Retries, &target.MaxHops, &target.TimeoutMs); err != nil {
-			log.Warn("GetTargets: Couldn't read results from targets, Error: ", err)
+		target, err := scanTarget(rows)
+		if err != nil {
+			log.Warn("getTargets: Couldn't read results from targets, Error: ", err)
 			return []TraceTarget{}, errors.New("Couldn't get targets")
 		}
 		targets = append(targets, target)
 	}
 
-	log.Debugf("GetTargets: returning '%v' targets from db...", len(targets))
+	log.Debugf("getTargets: returning '%v' targets from db...", len(targets))
 	return targets, nil
 }
 
-// CreateTarget stores a new target in the db
-func CreateTarget(db *DB, target TraceTarget) (TraceTarget, error) {
-	log.Debug("CreateTarget: Creating new target, name: ", target.Name)
+// GetTargetsForSlave reads all active targets assigned to the given slave from the db. Targets without a target group
+// are assigned to all slaves, grouped targets only to the slaves in a slave group the target group is assigned to.
+func GetTargetsForSlave(db *DB, slaveID uuid.UUID) ([]TraceTarget, error) {
+
+	log.Debugf("GetTargetsForSlave: fetching targets for slave '%v' from db...", slaveID)
+	targets := []TraceTarget{}
 
 	query := `
-	INSERT INTO t_Targets (strTargetId, strDescription, strDestination, nRetries, nMaxHops, nTimeoutMSec) 
-	VALUES (?, ?, ?, ?, ?, ?)
-	`
+		SELECT ` + targetColumns + `
+		FROM t_Targets t
+		WHERE t.dtArchived IS NULL AND (
+			NOT EXISTS (SELECT 1 FROM t_TargetGroupMembers tm WHERE tm.strTargetId = t.strTargetId)
+			OR t.strTargetId IN (
+				SELECT tm.strTargetId
+				FROM t_TargetGroupMembers tm
+					JOIN t_SlaveGroupTargetGroups a ON a.strTargetGroupId = tm.strGroupId
+					JOIN t_SlaveGroupMembers sm ON sm.strGroupId = a.strSlaveGroupId
+				WHERE sm.strSlaveId = ?
+			)
+		)
+		`
+	rows, err := db.Query(query, slaveID)
+	if err != nil {
+		log.Warn("GetTargetsForSlave: Couldn't get targets from db, Error: ", err)
+		return targets, errors.New("Couldn't get targets")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		target, err := scanTarget(rows)
+		if err != nil {
+			log.Warn("GetTargetsForSlave: Couldn't read results from targets, Error: ", err)
+			return []TraceTarget{}, errors.New("Couldn't get targets")
+		}
+		targets = append(targets, target)
+	}
+
+	log.Debugf("GetTargetsForSlave: returning '%v' targets for slave '%v'...", len(targets), slaveID)
+	return targets, nil
+}
 
-	// default values
+// applyTargetDefaults fills in the default probe parameters for all unset parameters of the target
+func applyTargetDefaults(target *TraceTarget) {
 	if target.Retries == 0 {
 		target.Retries = 1
 	}
@@ -85,10 +146,16 @@ func CreateTarget(db *DB, target TraceTarget) (TraceTarget, error) {
 	if target.TimeoutMs == 0 {
 		target.TimeoutMs = 500
 	}
+}
+
+// CreateTarget stores a new target in the db and adds it to the given target groups
+func CreateTarget(db *DB, target TraceTarget, groupIDs ...uuid.UUID) (TraceTarget, error) {
+	log.Debug("CreateTarget: Creating new target, name: ", target.Name)
+
+	applyTargetDefaults(&target)
 
 	target.ID = uuid.New()
-	_, err := db.Exec(query, target.ID, target.Name, target.Address, target.Retries, target.MaxHops, target.TimeoutMs)
-	if err != nil {
+	if err := insertTarget(db, target, groupIDs); err != nil {
 		log.Warn("CreateTarget: Couldn't create target, Error: ", err)
 		return TraceTarget{}, errors.New("Couldn't create target")
 	}
@@ -97,6 +164,38 @@ func CreateTarget(db *DB, target TraceTarget) (TraceTarget, error) {
 	return target, nil
 }
 
+// insertTarget inserts a target and adds it to the given target groups in a single transaction
+func insertTarget(db *DB, target TraceTarget, groupIDs []uuid.UUID) (err error) {
+
+	var tx *Tx
+	if tx, err = db.Begin(); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	query := `
+	INSERT INTO t_Targets (strTargetId, strDescription, strDestination, nRetries, nMaxHops, nTimeoutMSec) 
+	VALUES (?, ?, ?, ?, ?, ?)
+	`
+	_, err = tx.Exec(query, target.ID, target.Name, target.Address, target.Retries, target.MaxHops, target.TimeoutMs)
+	if err != nil {
+		return err
+	}
+
+	for _, groupID := range groupIDs {
+		query = "INSERT INTO t_TargetGroupMembers (strGroupId, strTargetId) VALUES (?, ?)"
+		if _, err = tx.Exec(query, groupID, target.ID); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
 // UpdateTarget updates an existing new target in the db
 func UpdateTarget(db *DB, target TraceTarget) (TraceTarget, error) {
 	log.Debugf("UpdateTarget: Updating target '%v'...", target.ID)
@@ -121,25 +220,76 @@ func UpdateTarget(db *DB, target TraceTarget) (TraceTarget, error) {
 	return target, nil
 }
 
-// DeleteTarget deletes an existing target from the db
-func DeleteTarget(db *DB, targetID uuid.UUID) error {
-	log.Debugf("DeleteTarget: Deleting target '%v'...", targetID)
+// ArchiveTarget archives an existing target, it isn't delivered to the slaves anymore but its history is kept
+func ArchiveTarget(db *DB, targetID uuid.UUID) error {
+	log.Debugf("ArchiveTarget: Archiving target '%v'...", targetID)
+
+	query := "UPDATE t_Targets SET dtArchived = ? WHERE strTargetId = ? AND dtArchived IS NULL"
+
+	res, err := db.Exec(query, time.Now().UTC(), targetID)
+	if err != nil {
+		log.Warn("ArchiveTarget: Couldn't archive target, Error: ", err)
+		return errors.New("Couldn't archive target")
+	}
+
+	numRows, err := res.RowsAffected()
+	if err != nil {
+		log.Warn("ArchiveTarget: Error: Can't get number of affected rows, Error: ", err)
+		return errors.New("DB Error")
+	}
+
+	log.Debugf("ArchiveTarget: Target '%v' successfully archived, rows: '%v'", targetID, numRows)
+	return nil
+}
+
+// RestoreTarget restores an archived target together with its history
+func RestoreTarget(db *DB, targetID uuid.UUID) error {
+	log.Debugf("RestoreTarget: Restoring target '%v'...", targetID)
 
-	query := "DELETE FROM t_Targets WHERE strTargetId = ?"
+	query := "UPDATE t_Targets SET dtArchived = NULL WHERE strTargetId = ? AND dtArchived IS NOT NULL AND dtPurgeRequested IS NULL"
 
 	res, err := db.Exec(query, targetID)
 	if err != nil {
-		log.Warn("DeleteTarget: Couldn't delete target, Error: ", err)
-		return errors.New("Couldn't delete target")
+		log.Warn("RestoreTarget: Couldn't restore target, Error: ", err)
+		return errors.New("Couldn't restore target")
 	}
 
 	numRows, err := res.RowsAffected()
 	if err != nil {
-		log.Warn("DeleteTarget: Error: Can't get number of affected rows, Error: ", err)
+		log.Warn("RestoreTarget: Error: Can't get number of affected rows, Error: ", err)
 		return errors.New("DB Error")
 	}
+	if numRows == 0 {
+		log.Debugf("RestoreTarget: Target '%v' isn't archived or is being purged", targetID)
+		return ErrNotArchived
+	}
 
-	log.Debugf("DeleteTarget: Target '%v' successfully deleted, rows: '%v'", targetID, numRows)
+	log.Debugf("RestoreTarget: Target '%v' successfully restored", targetID)
 	return nil
+}
+
+// PurgeTarget requests the deletion of an archived target, the Purger deletes the target and all its results
+func PurgeTarget(db *DB, targetID uuid.UUID) error {
+	log.Debugf("PurgeTarget: Requesting purge of target '%v'...", targetID)
+
+	query := "UPDATE t_Targets SET dtPurgeRequested = ? WHERE strTargetId = ? AND dtArchived IS NOT NULL AND dtPurgeRequested IS NULL"
 
+	res, err := db.Exec(query, time.Now().UTC(), targetID)
+	if err != nil {
+		log.Warn("PurgeTarget: Couldn't request purge of target, Error: ", err)
+		return errors.New("Couldn't request purge of target")
+	}
+
+	numRows, err := res.RowsAffected()
+	if err != nil {
+		log.Warn("PurgeTarget: Error: Can't get number of affected rows, Error: ", err)
+		return errors.New("DB Error")
+	}
+	if numRows == 0 {
+		log.Debugf("PurgeTarget: Target '%v' isn't archived or is already being purged", targetID)
+		return ErrNotArchived
+	}
+
+	log.Debugf("PurgeTarget: Purge of target '%v' successfully requested", targetID)
+	return nil
 }
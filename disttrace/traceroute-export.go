@@ -0,0 +1,263 @@
+package disttrace
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// formats of traceroute exports
+const (
+	TracerouteExportCSV     = "csv"
+	TracerouteExportNDJSON  = "ndjson"
+	TracerouteExportParquet = "parquet"
+)
+
+// ErrUnknownExportFormat is returned for traceroute exports in formats which aren't supported
+var ErrUnknownExportFormat = errors.New("Unknown export format")
+
+// tracerouteExportColumns are the columns of the CSV and parquet exports, a row per hop
+var tracerouteExportColumns = []string{
+	"tracerouteId", "startTime", "slaveId", "slave", "targetId", "target", "address", "success", "hopCount",
+	"hopIndex", "hopIp", "hopDns", "rttMs", "loss", "asn", "asName", "country",
+}
+
+// tracerouteExportWriter writes the traceroutes of an export in a single format
+type tracerouteExportWriter interface {
+	write(tr Traceroute) error
+	close() error
+}
+
+// TracerouteExportFormatFromFileName returns the export format matching the extension of a file name, CSV by default
+func TracerouteExportFormatFromFileName(fileName string) string {
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".ndjson", ".jsonl":
+		return TracerouteExportNDJSON
+	case ".parquet":
+		return TracerouteExportParquet
+	}
+	return TracerouteExportCSV
+}
+
+// TracerouteExportContentType returns the media type of a traceroute export format
+func TracerouteExportContentType(format string) string {
+	switch format {
+	case TracerouteExportCSV:
+		return "text/csv"
+	case TracerouteExportNDJSON:
+		return "application/x-ndjson"
+	case TracerouteExportParquet:
+		return "application/vnd.apache.parquet"
+	}
+	return "application/octet-stream"
+}
+
+// ExportTraceroutes writes all traceroutes matching the filter, newest first, and returns their number. CSV and
+// parquet files have a row per hop, NDJSON a traceroute including its hops per line. The traceroutes are read page
+// by page, only a page and a parquet row group are held in memory. Nothing is written if the filter is invalid.
+func ExportTraceroutes(db *DB, filter TracerouteFilter, format string, w io.Writer) (int, error) {
+
+	log.Debugf("ExportTraceroutes: exporting traceroutes, format: %v, filter: %+v", format, filter)
+
+	switch format {
+	case TracerouteExportCSV, TracerouteExportNDJSON, TracerouteExportParquet:
+	default:
+		return 0, ErrUnknownExportFormat
+	}
+
+	filter.Limit, filter.Cursor = MaxTracerouteLimit, ""
+	page, err := QueryTraceroutes(db, filter)
+	if err != nil {
+		return 0, err
+	}
+
+	out := bufio.NewWriter(w)
+	var writer tracerouteExportWriter
+	switch format {
+	case TracerouteExportCSV:
+		writer, err = newCSVExportWriter(out)
+	case TracerouteExportNDJSON:
+		writer = ndjsonExportWriter{encoder: json.NewEncoder(out)}
+	case TracerouteExportParquet:
+		writer = newParquetExportWriter(out)
+	}
+	if err != nil {
+		log.Warn("ExportTraceroutes: Couldn't write export, Error: ", err)
+		return 0, errors.New("Couldn't write export")
+	}
+
+	count := 0
+	for {
+		for _, tr := range page.Traceroutes {
+			if err := writer.write(tr); err != nil {
+				log.Warn("ExportTraceroutes: Couldn't write export, Error: ", err)
+				return count, errors.New("Couldn't write export")
+			}
+			count++
+		}
+
+		if page.NextCursor == "" {
+			break
+		}
+		filter.Cursor = page.NextCursor
+		if page, err = QueryTraceroutes(db, filter); err != nil {
+			return count, err
+		}
+	}
+
+	if err := writer.close(); err != nil {
+		log.Warn("ExportTraceroutes: Couldn't write export, Error: ", err)
+		return count, errors.New("Couldn't write export")
+	}
+	if err := out.Flush(); err != nil {
+		log.Warn("ExportTraceroutes: Couldn't write export, Error: ", err)
+		return count, errors.New("Couldn't write export")
+	}
+
+	log.Debugf("ExportTraceroutes: exported '%v' traceroutes", count)
+	return count, nil
+}
+
+// tracerouteExportRows returns the rows of a traceroute, a row per hop or a single row without hop if it has none.
+// Values which aren't known are nil.
+func tracerouteExportRows(tr Traceroute) [][]interface{} {
+
+	traceroute := []interface{}{
+		tr.ID.String(), tr.StartTime, tr.Slave.ID.String(), tr.Slave.Name, tr.Target.ID.String(), tr.Target.Name,
+		tr.Target.Address, tr.Success, int32(tr.HopCount),
+	}
+	if len(tr.Hops) == 0 {
+		return [][]interface{}{append(traceroute, nil, nil, nil, nil, nil, nil, nil, nil)}
+	}
+
+	rows := [][]interface{}{}
+	for _, hop := range tr.Hops {
+		row := append(append([]interface{}{}, traceroute...), int32(hop.Index), hop.IPAddress)
+		if hop.DNSName != "" {
+			row = append(row, hop.DNSName)
+		} else {
+			row = append(row, nil)
+		}
+		if hop.RTTMs != nil {
+			row = append(row, *hop.RTTMs)
+		} else {
+			row = append(row, nil)
+		}
+		if hop.Loss != nil {
+			row = append(row, *hop.Loss)
+		} else {
+			row = append(row, nil)
+		}
+		if hop.AS != nil {
+			row = append(row, int64(hop.AS.Number), hop.AS.Description, hop.AS.Country)
+		} else {
+			row = append(row, nil, nil, nil)
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// csvExportWriter writes a row per hop with a header, unknown values are empty
+type csvExportWriter struct {
+	writer *csv.Writer
+}
+
+func newCSVExportWriter(w io.Writer) (csvExportWriter, error) {
+	writer := csvExportWriter{writer: csv.NewWriter(w)}
+	return writer, writer.writer.Write(tracerouteExportColumns)
+}
+
+func (w csvExportWriter) write(tr Traceroute) error {
+	for _, row := range tracerouteExportRows(tr) {
+		record := make([]string, len(row))
+		for i, value := range row {
+			switch value := value.(type) {
+			case time.Time:
+				record[i] = value.UTC().Format(time.RFC3339)
+			case bool:
+				record[i] = strconv.FormatBool(value)
+			case int32:
+				record[i] = strconv.Itoa(int(value))
+			case int64:
+				record[i] = strconv.FormatInt(value, 10)
+			case float64:
+				record[i] = strconv.FormatFloat(value, 'f', -1, 64)
+			case string:
+				record[i] = value
+			}
+		}
+		if err := w.writer.Write(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w csvExportWriter) close() error {
+	w.writer.Flush()
+	return w.writer.Error()
+}
+
+// ndjsonExportWriter writes a traceroute including its hops per line
+type ndjsonExportWriter struct {
+	encoder *json.Encoder
+}
+
+func (w ndjsonExportWriter) write(tr Traceroute) error {
+	return w.encoder.Encode(tr)
+}
+
+func (w ndjsonExportWriter) close() error {
+	return nil
+}
+
+// parquetExportWriter writes a row per hop to a parquet file, the start time is a timestamp in ms
+type parquetExportWriter struct {
+	writer *parquetWriter
+}
+
+func newParquetExportWriter(w io.Writer) parquetExportWriter {
+
+	kinds := map[string]int32{
+		"startTime": parquetInt64, "success": parquetBoolean, "hopCount": parquetInt32, "hopIndex": parquetInt32,
+		"rttMs": parquetDouble, "loss": parquetDouble, "asn": parquetInt64,
+	}
+
+	// the hop columns are missing for traceroutes without hops
+	columns := []*parquetColumn{}
+	optional := false
+	for _, name := range tracerouteExportColumns {
+		column := &parquetColumn{name: name, kind: parquetByteArray, converted: parquetUTF8}
+		if kind, exists := kinds[name]; exists {
+			column.kind, column.converted = kind, parquetNone
+		}
+		if name == "startTime" {
+			column.converted = parquetTimestampMillis
+		}
+		optional = optional || name == "hopIndex"
+		column.optional = optional
+		columns = append(columns, column)
+	}
+
+	return parquetExportWriter{writer: newParquetWriter(w, columns)}
+}
+
+func (w parquetExportWriter) write(tr Traceroute) error {
+	for _, row := range tracerouteExportRows(tr) {
+		if err := w.writer.writeRow(row...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w parquetExportWriter) close() error {
+	return w.writer.close()
+}
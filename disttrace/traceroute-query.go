@@ -0,0 +1,262 @@
+package disttrace
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// errors of invalid traceroute queries
+var (
+	ErrInvalidCursor = errors.New("Invalid cursor")
+	ErrNoASNDatabase = errors.New("No ASN database loaded, set asn.file to filter by ASN")
+)
+
+// limits of the number of traceroutes returned at once
+const (
+	DefaultTracerouteLimit = 100
+	MaxTracerouteLimit     = 1000
+)
+
+// TracerouteFilter selects the traceroutes returned by QueryTraceroutes, unset fields don't filter.
+// Slaves and Targets hold IDs or names, a traceroute matches if it matches any of them.
+type TracerouteFilter struct {
+	Slaves  []string
+	Targets []string
+	From    time.Time
+	To      time.Time
+	Success *bool
+	HopIP   string
+	ASN     int
+	Limit   int
+	// Cursor is the NextCursor of the previous page
+	Cursor string
+	// Scope restricts the traceroutes to the targets a user may access
+	Scope TargetScope
+}
+
+// Traceroute is a single traceroute including its hops
+type Traceroute struct {
+	ID        uuid.UUID
+	StartTime time.Time
+	Slave     TracerouteSlave
+	Target    TracerouteTarget
+	Success   bool
+	HopCount  int
+	PathID    string `json:",omitempty"`
+	Hops      []TracerouteHop
+}
+
+// TracerouteSlave identifies the slave of a traceroute
+type TracerouteSlave struct {
+	ID   uuid.UUID
+	Name string
+}
+
+// TracerouteTarget identifies the target of a traceroute
+type TracerouteTarget struct {
+	ID      uuid.UUID
+	Name    string
+	Address string
+}
+
+// TracerouteHop is a single hop of a traceroute, RTT and loss are missing for traceroutes stored before they were
+// recorded, AS is only set if an ASN database is loaded
+type TracerouteHop struct {
+	Index     int
+	IPAddress string
+	DNSName   string   `json:",omitempty"`
+	RTTMs     *float64 `json:",omitempty"`
+	Loss      *float64 `json:",omitempty"`
+	AS        *ASNInfo `json:",omitempty"`
+}
+
+// TraceroutePage holds a page of traceroutes, newest first. NextCursor is set if there are more traceroutes.
+type TraceroutePage struct {
+	Traceroutes []Traceroute
+	NextCursor  string `json:",omitempty"`
+}
+
+// tracerouteCursor is the position after the last traceroute of a page
+type tracerouteCursor struct {
+	Start string    `json:"s"`
+	ID    uuid.UUID `json:"i"`
+}
+
+// QueryTraceroutes returns a page of the traceroutes matching the filter, newest first
+func QueryTraceroutes(db *DB, filter TracerouteFilter) (TraceroutePage, error) {
+
+	log.Debugf("QueryTraceroutes: fetching traceroutes, filter: %+v", filter)
+	page := TraceroutePage{Traceroutes: []Traceroute{}}
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = DefaultTracerouteLimit
+	}
+	if limit > MaxTracerouteLimit {
+		limit = MaxTracerouteLimit
+	}
+
+	conditions := []string{}
+	args := []interface{}{}
+	start := db.Dialect.TimeExpr("t.dtStart")
+
+	// slaves and targets are given by ID or name, targets are named by their description
+	anyOf := func(idColumn string, nameColumn string, values []string) {
+		alternatives := []string{}
+		for _, value := range values {
+			if id, err := uuid.Parse(value); err == nil {
+				alternatives = append(alternatives, idColumn+" = ?")
+				args = append(args, id)
+			} else {
+				alternatives = append(alternatives, nameColumn+" = ?")
+				args = append(args, value)
+			}
+		}
+		if len(alternatives) > 0 {
+			conditions = append(conditions, "("+strings.Join(alternatives, " OR ")+")")
+		}
+	}
+	anyOf("s.strSlaveId", "s.strSlaveName", filter.Slaves)
+	anyOf("tg.strTargetId", "tg.strDescription", filter.Targets)
+	if filter.Scope != nil {
+		condition, scopeArgs := filter.Scope.condition("tg.strTargetId")
+		conditions = append(conditions, condition)
+		args = append(args, scopeArgs...)
+	}
+
+	if !filter.From.IsZero() {
+		conditions = append(conditions, start+" >= "+db.Dialect.TimeExpr("?"))
+		args = append(args, filter.From.UTC().Format(time.RFC3339))
+	}
+	if !filter.To.IsZero() {
+		conditions = append(conditions, start+" < "+db.Dialect.TimeExpr("?"))
+		args = append(args, filter.To.UTC().Format(time.RFC3339))
+	}
+	if filter.Success != nil {
+		conditions = append(conditions, "t.nSuccess = ?")
+		args = append(args, *filter.Success)
+	}
+	if filter.HopIP != "" {
+		conditions = append(conditions, "EXISTS (SELECT 1 FROM t_PathHops ph WHERE ph.strPathId = t.strPathId AND ph.strHopIPAddress = ?)")
+		args = append(args, filter.HopIP)
+	}
+	if filter.ASN != 0 {
+		if !ASNDatabaseLoaded() {
+			return page, ErrNoASNDatabase
+		}
+		conditions = append(conditions, "EXISTS (SELECT 1 FROM t_PathHops ph WHERE ph.strPathId = t.strPathId AND ph.nASN = ?)")
+		args = append(args, filter.ASN)
+	}
+
+	if filter.Cursor != "" {
+		cursor, err := decodeTracerouteCursor(filter.Cursor)
+		if err != nil {
+			return page, err
+		}
+		conditions = append(conditions, "("+start+" < "+db.Dialect.TimeExpr("?")+" OR ("+start+" = "+db.Dialect.TimeExpr("?")+" AND t.strTracerouteId < ?))")
+		args = append(args, cursor.Start, cursor.Start, cursor.ID)
+	}
+
+	query := `
+		SELECT t.strTracerouteId, t.dtStart, t.nSuccess, t.strPathId, t.strHopRTTs, t.strHopLoss,
+			s.strSlaveId, s.strSlaveName, tg.strTargetId, tg.strDescription, tg.strDestination
+		FROM t_Traceroutes t
+		JOIN t_Slaves s ON t.strSlaveId = s.strSlaveId
+		JOIN t_Targets tg ON t.strTargetId = tg.strTargetId
+		`
+	if len(conditions) > 0 {
+		query += "WHERE " + strings.Join(conditions, " AND ") + "\n"
+	}
+	// fetch one more traceroute to know if there is another page
+	query += "ORDER BY " + start + " DESC, t.strTracerouteId DESC LIMIT " + strconv.Itoa(limit+1)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		log.Warn("QueryTraceroutes: Couldn't get traceroutes from DB, Error: ", err)
+		return page, errors.New("Couldn't get traceroutes from DB")
+	}
+	defer rows.Close()
+
+	rttVectors, lossVectors := [][]float64{}, [][]float64{}
+	for rows.Next() {
+		var tr Traceroute
+		var startTime dbTime
+		var pathID, rtts, loss sql.NullString
+
+		if err := rows.Scan(&tr.ID, &startTime, &tr.Success, &pathID, &rtts, &loss,
+			&tr.Slave.ID, &tr.Slave.Name, &tr.Target.ID, &tr.Target.Name, &tr.Target.Address); err != nil {
+			log.Warn("QueryTraceroutes: Couldn't read DB result set, Error: ", err)
+			return TraceroutePage{Traceroutes: []Traceroute{}}, errors.New("Couldn't read DB result set")
+		}
+
+		tr.StartTime = startTime.UTC()
+		tr.PathID = pathID.String
+		page.Traceroutes = append(page.Traceroutes, tr)
+		rttVectors = append(rttVectors, decodeVector(rtts))
+		lossVectors = append(lossVectors, decodeVector(loss))
+	}
+	rows.Close()
+
+	if len(page.Traceroutes) > limit {
+		page.Traceroutes = page.Traceroutes[:limit]
+		last := page.Traceroutes[limit-1]
+		page.NextCursor = encodeTracerouteCursor(tracerouteCursor{Start: last.StartTime.Format(time.RFC3339), ID: last.ID})
+	}
+
+	// combine the hops of the path with the measured RTTs and loss
+	for i := range page.Traceroutes {
+		tr := &page.Traceroutes[i]
+
+		hops, err := getPathHops(db, tr.PathID)
+		if err != nil {
+			return TraceroutePage{Traceroutes: []Traceroute{}}, errors.New("Couldn't get hops of traceroute")
+		}
+
+		tr.Hops = []TracerouteHop{}
+		for pos, hop := range hops {
+			detail := TracerouteHop{Index: hop.Index, IPAddress: hop.IPAddress, DNSName: hop.DNSName, AS: LookupASN(hop.IPAddress)}
+			if pos < len(rttVectors[i]) {
+				rtt := rttVectors[i][pos] * 1000
+				detail.RTTMs = &rtt
+			}
+			if pos < len(lossVectors[i]) {
+				detail.Loss = &lossVectors[i][pos]
+			}
+			tr.Hops = append(tr.Hops, detail)
+		}
+		tr.HopCount = len(tr.Hops)
+	}
+
+	log.Debugf("QueryTraceroutes: returning '%v' traceroutes from db...", len(page.Traceroutes))
+	return page, nil
+}
+
+// encodeTracerouteCursor returns the opaque form of a cursor
+func encodeTracerouteCursor(cursor tracerouteCursor) string {
+	encoded, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(encoded)
+}
+
+// decodeTracerouteCursor reads a cursor returned by encodeTracerouteCursor
+func decodeTracerouteCursor(encoded string) (tracerouteCursor, error) {
+
+	var cursor tracerouteCursor
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return cursor, ErrInvalidCursor
+	}
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return cursor, ErrInvalidCursor
+	}
+	if _, err := time.Parse(time.RFC3339, cursor.Start); err != nil {
+		return cursor, ErrInvalidCursor
+	}
+	return cursor, nil
+}
@@ -1,9 +1,16 @@
 package disttrace
 
 import (
+	"database/sql"
+	"encoding/json"
 	"errors"
-	"github.com/google/uuid"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/google/uuid"
 )
 
 import (
@@ -55,3 +62,287 @@ func ValidateTraceResult(res TraceResult) (bool, error) {
 	log.Debug("ValidateTraceResult: Results are valid")
 	return true, nil
 }
+
+// StoreTraceResult stores a traceroute result of a slave, the hops are stored once per distinct path
+func StoreTraceResult(db *DB, result TraceResult) (traceID uuid.UUID, err error) {
+
+	log.Debugf("StoreTraceResult: Storing result of slave '%v' for target '%v'...", result.Slave.ID, result.Target.ID)
+
+	var tx *Tx
+	if tx, err = db.Begin(); err != nil {
+		log.Warn("StoreTraceResult: Error creating database transaction, Error: ", err)
+		return uuid.Nil, errors.New("Database error")
+	}
+	// catch errors and rollback!
+	defer func() {
+		if err != nil {
+			log.Warn("StoreTraceResult: Caught error during database operations, rolling transaction back!")
+			tx.Rollback()
+		}
+	}()
+
+	// store path of the result
+	hops, rtts, loss := pathFromResult(result)
+	var pathID string
+	if pathID, err = storePath(tx, hops, result.DateTime); err != nil {
+		return uuid.Nil, errors.New("Database error")
+	}
+	if err = storePathSighting(tx, result.Slave.ID, result.Target.ID, pathID, result.DateTime, 1); err != nil {
+		return uuid.Nil, errors.New("Database error")
+	}
+
+	// the previous path of the slave to the target is only needed to notify subscribers about route changes
+	var previousPathID sql.NullString
+	subscribed := hasEventSubscribers()
+	if subscribed {
+		if previousPathID, err = previousTraceroutePath(tx, result); err != nil {
+			return uuid.Nil, errors.New("Database error")
+		}
+	}
+
+	// Insert result info
+	query := `
+		INSERT INTO t_Traceroutes (strTracerouteId, strSlaveId, strTargetId, dtStart, strAnnotations, nSuccess, strPathId, strHopRTTs, strHopLoss) 
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?) 
+		`
+	traceID = uuid.New()
+	if _, err = tx.Exec(query, traceID, result.Slave.ID, result.Target.ID, result.DateTime.Format(time.RFC3339), "", result.Success,
+		pathID, encodeVector(rtts), encodeVector(loss)); err != nil {
+		log.Warn("StoreTraceResult: Error while inserting result, Error: ", err)
+		return uuid.Nil, errors.New("Database error")
+	}
+	log.Debugf("StoreTraceResult: Inserted result with ID '%v' on path '%v', commiting transaction...", traceID, pathID)
+
+	if err = tx.Commit(); err != nil {
+		log.Warn("StoreTraceResult: Error while commiting transaction, Error: ", err)
+		return uuid.Nil, errors.New("Database error")
+	}
+
+	if subscribed {
+		tr := tracerouteFromResult(traceID, pathID, result, hops, rtts, loss)
+		publishEvent(EventTraceroute, tr.Slave.ID, tr.Target.ID, tr)
+		if previousPathID.Valid && previousPathID.String != pathID {
+			publishRouteChange(db, tr, previousPathID.String)
+		}
+	}
+
+	return traceID, nil
+}
+
+// TraceHistoryEntry holds a summary of a single traceroute for the history list
+type TraceHistoryEntry struct {
+	TraceID    uuid.UUID
+	SlaveID    uuid.UUID
+	SlaveName  string
+	DestID     uuid.UUID
+	DestName   string
+	StartTime  string
+	HopCnt     int64
+	DetailJSON string
+}
+
+// hopDetail holds the details of a single hop in the history list
+type hopDetail struct {
+	IP       string
+	DNS      string
+	Duration float64
+}
+
+// GetTraceHistory returns the latest traceroutes of the targets in scope including their hops, newest first. A limit
+// of 0 returns all traceroutes.
+func GetTraceHistory(db *DB, limit int, scope TargetScope) ([]TraceHistoryEntry, error) {
+
+	log.Debugf("GetTraceHistory: fetching the latest '%v' traceroutes from db...", limit)
+	entries := []TraceHistoryEntry{}
+
+	query := `
+		SELECT t.strTracerouteId, s.strSlaveId, s.strSlaveName, tg.strTargetId, tg.strDestination, t.dtStart, t.strPathId, t.strHopRTTs
+		FROM t_Traceroutes t 
+		JOIN t_Slaves s ON t.strSlaveId = s.strSlaveId 
+		JOIN t_Targets tg ON t.strTargetId = tg.strTargetId
+		`
+	args := []interface{}{}
+	if scope != nil {
+		condition, scopeArgs := scope.condition("t.strTargetId")
+		query += "WHERE " + condition + " "
+		args = append(args, scopeArgs...)
+	}
+	query += "ORDER BY " + db.Dialect.TimeExpr("t.dtStart") + " DESC "
+	if limit != 0 {
+		query += "LIMIT " + strconv.Itoa(limit)
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		log.Warn("GetTraceHistory: Couldn't get last results from DB, Error: ", err)
+		return entries, errors.New("Couldn't get last results from DB")
+	}
+	defer rows.Close()
+
+	pathIDs := []string{}
+	rttVectors := [][]float64{}
+	for rows.Next() {
+		var entry TraceHistoryEntry
+		var start dbTime
+		var pathID, rtts sql.NullString
+
+		if err := rows.Scan(&entry.TraceID, &entry.SlaveID, &entry.SlaveName, &entry.DestID, &entry.DestName, &start, &pathID, &rtts); err != nil {
+			log.Warn("GetTraceHistory: Couldn't read DB result set, Error: ", err)
+			return []TraceHistoryEntry{}, errors.New("Couldn't read DB result set")
+		}
+
+		entry.StartTime = start.UTC().Format("02.01.2006 15:04")
+		entries = append(entries, entry)
+		pathIDs = append(pathIDs, pathID.String)
+		rttVectors = append(rttVectors, decodeVector(rtts))
+	}
+	rows.Close()
+
+	// combine the hops of the path with the measured durations
+	for i := range entries {
+		hops, err := getPathHops(db, pathIDs[i])
+		if err != nil {
+			return []TraceHistoryEntry{}, errors.New("Couldn't get hops of traceroute")
+		}
+
+		details := []string{}
+		for pos, hop := range hops {
+			detail := hopDetail{IP: hop.IPAddress, DNS: hop.DNSName}
+			if pos < len(rttVectors[i]) {
+				detail.Duration = rttVectors[i][pos]
+			}
+			encoded, err := json.Marshal(detail)
+			if err != nil {
+				log.Warn("GetTraceHistory: Couldn't marshal hop details, Error: ", err)
+				return []TraceHistoryEntry{}, errors.New("Couldn't marshal hop details")
+			}
+			details = append(details, fmt.Sprintf("\"%v\":%s", hop.Index, encoded))
+		}
+		entries[i].HopCnt = int64(len(hops))
+		entries[i].DetailJSON = "{" + strings.Join(details, ",") + "}"
+	}
+
+	log.Debugf("GetTraceHistory: returning '%v' traceroutes from db...", len(entries))
+	return entries, nil
+}
+
+// GraphData holds all links between hops seen in the traceroutes of a slave to a target
+type GraphData struct {
+	Start time.Time
+	End   time.Time
+	// every link consists of: previous hop address, hop address, count, average duration in ms
+	Data [][]interface{}
+}
+
+// GetGraphData returns the links between hops in the traceroutes of a slave to a target in the given period,
+// skipping the first hops. Raw data is combined with the hourly and daily aggregates of older data.
+func GetGraphData(db *DB, targetID uuid.UUID, slaveID uuid.UUID, skip int, from time.Time, to time.Time) (GraphData, error) {
+
+	log.Debugf("GetGraphData: fetching graph data of slave '%v' for target '%v' from '%v' to '%v', skip: %v", slaveID, targetID, from, to, skip)
+	graph := GraphData{Data: [][]interface{}{}}
+
+	type linkKey struct {
+		HopIndex         int
+		HopIPAddress     string
+		PrevHopIPAddress string
+	}
+	links := make(map[linkKey]*hopAggregate)
+	order := []linkKey{}
+
+	// addLink merges a link seen in the given period into the collected links
+	addLink := func(key linkKey, agg hopAggregate, start time.Time, end time.Time) {
+		if graph.Start.IsZero() || start.Before(graph.Start) {
+			graph.Start = start
+		}
+		if end.After(graph.End) {
+			graph.End = end
+		}
+
+		if _, exists := links[key]; !exists {
+			links[key] = &hopAggregate{}
+			order = append(order, key)
+		}
+		links[key].add(agg)
+	}
+
+	timeFrom, timeTo := from.UTC().Format(time.RFC3339), to.UTC().Format(time.RFC3339)
+
+	aggregateQuery := `
+		SELECT strPrevHopIPAddress, strHopIPAddress, nHopIndex, SUM(nCount), SUM(dDurationSumSec),
+			MIN(` + db.Dialect.TimeExpr("dtBucket") + `), MAX(` + db.Dialect.TimeExpr("dtBucket") + `)
+		FROM t_HopAggregates
+		WHERE strTargetId = ? AND strSlaveId = ? AND nHopIndex > ?
+			AND ` + db.Dialect.TimeExpr("dtBucket") + ` >= ` + db.Dialect.TimeExpr("?") + `
+			AND ` + db.Dialect.TimeExpr("dtBucket") + ` < ` + db.Dialect.TimeExpr("?") + `
+		GROUP BY strHopIPAddress, nHopIndex, strPrevHopIPAddress
+		`
+
+	rows, err := db.Query(aggregateQuery, targetID, slaveID, skip, timeFrom, timeTo)
+	if err != nil {
+		log.Warn("GetGraphData: Error while getting aggregated graph data, Error: ", err)
+		return GraphData{Data: [][]interface{}{}}, errors.New("Error while getting graph data")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var key linkKey
+		var agg hopAggregate
+		var start, end dbTime
+		if err := rows.Scan(&key.PrevHopIPAddress, &key.HopIPAddress, &key.HopIndex, &agg.Count, &agg.DurationSumSec, &start, &end); err != nil {
+			log.Warn("GetGraphData: Error while reading aggregated graph data, Error: ", err)
+			return GraphData{Data: [][]interface{}{}}, errors.New("Error while getting graph data")
+		}
+		addLink(key, agg, start.Time, end.Time)
+	}
+	rows.Close()
+
+	// raw traceroutes only reference their path, the links are taken from the path's hops
+	rawQuery := `
+		SELECT strPathId, strHopRTTs, dtStart
+		FROM t_Traceroutes
+		WHERE strTargetId = ? AND strSlaveId = ? AND strPathId IS NOT NULL
+			AND ` + db.Dialect.TimeExpr("dtStart") + ` >= ` + db.Dialect.TimeExpr("?") + `
+			AND ` + db.Dialect.TimeExpr("dtStart") + ` < ` + db.Dialect.TimeExpr("?") + `
+		`
+
+	if rows, err = db.Query(rawQuery, targetID, slaveID, timeFrom, timeTo); err != nil {
+		log.Warn("GetGraphData: Error while getting graph data, Error: ", err)
+		return GraphData{Data: [][]interface{}{}}, errors.New("Error while getting graph data")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var pathID string
+		var rtts sql.NullString
+		var start dbTime
+		if err := rows.Scan(&pathID, &rtts, &start); err != nil {
+			log.Warn("GetGraphData: Error while reading graph data, Error: ", err)
+			return GraphData{Data: [][]interface{}{}}, errors.New("Error while getting graph data")
+		}
+
+		hops, err := getPathHops(db, pathID)
+		if err != nil {
+			return GraphData{Data: [][]interface{}{}}, errors.New("Error while getting graph data")
+		}
+		durations := decodeVector(rtts)
+		for pos, hop := range hops {
+			if hop.Index <= skip {
+				continue
+			}
+			agg := hopAggregate{Count: 1}
+			if pos < len(durations) {
+				agg.DurationSumSec = durations[pos]
+			}
+			addLink(linkKey{HopIndex: hop.Index, HopIPAddress: hop.IPAddress, PrevHopIPAddress: hop.PrevIPAddress}, agg, start.UTC(), start.UTC())
+		}
+	}
+
+	sort.SliceStable(order, func(i, j int) bool { return order[i].HopIndex < order[j].HopIndex })
+	for _, key := range order {
+		link := links[key]
+		graph.Data = append(graph.Data, []interface{}{key.PrevHopIPAddress, key.HopIPAddress, link.Count, link.DurationSumSec / float64(link.Count) * 1000})
+	}
+
+	log.Debugf("GetGraphData: returning '%v' links from db...", len(graph.Data))
+	return graph, nil
+}
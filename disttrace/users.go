@@ -2,16 +2,18 @@ package disttrace
 
 import (
 	"bytes"
-	"crypto/sha256"
 	"database/sql"
 	"errors"
-	"math"
-	"math/rand"
-	"strconv"
 
 	"github.com/google/uuid"
 )
 
+// ErrWrongPassword is returned if the current password of a user doesn't match
+var ErrWrongPassword = errors.New("Wrong password")
+
+// ErrInvalidPassword is returned for new passwords which are empty or the same as before
+var ErrInvalidPassword = errors.New("New password must not be empty or the current one")
+
 // User contains information about a single dist-traceroute user
 type User struct {
 	ID                  uuid.UUID
@@ -19,52 +21,122 @@ type User struct {
 	Password            []byte
 	Salt                int
 	PasswordNeedsChange bool
+	// Role is one of viewer, operator or admin
+	Role string
+	// TargetGroups restrict viewers and operators to the targets of these groups, empty allows all targets
+	TargetGroups []uuid.UUID
 }
 
-// AuthUser checks supplied username/PW combination
-func AuthUser(name string, pwd string, db *DB) bool {
+const userColumns = "strUserId, strUserName, strPassword, nSalt, nPassNeedsChange, strRole"
+
+// scanUser reads a single user from the given row, the target groups are read separately
+func scanUser(row interface{ Scan(...interface{}) error }) (User, error) {
+	var user User
+	if err := row.Scan(&user.ID, &user.Name, &user.Password, &user.Salt, &user.PasswordNeedsChange, &user.Role); err != nil {
+		return User{}, err
+	}
+	user.TargetGroups = []uuid.UUID{}
+	return user, nil
+}
+
+// fillUserTargetGroups reads the target groups of the given user
+func fillUserTargetGroups(db *DB, user *User) (err error) {
+	user.TargetGroups, err = getUserTargetGroups(db, user.ID)
+	return
+}
+
+// AuthUser checks supplied username/PW combination and returns the user. Legacy password hashes are replaced by
+// argon2id hashes on success.
+func AuthUser(name string, pwd string, db *DB) (User, bool) {
 
 	log.Debug("AuthUser: checking for user: ", name)
-	user := User{}
 
-	query := `
-		SELECT strUserId, strUserName, strPassword, nSalt, nPassNeedsChange FROM t_Users 
-		WHERE strUserName = ? 
-		`
+	query := "SELECT " + userColumns + " FROM t_Users WHERE strUserName = ?"
 
-	row := db.QueryRow(query, name)
-	if err := row.Scan(&user.ID, &user.Name, &user.Password, &user.Salt, &user.PasswordNeedsChange); err != nil {
+	user, err := scanUser(db.QueryRow(query, name))
+	if err != nil {
 		if err == sql.ErrNoRows {
 			log.Debug("AuthUser: Couldn't find specified user in DB...")
-			return false
+			return User{}, false
 		}
 		log.Warn("AuthUser: Error while getting user from DB, Error: ", err)
-		return false
+		return User{}, false
 	}
 
 	log.Debug("AuthUser: User found, checking password...")
 
-	pass := sha256.Sum256(append([]byte(pwd)[:], []byte(strconv.Itoa(user.Salt))[:]...))
-
-	if bytes.Compare(pass[:], user.Password) != 0 {
+	ok, needsRehash := verifyPassword([]byte(pwd), user.Password, user.Salt)
+	if !ok {
 		log.Warn("AuthUser: Passwords don't match!")
-		return false
+		return User{}, false
+	}
+
+	if needsRehash {
+		if err := setPassword(db, &user, []byte(pwd)); err != nil {
+			log.Warnf("AuthUser: Couldn't rehash password of user '%v', keeping the old hash", user.Name)
+		} else {
+			log.Infof("AuthUser: Rehashed password of user '%v'", user.Name)
+		}
+	}
+
+	if err := fillUserTargetGroups(db, &user); err != nil {
+		log.Warn("AuthUser: Couldn't get target groups of user, Error: ", err)
+		return User{}, false
 	}
 
 	log.Debug("AuthUser: Success")
-	return true
+	return user, true
+}
+
+// ChangePassword sets a new password of a user after checking the current one and clears PasswordNeedsChange
+func ChangePassword(db *DB, name string, current string, password string) (User, error) {
+
+	log.Debugf("ChangePassword: Changing password of user '%v'...", name)
+
+	user, ok := AuthUser(name, current, db)
+	if !ok {
+		return User{}, ErrWrongPassword
+	}
+	if len(password) == 0 || password == current {
+		return User{}, ErrInvalidPassword
+	}
+
+	user.PasswordNeedsChange = false
+	if err := setPassword(db, &user, []byte(password)); err != nil {
+		return User{}, err
+	}
+
+	log.Infof("ChangePassword: User '%v' changed their password", user.Name)
+	return user, nil
+}
+
+// setPassword stores the argon2id hash of a new password of the user
+func setPassword(db *DB, user *User, password []byte) error {
+
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	query := "UPDATE t_Users SET strPassword = ?, nSalt = ?, nPassNeedsChange = ? WHERE strUserId = ?"
+	if _, err := db.Exec(query, hash, 0, user.PasswordNeedsChange, user.ID); err != nil {
+		log.Warn("setPassword: Couldn't update password, Error: ", err)
+		return errors.New("Couldn't update password")
+	}
+
+	user.Password, user.Salt = hash, 0
+	return nil
 }
 
 // GetUser returns the specified user from DB
 func GetUser(userID uuid.UUID, db *DB) (User, error) {
 
 	log.Debug("GetUser: fetching user with ID: ", userID)
-	user := User{}
 
-	query := "SELECT strUserId, strUserName, strPassword, nSalt, nPassNeedsChange FROM t_Users WHERE strUserId = ?"
+	query := "SELECT " + userColumns + " FROM t_Users WHERE strUserId = ?"
 
-	row := db.QueryRow(query, userID)
-	if err := row.Scan(&user.ID, &user.Name, &user.Password, &user.Salt, &user.PasswordNeedsChange); err != nil {
+	user, err := scanUser(db.QueryRow(query, userID))
+	if err != nil {
 		if err == sql.ErrNoRows {
 			log.Debug("GetUser: Couldn't find specified user in DB...")
 			return User{}, nil
@@ -72,6 +144,10 @@ func GetUser(userID uuid.UUID, db *DB) (User, error) {
 		log.Warn("GetUser: Error while getting user from DB, Error: ", err)
 		return User{}, errors.New("Error while getting user from DB")
 	}
+	if err := fillUserTargetGroups(db, &user); err != nil {
+		log.Warn("GetUser: Couldn't get target groups of user, Error: ", err)
+		return User{}, errors.New("Error while getting user from DB")
+	}
 
 	log.Debugf("GetUser: Returning user name '%v' for ID '%v'", user.Name, user.ID)
 	return user, nil
@@ -83,7 +159,7 @@ func GetUsers(db *DB) ([]User, error) {
 	log.Debug("GetUsers: fetching users from db...")
 	users := []User{}
 
-	query := "SELECT strUserId, strUserName, strPassword, nSalt, nPassNeedsChange FROM t_Users"
+	query := "SELECT " + userColumns + " FROM t_Users"
 	rows, err := db.Query(query)
 	if err != nil {
 		log.Warn("GetUsers: Couldn't get users from db, Error: ", err)
@@ -92,34 +168,48 @@ func GetUsers(db *DB) ([]User, error) {
 	defer rows.Close()
 
 	for rows.Next() {
-		var user = User{}
-		if err := rows.Scan(&user.ID, &user.Name, &user.Password, &user.Salt, &user.PasswordNeedsChange); err != nil {
+		user, err := scanUser(rows)
+		if err != nil {
 			log.Warn("GetUsers: Couldn't read results from users, Error: ", err)
 			return []User{}, errors.New("Couldn't get users")
 		}
 		users = append(users, user)
 	}
+	rows.Close()
+
+	for i := range users {
+		if err := fillUserTargetGroups(db, &users[i]); err != nil {
+			log.Warn("GetUsers: Couldn't get target groups of users, Error: ", err)
+			return []User{}, errors.New("Couldn't get users")
+		}
+	}
 
 	log.Debugf("GetUsers: returning '%v' users from db...", len(users))
 	return users, nil
 }
 
-// CreateUser stores a new user in the db
+// CreateUser stores a new user in the db, users without role become viewers
 func CreateUser(db *DB, user User) (User, error) {
 	log.Debug("CreateUser: Creating new user, name: ", user.Name)
 
-	query := `
-	INSERT INTO t_Users (strUserId, strUserName, strPassword, nSalt, nPassNeedsChange) 
-	VALUES (?, ?, ?, ?, ?)
-	`
+	if user.Role == "" {
+		user.Role = RoleViewer
+	}
+	if !ValidRole(user.Role) {
+		return User{}, ErrInvalidRole
+	}
+	if user.TargetGroups == nil {
+		user.TargetGroups = []uuid.UUID{}
+	}
 
 	user.ID = uuid.New()
-	user.Salt = rand.Intn(math.MaxInt32)
-	password := sha256.Sum256(append(user.Password[:], []byte(strconv.Itoa(user.Salt))[:]...))
-	user.Password = password[:]
-
-	_, err := db.Exec(query, user.ID, user.Name, user.Password, user.Salt, user.PasswordNeedsChange)
+	password, err := hashPassword(user.Password)
 	if err != nil {
+		return User{}, errors.New("Couldn't create user")
+	}
+	user.Password, user.Salt = password, 0
+
+	if err := storeUser(db, user, true); err != nil {
 		log.Warn("CreateUser: Couldn't create user, Error: ", err)
 		return User{}, errors.New("Couldn't create user")
 	}
@@ -128,7 +218,7 @@ func CreateUser(db *DB, user User) (User, error) {
 	return user, nil
 }
 
-// UpdateUser updates an existing user in the db
+// UpdateUser updates an existing user in the db. An empty role and missing target groups keep the current ones.
 func UpdateUser(db *DB, user User) (User, error) {
 	log.Debugf("UpdateUser: Updating user '%v'...", user.ID)
 
@@ -138,37 +228,114 @@ func UpdateUser(db *DB, user User) (User, error) {
 		return User{}, errors.New("Couldn't get old userinfo from DB")
 	}
 
+	if user.Role == "" {
+		user.Role = oldUser.Role
+	}
+	if !ValidRole(user.Role) {
+		return User{}, ErrInvalidRole
+	}
+	if user.TargetGroups == nil {
+		user.TargetGroups = oldUser.TargetGroups
+	}
+
+	// somebody has to be able to manage the users
+	if oldUser.Role == RoleAdmin && user.Role != RoleAdmin {
+		if admins, err := countOtherAdmins(db, user.ID); err != nil {
+			log.Warn("UpdateUser: Couldn't count admins, Error: ", err)
+			return User{}, errors.New("Couldn't update user")
+		} else if admins == 0 {
+			return User{}, ErrLastAdmin
+		}
+	}
+
+	// the role and the target groups are part of the tokens of the user
+	endSessions := user.Role != oldUser.Role || !sameIDs(user.TargetGroups, oldUser.TargetGroups)
+
 	if bytes.Compare(oldUser.Password, user.Password) != 0 {
+		endSessions = true
 		log.Debug("UpdateUser: PW has changed, setting new hashed pw...")
-		user.Salt = rand.Intn(math.MaxInt32)
-		password := sha256.Sum256(append(user.Password[:], []byte(strconv.Itoa(user.Salt))[:]...))
-		user.Password = password[:]
+		password, err := hashPassword(user.Password)
+		if err != nil {
+			return User{}, errors.New("Couldn't update user")
+		}
+		user.Password, user.Salt = password, 0
+	} else {
+		user.Salt = oldUser.Salt
 	}
 
-	query := `UPDATE t_Users 
-	SET strUserName = ?, strPassword = ?, nSalt = ?, nPassNeedsChange = ?
-	WHERE strUserId = ?`
-
-	res, err := db.Exec(query, user.Name, user.Password, user.Salt, user.PasswordNeedsChange, user.ID)
-	if err != nil {
+	if err := storeUser(db, user, false); err != nil {
 		log.Warn("UpdateUser: Couldn't update user, Error: ", err)
 		return User{}, errors.New("Couldn't update user")
 	}
 
-	numRows, err := res.RowsAffected()
-	if err != nil {
-		log.Warn("UpdateUser: Error: Can't get number of affected rows, Error: ", err)
-		return User{}, errors.New("DB Error")
+	// whoever knew the previous password or got the previous rights mustn't stay logged in
+	if endSessions {
+		if err := EndUserSessions(db, user.ID, ""); err != nil {
+			return User{}, errors.New("Couldn't end sessions of user")
+		}
 	}
 
-	log.Debugf("UpdateUser: User '%v' successfully updated, affected rows: '%v", user.ID, numRows)
+	log.Debugf("UpdateUser: User '%v' successfully updated", user.ID)
 	return user, nil
 }
 
+// storeUser inserts or updates a user and its target groups in a single transaction
+func storeUser(db *DB, user User, create bool) (err error) {
+
+	var tx *Tx
+	if tx, err = db.Begin(); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if create {
+		query := `
+		INSERT INTO t_Users (strUserId, strUserName, strPassword, nSalt, nPassNeedsChange, strRole) 
+		VALUES (?, ?, ?, ?, ?, ?)
+		`
+		_, err = tx.Exec(query, user.ID, user.Name, user.Password, user.Salt, user.PasswordNeedsChange, user.Role)
+	} else {
+		query := `UPDATE t_Users 
+		SET strUserName = ?, strPassword = ?, nSalt = ?, nPassNeedsChange = ?, strRole = ?
+		WHERE strUserId = ?`
+		_, err = tx.Exec(query, user.Name, user.Password, user.Salt, user.PasswordNeedsChange, user.Role, user.ID)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err = replaceGroupMembers(tx, "t_UserTargetGroups", "strUserId", "strGroupId", user.ID, user.TargetGroups); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
 // DeleteUser deletes an existing user from the db
 func DeleteUser(db *DB, userID uuid.UUID) error {
 	log.Debugf("DeleteUser: Deleting user '%v'...", userID)
 
+	user, err := GetUser(userID, db)
+	if err != nil {
+		return errors.New("Couldn't delete user")
+	}
+	if user.Role == RoleAdmin {
+		if admins, err := countOtherAdmins(db, userID); err != nil {
+			log.Warn("DeleteUser: Couldn't count admins, Error: ", err)
+			return errors.New("Couldn't delete user")
+		} else if admins == 0 {
+			return ErrLastAdmin
+		}
+	}
+
+	if err := EndUserSessions(db, userID, ""); err != nil {
+		return errors.New("Couldn't delete user")
+	}
+
 	query := "DELETE FROM t_Users WHERE strUserId = ?"
 
 	res, err := db.Exec(query, userID)
@@ -9,7 +9,10 @@ require (
 	github.com/google/uuid v1.1.1
 	github.com/gorilla/handlers v1.4.2
 	github.com/gorilla/mux v1.7.3
+	github.com/lib/pq v1.2.0
 	github.com/mattn/go-sqlite3 v1.11.0
 	github.com/sirupsen/logrus v1.4.2
 	github.com/urfave/negroni v1.0.0
+	golang.org/x/crypto v0.0.0-20191011191535-87dc89f01550
+	gopkg.in/yaml.v2 v2.2.4
 )
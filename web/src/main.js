@@ -5,6 +5,9 @@ import vuetify from "@/plugins/vuetify";
 import router from "@/router/router";
 import axios from "axios";
 
+// all API requests go to the master configured by VUE_APP_API_URL, see .env
+axios.defaults.baseURL = process.env.VUE_APP_API_URL;
+
 axios.interceptors.response.use(
   function(response) {
     return response;
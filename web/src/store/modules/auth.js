@@ -2,10 +2,17 @@ import axios from "axios";
 import jwtDecode from "jwt-decode";
 import router from "@/router/router";
 
+// refreshes the token of the session before it expires
+let refreshTimer = null;
+
+// each role may do everything the roles before it may do
+const roleLevels = { viewer: 1, operator: 2, admin: 3 };
+
 const state = () => {
   return {
     token: "",
-    claims: {}
+    claims: {},
+    refreshToken: ""
   };
 };
 
@@ -18,19 +25,23 @@ const getters = {
 
   getAuthClaims: state => state.claims,
 
-  isAuthorized: state => state.token !== ""
+  isAuthorized: state => state.token !== "",
+
+  // e.g. hasRole("operator") is true for operators and admins
+  hasRole: state => role =>
+    (roleLevels[state.claims.Role] || 0) >= roleLevels[role],
+
+  // only the password may be changed until then
+  mustChangePassword: state => state.claims.PasswordNeedsChange === true
 };
 
 const actions = {
-  fetchAuthToken({ commit, rootGetters }, creds) {
+  fetchAuthToken({ dispatch }, creds) {
     return new Promise((resolve, reject) => {
       axios
-        .get(
-          `http://localhost:8990/api/auth?user=${creds.user}&password=${creds.password}`,
-          rootGetters["getAuthHeader"]
-        )
+        .post("/api/auth", { User: creds.user, Password: creds.password })
         .then(res => {
-          commit("setToken", res.data);
+          dispatch("setTokens", res.data);
           resolve(true);
         })
         .catch(err => {
@@ -38,22 +49,93 @@ const actions = {
           reject(false);
         });
     });
+  },
+
+  refreshAuthToken({ state, dispatch }) {
+    if (state.refreshToken === "") {
+      return;
+    }
+
+    // a failed refresh logs out, see the 401 handler in main.js
+    axios
+      .post("/api/auth/refresh", { RefreshToken: state.refreshToken })
+      .then(res => {
+        dispatch("setTokens", res.data);
+      })
+      .catch(err => {
+        console.log("refreshAuthToken Error caught: " + err);
+      });
+  },
+
+  setTokens({ commit, dispatch }, tokens) {
+    commit("setToken", tokens.AccessToken);
+    if (tokens.RefreshToken) {
+      commit("setRefreshToken", tokens.RefreshToken);
+    }
+
+    // refresh a minute before the token expires
+    clearTimeout(refreshTimer);
+    const delay = new Date(tokens.ExpiresAt) - new Date() - 60 * 1000;
+    refreshTimer = setTimeout(
+      () => dispatch("refreshAuthToken"),
+      Math.max(delay, 0)
+    );
+  },
+
+  logout({ commit, rootGetters }) {
+    clearTimeout(refreshTimer);
+    return axios
+      .post("/api/auth/logout", null, rootGetters["getAuthHeader"])
+      .catch(err => {
+        console.log("logout Error caught: " + err);
+      })
+      .then(() => {
+        commit("unsetToken");
+      });
+  },
+
+  changePassword({ dispatch, rootGetters }, passwords) {
+    return new Promise((resolve, reject) => {
+      axios
+        .post(
+          "/api/auth/password",
+          {
+            Password: passwords.password,
+            NewPassword: passwords.newPassword
+          },
+          rootGetters["getAuthHeader"]
+        )
+        .then(res => {
+          dispatch("setTokens", res.data);
+          resolve(true);
+        })
+        .catch(err => {
+          console.log("changePassword Error caught: " + err);
+          reject(err.response ? err.response.data : "");
+        });
+    });
   }
 };
 
 const mutations = {
   setToken: (state, token) => {
+    const login = state.token === "";
     state.token = token;
     state.claims = jwtDecode(token);
-    if (router.currentRoute.path != "/") {
+    if (login && router.currentRoute.path != "/") {
       console.log(router);
       router.push("/");
     }
   },
 
+  setRefreshToken: (state, refreshToken) => {
+    state.refreshToken = refreshToken;
+  },
+
   unsetToken: state => {
     state.token = "";
     state.claims = {};
+    state.refreshToken = "";
   }
 };
 
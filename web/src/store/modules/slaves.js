@@ -2,12 +2,14 @@ import axios from "axios";
 
 const state = () => {
   return {
-    slaves: []
+    slaves: [],
+    telemetry: []
   };
 };
 
 const getters = {
-  getSlaves: state => state.slaves
+  getSlaves: state => state.slaves,
+  getSlaveTelemetry: state => state.telemetry
 };
 
 const actions = {
@@ -15,7 +17,7 @@ const actions = {
     if (!rootGetters["isAuthorized"]) return;
     try {
       const response = await axios.get(
-        `http://localhost:8990/api/slaves?limit=${limit}`,
+        `/api/slaves?limit=${limit}`,
         rootGetters["getAuthHeader"]
       );
       commit("setSlaves", response.data);
@@ -24,11 +26,25 @@ const actions = {
     }
   },
 
+  // reports of the last 24h, newest first
+  async fetchSlaveTelemetry({ commit, rootGetters }, slaveID) {
+    if (!rootGetters["isAuthorized"]) return;
+    try {
+      const response = await axios.get(
+        `/api/slaves/${slaveID}/telemetry`,
+        rootGetters["getAuthHeader"]
+      );
+      commit("setSlaveTelemetry", response.data.slice().reverse());
+    } catch (error) {
+      console.log("Error caught: " + error);
+    }
+  },
+
   async createSlave({ commit, rootGetters }, slave) {
     if (!rootGetters["isAuthorized"]) return;
     try {
       const response = await axios.post(
-        `http://localhost:8990/api/slaves?name=${slave.Name}&secret=${slave.Secret}`,
+        `/api/slaves?name=${slave.Name}&secret=${slave.Secret}`,
         "",
         rootGetters["getAuthHeader"]
       );
@@ -44,7 +60,7 @@ const actions = {
     if (!rootGetters["isAuthorized"]) return;
     try {
       const response = await axios.put(
-        `http://localhost:8990/api/slaves`,
+        `/api/slaves`,
         slave,
         rootGetters["getAuthHeader"]
       );
@@ -60,7 +76,7 @@ const actions = {
     if (!rootGetters["isAuthorized"]) return;
     try {
       const response = await axios.delete(
-        `http://localhost:8990/api/slaves/${slaveId}`,
+        `/api/slaves/${slaveId}`,
         rootGetters["getAuthHeader"]
       );
       commit("removeSlave", response.data.ID);
@@ -74,6 +90,7 @@ const actions = {
 
 const mutations = {
   setSlaves: (state, slaves) => (state.slaves = slaves),
+  setSlaveTelemetry: (state, telemetry) => (state.telemetry = telemetry),
   addSlave: (state, slave) => state.slaves.push(slave),
   updateSlave: (state, slave) => {
     state.slaves = state.slaves.map(el => (el.ID == slave.ID ? slave : el));
@@ -20,7 +20,7 @@ const actions = {
     if (!rootGetters["isAuthorized"]) return;
     try {
       const response = await axios.get(
-        "http://localhost:8990/api/status",
+        "/api/status",
         rootGetters["getAuthHeader"]
       );
       commit("setStatus", response.data);
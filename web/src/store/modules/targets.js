@@ -2,12 +2,14 @@ import axios from "axios";
 
 const state = () => {
   return {
-    targets: []
+    targets: [],
+    targetGroups: []
   };
 };
 
 const getters = {
-  getTargets: state => state.targets
+  getTargets: state => state.targets,
+  getTargetGroups: state => state.targetGroups
 };
 
 const actions = {
@@ -15,7 +17,7 @@ const actions = {
     if (!rootGetters["isAuthorized"]) return;
     try {
       const response = await axios.get(
-        `http://localhost:8990/api/targets?limit=${limit}`,
+        `/api/targets?limit=${limit}`,
         rootGetters["getAuthHeader"]
       );
       commit("setTargets", response.data);
@@ -24,11 +26,24 @@ const actions = {
     }
   },
 
+  async fetchTargetGroups({ commit, rootGetters }) {
+    if (!rootGetters["isAuthorized"]) return;
+    try {
+      const response = await axios.get(
+        `/api/groups/targets`,
+        rootGetters["getAuthHeader"]
+      );
+      commit("setTargetGroups", response.data);
+    } catch (error) {
+      console.log("Error caught: " + error);
+    }
+  },
+
   async createTarget({ commit, rootGetters }, target) {
     if (!rootGetters["isAuthorized"]) return;
     try {
       const response = await axios.post(
-        `http://localhost:8990/api/targets?name=${target.Name}&address=${target.Address}&retries=${target.Retries}&maxHops=${target.MaxHops}&timeout=${target.Timeout}`,
+        `/api/targets?name=${target.Name}&address=${target.Address}&retries=${target.Retries}&maxHops=${target.MaxHops}&timeout=${target.Timeout}`,
         "",
         rootGetters["getAuthHeader"]
       );
@@ -44,7 +59,7 @@ const actions = {
     if (!rootGetters["isAuthorized"]) return;
     try {
       const response = await axios.put(
-        `http://localhost:8990/api/targets`,
+        `/api/targets`,
         target,
         rootGetters["getAuthHeader"]
       );
@@ -60,7 +75,7 @@ const actions = {
     if (!rootGetters["isAuthorized"]) return;
     try {
       const response = await axios.delete(
-        `http://localhost:8990/api/targets/${targetID}`,
+        `/api/targets/${targetID}`,
         rootGetters["getAuthHeader"]
       );
       commit("removeTarget", response.data.ID);
@@ -74,6 +89,7 @@ const actions = {
 
 const mutations = {
   setTargets: (state, targets) => (state.targets = targets),
+  setTargetGroups: (state, groups) => (state.targetGroups = groups),
   addTarget: (state, target) => state.targets.push(target),
   updateTarget: (state, target) => {
     state.targets = state.targets.map(el => (el.ID == target.ID ? target : el));
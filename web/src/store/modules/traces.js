@@ -1,8 +1,37 @@
 import axios from "axios";
+import dateFormat from "dateformat";
+
+// connection of the live event stream, aborted to unsubscribe
+let eventStream = null;
+
+// traceHistoryEntry converts a traceroute of the event stream into an entry
+// of the trace history
+const traceHistoryEntry = tr => {
+  const details = {};
+  tr.Hops.forEach(hop => {
+    details[hop.Index] = {
+      IP: hop.IPAddress,
+      DNS: hop.DNSName || "",
+      Duration: (hop.RTTMs || 0) / 1000
+    };
+  });
+
+  return {
+    TraceID: tr.ID,
+    SlaveID: tr.Slave.ID,
+    SlaveName: tr.Slave.Name,
+    DestID: tr.Target.ID,
+    DestName: tr.Target.Address,
+    StartTime: dateFormat(new Date(tr.StartTime), "dd.mm.yyyy HH:MM", true),
+    HopCnt: tr.HopCount,
+    DetailJSON: JSON.stringify(details)
+  };
+};
 
 const state = () => {
   return {
     traces: [],
+    tracesLimit: 0,
     graphData: [],
     graphStart: 0,
     graphEnd: 0
@@ -19,9 +48,10 @@ const getters = {
 const actions = {
   async fetchTraces({ commit, rootGetters }, limit) {
     if (!rootGetters["isAuthorized"]) return;
+    commit("setTracesLimit", limit);
     try {
       const response = await axios.get(
-        `http://localhost:8990/api/traces?limit=${limit}`,
+        `/api/traces?limit=${limit}`,
         rootGetters["getAuthHeader"]
       );
       commit("setTraces", response.data);
@@ -30,11 +60,77 @@ const actions = {
     }
   },
 
+  // subscribeEvents receives new traceroutes and alerts as they arrive at the
+  // master, it reconnects after errors
+  async subscribeEvents({ state, dispatch, rootGetters }) {
+    if (!rootGetters["isAuthorized"] || eventStream) return;
+    const controller = new AbortController();
+    eventStream = controller;
+
+    try {
+      const response = await fetch(
+        `${axios.defaults.baseURL || ""}/api/v1/events?type=traceroute,alert`,
+        { ...rootGetters["getAuthHeader"], signal: controller.signal }
+      );
+      if (!response.ok) throw new Error("Status " + response.status);
+
+      // reload what was missed while disconnected
+      dispatch("fetchTraces", state.tracesLimit);
+      dispatch("fetchStatus");
+
+      // events are separated by empty lines, only their data is used
+      const reader = response.body.getReader();
+      const decoder = new TextDecoder();
+      let buffer = "";
+      for (;;) {
+        const { done, value } = await reader.read();
+        if (done) break;
+        buffer += decoder.decode(value, { stream: true });
+
+        let end;
+        while ((end = buffer.indexOf("\n\n")) >= 0) {
+          const data = buffer
+            .slice(0, end)
+            .split("\n")
+            .filter(line => line.startsWith("data:"))
+            .map(line => line.slice(5).trim())
+            .join("\n");
+          buffer = buffer.slice(end + 2);
+          if (data) dispatch("handleEvent", JSON.parse(data));
+        }
+      }
+    } catch (error) {
+      if (controller.signal.aborted) return;
+      console.log("Error caught: " + error);
+    }
+
+    if (eventStream !== controller) return;
+    eventStream = null;
+    setTimeout(() => dispatch("subscribeEvents"), 5 * 1000);
+  },
+
+  unsubscribeEvents() {
+    if (!eventStream) return;
+    eventStream.abort();
+    eventStream = null;
+  },
+
+  handleEvent({ commit, dispatch }, event) {
+    switch (event.Type) {
+      case "traceroute":
+        commit("addTrace", traceHistoryEntry(event.Data));
+        break;
+      case "alert":
+        dispatch("fetchStatus");
+        break;
+    }
+  },
+
   async fetchGraphData({ commit, rootGetters }, payload) {
     if (!rootGetters["isAuthorized"]) return;
     try {
       const response = await axios.get(
-        `http://localhost:8990/api/graph?destID=${payload.destID}&slaveID=${payload.slaveID}&skip=${payload.skip}`,
+        `/api/graph?destID=${payload.destID}&slaveID=${payload.slaveID}&skip=${payload.skip}`,
         rootGetters["getAuthHeader"]
       );
       commit("setGraphData", response.data.Data);
@@ -48,6 +144,11 @@ const actions = {
 
 const mutations = {
   setTraces: (state, traces) => (state.traces = traces),
+  setTracesLimit: (state, limit) => (state.tracesLimit = limit),
+  addTrace: (state, trace) => {
+    state.traces.unshift(trace);
+    if (state.tracesLimit > 0) state.traces.splice(state.tracesLimit);
+  },
 
   setGraphData: (state, graphData) => (state.graphData = graphData),
   setGraphStart: (state, graphStart) => (state.graphStart = graphStart),
@@ -15,7 +15,7 @@ const actions = {
     if (!rootGetters["isAuthorized"]) return;
     try {
       const response = await axios.get(
-        `http://localhost:8990/api/users?limit=${limit}`,
+        `/api/users?limit=${limit}`,
         rootGetters["getAuthHeader"]
       );
       commit("setUsers", response.data);
@@ -27,8 +27,9 @@ const actions = {
   async createUser({ commit, rootGetters }, user) {
     if (!rootGetters["isAuthorized"]) return;
     try {
+      const groups = user.TargetGroups.join(",");
       const response = await axios.post(
-        `http://localhost:8990/api/users?name=${user.Name}&password=${user.Password}&pwNeedsChange=${user.PasswordNeedsChange}`,
+        `/api/users?name=${user.Name}&password=${user.Password}&pwNeedsChange=${user.PasswordNeedsChange}&role=${user.Role}&targetGroup=${groups}`,
         "",
         rootGetters["getAuthHeader"]
       );
@@ -50,7 +51,7 @@ const actions = {
         console.log(user.Password);
       }
       const response = await axios.put(
-        `http://localhost:8990/api/users`,
+        `/api/users`,
         user,
         rootGetters["getAuthHeader"]
       );
@@ -67,7 +68,7 @@ const actions = {
     if (!rootGetters["isAuthorized"]) return;
     try {
       const response = await axios.delete(
-        `http://localhost:8990/api/users/${userID}`,
+        `/api/users/${userID}`,
         rootGetters["getAuthHeader"]
       );
       commit("removeUser", response.data.ID);